@@ -35,6 +35,7 @@ import (
 
 	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
 	"github.com/CortexFoundation/CortexTheseus/ctxc"
+	"github.com/CortexFoundation/CortexTheseus/dashboard"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/node"
 	"github.com/CortexFoundation/CortexTheseus/params"
@@ -84,8 +85,8 @@ type cortexConfig struct {
 	Cortex      ctxc.Config
 	Node        node.Config
 	Cortexstats ctxcstatsConfig
-	// Dashboard dashboard.Config
-	TorrentFs torrentfs.Config
+	Dashboard   dashboard.Config
+	TorrentFs   torrentfs.Config
 }
 
 func loadConfig(file string, cfg *cortexConfig) error {
@@ -116,9 +117,9 @@ func defaultNodeConfig() node.Config {
 func makeConfigNode(ctx *cli.Context) (*node.Node, cortexConfig) {
 	// Load defaults.
 	cfg := cortexConfig{
-		Cortex: ctxc.DefaultConfig,
-		Node:   defaultNodeConfig(),
-		// Dashboard: dashboard.DefaultConfig,
+		Cortex:    ctxc.DefaultConfig,
+		Node:      defaultNodeConfig(),
+		Dashboard: dashboard.DefaultConfig,
 		TorrentFs: torrentfs.DefaultConfig,
 	}
 
@@ -141,7 +142,7 @@ func makeConfigNode(ctx *cli.Context) (*node.Node, cortexConfig) {
 	// }
 
 	//utils.SetShhConfig(ctx, stack, &cfg.Shh)
-	// utils.SetDashboardConfig(ctx, &cfg.Dashboard)
+	utils.SetDashboardConfig(ctx, &cfg.Dashboard)
 	utils.SetTorrentFsConfig(ctx, &cfg.TorrentFs)
 
 	return stack, cfg
@@ -166,6 +167,15 @@ func makeFullNode(ctx *cli.Context) *node.Node {
 	}
 	if storageEnabled {
 		log.Info("FullNode", "storageEnabled", storageEnabled)
+		if cfg.TorrentFs.Lazy() {
+			// Block processing does not wait for the data fetched on request,
+			// the blocks running inference on it are imported again once the
+			// storage fetched it.
+			if ctx.GlobalBool(utils.MiningEnabledFlag.Name) {
+				utils.Fatalf("Lazy storage mode (--%s) cannot be used while mining", utils.StorageModeFlag.Name)
+			}
+			log.Warn("Lazy storage mode, blocks running inference are only imported once their data is fetched")
+		}
 		utils.RegisterStorageService(stack, &cfg.TorrentFs, gitCommit)
 	}
 	if deviceType := utils.IsCVMIPC(ctx.GlobalString(utils.InferDeviceTypeFlag.Name)); deviceType != "" {
@@ -224,9 +234,9 @@ func makeFullNode(ctx *cli.Context) *node.Node {
 
 	utils.RegisterCortexService(stack, &cfg.Cortex)
 
-	// if ctx.GlobalBool(utils.DashboardEnabledFlag.Name) {
-	// 	utils.RegisterDashboardService(stack, &cfg.Dashboard, gitCommit)
-	// }
+	if ctx.GlobalBool(utils.DashboardEnabledFlag.Name) {
+		utils.RegisterDashboardService(stack, &cfg.Dashboard, gitCommit)
+	}
 	// Whisper must be explicitly enabled by specifying at least 1 whisper flag or in dev mode
 	//shhEnabled := enableWhisper(ctx)
 	// shhAutoEnabled := !ctx.GlobalIsSet(utils.WhisperEnabledFlag.Name) && ctx.GlobalIsSet(utils.DeveloperFlag.Name)
@@ -101,6 +101,7 @@ var (
 		utils.MinerCudaFlag,
 		//utils.MinerOpenCLFlag,
 		utils.MinerDevicesFlag,
+		utils.CuckooPluginVerifyFlag,
 		//utils.MinerAlgorithmFlag,
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
@@ -120,6 +121,10 @@ var (
 		utils.RPCVirtualHostsFlag,
 		// utils.CortexStatsURLFlag,
 		// utils.MetricsEnabledFlag,
+		utils.DashboardEnabledFlag,
+		utils.DashboardAddrFlag,
+		utils.DashboardPortFlag,
+		utils.DashboardRefreshFlag,
 		// utils.FakePoWFlag,
 		// utils.NoCompactionFlag,
 		utils.GpoBlocksFlag,
@@ -144,6 +149,23 @@ var (
 		utils.StorageTrackerFlag,
 		utils.StorageDisableDHTFlag,
 		utils.StorageFullFlag,
+		utils.StorageScrubIntervalFlag,
+		utils.StorageMaxDiskFlag,
+		utils.StorageProtectBlocksFlag,
+		utils.StorageModeFlag,
+		utils.StorageLazyTimeoutFlag,
+		utils.StorageUploadRateFlag,
+		utils.StorageDownloadRateFlag,
+		utils.StorageTorrentUploadRateFlag,
+		utils.StorageTorrentDownloadRateFlag,
+		utils.StorageMaxConnsFlag,
+		utils.StorageMaxHalfOpenFlag,
+		utils.StorageOffPeakFlag,
+		utils.StorageBlocklistFlag,
+		utils.StorageBlocklistURLFlag,
+		utils.StorageBlocklistSignerFlag,
+		utils.StorageBlocklistRefreshFlag,
+		utils.StorageKeyFileFlag,
 	}
 
 	rpcFlags = []cli.Flag{
@@ -207,6 +229,8 @@ func init() {
 		// makedagCommand,
 		versionCommand,
 		cvmCommand,
+		// See modelcmd.go:
+		modelCommand,
 		// bugCommand,
 		// licenseCommand,
 		// See config.go
@@ -0,0 +1,302 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+
+	ctxc "github.com/CortexFoundation/CortexTheseus"
+	"github.com/CortexFoundation/CortexTheseus/accounts/keystore"
+	"github.com/CortexFoundation/CortexTheseus/client"
+	"github.com/CortexFoundation/CortexTheseus/cmd/internal/model"
+	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const receiptInterval = 3 * time.Second // Poll interval while waiting for transaction receipts
+
+var (
+	ModelDirFlag = cli.StringFlag{
+		Name:  "model.dir",
+		Usage: "Directory holding the compiled model files symbol and params",
+	}
+	ModelOutFlag = cli.StringFlag{
+		Name:  "model.out",
+		Usage: "Directory the seed (<infohash>/torrent and <infohash>/data) is written to",
+		Value: "publish",
+	}
+	ModelCommentFlag = cli.StringFlag{
+		Name:  "model.comment",
+		Usage: "Comment stored in the model meta",
+	}
+	ModelGasFlag = cli.Uint64Flag{
+		Name:  "model.gas",
+		Usage: "Gas paid to the author for each inference of the model",
+	}
+	ModelFromFlag = cli.StringFlag{
+		Name:  "model.from",
+		Usage: "Keystore account signing the transactions, also the model author",
+	}
+	ModelEndpointFlag = cli.StringFlag{
+		Name:  "model.endpoint",
+		Usage: "RPC endpoint of the node the transactions are sent to",
+		Value: "http://127.0.0.1:8545",
+	}
+	ModelTimeoutFlag = cli.DurationFlag{
+		Name:  "model.timeout",
+		Usage: "Time each transaction is given to be mined before publishing stops",
+		Value: 10 * time.Minute,
+	}
+	ModelDryRunFlag = cli.BoolFlag{
+		Name:  "model.dryrun",
+		Usage: "Only print the transaction payloads, nothing is written, signed or sent",
+	}
+
+	modelCommand = cli.Command{
+		Name:     "model",
+		Usage:    "Package and publish models",
+		Category: "MODEL COMMANDS",
+		Subcommands: []cli.Command{
+			{
+				Name:   "publish",
+				Usage:  "Package a compiled model and publish it on chain",
+				Action: utils.MigrateFlags(modelPublish),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.KeyStoreDirFlag,
+					utils.PasswordFileFlag,
+					ModelDirFlag,
+					ModelOutFlag,
+					ModelCommentFlag,
+					ModelGasFlag,
+					ModelFromFlag,
+					ModelEndpointFlag,
+					ModelTimeoutFlag,
+					ModelDryRunFlag,
+				},
+				Description: `
+    cortex model publish --model.dir <dir> --model.from <address>
+
+Builds the seed of the model in <dir> (files symbol and params), derives the
+input and output shapes from the graph, estimates the inference gas and
+encodes the model meta. The meta is then sent in a contract creation
+transaction signed with the keystore account, followed by the upload
+transactions until the whole model is on chain.
+
+The seed written to --model.out has to be served by torrentfs while uploading,
+as full nodes only accept the upload transactions once they can fetch the data.
+With --model.dryrun the seed is built in a temporary directory and removed,
+only the transaction payloads are printed.
+
+Publishing stops when a transaction is not mined within --model.timeout, for
+instance an upload dropped by the pool once the block quota is reached.`,
+			},
+		},
+	}
+)
+
+func modelPublish(ctx *cli.Context) error {
+	dir := ctx.String(ModelDirFlag.Name)
+	if dir == "" {
+		utils.Fatalf("Model directory (--%s) is required", ModelDirFlag.Name)
+	}
+	dryRun := ctx.Bool(ModelDryRunFlag.Name)
+	from := ctx.String(ModelFromFlag.Name)
+	if from == "" && !dryRun {
+		utils.Fatalf("Publishing account (--%s) is required", ModelFromFlag.Name)
+	}
+	if from != "" && !common.IsHexAddress(from) {
+		utils.Fatalf("Invalid publishing account %q", from)
+	}
+	author := common.HexToAddress(from)
+
+	out := ctx.String(ModelOutFlag.Name)
+	if dryRun {
+		// The seed is only built for its info hash
+		tmp, err := ioutil.TempDir("", "cortex-model-")
+		if err != nil {
+			utils.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		out = tmp
+	}
+	pkg, err := model.Build(dir, out, ctx.String(ModelCommentFlag.Name), ctx.Uint64(ModelGasFlag.Name), author)
+	if err != nil {
+		utils.Fatalf("Failed to package model: %v", err)
+	}
+	if pkg.InferGas, err = model.InferGas(pkg.GraphJSON); err != nil {
+		fmt.Printf("Inference gas:  unknown (%v)\n", err)
+	} else {
+		fmt.Printf("Inference gas:  %d\n", pkg.InferGas)
+	}
+	fmt.Printf("Info hash:      %s\n", pkg.InfoHash.HexString())
+	if !dryRun {
+		fmt.Printf("Seed:           %s\n", pkg.SeedDir)
+	}
+	fmt.Printf("Raw size:       %s\n", common.StorageSize(pkg.Meta.RawSize))
+	fmt.Printf("Input shape:    %v\n", pkg.Meta.InputShape)
+	fmt.Printf("Output shape:   %v\n", pkg.Meta.OutputShape)
+	fmt.Printf("Uploads:        %d\n", pkg.Uploads)
+
+	if dryRun {
+		meta, _ := json.MarshalIndent(pkg.Meta, "", "  ")
+		fmt.Printf("Model meta:     %s\n", meta)
+		fmt.Printf("Create payload: %s\n", hexutil.Encode(pkg.Payload))
+		fmt.Printf("Upload tx:      to <model address>, value 0, gas %d, no payload, %d times\n", params.UploadGas, pkg.Uploads)
+		return nil
+	}
+	return publishModel(ctx, author, pkg)
+}
+
+// publishModel sends the creation transaction of the model and then drives
+// its upload transactions to completion.
+func publishModel(ctx *cli.Context, from common.Address, pkg *model.Package) error {
+	cfg := cortexConfig{Node: defaultNodeConfig()}
+	utils.SetNodeConfig(ctx, &cfg.Node)
+	scryptN, scryptP, keydir, err := cfg.Node.AccountConfig()
+	if err != nil {
+		utils.Fatalf("Failed to read configuration: %v", err)
+	}
+	ks := keystore.NewKeyStore(keydir, scryptN, scryptP)
+	account, _ := unlockAccount(ctx, ks, from.Hex(), 0, utils.MakePasswordList(ctx))
+	if account.Address != from {
+		utils.Fatalf("Failed to unlock account %s", from.Hex())
+	}
+
+	rc, err := rpc.Dial(ctx.String(ModelEndpointFlag.Name))
+	if err != nil {
+		utils.Fatalf("Failed to connect to node: %v", err)
+	}
+	defer rc.Close()
+	cl := ctxcclient.NewClient(rc)
+	bg := context.Background()
+
+	var chainID hexutil.Uint64
+	if err := rc.CallContext(bg, &chainID, "ctxc_chainId"); err != nil {
+		utils.Fatalf("Failed to retrieve chain id: %v", err)
+	}
+	nonce, err := cl.PendingNonceAt(bg, from)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve nonce: %v", err)
+	}
+	price, err := cl.SuggestGasPrice(bg)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve gas price: %v", err)
+	}
+	timeout := ctx.Duration(ModelTimeoutFlag.Name)
+	send := func(what string, tx *types.Transaction) *types.Receipt {
+		signed, err := ks.SignTx(account, tx, new(big.Int).SetUint64(uint64(chainID)))
+		if err != nil {
+			utils.Fatalf("Failed to sign transaction: %v", err)
+		}
+		if err := cl.SendTransaction(bg, signed); err != nil {
+			utils.Fatalf("Failed to send transaction: %v", err)
+		}
+		wait, cancel := context.WithTimeout(bg, timeout)
+		defer cancel()
+		receipt, err := waitReceipt(wait, cl, signed.Hash())
+		if err == context.DeadlineExceeded {
+			utils.Fatalf("%s stalled: transaction %x not mined within %v, possibly dropped by the pool", what, signed.Hash(), timeout)
+		}
+		if err != nil {
+			utils.Fatalf("Failed to retrieve receipt of %s %x: %v", what, signed.Hash(), err)
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			utils.Fatalf("%s: transaction %x failed", what, signed.Hash())
+		}
+		return receipt
+	}
+
+	gas, err := cl.EstimateGas(bg, ctxc.CallMsg{From: from, GasPrice: price, Value: new(big.Int), Data: pkg.Payload})
+	if err != nil {
+		utils.Fatalf("Failed to estimate creation gas: %v", err)
+	}
+	addr := crypto.CreateAddress(from, nonce)
+	fmt.Printf("Creating model %s\n", addr.Hex())
+	receipt := send("Creation", types.NewContractCreation(nonce, new(big.Int), gas, price, pkg.Payload))
+	nonce++
+
+	// Uploads are only accepted once the data had time to spread among the
+	// seeding nodes.
+	if receipt.ContractAddress != (common.Address{}) {
+		addr = receipt.ContractAddress
+	}
+	var birth hexutil.Big
+	if err := rc.CallContext(bg, &birth, "ctxc_getNum", addr, "latest"); err != nil {
+		utils.Fatalf("Failed to retrieve model block: %v", err)
+	}
+	ready := new(big.Int).Add(birth.ToInt(), big.NewInt(params.SeedingBlks))
+	for {
+		head, err := cl.HeaderByNumber(bg, nil)
+		if err != nil {
+			utils.Fatalf("Failed to retrieve head: %v", err)
+		}
+		if head.Number.Cmp(ready) >= 0 {
+			break
+		}
+		fmt.Printf("Waiting for block %v to upload, current %v\n", ready, head.Number)
+		time.Sleep(receiptInterval)
+	}
+
+	for i := uint64(0); ; i++ {
+		remain, err := cl.UploadAt(bg, addr, nil)
+		if err != nil {
+			utils.Fatalf("Failed to retrieve upload progress: %v", err)
+		}
+		fmt.Printf("Upload %d/%d, %s remaining\n", i, pkg.Uploads, common.StorageSize(remain.Uint64()))
+		if remain.Sign() == 0 {
+			break
+		}
+		send(fmt.Sprintf("Upload %d/%d", i+1, pkg.Uploads), types.NewTransaction(nonce, addr, new(big.Int), params.UploadGas, price, nil))
+		nonce++
+	}
+	fmt.Printf("Model %s published, usable once mature\n", addr.Hex())
+	return nil
+}
+
+// waitReceipt polls the node until the transaction is mined, or ctx is done.
+func waitReceipt(ctx context.Context, cl *ctxcclient.Client, hash common.Hash) (*types.Receipt, error) {
+	for {
+		receipt, err := cl.TransactionReceipt(ctx, hash)
+		if err == nil {
+			return receipt, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != ctxc.NotFound {
+			return nil, err
+		}
+		select {
+		case <-time.After(receiptInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
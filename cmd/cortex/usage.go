@@ -87,16 +87,15 @@ var AppHelpFlagGroups = []flagGroup{
 	// 		utils.DeveloperPeriodFlag,
 	// 	},
 	// },
-	//{
-	//	Name: "DASHBOARD",
-	//	Flags: []cli.Flag{
-	//		utils.DashboardEnabledFlag,
-	//		utils.DashboardAddrFlag,
-	//		utils.DashboardPortFlag,
-	//		utils.DashboardRefreshFlag,
-	//		utils.DashboardAssetsFlag,
-	//	},
-	//},
+	{
+		Name: "DASHBOARD",
+		Flags: []cli.Flag{
+			utils.DashboardEnabledFlag,
+			utils.DashboardAddrFlag,
+			utils.DashboardPortFlag,
+			utils.DashboardRefreshFlag,
+		},
+	},
 	{
 		Name: "TRANSACTION POOL",
 		Flags: []cli.Flag{
@@ -185,6 +184,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.MinerCudaFlag,
 			//utils.MinerOpenCLFlag,
 			utils.MinerDevicesFlag,
+			utils.CuckooPluginVerifyFlag,
 			//utils.MinerAlgorithmFlag,
 		},
 	},
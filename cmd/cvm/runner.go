@@ -71,6 +71,9 @@ func readGenesis(genesisPath string) *core.Genesis {
 	if err := json.NewDecoder(file).Decode(genesis); err != nil {
 		utils.Fatalf("invalid genesis file: %v", err)
 	}
+	if err := genesis.Validate(); err != nil {
+		utils.Fatalf("invalid genesis file: %v", err)
+	}
 	return genesis
 }
 
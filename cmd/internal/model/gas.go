@@ -0,0 +1,223 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits of the cvm runtime on the cost of a graph.
+const (
+	maxBaseOps = 1 << 30 // Operations per output element of a single node
+	maxOps     = 1 << 40 // Operations of the whole graph
+	maxMemory  = 1 << 40 // Memory cost of the whole graph
+)
+
+// gasGraph is the subset of a compiled graph needed to estimate its gas.
+type gasGraph struct {
+	Nodes []struct {
+		Op    string `json:"op"`
+		Attrs struct {
+			FuncName string `json:"func_name"`
+		} `json:"attrs"`
+		Inputs [][]int `json:"inputs"`
+	} `json:"nodes"`
+	NodeRowPtr []int `json:"node_row_ptr"`
+	Attrs      struct {
+		Shape   []json.RawMessage `json:"shape"`
+		OpAttrs []json.RawMessage `json:"op_attrs"`
+	} `json:"attrs"`
+}
+
+// opName strips the numeric suffix the compiler appends to repeated
+// operators, conv2d_3 is a conv2d.
+func opName(name string) string {
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] >= '0' && name[i] <= '9' {
+			continue
+		}
+		if name[i] == '_' {
+			return name[:i]
+		}
+		break
+	}
+	return name
+}
+
+// InferGas estimates the gas of a single inference of a compiled graph the
+// way the cvm runtime does: every element of a variable costs 5, every
+// element produced by an operator costs 5 plus the operations needed to
+// compute it.
+func InferGas(symbol []byte) (uint64, error) {
+	var g gasGraph
+	if err := json.Unmarshal(symbol, &g); err != nil {
+		return 0, err
+	}
+	if len(g.Attrs.Shape) != 2 || len(g.NodeRowPtr) != len(g.Nodes)+1 {
+		return 0, errors.New("graph without shapes or entries")
+	}
+	var shapes [][]uint64
+	if err := json.Unmarshal(g.Attrs.Shape[1], &shapes); err != nil {
+		return 0, err
+	}
+	var opAttrs []string
+	if len(g.Attrs.OpAttrs) == 2 {
+		if err := json.Unmarshal(g.Attrs.OpAttrs[1], &opAttrs); err != nil {
+			return 0, err
+		}
+	}
+	if len(opAttrs) != len(g.Nodes) {
+		return 0, errors.New("graph without operator attributes")
+	}
+	// size returns the number of elements of a node entry.
+	size := func(nid, index int) (uint64, error) {
+		if nid < 0 || nid >= len(g.Nodes) {
+			return 0, fmt.Errorf("invalid graph node %d", nid)
+		}
+		eid := g.NodeRowPtr[nid] + index
+		if eid < 0 || eid >= len(shapes) {
+			return 0, fmt.Errorf("invalid graph entry %d", eid)
+		}
+		n := uint64(1)
+		for _, dim := range shapes[eid] {
+			if dim != 0 && n > maxMemory/dim {
+				return 0, fmt.Errorf("graph entry %d too large", eid)
+			}
+			n *= dim
+		}
+		return n, nil
+	}
+	input := func(nid, i int) (uint64, []uint64, error) {
+		inputs := g.Nodes[nid].Inputs
+		if i >= len(inputs) || len(inputs[i]) < 2 {
+			return 0, nil, fmt.Errorf("node %d misses input %d", nid, i)
+		}
+		n, err := size(inputs[i][0], inputs[i][1])
+		if err != nil {
+			return 0, nil, err
+		}
+		return n, shapes[g.NodeRowPtr[inputs[i][0]]+inputs[i][1]], nil
+	}
+
+	var ops, mem uint64
+	for nid, node := range g.Nodes {
+		if node.Op == "null" {
+			n, err := size(nid, 0)
+			if err != nil {
+				return 0, err
+			}
+			mem += n * 5
+		} else {
+			var attrs struct {
+				UseBias  *string `json:"use_bias"`
+				PoolSize string  `json:"pool_size"`
+			}
+			if opAttrs[nid] != "" {
+				if err := json.Unmarshal([]byte(opAttrs[nid]), &attrs); err != nil {
+					return 0, fmt.Errorf("node %d: %v", nid, err)
+				}
+			}
+			bias := uint64(1)
+			if attrs.UseBias != nil && !strings.EqualFold(*attrs.UseBias, "true") && *attrs.UseBias != "1" {
+				bias = 0
+			}
+
+			out, base := 0, uint64(1)
+			switch opName(node.Attrs.FuncName) {
+			case "dense":
+				_, weight, err := input(nid, 1)
+				if err != nil {
+					return 0, err
+				}
+				if len(weight) < 2 {
+					return 0, fmt.Errorf("node %d: invalid dense weight", nid)
+				}
+				base = weight[1]*3 + bias
+			case "non_max_suppression":
+				_, shape, err := input(nid, 0)
+				if err != nil {
+					return 0, err
+				}
+				if len(shape) < 1 {
+					return 0, fmt.Errorf("node %d: invalid input", nid)
+				}
+				base = shape[0] * 20
+			case "conv2d":
+				n, weight, err := input(nid, 1)
+				if err != nil {
+					return 0, err
+				}
+				if len(weight) < 1 || weight[0] == 0 {
+					return 0, fmt.Errorf("node %d: invalid conv2d weight", nid)
+				}
+				base = n/weight[0]*3 + bias
+			case "max_pool2d":
+				for _, dim := range strings.Split(strings.Trim(attrs.PoolSize, "()[] "), ",") {
+					d, err := strconv.ParseUint(strings.TrimSpace(dim), 10, 32)
+					if err != nil {
+						return 0, fmt.Errorf("node %d: invalid pool size %q", nid, attrs.PoolSize)
+					}
+					if base *= d; base > maxBaseOps {
+						break
+					}
+				}
+			case "sum":
+				n, _, err := input(nid, 0)
+				if err != nil {
+					return 0, err
+				}
+				m, err := size(nid, 0)
+				if err != nil {
+					return 0, err
+				}
+				if m == 0 {
+					return 0, fmt.Errorf("node %d: empty output", nid)
+				}
+				base = n / m
+			case "get_valid_count":
+				// The valid count comes first, the main output is the array.
+				out = 1
+			}
+			if base > maxBaseOps {
+				return 0, fmt.Errorf("node %d: %d operations per output exceed %d", nid, base, maxBaseOps)
+			}
+			n, err := size(nid, out)
+			if err != nil {
+				return 0, err
+			}
+			if n != 0 && base > (maxOps-ops)/n {
+				return 0, fmt.Errorf("graph operations exceed %d", maxOps)
+			}
+			ops += base * n
+			for i := 0; i < g.NodeRowPtr[nid+1]-g.NodeRowPtr[nid]; i++ {
+				n, err := size(nid, i)
+				if err != nil {
+					return 0, err
+				}
+				mem += n * 5
+			}
+		}
+		if mem > maxMemory {
+			return 0, fmt.Errorf("graph memory cost exceeds %d", maxMemory)
+		}
+	}
+	return mem + ops, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpName(t *testing.T) {
+	tests := map[string]string{
+		"conv2d":       "conv2d",
+		"conv2d_1":     "conv2d",
+		"conv2d_12":    "conv2d",
+		"max_pool2d":   "max_pool2d",
+		"max_pool2d_3": "max_pool2d",
+		"broadcast_":   "broadcast",
+	}
+	for name, want := range tests {
+		if have := opName(name); have != want {
+			t.Errorf("%s: have %s, want %s", name, have, want)
+		}
+	}
+}
+
+func TestInferGas(t *testing.T) {
+	symbol, err := ioutil.ReadFile(filepath.Join(testModelDir, "symbol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gas, err := InferGas(symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(137396206); gas != want {
+		t.Errorf("gas mismatch: have %d, want %d", gas, want)
+	}
+}
+
+func TestInferGasOps(t *testing.T) {
+	// A 1x8 input through a dense layer of 4 units without bias, then a 2x2
+	// pooling over its 1x1x2x2 reshape and a sum down to a scalar.
+	graph := `{
+		"nodes": [
+			{"op": "null", "inputs": []},
+			{"op": "null", "inputs": []},
+			{"op": "cvm_op", "attrs": {"func_name": "dense"}, "inputs": [[0, 0, 0], [1, 0, 0]]},
+			{"op": "cvm_op", "attrs": {"func_name": "reshape"}, "inputs": [[2, 0, 0]]},
+			{"op": "cvm_op", "attrs": {"func_name": "max_pool2d_1"}, "inputs": [[3, 0, 0]]},
+			{"op": "cvm_op", "attrs": {"func_name": "sum"}, "inputs": [[4, 0, 0]]}
+		],
+		"node_row_ptr": [0, 1, 2, 3, 4, 5, 6],
+		"attrs": {
+			"shape": ["list_shape", [[1, 8], [4, 8], [1, 4], [1, 1, 2, 2], [1, 1, 1, 1], []]],
+			"op_attrs": ["list_str", ["", "", "{\"use_bias\": \"False\"}", "", "{\"pool_size\": \"(2, 2)\"}", ""]]
+		}
+	}`
+	gas, err := InferGas([]byte(graph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := uint64(8+32+4+4+1+1) * 5
+	ops := uint64(8*3*4 + 1*4 + 4*1 + 1*1)
+	if gas != mem+ops {
+		t.Errorf("gas mismatch: have %d, want %d", gas, mem+ops)
+	}
+
+	if _, err := InferGas([]byte(`{"nodes":[]}`)); err == nil {
+		t.Error("graph without shapes accepted")
+	}
+}
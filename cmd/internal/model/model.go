@@ -0,0 +1,233 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+// Package model packages compiled models to be published on chain.
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	PieceLength = 4 * 1024 * 1024 // Piece length of the model torrents built for publishing
+	DataName    = "data"          // Name of the torrent root, the storage layout expects data/symbol and data/params
+)
+
+// Package is a compiled model ready to be published.
+type Package struct {
+	Meta      *types.ModelMeta
+	InfoHash  metainfo.Hash
+	Payload   []byte
+	Uploads   uint64 // Number of upload transactions needed after the creation
+	InferGas  uint64 // Estimated gas of a single inference, zero if unknown
+	SeedDir   string
+	MetaInfo  *metainfo.MetaInfo
+	GraphJSON []byte
+}
+
+// modelGraph is the subset of a compiled graph needed to derive the model shapes.
+type modelGraph struct {
+	Nodes []struct {
+		Op   string `json:"op"`
+		Name string `json:"name"`
+	} `json:"nodes"`
+	ArgNodes   []int   `json:"arg_nodes"`
+	NodeRowPtr []int   `json:"node_row_ptr"`
+	Heads      [][]int `json:"heads"`
+	Attrs      struct {
+		Shape []json.RawMessage `json:"shape"`
+	} `json:"attrs"`
+}
+
+// GraphShapes returns the input and output shapes of a compiled graph. The
+// input is the argument node called data, or the first argument otherwise,
+// and the output is the first head of the graph.
+func GraphShapes(symbol []byte) (input, output []uint64, err error) {
+	var g modelGraph
+	if err := json.Unmarshal(symbol, &g); err != nil {
+		return nil, nil, err
+	}
+	if len(g.Attrs.Shape) != 2 || len(g.ArgNodes) == 0 || len(g.Heads) == 0 || len(g.Heads[0]) < 2 {
+		return nil, nil, errors.New("graph without shapes, arguments or heads")
+	}
+	var shapes [][]uint64
+	if err := json.Unmarshal(g.Attrs.Shape[1], &shapes); err != nil {
+		return nil, nil, err
+	}
+	entry := func(nid, index int) ([]uint64, error) {
+		if nid < 0 || nid >= len(g.Nodes) {
+			return nil, fmt.Errorf("invalid graph node %d", nid)
+		}
+		eid := nid + index
+		if len(g.NodeRowPtr) > nid {
+			eid = g.NodeRowPtr[nid] + index
+		}
+		if eid < 0 || eid >= len(shapes) {
+			return nil, fmt.Errorf("invalid graph entry %d", eid)
+		}
+		return shapes[eid], nil
+	}
+
+	data := g.ArgNodes[0]
+	for _, nid := range g.ArgNodes {
+		if nid >= 0 && nid < len(g.Nodes) && g.Nodes[nid].Name == "data" {
+			data = nid
+			break
+		}
+	}
+	if input, err = entry(data, 0); err != nil {
+		return nil, nil, err
+	}
+	if output, err = entry(g.Heads[0][0], g.Heads[0][1]); err != nil {
+		return nil, nil, err
+	}
+	return input, output, nil
+}
+
+// buildSeed copies the model files into out/<infohash>/data, writes the
+// torrent next to it and returns its metainfo.
+func buildSeed(dir, out string) (*metainfo.MetaInfo, string, error) {
+	staging, err := ioutil.TempDir(out, ".staging")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(staging)
+
+	data := filepath.Join(staging, DataName)
+	if err := os.MkdirAll(data, 0755); err != nil {
+		return nil, "", err
+	}
+	for _, name := range []string{"symbol", "params"} {
+		if err := copyFile(filepath.Join(dir, name), filepath.Join(data, name)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	info := metainfo.Info{PieceLength: PieceLength}
+	if err := info.BuildFromFilePath(data); err != nil {
+		return nil, "", err
+	}
+	mi := &metainfo.MetaInfo{CreatedBy: "cortex", CreationDate: time.Now().Unix()}
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		return nil, "", err
+	}
+
+	seed := filepath.Join(out, mi.HashInfoBytes().HexString())
+	if err := os.RemoveAll(seed); err != nil {
+		return nil, "", err
+	}
+	if err := os.Rename(staging, seed); err != nil {
+		return nil, "", err
+	}
+	f, err := os.Create(filepath.Join(seed, "torrent"))
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	if err := mi.Write(f); err != nil {
+		return nil, "", err
+	}
+	return mi, seed, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Build builds the seed of the model in dir under out, along with its model
+// meta and the payload of the transaction creating it.
+func Build(dir, out, comment string, gas uint64, author common.Address) (*Package, error) {
+	if gas > params.MODEL_GAS_LIMIT {
+		return nil, fmt.Errorf("model gas %d exceeds limit %d", gas, params.MODEL_GAS_LIMIT)
+	}
+	symbol, err := ioutil.ReadFile(filepath.Join(dir, "symbol"))
+	if err != nil {
+		return nil, err
+	}
+	input, output, err := GraphShapes(symbol)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model graph: %v", err)
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return nil, err
+	}
+	mi, seed, err := buildSeed(dir, out)
+	if err != nil {
+		return nil, err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(info.TotalLength())
+	if size <= params.MODEL_MIN_UPLOAD_BYTES || size > params.MODEL_MAX_UPLOAD_BYTES {
+		return nil, fmt.Errorf("invalid model size %d", size)
+	}
+
+	ih := mi.HashInfoBytes()
+	meta := &types.ModelMeta{
+		Comment:       comment,
+		Hash:          common.BytesToAddress(ih.Bytes()),
+		RawSize:       size,
+		InputShape:    input,
+		OutputShape:   output,
+		Gas:           gas,
+		AuthorAddress: author,
+	}
+	code, err := meta.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	var uploads uint64
+	if size > params.DEFAULT_UPLOAD_BYTES {
+		uploads = (size - params.DEFAULT_UPLOAD_BYTES + params.PER_UPLOAD_BYTES - 1) / params.PER_UPLOAD_BYTES
+	}
+	return &Package{
+		Meta:      meta,
+		InfoHash:  ih,
+		Payload:   append([]byte{0, 1}, code...),
+		Uploads:   uploads,
+		SeedDir:   seed,
+		MetaInfo:  mi,
+		GraphJSON: symbol,
+	}, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const testModelDir = "../../../cvm-runtime/tests/3145ad19228c1cd2d051314e72f26c1ce77b7f02"
+
+func TestGraphShapes(t *testing.T) {
+	symbol, err := ioutil.ReadFile(filepath.Join(testModelDir, "symbol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, output, err := GraphShapes(symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 3, 32, 32}; !reflect.DeepEqual(input, want) {
+		t.Errorf("input shape mismatch: have %v, want %v", input, want)
+	}
+	if want := []uint64{1, 10}; !reflect.DeepEqual(output, want) {
+		t.Errorf("output shape mismatch: have %v, want %v", output, want)
+	}
+	if _, _, err := GraphShapes([]byte(`{"nodes":[]}`)); err == nil {
+		t.Error("graph without shapes accepted")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	out, err := ioutil.TempDir("", "cortex-model-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	author := common.HexToAddress("0x0000000000000000000000000000000000000abc")
+	pkg, err := Build(testModelDir, out, "test", 100, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The seed has to be laid out the way the storage serves it.
+	mi, err := metainfo.LoadFromFile(filepath.Join(pkg.SeedDir, "torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.HashInfoBytes() != pkg.InfoHash || filepath.Base(pkg.SeedDir) != pkg.InfoHash.HexString() {
+		t.Errorf("seed does not match info hash %v", pkg.InfoHash)
+	}
+	for _, name := range []string{"symbol", "params"} {
+		if _, err := os.Stat(filepath.Join(pkg.SeedDir, DataName, name)); err != nil {
+			t.Errorf("seed misses %s: %v", name, err)
+		}
+	}
+	// Nothing but the seed is left in out.
+	if entries, err := ioutil.ReadDir(out); err != nil || len(entries) != 1 {
+		t.Errorf("out holds %d entries besides the seed: %v", len(entries)-1, err)
+	}
+	// The payload must decode to the packaged meta.
+	meta, err := types.ParseModelMeta(pkg.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.InfoHash() != pkg.InfoHash || meta.AuthorAddress != author || meta.Gas != 100 {
+		t.Errorf("meta mismatch: %+v", meta)
+	}
+	if meta.RawSize == 0 || pkg.Uploads == 0 {
+		t.Errorf("invalid size %d or uploads %d", meta.RawSize, pkg.Uploads)
+	}
+	if _, err := Build(testModelDir, out, "", 1<<40, author); err == nil {
+		t.Error("model gas above the limit accepted")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	catalogNumberFlag = cli.Uint64Flag{
+		Name:  "number",
+		Usage: "Block number of the trusted root",
+	}
+	catalogRootFlag = cli.StringFlag{
+		Name:  "root",
+		Usage: "Trusted storage root at --number, as returned by torrent_storage",
+	}
+	catalogCheckpointFlag = cli.Uint64Flag{
+		Name:  "checkpoint",
+		Usage: "Only import the catalog up to this block, scanning the chain from there (0 = all of it)",
+	}
+	catalogPeerFlag = cli.StringFlag{
+		Name:  "peer",
+		Usage: "RPC endpoint of the trusted node to fetch the catalog from",
+	}
+
+	catalogCommand = cli.Command{
+		Name:  "catalog",
+		Usage: "Export and import the file storage catalog",
+		Description: `The catalog holds the record blocks and files of the storage, along
+with the Merkle root committing to them. A node imports it instead of scanning
+the whole chain, then goes on from the last block listened by the catalog.
+
+Catalogs are checked against the built-in checkpoint, and against --root at
+--number when given. The roots only commit to the block hashes: transactions
+and files are not authenticated until the node checks them against the chain
+at its next start. The node must be stopped while its catalog is imported.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Export the catalog of --dir to a file",
+				ArgsUsage: "<file>",
+				Action:    catalogExport,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import the catalog of a file into --dir",
+				ArgsUsage: "<file>",
+				Action:    catalogImport,
+				Flags:     []cli.Flag{catalogNumberFlag, catalogRootFlag, catalogCheckpointFlag},
+			},
+			{
+				Name:   "sync",
+				Usage:  "Import the catalog of a trusted node into --dir",
+				Action: catalogSync,
+				Flags:  []cli.Flag{catalogPeerFlag, catalogNumberFlag, catalogRootFlag, catalogCheckpointFlag},
+			},
+		},
+	}
+)
+
+// openStorage opens the file storage of the data dir set on the command line.
+func openStorage(ctx *cli.Context) (*torrentfs.FileStorage, error) {
+	return torrentfs.NewFileStorage(&torrentfs.Config{DataDir: ctx.GlobalString("dir")})
+}
+
+func catalogExport(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("catalog file expected")
+	}
+	fs, err := openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	c := fs.Catalog()
+
+	f, err := os.Create(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	if err := torrentfs.WriteCatalog(f, c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d blocks and %d files up to block %d, root %x\n", len(c.Blocks), len(c.Files), c.LastListenBlockNumber, c.Root)
+	return nil
+}
+
+func catalogImport(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("catalog file expected")
+	}
+	f, err := os.Open(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	c, err := torrentfs.ReadCatalog(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return importCatalog(ctx, c)
+}
+
+func catalogSync(ctx *cli.Context) error {
+	peer := ctx.String(catalogPeerFlag.Name)
+	if peer == "" {
+		return errors.New("--peer is required")
+	}
+	if !ctx.IsSet(catalogRootFlag.Name) {
+		return errors.New("--root and --number of the trusted node are required")
+	}
+	client, err := rpc.Dial(peer)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	var c torrentfs.Catalog
+	if err := client.Call(&c, "torrent_catalog"); err != nil {
+		return err
+	}
+	return importCatalog(ctx, &c)
+}
+
+// importCatalog verifies c against the trusted roots of the command line and
+// imports it into the data dir.
+func importCatalog(ctx *cli.Context, c *torrentfs.Catalog) error {
+	trusted := make(map[uint64]common.Hash)
+	if ctx.IsSet(catalogRootFlag.Name) {
+		root := ctx.String(catalogRootFlag.Name)
+		if len(common.FromHex(root)) != common.HashLength {
+			return fmt.Errorf("invalid root %q", root)
+		}
+		trusted[ctx.Uint64(catalogNumberFlag.Name)] = common.HexToHash(root)
+	}
+	if number := ctx.Uint64(catalogCheckpointFlag.Name); number > 0 {
+		if err := c.Truncate(number); err != nil {
+			return err
+		}
+	}
+	matched, err := c.Verify(trusted)
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		fmt.Fprintln(os.Stderr, "Warning: catalog not anchored to any trusted root, only its consistency was checked")
+	}
+	fmt.Fprintln(os.Stderr, "Warning: catalog transactions and files not authenticated, they are checked against the chain at the next start")
+
+	fs, err := openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	if err := fs.ImportCatalog(c); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d blocks and %d files up to block %d, root %x\n", len(c.Blocks), len(c.Files), c.LastListenBlockNumber, c.Root)
+	return nil
+}
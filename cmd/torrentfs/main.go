@@ -2,11 +2,13 @@ package main
 
 import (
 	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
 	"github.com/CortexFoundation/CortexTheseus/torrentfs"
 	cli "gopkg.in/urfave/cli.v1"
 	glog "log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
@@ -17,6 +19,12 @@ type Config struct {
 	NSeed    int
 	NActive  int
 	Dht      bool
+	RPCAddr  string
+	Mode     string
+
+	BoostAddr  string
+	BoostRate  int
+	BoostAllow string
 }
 
 var gitCommit = "" // Git SHA1 commit hash of the release (set via linker flags)
@@ -44,8 +52,37 @@ func main() {
 			Usage:       "datadir",
 			Destination: &conf.Dir,
 		},
+		cli.StringFlag{
+			Name:        "rpcaddr",
+			Value:       "127.0.0.1:8086",
+			Usage:       "HTTP-RPC listening address of the torrent API (empty to disable)",
+			Destination: &conf.RPCAddr,
+		},
+		cli.StringFlag{
+			Name:        "mode",
+			Value:       torrentfs.DefaultConfig.SyncMode,
+			Usage:       `Sync mode ("full" fetches every torrent, "lazy" only those requested)`,
+			Destination: &conf.Mode,
+		},
+		cli.StringFlag{
+			Name:        "boost.addr",
+			Usage:       "Listening address of the boost server mirroring the completed torrents (empty to disable)",
+			Destination: &conf.BoostAddr,
+		},
+		cli.IntFlag{
+			Name:        "boost.rate",
+			Usage:       "Upload limit of the boost server in KB/s (0 = unlimited)",
+			Destination: &conf.BoostRate,
+		},
+		cli.StringFlag{
+			Name:        "boost.allow",
+			Usage:       "Comma separated IPs or CIDRs allowed to use the boost server (empty = all)",
+			Destination: &conf.BoostAllow,
+		},
 	}
 
+	app.Commands = []cli.Command{catalogCommand, migrateCommand}
+
 	app.Action = func(c *cli.Context) error {
 		mainExitCode(&conf)
 		return nil
@@ -64,7 +101,8 @@ func mainExitCode(conf *Config) int {
 		RpcURI:          "",
 		DefaultTrackers: torrentfs.DefaultConfig.DefaultTrackers,
 		BoostNodes:      torrentfs.DefaultConfig.BoostNodes,
-		SyncMode:        torrentfs.DefaultConfig.SyncMode,
+		SyncMode:        conf.Mode,
+		LazyTimeout:     torrentfs.DefaultConfig.LazyTimeout,
 		DisableUTP:      torrentfs.DefaultConfig.DisableUTP,
 		MaxSeedingNum:   conf.NSeed,
 		MaxActiveNum:    conf.NActive,
@@ -73,8 +111,27 @@ func mainExitCode(conf *Config) int {
 	cfg.DataDir = conf.Dir
 	cfg.DisableDHT = !conf.Dht
 	cfg.DisableUTP = true
-	tfs, _ := torrentfs.New(&cfg, "")
+	cfg.BoostAddr = conf.BoostAddr
+	cfg.BoostRate = conf.BoostRate
+	if conf.BoostAllow != "" {
+		cfg.BoostAllow = strings.Split(conf.BoostAllow, ",")
+	}
+	tfs, err := torrentfs.New(&cfg, "")
+	if err != nil {
+		log.Error("Could not create torrentfs", "err", err)
+		return 1
+	}
 	tfs.Start(nil)
+	if conf.RPCAddr != "" {
+		listener, handler, err := rpc.StartHTTPEndpoint(conf.RPCAddr, tfs.APIs(), []string{"torrent"}, nil, []string{"localhost"}, rpc.DefaultHTTPTimeouts)
+		if err != nil {
+			log.Error("Could not start RPC api", "err", err)
+			return 1
+		}
+		defer handler.Stop()
+		defer listener.Close()
+		log.Info("HTTP endpoint opened", "url", "http://"+conf.RPCAddr)
+	}
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	for {
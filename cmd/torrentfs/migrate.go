@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	migrateDryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only list the migrations left to run",
+	}
+
+	migrateCommand = cli.Command{
+		Name:   "migrate",
+		Usage:  "Upgrade the file storage of --dir to the latest schema",
+		Action: migrate,
+		Flags:  []cli.Flag{migrateDryRunFlag},
+		Description: `The storage is also migrated when opened by the node; this command runs
+the migrations while it is stopped. The database is copied aside beforehand,
+next to it with the schema version it had as suffix.`,
+	}
+)
+
+func migrate(ctx *cli.Context) error {
+	dir := ctx.GlobalString("dir")
+	v, names, err := torrentfs.StorageSchema(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no file storage in %s", dir)
+	} else if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Printf("Storage at schema %d, up to date\n", v)
+		return nil
+	}
+	fmt.Printf("Storage at schema %d, %d migrations left:\n", v, len(names))
+	for _, name := range names {
+		fmt.Println("  " + name)
+	}
+	if ctx.Bool(migrateDryRunFlag.Name) {
+		return nil
+	}
+	fs, err := openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fs.Close(); err != nil {
+		return err
+	}
+	v, _, err = torrentfs.StorageSchema(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Storage migrated to schema %d\n", v)
+	return nil
+}
@@ -1,31 +1,86 @@
 package main
 
 import (
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs/tracker"
+	"github.com/anacrolix/torrent/metainfo"
 	cli "gopkg.in/urfave/cli.v1"
-	"os"
-	"os/exec"
-	"sync"
-	//        "sync/atomic"
 )
 
 type Config struct {
-	wg sync.WaitGroup
+	LogLevel  int
+	HTTPPorts string
+	UDPPorts  string
+	Interval  time.Duration
+	Expiry    time.Duration
+	RPC       string
+	Refresh   time.Duration
 }
 
-// curl -o- https://raw.githubusercontent.com/nvm-sh/nvm/v0.34.0/install.sh | bash
-// source ~/.bashrc
-// nvm install node
-// sudo npm install -g bittorrent-tracker
 func main() {
 	var conf Config
 	app := cli.NewApp()
-	app.Flags = []cli.Flag{}
+	app.Flags = []cli.Flag{
+		cli.IntFlag{
+			Name:        "verbosity",
+			Value:       3,
+			Usage:       "verbose level",
+			Destination: &conf.LogLevel,
+		},
+		cli.StringFlag{
+			Name:        "http",
+			Value:       strings.Join(params.Tracker_ports, ","),
+			Usage:       "Comma separated ports of the HTTP tracker",
+			Destination: &conf.HTTPPorts,
+		},
+		cli.StringFlag{
+			Name:        "udp",
+			Value:       strings.Join(udpPorts(), ","),
+			Usage:       "Comma separated ports of the UDP tracker",
+			Destination: &conf.UDPPorts,
+		},
+		cli.DurationFlag{
+			Name:        "interval",
+			Value:       tracker.DefaultConfig.Interval,
+			Usage:       "Announce interval asked to the peers",
+			Destination: &conf.Interval,
+		},
+		cli.DurationFlag{
+			Name:        "expiry",
+			Value:       tracker.DefaultConfig.Expiry,
+			Usage:       "Peers silent for longer are dropped",
+			Destination: &conf.Expiry,
+		},
+		cli.StringFlag{
+			Name:        "rpc",
+			Usage:       "Endpoint of a node serving the torrent API, to track only the files created on chain (empty = all)",
+			Destination: &conf.RPC,
+		},
+		cli.DurationFlag{
+			Name:        "rpc.refresh",
+			Value:       time.Minute,
+			Usage:       "Refresh interval of the files created on chain",
+			Destination: &conf.Refresh,
+		},
+		// Read by the metrics package at init
+		cli.BoolFlag{
+			Name:  "metrics",
+			Usage: "Enable the metrics served at /debug/metrics",
+		},
+	}
 
 	app.Action = func(c *cli.Context) error {
-		err := run(&conf)
-		return err
+		return run(&conf)
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -33,29 +88,102 @@ func main() {
 	}
 }
 
-func run(conf *Config) error {
+// udpPorts are the ports the npm tracker served UDP on, all of them.
+func udpPorts() []string {
+	ports := append([]string{}, params.Tracker_ports...)
+	for _, p := range params.UDP_Tracker_ports {
+		var found bool
+		for _, q := range ports {
+			if p == q {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+func addrs(ports string) (addrs []string) {
+	for _, port := range strings.Split(ports, ",") {
+		if port = strings.TrimSpace(port); port != "" {
+			addrs = append(addrs, net.JoinHostPort("", port))
+		}
+	}
+	return addrs
+}
 
-	for _, port := range params.Tracker_ports {
-		//cmd := exec.Command("bittorrent-tracker", "--port", port, "--stats", "false", "--http", "--silent") //, "2&>1", "&")
-		log.Info("Tracker service starting", "port", port)
-		conf.wg.Add(1)
-		go func(p string) error {
-			defer conf.wg.Done()
-			log.Info("Tracker service starting", "port", p)
-			//cmd := exec.Command("bittorrent-tracker", "--port", p, "--stats", "false", "--http", "--silent")
-			//cmd := exec.Command("bittorrent-tracker", "--port", p, "--http", "--silent")
-			//cmd := exec.Command("bittorrent-tracker", "--port", p, "--silent")
-			cmd := exec.Command("bittorrent-tracker", "--port", p)
-			err := cmd.Run()
+func run(conf *Config) error {
+	log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(conf.LogLevel), log.StreamHandler(os.Stdout, log.TerminalFormat(true))))
 
+	cfg := tracker.Config{
+		HTTPAddrs: addrs(conf.HTTPPorts),
+		UDPAddrs:  addrs(conf.UDPPorts),
+		Interval:  conf.Interval,
+		Expiry:    conf.Expiry,
+	}
+	quit := make(chan struct{})
+	if conf.RPC != "" {
+		client, err := rpc.Dial(conf.RPC)
+		if err != nil {
+			log.Error("Could not dial the torrent API", "rpc", conf.RPC, "err", err)
+			return err
+		}
+		defer client.Close()
+		var known atomic.Value
+		known.Store(make(map[metainfo.Hash]bool))
+		if err := refresh(client, &known); err != nil {
+			log.Error("Could not list the torrents", "rpc", conf.RPC, "err", err)
 			return err
-		}(port)
+		}
+		go func() {
+			ticker := time.NewTicker(conf.Refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := refresh(client, &known); err != nil {
+						log.Warn("Could not refresh the torrents", "rpc", conf.RPC, "err", err)
+					}
+				case <-quit:
+					return
+				}
+			}
+		}()
+		cfg.Filter = func(ih metainfo.Hash) bool {
+			return known.Load().(map[metainfo.Hash]bool)[ih]
+		}
+	}
+
+	t := tracker.New(cfg)
+	if err := t.Start(); err != nil {
+		log.Error("Could not start the tracker", "err", err)
+		return err
 	}
-	conf.wg.Wait()
-	//log.crit("cmd.Start", "err", err)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	<-c
+	close(quit)
+	t.Stop()
 	return nil
 }
 
-func stop(conf *Config) {
-	conf.wg.Wait()
+// refresh replaces the known torrents with the files of the torrentfs
+// storage, loaded or not.
+func refresh(client *rpc.Client, known *atomic.Value) error {
+	var files []struct {
+		InfoHash metainfo.Hash `json:"infoHash"`
+	}
+	if err := client.Call(&files, "torrent_files"); err != nil {
+		return err
+	}
+	set := make(map[metainfo.Hash]bool, len(files))
+	for _, f := range files {
+		set[f.InfoHash] = true
+	}
+	known.Store(set)
+	log.Debug("Tracked torrents refreshed", "torrents", len(set))
+	return nil
 }
@@ -45,6 +45,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/ctxc"
 	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
 	"github.com/CortexFoundation/CortexTheseus/ctxc/gasprice"
+	"github.com/CortexFoundation/CortexTheseus/dashboard"
 	"github.com/CortexFoundation/CortexTheseus/db"
 	// "github.com/CortexFoundation/CortexTheseus/stats"
 	"github.com/CortexFoundation/CortexTheseus/inference/synapse"
@@ -220,26 +221,100 @@ var (
 		Name:  "storage.full",
 		Usage: "download full file",
 	}
+	StorageScrubIntervalFlag = cli.IntFlag{
+		Name:  "storage.scrub",
+		Usage: "Seconds between two verifications of the seeded data (0 = disabled)",
+		Value: torrentfs.DefaultConfig.ScrubInterval,
+	}
+	StorageMaxDiskFlag = cli.Uint64Flag{
+		Name:  "storage.max_disk",
+		Usage: "The maximum disk usage of the torrent data in MB, least used seeds are evicted over it (0 = unlimited)",
+		Value: torrentfs.DefaultConfig.MaxDiskUsage,
+	}
+	StorageProtectBlocksFlag = cli.Uint64Flag{
+		Name:  "storage.protect_blocks",
+		Usage: "Seeds referenced in this many latest blocks are never evicted",
+		Value: torrentfs.DefaultConfig.ProtectBlocks,
+	}
+	StorageModeFlag = cli.StringFlag{
+		Name:  "storage.mode",
+		Usage: `Storage sync mode ("full" fetches every torrent, "lazy" only those requested)`,
+		Value: torrentfs.DefaultConfig.SyncMode,
+	}
+	StorageLazyTimeoutFlag = cli.IntFlag{
+		Name:  "storage.lazy_timeout",
+		Usage: "Seconds the virtual machine waits for the data fetched in lazy mode",
+		Value: torrentfs.DefaultConfig.LazyTimeout,
+	}
+	StorageUploadRateFlag = cli.IntFlag{
+		Name:  "storage.upload_rate",
+		Usage: "Upload limit of the torrent client in KB/s (0 = unlimited)",
+	}
+	StorageDownloadRateFlag = cli.IntFlag{
+		Name:  "storage.download_rate",
+		Usage: "Download limit of the torrent client in KB/s (0 = unlimited)",
+	}
+	StorageTorrentUploadRateFlag = cli.IntFlag{
+		Name:  "storage.torrent_upload_rate",
+		Usage: "Upload limit of each torrent in KB/s (0 = unlimited)",
+	}
+	StorageTorrentDownloadRateFlag = cli.IntFlag{
+		Name:  "storage.torrent_download_rate",
+		Usage: "Download limit of each torrent in KB/s (0 = unlimited)",
+	}
+	StorageMaxConnsFlag = cli.IntFlag{
+		Name:  "storage.max_conns",
+		Usage: "Maximum established connections per torrent (0 = default)",
+	}
+	StorageMaxHalfOpenFlag = cli.IntFlag{
+		Name:  "storage.max_half_open",
+		Usage: "Maximum half-open connections per torrent (0 = default)",
+	}
+	StorageOffPeakFlag = cli.StringFlag{
+		Name:  "storage.off_peak",
+		Usage: `Daily window lifting the rate limits, "HH:MM-HH:MM" in local time`,
+	}
+	StorageBlocklistFlag = cli.StringFlag{
+		Name:  "storage.blocklist",
+		Usage: "File of the info hashes never fetched nor served, reloaded on change",
+	}
+	StorageBlocklistURLFlag = cli.StringFlag{
+		Name:  "storage.blocklist_url",
+		Usage: "URL of a shared blocklist, versioned and signed at the same URL with a .sig suffix",
+	}
+	StorageBlocklistSignerFlag = cli.StringFlag{
+		Name:  "storage.blocklist_signer",
+		Usage: "Address of the key signing the shared blocklist",
+	}
+	StorageBlocklistRefreshFlag = cli.IntFlag{
+		Name:  "storage.blocklist_refresh",
+		Usage: "Seconds between two fetches of the shared blocklist",
+		Value: torrentfs.DefaultConfig.BlocklistRefresh,
+	}
+	StorageKeyFileFlag = cli.StringFlag{
+		Name:  "storage.keyfile",
+		Usage: "File of the keys encrypting the torrent data at rest, created when missing (empty = unencrypted)",
+	}
 	// Dashboard settings
-	// DashboardEnabledFlag = cli.BoolFlag{
-	// 	Name:  metrics.DashboardEnabledFlag,
-	// 	Usage: "Enable the dashboard",
-	// }
-	// DashboardAddrFlag = cli.StringFlag{
-	// 	Name:  "dashboard.addr",
-	// 	Usage: "Dashboard listening interface",
-	// 	Value: dashboard.DefaultConfig.Host,
-	// }
-	// DashboardPortFlag = cli.IntFlag{
-	// 	Name:  "dashboard.host",
-	// 	Usage: "Dashboard listening port",
-	// 	Value: dashboard.DefaultConfig.Port,
-	// }
-	// DashboardRefreshFlag = cli.DurationFlag{
-	// 	Name:  "dashboard.refresh",
-	// 	Usage: "Dashboard metrics collection refresh rate",
-	// 	Value: dashboard.DefaultConfig.Refresh,
-	// }
+	DashboardEnabledFlag = cli.BoolFlag{
+		Name:  metrics.DashboardEnabledFlag,
+		Usage: "Enable the read-only web dashboard",
+	}
+	DashboardAddrFlag = cli.StringFlag{
+		Name:  "dashboard.addr",
+		Usage: "Dashboard listening interface",
+		Value: dashboard.DefaultConfig.Host,
+	}
+	DashboardPortFlag = cli.IntFlag{
+		Name:  "dashboard.port",
+		Usage: "Dashboard listening port",
+		Value: dashboard.DefaultConfig.Port,
+	}
+	DashboardRefreshFlag = cli.DurationFlag{
+		Name:  "dashboard.refresh",
+		Usage: "Dashboard data collection refresh rate",
+		Value: dashboard.DefaultConfig.Refresh,
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = cli.StringFlag{
 		Name:  "txpool.locals",
@@ -403,6 +478,10 @@ var (
 		Name:  "miner.devices",
 		Usage: "the devices used mining, use --miner.devices=0,1",
 	}
+	CuckooPluginVerifyFlag = cli.BoolFlag{
+		Name:  "cuckoo.pluginverify",
+		Usage: "Cross-check block seals with the verifier of the miner plugin",
+	}
 	//	MinerAlgorithmFlag = cli.StringFlag{
 	//		Name:  "miner.algorithm",
 	//		Usage: "use mining algorithm, --miner.algorithm=cuckoo/cuckaroo",
@@ -1141,6 +1220,7 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 	cfg.MinerDevices = ctx.GlobalString(MinerDevicesFlag.Name)
 	cfg.Cuckoo.StrDeviceIds = cfg.MinerDevices
 	cfg.Cuckoo.Threads = ctx.GlobalInt(MinerThreadsFlag.Name)
+	cfg.Cuckoo.PluginVerify = ctx.GlobalBool(CuckooPluginVerifyFlag.Name)
 	//cfg.Cuckoo.Algorithm = ctx.GlobalString(MinerAlgorithmFlag.Name)
 	// cfg.InferURI = ctx.GlobalString(ModelCallInterfaceFlag.Name)
 	cfg.StorageDir = MakeStorageDir(ctx)
@@ -1220,11 +1300,11 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 }
 
 // SetDashboardConfig applies dashboard related command line flags to the config.
-// func SetDashboardConfig(ctx *cli.Context, cfg *dashboard.Config) {
-//	cfg.Host = ctx.GlobalString(DashboardAddrFlag.Name)
-//	cfg.Port = ctx.GlobalInt(DashboardPortFlag.Name)
-//	cfg.Refresh = ctx.GlobalDuration(DashboardRefreshFlag.Name)
-// }
+func SetDashboardConfig(ctx *cli.Context, cfg *dashboard.Config) {
+	cfg.Host = ctx.GlobalString(DashboardAddrFlag.Name)
+	cfg.Port = ctx.GlobalInt(DashboardPortFlag.Name)
+	cfg.Refresh = ctx.GlobalDuration(DashboardRefreshFlag.Name)
+}
 
 // SetTorrentFsConfig applies torrentFs related command line flags to the config.
 func SetTorrentFsConfig(ctx *cli.Context, cfg *torrentfs.Config) {
@@ -1249,9 +1329,25 @@ func SetTorrentFsConfig(ctx *cli.Context, cfg *torrentfs.Config) {
 	log.Debug("FsConfig", "MaxSeedingNum", ctx.GlobalInt(StorageMaxSeedingFlag.Name),
 		"MaxActiveNum", ctx.GlobalInt(StorageMaxActiveFlag.Name))
 	cfg.MaxActiveNum = ctx.GlobalInt(StorageMaxActiveFlag.Name)
-	cfg.SyncMode = ctx.GlobalString(SyncModeFlag.Name)
+	cfg.SyncMode = ctx.GlobalString(StorageModeFlag.Name)
+	cfg.LazyTimeout = ctx.GlobalInt(StorageLazyTimeoutFlag.Name)
+	cfg.UploadRate = ctx.GlobalInt(StorageUploadRateFlag.Name)
+	cfg.DownloadRate = ctx.GlobalInt(StorageDownloadRateFlag.Name)
+	cfg.TorrentUploadRate = ctx.GlobalInt(StorageTorrentUploadRateFlag.Name)
+	cfg.TorrentDownloadRate = ctx.GlobalInt(StorageTorrentDownloadRateFlag.Name)
+	cfg.MaxConns = ctx.GlobalInt(StorageMaxConnsFlag.Name)
+	cfg.MaxHalfOpenConns = ctx.GlobalInt(StorageMaxHalfOpenFlag.Name)
+	cfg.OffPeak = ctx.GlobalString(StorageOffPeakFlag.Name)
+	cfg.BlocklistFile = ctx.GlobalString(StorageBlocklistFlag.Name)
+	cfg.BlocklistURL = ctx.GlobalString(StorageBlocklistURLFlag.Name)
+	cfg.BlocklistSigner = ctx.GlobalString(StorageBlocklistSignerFlag.Name)
+	cfg.BlocklistRefresh = ctx.GlobalInt(StorageBlocklistRefreshFlag.Name)
+	cfg.StorageKeyFile = ctx.GlobalString(StorageKeyFileFlag.Name)
 	cfg.DisableDHT = ctx.GlobalBool(StorageDisableDHTFlag.Name)
 	cfg.FullSeed = ctx.GlobalBool(StorageFullFlag.Name)
+	cfg.ScrubInterval = ctx.GlobalInt(StorageScrubIntervalFlag.Name)
+	cfg.MaxDiskUsage = ctx.GlobalUint64(StorageMaxDiskFlag.Name)
+	cfg.ProtectBlocks = ctx.GlobalUint64(StorageProtectBlocksFlag.Name)
 	cfg.DataDir = MakeStorageDir(ctx)
 }
 
@@ -1260,7 +1356,15 @@ func RegisterCortexService(stack *node.Node, cfg *ctxc.Config) {
 	var err error
 	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
 		fullNode, err := ctxc.New(ctx, cfg)
-		return fullNode, err
+		if err != nil {
+			return nil, err
+		}
+		// Let an embedded storage service follow the chain in-process
+		var fs *torrentfs.TorrentFS
+		if ctx.Service(&fs) == nil {
+			fs.SetChainBackend(ctxc.NewStorageBackend(fullNode))
+		}
+		return fullNode, nil
 	})
 	if err != nil {
 		Fatalf("Failed to register the Cortex service: %v", err)
@@ -1280,11 +1384,14 @@ func RegisterStorageService(stack *node.Node, cfg *torrentfs.Config, commit stri
 }
 
 // RegisterDashboardService adds a dashboard to the stack.
-// func RegisterDashboardService(stack *node.Node, cfg *dashboard.Config, commit string) {
-// 	stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
-// 		return dashboard.New(cfg, commit, ctx.ResolvePath("logs")), nil
-// 	})
-// }
+func RegisterDashboardService(stack *node.Node, cfg *dashboard.Config, commit string) {
+	err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return dashboard.New(cfg, commit, stack.Attach), nil
+	})
+	if err != nil {
+		Fatalf("Failed to register the dashboard service: %v", err)
+	}
+}
 
 // RegisterCortexStatsService configures the Cortex Stats daemon and adds it to
 // the given node.
@@ -1366,7 +1473,7 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	} else {
 		engine = cuckoo.NewFaker()
 		if !ctx.GlobalBool(FakePoWFlag.Name) {
-			engine = cuckoo.New(cuckoo.Config{})
+			engine = cuckoo.New(cuckoo.Config{PluginVerify: ctx.GlobalBool(CuckooPluginVerifyFlag.Name)})
 		}
 	}
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
@@ -863,20 +863,38 @@ func (cuckoo *Cuckoo) Sha3Solution(sol *types.BlockSolution) []byte {
 	return ret
 }
 
+// CuckooVerifyHeader checks that the solution of a header meets the target
+// difficulty, then that it is a cycle of the graph of the seal hash and nonce,
+// of the cuckaroo variant the network mines unless the cuckoo one is
+// configured. With PluginVerify set, the verifier of the miner plugin must
+// agree with the Go one.
 func (cuckoo *Cuckoo) CuckooVerifyHeader(hash []byte, nonce uint64, sol *types.BlockSolution, number uint64, targetDiff *big.Int) (ok bool) {
-	if cuckoo.minerPlugin == nil {
-		err := cuckoo.InitPlugin()
-		if err != nil {
-			log.Error("cuckoo init error", "error", err)
-			return false
-		}
+	sha3hash := common.BytesToHash(cuckoo.Sha3Solution(sol))
+	if sha3hash.Big().Cmp(targetDiff) > 0 {
+		return false
 	}
-	m, err := cuckoo.minerPlugin.Lookup("CuckooVerify_cuckaroo")
+	verify, symbol := verifyCuckaroo, "CuckooVerify_cuckaroo"
+	if cuckoo.config.Algorithm == "cuckoo" {
+		verify, symbol = verifyCuckoo, "CuckooVerify"
+	}
+	err := verify(hash, nonce, sol)
 	if err != nil {
-		log.Error("cuckoo", "lookup cuckaroo verify error.", err)
-		return false
+		log.Trace("Invalid cuckoo cycle", "number", number, "algorithm", symbol, "err", err)
+	}
+	if cuckoo.config.PluginVerify {
+		if cuckoo.minerPlugin == nil {
+			log.Error("Cuckoo plugin verification enabled without plugin", "number", number)
+			return false
+		}
+		m, perr := cuckoo.minerPlugin.Lookup(symbol)
+		if perr != nil {
+			log.Error("Cuckoo plugin verify lookup failed", "symbol", symbol, "err", perr)
+			return false
+		}
+		if r := m.(func(*byte, uint64, types.BlockSolution, []byte, *big.Int) bool)(&hash[0], nonce, *sol, sha3hash.Bytes(), targetDiff); r != (err == nil) {
+			log.Error("Cuckoo verifiers disagree", "number", number, "plugin", r, "err", err)
+			return false
+		}
 	}
-	r := m.(func(*byte, uint64, types.BlockSolution, []byte, *big.Int) bool)(&hash[0], nonce, *sol, cuckoo.Sha3Solution(sol), targetDiff)
-	//r = CuckooVerify(&hash[0], len(hash), nonce, sol[:], nil, nil)
-	return r
+	return err == nil
 }
@@ -77,6 +77,8 @@ type Config struct {
 	StrDeviceIds string
 	Threads      int
 	Algorithm    string
+
+	PluginVerify bool // Cross-check the seals verified in Go with the miner plugin
 }
 
 type Cuckoo struct {
@@ -1,48 +1,72 @@
 package cuckoo
 
 import (
-	"fmt"
 	"math/big"
 	"testing"
 
+	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/params"
 )
 
-func TestTestMode(t *testing.T) {
+// testHeader is a block 1 header on top of the mainnet genesis, sealed on the
+// cuckaroo graph of 2^30 edges the network uses. The solution was found with
+// the sources of the miner library built with EDGEBITS=30 and is accepted by
+// its verifier; the header is not a recorded mainnet block.
+func testHeader() *types.Header {
+	return &types.Header{
+		ParentHash:  params.MainnetGenesisHash,
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    common.HexToAddress("0xb84041d064397bd8a1037220d996c16410c20f11"),
+		Root:        types.EmptyRootHash,
+		TxHash:      types.EmptyRootHash,
+		ReceiptHash: types.EmptyRootHash,
+		Difficulty:  big.NewInt(1),
+		Number:      big.NewInt(1),
+		GasLimit:    params.GenesisGasLimit,
+		Time:        big.NewInt(1561100400),
+		Nonce:       types.EncodeNonce(31),
+		Solution: types.BlockSolution{
+			27403103, 134901180, 184699608, 187440186, 236425033, 271523898, 293236225,
+			297632972, 321227770, 363142408, 365840680, 369986105, 371668205, 372465114,
+			400554023, 445362700, 459740676, 477979692, 490348451, 523383928, 572228429,
+			573420971, 619442012, 664598681, 678285017, 691166513, 740518219, 763047826,
+			765431832, 765777345, 772602511, 788273559, 797658660, 834501578, 906830596,
+			954657793, 956801837, 967439575, 983659416, 998012480, 1060872453, 1064094641,
+		},
+	}
+}
 
+func TestTestMode(t *testing.T) {
 	cuckoo := NewTester()
-	for i := 0; i < 1; i++ {
-		head := &types.Header{
-			Number:     big.NewInt(int64(i)),
-			Difficulty: big.NewInt(2),
-			Time:       big.NewInt(int64(i) * int64(i)),
-		}
-		target := new(big.Int).Div(maxUint256, head.Difficulty).Bytes()
-		fmt.Println(target)
-		// t.Fatal()
-		// target := big.NewInt(0)
-		// target.Exp(big.NewInt(2), big.NewInt(256), nil)
-		// target.Sub(target, head.Difficulty)
-		// target.Sub(target, big.NewInt(1))
-		// head.Difficulty = target
-		nb := types.NewBlockWithHeader(head)
-		block, err := cuckoo.Seal(nil, nb, nil)
-		if err != nil {
-			t.Fatalf("failed to seal block: %v", err)
-		}
-		// t.Fatalf("1")
-		// t.Fatal(block.Solution())
-		// t.Fatal(block.Header().SolutionHash)
-		// head.Nonce = types.EncodeNonce(block.Nonce())
-		// head.Solution = block.Solution()
-		// // t.Log(head.Solution)
-		// // head.Solution[0] = head.Solution[1]
-		// head.SolutionHash = block.Header().SolutionHash
-		t.Log(block.Header())
-		t.Fatal()
-		if err := cuckoo.VerifySeal(nil, block.Header()); err != nil {
-			t.Fatalf("unexpected verification error: %v", err)
-		}
-	}
 
+	header := testHeader()
+	if hash := cuckoo.SealHash(header); hash != common.HexToHash("0x3618ec78e45ee8ed34ad67869411a8108cf830a30a188c75ddd6bb1e629b8f75") {
+		t.Fatalf("seal hash mismatch: %x", hash)
+	}
+	if err := cuckoo.VerifySeal(nil, header); err != nil {
+		t.Fatalf("unexpected verification error: %v", err)
+	}
+	// The solution doesn't meet a difficulty above its own
+	sha3 := common.BytesToHash(cuckoo.Sha3Solution(&header.Solution)).Big()
+	header.Difficulty = new(big.Int).Add(new(big.Int).Div(maxUint256, sha3), common.Big1)
+	if err := cuckoo.VerifySeal(nil, header); err != errInvalidPoW {
+		t.Errorf("solution above the target: have %v, want %v", err, errInvalidPoW)
+	}
+	// Nor is it a cycle of another nonce, header or set of edges
+	header = testHeader()
+	header.Nonce = types.EncodeNonce(header.Nonce.Uint64() + 1)
+	if err := cuckoo.VerifySeal(nil, header); err != errInvalidPoW {
+		t.Errorf("another nonce: have %v, want %v", err, errInvalidPoW)
+	}
+	header = testHeader()
+	header.Time = big.NewInt(1561100401)
+	if err := cuckoo.VerifySeal(nil, header); err != errInvalidPoW {
+		t.Errorf("another header: have %v, want %v", err, errInvalidPoW)
+	}
+	header = testHeader()
+	header.Solution[proofSize-1]--
+	if err := cuckoo.VerifySeal(nil, header); err != errInvalidPoW {
+		t.Errorf("replaced edge: have %v, want %v", err, errInvalidPoW)
+	}
 }
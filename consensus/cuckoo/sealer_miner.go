@@ -59,12 +59,7 @@ search:
 			}
 			copy(result[:], res[0][0:len(res[0])])
 
-			m, err = cuckoo.minerPlugin.Lookup("CuckooVerify_cuckaroo")
-			if err != nil {
-				return err
-			}
-			ret := m.(func(*byte, uint64, types.BlockSolution, []byte, *big.Int) bool)(&hash[0], nonce, result, cuckoo.Sha3Solution(&result), target)
-			if ret {
+			if cuckoo.CuckooVerifyHeader(hash, nonce, &result, header.Number.Uint64(), target) {
 				// Correct solution found, create a new header with it
 				header = types.CopyHeader(header)
 				header.Nonce = types.EncodeNonce(uint64(nonce))
@@ -0,0 +1,206 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto/blake2b"
+)
+
+// Parameters of the Cuckoo Cycle graphs mined on the network, matching the
+// EDGEBITS and PROOFSIZE the miner library is built with.
+const (
+	edgeBits      = 30 // 2-log of the number of edges, and of the nodes on each side
+	proofSize     = 42 // length of the cycle of a solution
+	edgeBlockBits = 6  // 2-log of the number of edges hashed together by cuckaroo
+	edgeBlockSize = 1 << edgeBlockBits
+	edgeBlockMask = edgeBlockSize - 1
+)
+
+var (
+	errEdgeTooBig   = errors.New("edge too big")
+	errEdgeTooSmall = errors.New("edges not ascending")
+	errNonMatching  = errors.New("endpoints don't match up")
+	errBranch       = errors.New("branch in cycle")
+	errDeadEnd      = errors.New("cycle dead ends")
+	errShortCycle   = errors.New("cycle too short")
+)
+
+// sipKeys are the siphash keys generating the graph of a header.
+type sipKeys [4]uint64
+
+// newSipKeys derives the keys of the graph of the seal hash and nonce of a
+// header, from the blake2b hash of the seal hash followed by the little
+// endian nonce.
+func newSipKeys(hash []byte, nonce uint64) *sipKeys {
+	var buf [40]byte
+	copy(buf[:32], hash)
+	binary.LittleEndian.PutUint64(buf[32:], nonce)
+	sum := blake2b.Sum256(buf[:])
+
+	var keys sipKeys
+	for i := range keys {
+		keys[i] = binary.LittleEndian.Uint64(sum[i*8:])
+	}
+	return &keys
+}
+
+// sipState is the state of the siphash of the four keys.
+type sipState struct {
+	v0, v1, v2, v3 uint64
+}
+
+func (s *sipState) round() {
+	s.v0 += s.v1
+	s.v2 += s.v3
+	s.v1 = bits.RotateLeft64(s.v1, 13)
+	s.v3 = bits.RotateLeft64(s.v3, 16)
+	s.v1 ^= s.v0
+	s.v3 ^= s.v2
+	s.v0 = bits.RotateLeft64(s.v0, 32)
+	s.v2 += s.v1
+	s.v0 += s.v3
+	s.v1 = bits.RotateLeft64(s.v1, 17)
+	s.v3 = bits.RotateLeft64(s.v3, 21)
+	s.v1 ^= s.v2
+	s.v3 ^= s.v0
+	s.v2 = bits.RotateLeft64(s.v2, 32)
+}
+
+// hash24 hashes nonce from the current state. The miner library runs twice
+// the rounds of the reference siphash-2-4: four per message, eight to finalize.
+func (s *sipState) hash24(nonce uint64) {
+	s.v3 ^= nonce
+	for i := 0; i < 4; i++ {
+		s.round()
+	}
+	s.v0 ^= nonce
+	s.v2 ^= 0xff
+	for i := 0; i < 8; i++ {
+		s.round()
+	}
+}
+
+func (s *sipState) xorLanes() uint64 {
+	return (s.v0 ^ s.v1) ^ (s.v2 ^ s.v3)
+}
+
+// siphash24 returns the siphash of nonce.
+func (k *sipKeys) siphash24(nonce uint64) uint64 {
+	s := sipState{k[0], k[1], k[2], k[3]}
+	s.hash24(nonce)
+	return s.xorLanes()
+}
+
+// sipBlock returns the cuckaroo hash of edge: the block of edges containing
+// it is hashed in a chain, then xored with the hash of the last edge.
+func (k *sipKeys) sipBlock(edge uint64) uint64 {
+	var buf [edgeBlockSize]uint64
+
+	s := sipState{k[0], k[1], k[2], k[3]}
+	edge0 := edge &^ edgeBlockMask
+	for i := uint64(0); i < edgeBlockSize; i++ {
+		s.hash24(edge0 + i)
+		buf[i] = s.xorLanes()
+	}
+	if edge&edgeBlockMask == edgeBlockMask {
+		return buf[edgeBlockMask]
+	}
+	return buf[edge&edgeBlockMask] ^ buf[edgeBlockMask]
+}
+
+// cuckooEndpoints returns the endpoints of the edges of a cuckoo graph with
+// 2^bits edges.
+func cuckooEndpoints(keys *sipKeys, edge uint64, bits uint) (uint64, uint64) {
+	mask := uint64(1)<<bits - 1
+	return keys.siphash24(2*edge) & mask, keys.siphash24(2*edge+1) & mask
+}
+
+// cuckarooEndpoints returns the endpoints of the edges of a cuckaroo graph
+// with 2^bits edges.
+func cuckarooEndpoints(keys *sipKeys, edge uint64, bits uint) (uint64, uint64) {
+	mask := uint64(1)<<bits - 1
+	hash := keys.sipBlock(edge)
+	return hash & mask, (hash >> 32) & mask
+}
+
+// verifyCycle checks that the ascending edges of sol form a single cycle of
+// proofSize edges in the graph of 2^bits edges whose endpoints are generated
+// by endpoints.
+func verifyCycle(keys *sipKeys, sol []uint32, bits uint, endpoints func(*sipKeys, uint64, uint) (uint64, uint64)) error {
+	var (
+		uvs        [2 * proofSize]uint64
+		xor0, xor1 uint64
+		mask       = uint64(1)<<bits - 1
+	)
+	for n := 0; n < proofSize; n++ {
+		if uint64(sol[n]) > mask {
+			return errEdgeTooBig
+		}
+		if n > 0 && sol[n] <= sol[n-1] {
+			return errEdgeTooSmall
+		}
+		uvs[2*n], uvs[2*n+1] = endpoints(keys, uint64(sol[n]), bits)
+		xor0 ^= uvs[2*n]
+		xor1 ^= uvs[2*n+1]
+	}
+	// Each node of a cycle is the endpoint of two of its edges
+	if xor0|xor1 != 0 {
+		return errNonMatching
+	}
+	// Follow the cycle, alternating between the two sides of the graph
+	n, i := 0, 0
+	for {
+		j := i
+		for k := (i + 2) % (2 * proofSize); k != i; k = (k + 2) % (2 * proofSize) {
+			if uvs[k] == uvs[i] {
+				if j != i {
+					return errBranch
+				}
+				j = k
+			}
+		}
+		if j == i {
+			return errDeadEnd
+		}
+		i = j ^ 1
+		n++
+		if i == 0 {
+			break
+		}
+	}
+	if n != proofSize {
+		return errShortCycle
+	}
+	return nil
+}
+
+// verifyCuckoo checks that sol is a cycle of the cuckoo graph of the seal
+// hash and nonce of a header.
+func verifyCuckoo(hash []byte, nonce uint64, sol *types.BlockSolution) error {
+	return verifyCycle(newSipKeys(hash, nonce), sol[:], edgeBits, cuckooEndpoints)
+}
+
+// verifyCuckaroo checks that sol is a cycle of the cuckaroo graph of the seal
+// hash and nonce of a header.
+func verifyCuckaroo(hash []byte, nonce uint64, sol *types.BlockSolution) error {
+	return verifyCycle(newSipKeys(hash, nonce), sol[:], edgeBits, cuckarooEndpoints)
+}
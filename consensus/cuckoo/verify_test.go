@@ -0,0 +1,273 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package cuckoo
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+)
+
+// Cycles of graphs of 2^19 edges, accepted by the verifiers of the miner
+// library built with EDGEBITS=19.
+var cycleTests = []struct {
+	cuckaroo bool
+	hash     string
+	nonce    uint64
+	sol      [proofSize]uint32
+}{
+	{
+		cuckaroo: true,
+		hash:     "0x497bb4dd689ba04d0dae68a083d6a5e874c41ce4ae769dddb621c1643239bd37",
+		nonce:    10973989770659788770,
+		sol: [proofSize]uint32{
+			4604, 12817, 22810, 72598, 73158, 100514, 102940, 124833, 138362, 162694, 172023, 178659, 193230, 194675,
+			204511, 229399, 241127, 252762, 286974, 288547, 296629, 316761, 332603, 343905, 355471, 387858, 388220, 399618,
+			404176, 404499, 408932, 412136, 423984, 446226, 452359, 466099, 478890, 482044, 486116, 487807, 495619, 520721,
+		},
+	},
+	{
+		cuckaroo: true,
+		hash:     "0x2d21a3c02d4e8b1fda499d70e46fccbf5e74aba28fa91c1a90f3ec414353f3c6",
+		nonce:    15541826549274096667,
+		sol: [proofSize]uint32{
+			3085, 16585, 23119, 38301, 69181, 74037, 76524, 83179, 85953, 129347, 219516, 221501, 229090, 244049,
+			255476, 265376, 266493, 275502, 291344, 297185, 306211, 312583, 313920, 321198, 326325, 343931, 354481, 365242,
+			397016, 409358, 413345, 441269, 458689, 464353, 467800, 483759, 494534, 497569, 505254, 510037, 510766, 518754,
+		},
+	},
+	{
+		cuckaroo: true,
+		hash:     "0x29df46e5cddeebbf97c8321d86c086e4a0bb054b39b45f38ef12cb156da706e7",
+		nonce:    8601271332103200767,
+		sol: [proofSize]uint32{
+			20448, 22475, 42112, 53877, 83243, 90646, 93295, 115195, 150844, 160246, 162941, 165980, 174375, 192050,
+			198691, 202493, 219631, 230234, 235876, 237501, 245795, 292137, 294673, 294946, 309100, 318780, 323957, 327915,
+			342248, 346228, 352080, 366062, 387169, 403437, 424396, 428270, 437648, 460140, 485480, 492746, 495192, 503267,
+		},
+	},
+	{
+		cuckaroo: false,
+		hash:     "0x57b070150236215e65b4d61ba85b6a16286df66f6f6297fb3eee1dea4b994c5f",
+		nonce:    6230598153474941526,
+		sol: [proofSize]uint32{
+			19949, 29003, 30279, 43898, 66364, 79202, 81126, 88387, 91369, 108986, 136390, 146027, 155381, 162622,
+			162990, 165384, 196165, 250330, 262651, 264429, 302669, 306664, 310007, 318177, 324895, 340874, 341092, 369410,
+			388534, 414009, 419877, 427491, 429853, 433374, 433513, 435303, 436956, 454930, 463044, 500072, 506587, 510036,
+		},
+	},
+	{
+		cuckaroo: false,
+		hash:     "0x65828ced87a07921a5ac1292233f97c5505d5a63c1a6db420e438ddfed6f64e4",
+		nonce:    5186034191373460436,
+		sol: [proofSize]uint32{
+			29538, 57678, 78712, 80911, 123901, 149852, 150337, 151156, 152889, 165982, 187989, 199582, 200157, 204407,
+			245877, 249154, 256346, 273138, 279129, 291196, 291355, 295338, 315864, 326837, 345798, 350297, 368821, 376352,
+			377663, 393442, 398896, 415428, 445296, 445428, 452286, 457898, 464570, 467624, 504657, 506786, 508228, 518059,
+		},
+	},
+	{
+		cuckaroo: false,
+		hash:     "0x0a43ce307b066f31562ef4c2e2f1cc6e5b18af051fd941cca949a85adcdfec4d",
+		nonce:    9218804591686263246,
+		sol: [proofSize]uint32{
+			9449, 28457, 33906, 51212, 57048, 68941, 70185, 74566, 94279, 114834, 117478, 117865, 120697, 126988,
+			129631, 140044, 147336, 152131, 188789, 243985, 244114, 250166, 253243, 288966, 289682, 303909, 320521, 350455,
+			358296, 366667, 375142, 405515, 429264, 444025, 444053, 460810, 461561, 471186, 482740, 489262, 493000, 523982,
+		},
+	},
+}
+
+// Endpoints of edges of the mainnet graphs, as generated by the miner library.
+var endpointTests = []struct {
+	hash             string
+	nonce, edge      uint64
+	cuckooU, cuckooV uint64
+	rooU, rooV       uint64
+}{
+	{"0xf3ff4d451e429e182215aaee06a2d64b6d1aadc9e5031e4b99bf11ae0a796ebc", 1348050685572117713, 13459957, 610964958, 162878929, 916964552, 722958229},
+	{"0x44c85f6209385c6601ddb3fc1472b881d99c8428183c3fae7166ecbd7cc3ba26", 1336974230205902639, 172947200, 563773303, 52193257, 821923211, 461391752},
+	{"0xc55e2f5169c91c0d577477c4b2615a7b07d8dd0abb8f6026f0560c8be735092e", 7860306706849867314, 354559173, 904036850, 22997143, 379740103, 166762317},
+	{"0x6fd15f2c30531c00dd92d4e7d5b2c494d150ad051371aed6b0eae1a7723c552f", 15825244870033004841, 259566597, 574923499, 941052758, 993732300, 218752916},
+	{"0x2a15b56f91cf81def8e1fa903b13363e45f90dd7afadeadf2034f49bd58cf46e", 12431246918855007854, 71874851, 411328953, 686953040, 63304103, 180005957},
+	{"0x9f9001f27bfb101f0737b6b793a57890d5056c85a2cf6b3d25c5346722acd240", 13289094562171770244, 100715927, 974976309, 599281142, 372620130, 797767493},
+	{"0x44cdcc73cb701e43bc9e40a1a57a9f80e4a79df221c8196421a6de653d73c4f3", 1853410386881019018, 1043771340, 238308082, 1012016015, 709451596, 671960950},
+	{"0x03baa95e2b531a5401a16901da5c0acce81aa5efa2efa7a64365868a58de78b5", 6191077824805765617, 1062296753, 332498489, 436122308, 374740158, 695356660},
+}
+
+func TestEndpoints(t *testing.T) {
+	for i, tt := range endpointTests {
+		keys := newSipKeys(hexutil.MustDecode(tt.hash), tt.nonce)
+		if u, v := cuckooEndpoints(keys, tt.edge, edgeBits); u != tt.cuckooU || v != tt.cuckooV {
+			t.Errorf("test %d: cuckoo endpoints mismatch: have %d-%d, want %d-%d", i, u, v, tt.cuckooU, tt.cuckooV)
+		}
+		if u, v := cuckarooEndpoints(keys, tt.edge, edgeBits); u != tt.rooU || v != tt.rooV {
+			t.Errorf("test %d: cuckaroo endpoints mismatch: have %d-%d, want %d-%d", i, u, v, tt.rooU, tt.rooV)
+		}
+	}
+}
+
+func TestVerifyCycle(t *testing.T) {
+	const bits = 19
+	for i, tt := range cycleTests {
+		endpoints, other := cuckooEndpoints, cuckarooEndpoints
+		if tt.cuckaroo {
+			endpoints, other = other, endpoints
+		}
+		keys := newSipKeys(hexutil.MustDecode(tt.hash), tt.nonce)
+		sol := tt.sol
+		if err := verifyCycle(keys, sol[:], bits, endpoints); err != nil {
+			t.Errorf("test %d: valid cycle rejected: %v", i, err)
+		}
+		// Not a cycle of the other variant, nor of another nonce
+		if err := verifyCycle(keys, sol[:], bits, other); err == nil {
+			t.Errorf("test %d: cycle accepted by the other variant", i)
+		}
+		if err := verifyCycle(newSipKeys(hexutil.MustDecode(tt.hash), tt.nonce+1), sol[:], bits, endpoints); err == nil {
+			t.Errorf("test %d: cycle accepted with another nonce", i)
+		}
+		// Tampered edges
+		sol[3], sol[4] = sol[4], sol[3]
+		if err := verifyCycle(keys, sol[:], bits, endpoints); err != errEdgeTooSmall {
+			t.Errorf("test %d: swapped edges: have %v, want %v", i, err, errEdgeTooSmall)
+		}
+		sol = tt.sol
+		sol[proofSize-1] = 1 << bits
+		if err := verifyCycle(keys, sol[:], bits, endpoints); err != errEdgeTooBig {
+			t.Errorf("test %d: edge out of the graph: have %v, want %v", i, err, errEdgeTooBig)
+		}
+		sol = tt.sol
+		sol[0]--
+		if err := verifyCycle(keys, sol[:], bits, endpoints); err == nil {
+			t.Errorf("test %d: replaced edge accepted", i)
+		}
+	}
+}
+
+// ring returns the endpoints of the edges of a cycle of 2*n edges, the edge
+// 2k joining the nodes u_k and v_k, the edge 2k+1 the nodes u_k+1 and v_k.
+func ring(e, n, offset uint64) (uint64, uint64) {
+	k := e / 2
+	if e%2 == 0 {
+		return offset + k, offset + k
+	}
+	return offset + (k+1)%n, offset + k
+}
+
+// Tests the cycle following on graphs laid out by hand.
+func TestVerifyCycleShape(t *testing.T) {
+	var sol [proofSize]uint32
+	for i := range sol {
+		sol[i] = uint32(i)
+	}
+	tests := []struct {
+		name  string
+		edges func(e uint64) (uint64, uint64)
+		err   error
+	}{
+		{
+			"cycle",
+			func(e uint64) (uint64, uint64) { return ring(e, proofSize/2, 0) },
+			nil,
+		},
+		{
+			// Two cycles of 20 and 22 edges
+			"short",
+			func(e uint64) (uint64, uint64) {
+				if e < 20 {
+					return ring(e, 10, 0)
+				}
+				return ring(e-20, 11, 100)
+			},
+			errShortCycle,
+		},
+		{
+			// The first edge ends at a node of its own, with the other
+			// nodes moved to keep the endpoints matching up
+			"dead end",
+			func(e uint64) (uint64, uint64) {
+				_, v := ring(e, proofSize/2, 0)
+				switch e {
+				case 0:
+					return 64, v
+				case 1:
+					return 128, v
+				case 2:
+					return 64 ^ 128, v
+				}
+				return ring(e, proofSize/2, 0)
+			},
+			errDeadEnd,
+		},
+		{
+			// The first three edges are moved onto the first node, which
+			// the last edge closing the cycle also joins
+			"branch",
+			func(e uint64) (uint64, uint64) {
+				if _, v := ring(e, proofSize/2, 0); e < 3 {
+					return 0, v
+				}
+				return ring(e, proofSize/2, 0)
+			},
+			errBranch,
+		},
+	}
+	for _, tt := range tests {
+		endpoints := func(keys *sipKeys, edge uint64, bits uint) (uint64, uint64) { return tt.edges(edge) }
+		if err := verifyCycle(&sipKeys{}, sol[:], edgeBits, endpoints); err != tt.err {
+			t.Errorf("%s: have %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCuckooVerifyHeader(t *testing.T) {
+	cuckoo := &Cuckoo{}
+
+	var sol types.BlockSolution
+	for i := range sol {
+		sol[i] = uint32(i)
+	}
+	hash := common.HexToHash("0x01").Bytes()
+	sha3 := common.BytesToHash(cuckoo.Sha3Solution(&sol)).Big()
+
+	// Rejected on the difficulty before the cycle is checked
+	if cuckoo.CuckooVerifyHeader(hash, 0, &sol, 1, new(big.Int).Sub(sha3, common.Big1)) {
+		t.Error("solution above the target accepted")
+	}
+	if cuckoo.CuckooVerifyHeader(hash, 0, &sol, 1, sha3) {
+		t.Error("invalid cycle accepted")
+	}
+
+	// A cuckaroo seal is not a cuckoo cycle, and isn't accepted with the
+	// plugin cross-check enabled but no plugin to run it
+	header := testHeader()
+	hash = cuckoo.SealHash(header).Bytes()
+	if !cuckoo.CuckooVerifyHeader(hash, header.Nonce.Uint64(), &header.Solution, 1, maxUint256) {
+		t.Error("cuckaroo seal rejected")
+	}
+	cuckoo.config.Algorithm = "cuckoo"
+	if cuckoo.CuckooVerifyHeader(hash, header.Nonce.Uint64(), &header.Solution, 1, maxUint256) {
+		t.Error("cuckaroo seal accepted as a cuckoo cycle")
+	}
+	cuckoo.config = Config{PluginVerify: true}
+	if cuckoo.CuckooVerifyHeader(hash, header.Nonce.Uint64(), &header.Solution, 1, maxUint256) {
+		t.Error("seal accepted without the plugin to cross-check it")
+	}
+}
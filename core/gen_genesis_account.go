@@ -5,12 +5,12 @@ package core
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"math/big"
 
 	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/common/math"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
 )
 
 var _ = (*genesisAccountMarshaling)(nil)
@@ -23,6 +23,8 @@ func (g GenesisAccount) MarshalJSON() ([]byte, error) {
 		Nonce      math.HexOrDecimal64         `json:"nonce,omitempty"`
 		BlockNum   math.HexOrDecimal64         `json:"blocknum,omitempty"`
 		PrivateKey hexutil.Bytes               `json:"secretKey,omitempty"`
+		Model      *types.ModelMeta            `json:"model,omitempty"`
+		Input      *types.InputMeta            `json:"input,omitempty"`
 	}
 	var enc GenesisAccount
 	enc.Code = g.Code
@@ -35,7 +37,8 @@ func (g GenesisAccount) MarshalJSON() ([]byte, error) {
 	enc.Balance = (*math.HexOrDecimal256)(g.Balance)
 	enc.Nonce = math.HexOrDecimal64(g.Nonce)
 	enc.PrivateKey = g.PrivateKey
-	fmt.Println("enc = ", enc)
+	enc.Model = g.Model
+	enc.Input = g.Input
 	return json.Marshal(&enc)
 }
 
@@ -47,6 +50,8 @@ func (g *GenesisAccount) UnmarshalJSON(input []byte) error {
 		Nonce      *math.HexOrDecimal64        `json:"nonce,omitempty"`
 		BlockNum   *math.HexOrDecimal64        `json:"blocknum,omitempty"`
 		PrivateKey *hexutil.Bytes              `json:"secretKey,omitempty"`
+		Model      *types.ModelMeta            `json:"model,omitempty"`
+		Input      *types.InputMeta            `json:"input,omitempty"`
 	}
 	var dec GenesisAccount
 	if err := json.Unmarshal(input, &dec); err != nil {
@@ -74,6 +79,11 @@ func (g *GenesisAccount) UnmarshalJSON(input []byte) error {
 	if dec.BlockNum != nil {
 		g.BlockNum = big.NewInt(int64(*dec.BlockNum))
 	}
-	fmt.Println("dec: ", dec, *dec.BlockNum, "g.BlockNum = ", g.BlockNum)
+	if dec.Model != nil {
+		g.Model = dec.Model
+	}
+	if dec.Input != nil {
+		g.Input = dec.Input
+	}
 	return nil
 }
@@ -87,6 +87,102 @@ type GenesisAccount struct {
 	BlockNum   *big.Int                    `json:"blocknum"`
 	Nonce      uint64                      `json:"nonce,omitempty"`
 	PrivateKey []byte                      `json:"secretKey,omitempty"` // for tests
+
+	// Model and Input pre-register a fully uploaded meta at the account, so
+	// private networks and fixtures don't have to replay upload transactions.
+	// At most one of them may be set, and Code must be empty then.
+	Model *types.ModelMeta `json:"model,omitempty"`
+	Input *types.InputMeta `json:"input,omitempty"`
+}
+
+var (
+	errGenesisMetaCode     = errors.New("genesis meta account can't carry code")
+	errGenesisMetaConflict = errors.New("genesis account can't register both a model and an input")
+)
+
+// metaCode returns the meta code of a pre-registered model or input born at
+// the given block, nil if the account registers neither.
+func (a *GenesisAccount) metaCode(birth *big.Int) ([]byte, error) {
+	switch {
+	case a.Model != nil && a.Input != nil:
+		return nil, errGenesisMetaConflict
+	case a.Model != nil:
+		if len(a.Code) > 0 {
+			return nil, errGenesisMetaCode
+		}
+		meta := *a.Model
+		if meta.RawSize <= params.MODEL_MIN_UPLOAD_BYTES || meta.RawSize > params.MODEL_MAX_UPLOAD_BYTES {
+			return nil, fmt.Errorf("invalid model raw size %d", meta.RawSize)
+		}
+		if meta.Hash == (common.Address{}) {
+			return nil, errors.New("model without info hash")
+		}
+		if len(meta.InputShape) == 0 || len(meta.OutputShape) == 0 {
+			return nil, errors.New("model without input or output shape")
+		}
+		if meta.AuthorAddress == (common.Address{}) {
+			return nil, errors.New("model without author")
+		}
+		if meta.Gas > params.MODEL_GAS_LIMIT {
+			return nil, fmt.Errorf("model gas %d exceeds limit %d", meta.Gas, params.MODEL_GAS_LIMIT)
+		}
+		if len(meta.Royalties) > 0 {
+			if err := meta.ValidateRoyalties(); err != nil {
+				return nil, err
+			}
+		}
+		meta.BlockNum = *birth
+		code, err := meta.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{0, 1}, code...), nil
+	case a.Input != nil:
+		if len(a.Code) > 0 {
+			return nil, errGenesisMetaCode
+		}
+		meta := *a.Input
+		if meta.RawSize == 0 {
+			return nil, errors.New("input without raw size")
+		}
+		if meta.Hash == (common.Address{}) {
+			return nil, errors.New("input without info hash")
+		}
+		if len(meta.Shape) == 0 {
+			return nil, errors.New("input without shape")
+		}
+		meta.BlockNum = *birth
+		code, err := meta.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{0, 2}, code...), nil
+	}
+	return nil, nil
+}
+
+// metaBirth returns the birth block of the metas pre-registered in the genesis
+// allocation: the genesis block itself, or block 1 for a genesis at block 0 as
+// the CVM takes a zero birth block for a meta that was never uploaded. ToBlock
+// marks them with types.GenesisMetaKey so they skip the maturity window.
+func (g *Genesis) metaBirth() *big.Int {
+	if g.Number == 0 {
+		return big.NewInt(1)
+	}
+	return new(big.Int).SetUint64(g.Number)
+}
+
+// Validate checks the models and inputs pre-registered in the genesis allocation.
+func (g *Genesis) Validate() error {
+	for addr, account := range g.Alloc {
+		if _, err := account.metaCode(g.metaBirth()); err != nil {
+			return fmt.Errorf("invalid genesis meta at %x: %v", addr, err)
+		}
+		if account.Model != nil && len(account.Model.Royalties) > 0 && g.Config != nil && !g.Config.IsRoyalty(new(big.Int).SetUint64(g.Number)) {
+			return fmt.Errorf("invalid genesis meta at %x: royalties before the royalty fork", addr)
+		}
+	}
+	return nil
 }
 
 // field type overrides for gencodec
@@ -162,6 +258,11 @@ func SetupGenesisBlockWithOverride(db ctxcdb.Database, genesis *Genesis, overrid
 	if genesis != nil && genesis.Config == nil {
 		return params.AllCuckooProtocolChanges, common.Hash{}, errGenesisNoConfig
 	}
+	if genesis != nil {
+		if err := genesis.Validate(); err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
+	}
 
 	// Just commit the new block if there is no stored genesis block.
 	stored := rawdb.ReadCanonicalHash(db, 0)
@@ -255,7 +356,9 @@ func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 }
 
 // ToBlock creates the genesis block and writes state of a genesis specification
-// to the given database (or discards it if nil).
+// to the given database (or discards it if nil). The metas of an allocation
+// failing Validate are left out, Commit and SetupGenesisBlock return its error
+// instead.
 func (g *Genesis) ToBlock(db ctxcdb.Database) *types.Block {
 	if db == nil {
 		db = rawdb.NewMemoryDatabase()
@@ -268,7 +371,16 @@ func (g *Genesis) ToBlock(db ctxcdb.Database) *types.Block {
 		for key, value := range account.Storage {
 			statedb.SetState(addr, key, value)
 		}
-		statedb.SetNum(addr, account.BlockNum)
+		if code, err := account.metaCode(g.metaBirth()); err != nil {
+			log.Error("Invalid genesis meta left out", "address", addr, "err", err)
+		} else if code != nil {
+			statedb.SetCode(addr, code)
+			statedb.SetUpload(addr, big.NewInt(0))
+			statedb.SetNum(addr, g.metaBirth())
+			statedb.SetState(addr, types.GenesisMetaKey, common.BytesToHash([]byte{1}))
+		} else {
+			statedb.SetNum(addr, account.BlockNum)
+		}
 	}
 	root := statedb.IntermediateRoot(false)
 	head := &types.Header{
@@ -300,6 +412,9 @@ func (g *Genesis) ToBlock(db ctxcdb.Database) *types.Block {
 // Commit writes the block and state of a genesis specification to the database.
 // The block is committed as the canonical head block.
 func (g *Genesis) Commit(db ctxcdb.Database) (*types.Block, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
 	block := g.ToBlock(db)
 	if block.Number().Sign() != 0 {
 		return nil, fmt.Errorf("can't commit genesis block with number > 0")
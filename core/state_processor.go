@@ -106,7 +106,8 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 
 	qp := new(big.Int).Sub(header.Quota, header.QuotaUsed)
 	// Apply the transaction to the current state (included in the env)
-	_, gas, quota, failed, err := ApplyMessage(vmenv, msg, gp, qp)
+	st := NewStateTransition(vmenv, msg, gp, qp)
+	_, gas, quota, failed, err := st.TransitionDb()
 	if err != nil {
 		return nil, 0, err
 	}
@@ -135,6 +136,7 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt := types.NewReceipt(root, failed, *usedGas)
 	receipt.TxHash = tx.Hash()
 	receipt.GasUsed = gas
+	receipt.Royalties = st.Royalties()
 	// if the transaction created a contract, store the creation address in the receipt.
 	if msg.To() == nil {
 		receipt.ContractAddress = crypto.CreateAddress(vmenv.Context.Origin, tx.Nonce())
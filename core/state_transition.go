@@ -19,10 +19,13 @@ package core
 import (
 	"errors"
 	//	"fmt"
+	"bytes"
 	"math"
 	"math/big"
+	"sort"
 
 	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/core/vm"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/params"
@@ -70,6 +73,7 @@ type StateTransition struct {
 	state      vm.StateDB
 	cvm        *vm.CVM
 	modelGas   map[common.Address]uint64
+	royalties  []*types.RoyaltyPayout
 }
 
 // Message represents a message sent to a contract.
@@ -375,7 +379,11 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, quotaUsed
 			reward := new(big.Int).Mul(new(big.Int).SetUint64(mgas), st.gasPrice)
 			log.Debug("Model author reward", "author", addr.Hex(), "reward", reward, "number", cvm.BlockNumber)
 			st.state.AddBalance(addr, reward)
+			st.royalties = append(st.royalties, &types.RoyaltyPayout{Beneficiary: addr, Gas: mgas, Amount: reward})
 		}
+		sort.Slice(st.royalties, func(i, j int) bool {
+			return bytes.Compare(st.royalties[i].Beneficiary.Bytes(), st.royalties[j].Beneficiary.Bytes()) < 0
+		})
 	}
 
 	//normal gas
@@ -402,6 +410,12 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, quotaUsed
 	return ret, st.gasUsed(), quota, vmerr != nil, err
 }
 
+// Royalties returns the model gas rewards paid out by the transition, ordered
+// by beneficiary address.
+func (st *StateTransition) Royalties() []*types.RoyaltyPayout {
+	return st.royalties
+}
+
 func Min(x, y *big.Int) *big.Int {
 	if x.Cmp(y) < 0 {
 		return x
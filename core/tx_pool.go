@@ -144,6 +144,8 @@ type TxPoolConfig struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	UploadSlots uint64 // Maximum number of executable upload transactions offered per target address
 }
 
 // DefaultTxPoolConfig contains the default configurations for the transaction
@@ -161,6 +163,8 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	GlobalQueue:  1024,
 
 	Lifetime: 3 * time.Hour,
+
+	UploadSlots: 8,
 }
 
 // sanitize checks the provided user configurations and changes anything that's
@@ -199,6 +203,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool lifetime", "provided", conf.Lifetime, "updated", DefaultTxPoolConfig.Lifetime)
 		conf.Lifetime = DefaultTxPoolConfig.Lifetime
 	}
+	if conf.UploadSlots < 1 {
+		log.Warn("Sanitizing invalid txpool upload slots", "provided", conf.UploadSlots, "updated", DefaultTxPoolConfig.UploadSlots)
+		conf.UploadSlots = DefaultTxPoolConfig.UploadSlots
+	}
 	return conf
 }
 
@@ -224,6 +232,8 @@ type TxPool struct {
 	currentState  *state.StateDB      // Current state in the blockchain head
 	pendingState  *state.ManagedState // Pending state tracking virtual nonces
 	currentMaxGas uint64              // Current gas limit for transaction caps
+	currentQuota  *big.Int            // Upload quota available to the next block
+	nextNumber    *big.Int            // Number of the next block, used for upload maturity checks
 
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *txJournal  // Journal of local transaction to back up to disk
@@ -483,6 +493,109 @@ func (pool *TxPool) Pending() (map[common.Address]types.Transactions, error) {
 	return pending, nil
 }
 
+// Uploads retrieves the upload lane of the pending pool: the executable upload
+// transactions, ordered for block inclusion. Transactions whose quota cost
+// fits into the quota available to the next block come first, followed by
+// those with the least remaining upload, so that almost finished uploads
+// complete before new ones start. At most UploadSlots transactions (and never
+// more than the remaining upload still requires) are offered per target.
+//
+// Only the leading upload transactions of each account are part of the lane,
+// since anything behind a non-upload transaction can't be executed ahead of it.
+func (pool *TxPool) Uploads() types.Transactions {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.uploads()
+}
+
+// uploads assembles the upload lane. The pool lock must be held.
+func (pool *TxPool) uploads() types.Transactions {
+	var (
+		lane    = make(uploadsByQuota, 0)
+		targets = make(map[common.Address]uint64)
+	)
+	for _, list := range pool.pending {
+		for _, tx := range list.Flatten() {
+			if !isUpload(pool.currentState, tx) || !pool.uploadReady(*tx.To()) {
+				break
+			}
+			var (
+				to     = *tx.To()
+				remain = pool.currentState.Upload(to)
+				cost   = Min(new(big.Int).SetUint64(params.PER_UPLOAD_BYTES), remain)
+			)
+			// Limit the lane to the number of transactions the target still needs
+			need := new(big.Int).Add(remain, new(big.Int).SetUint64(params.PER_UPLOAD_BYTES-1))
+			need.Div(need, new(big.Int).SetUint64(params.PER_UPLOAD_BYTES))
+
+			limit := pool.config.UploadSlots
+			if need.IsUint64() && need.Uint64() < limit {
+				limit = need.Uint64()
+			}
+			if targets[to] >= limit {
+				break
+			}
+			targets[to]++
+
+			lane = append(lane, &uploadTx{
+				tx:     tx,
+				remain: remain,
+				fits:   pool.currentQuota != nil && pool.currentQuota.Cmp(cost) >= 0,
+			})
+		}
+	}
+	sort.Sort(lane)
+
+	txs := make(types.Transactions, len(lane))
+	for i, utx := range lane {
+		txs[i] = utx.tx
+	}
+	return txs
+}
+
+// uploadReady checks whether the upload target has been created long enough
+// ago for its torrent to be seeded, mirroring the check done by the state
+// transition before an upload is accepted.
+func (pool *TxPool) uploadReady(addr common.Address) bool {
+	num := pool.currentState.GetNum(addr)
+	if num == nil || num.Sign() <= 0 || pool.nextNumber == nil {
+		return false
+	}
+	return num.Cmp(new(big.Int).Sub(pool.nextNumber, big.NewInt(params.SeedingBlks))) <= 0
+}
+
+// isUpload checks whether the transaction is an upload transaction against the
+// given state, i.e. a zero value transfer to an address still waiting for bytes.
+func isUpload(statedb *state.StateDB, tx *types.Transaction) bool {
+	return tx != nil && tx.To() != nil && tx.Value().Sign() == 0 && statedb.Uploading(*tx.To())
+}
+
+// uploadTx is an upload transaction tagged with the state of its target.
+type uploadTx struct {
+	tx     *types.Transaction
+	remain *big.Int // Bytes the target still has to upload
+	fits   bool     // Whether the upload cost fits into the available block quota
+}
+
+// uploadsByQuota implements the sort interface to order the upload lane.
+type uploadsByQuota []*uploadTx
+
+func (s uploadsByQuota) Len() int      { return len(s) }
+func (s uploadsByQuota) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s uploadsByQuota) Less(i, j int) bool {
+	if s[i].fits != s[j].fits {
+		return s[i].fits
+	}
+	if cmp := s[i].remain.Cmp(s[j].remain); cmp != 0 {
+		return cmp < 0
+	}
+	if cmp := s[i].tx.GasPrice().Cmp(s[j].tx.GasPrice()); cmp != 0 {
+		return cmp > 0
+	}
+	return s[i].tx.Nonce() < s[j].tx.Nonce()
+}
+
 // Locals retrieves the accounts currently considered local by the pool.
 func (pool *TxPool) Locals() []common.Address {
 	pool.mu.Lock()
@@ -543,7 +656,7 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 		return ErrInsufficientFunds
 	}
 	// Ensure the transaction has more gas than the basic tx fee.
-	intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, tx != nil && tx.To() != nil && tx.Value().Sign() == 0 && pool.currentState.Uploading(*tx.To()), true, pool.istanbul)
+	intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, isUpload(pool.currentState, tx), true, pool.istanbul)
 	//intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, true)
 	if err != nil {
 		return err
@@ -1149,13 +1262,21 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	pool.pendingState = state.ManageState(statedb)
 	pool.currentMaxGas = newHead.GasLimit
 
+	// Track the upload quota the next block will be able to spend
+	next := new(big.Int).Add(newHead.Number, big.NewInt(1))
+	pool.nextNumber = next
+	pool.currentQuota = new(big.Int).SetUint64(pool.chainconfig.GetBlockQuota(next))
+	if newHead.Quota != nil && newHead.QuotaUsed != nil {
+		pool.currentQuota.Add(pool.currentQuota, newHead.Quota)
+		pool.currentQuota.Sub(pool.currentQuota, newHead.QuotaUsed)
+	}
+
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
 	senderCacher.recover(pool.signer, reinject)
 	pool.addTxsLocked(reinject, false)
 
 	// Update all fork indicator by next pending block number.
-	next := new(big.Int).Add(newHead.Number, big.NewInt(1))
 	pool.istanbul = pool.chainconfig.IsIstanbul(next)
 }
 
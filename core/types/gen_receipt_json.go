@@ -15,14 +15,15 @@ var _ = (*receiptMarshaling)(nil)
 // MarshalJSON marshals as JSON.
 func (r Receipt) MarshalJSON() ([]byte, error) {
 	type Receipt struct {
-		PostState         hexutil.Bytes  `json:"root"`
-		Status            hexutil.Uint64 `json:"status"`
-		CumulativeGasUsed hexutil.Uint64 `json:"cumulativeGasUsed" gencodec:"required"`
-		Bloom             Bloom          `json:"logsBloom"         gencodec:"required"`
-		Logs              []*Log         `json:"logs"              gencodec:"required"`
-		TxHash            common.Hash    `json:"transactionHash" gencodec:"required"`
-		ContractAddress   common.Address `json:"contractAddress"`
-		GasUsed           hexutil.Uint64 `json:"gasUsed" gencodec:"required"`
+		PostState         hexutil.Bytes    `json:"root"`
+		Status            hexutil.Uint64   `json:"status"`
+		CumulativeGasUsed hexutil.Uint64   `json:"cumulativeGasUsed" gencodec:"required"`
+		Bloom             Bloom            `json:"logsBloom"         gencodec:"required"`
+		Logs              []*Log           `json:"logs"              gencodec:"required"`
+		TxHash            common.Hash      `json:"transactionHash" gencodec:"required"`
+		ContractAddress   common.Address   `json:"contractAddress"`
+		GasUsed           hexutil.Uint64   `json:"gasUsed" gencodec:"required"`
+		Royalties         []*RoyaltyPayout `json:"royalties"`
 	}
 	var enc Receipt
 	enc.PostState = r.PostState
@@ -33,20 +34,22 @@ func (r Receipt) MarshalJSON() ([]byte, error) {
 	enc.TxHash = r.TxHash
 	enc.ContractAddress = r.ContractAddress
 	enc.GasUsed = hexutil.Uint64(r.GasUsed)
+	enc.Royalties = r.Royalties
 	return json.Marshal(&enc)
 }
 
 // UnmarshalJSON unmarshals from JSON.
 func (r *Receipt) UnmarshalJSON(input []byte) error {
 	type Receipt struct {
-		PostState         *hexutil.Bytes  `json:"root"`
-		Status            *hexutil.Uint64 `json:"status"`
-		CumulativeGasUsed *hexutil.Uint64 `json:"cumulativeGasUsed" gencodec:"required"`
-		Bloom             *Bloom          `json:"logsBloom"         gencodec:"required"`
-		Logs              []*Log          `json:"logs"              gencodec:"required"`
-		TxHash            *common.Hash    `json:"transactionHash" gencodec:"required"`
-		ContractAddress   *common.Address `json:"contractAddress"`
-		GasUsed           *hexutil.Uint64 `json:"gasUsed" gencodec:"required"`
+		PostState         *hexutil.Bytes   `json:"root"`
+		Status            *hexutil.Uint64  `json:"status"`
+		CumulativeGasUsed *hexutil.Uint64  `json:"cumulativeGasUsed" gencodec:"required"`
+		Bloom             *Bloom           `json:"logsBloom"         gencodec:"required"`
+		Logs              []*Log           `json:"logs"              gencodec:"required"`
+		TxHash            *common.Hash     `json:"transactionHash" gencodec:"required"`
+		ContractAddress   *common.Address  `json:"contractAddress"`
+		GasUsed           *hexutil.Uint64  `json:"gasUsed" gencodec:"required"`
+		Royalties         []*RoyaltyPayout `json:"royalties"`
 	}
 	var dec Receipt
 	if err := json.Unmarshal(input, &dec); err != nil {
@@ -81,5 +84,8 @@ func (r *Receipt) UnmarshalJSON(input []byte) error {
 		return errors.New("missing required field 'gasUsed' for Receipt")
 	}
 	r.GasUsed = uint64(*dec.GasUsed)
+	if dec.Royalties != nil {
+		r.Royalties = dec.Royalties
+	}
 	return nil
 }
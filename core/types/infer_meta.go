@@ -6,6 +6,8 @@ import (
 	"math/big"
 
 	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/CortexFoundation/CortexTheseus/rlp"
 	"github.com/anacrolix/torrent/metainfo"
 )
@@ -19,8 +21,28 @@ var (
 	ErrorNotMature         = errors.New("Not mature")
 	ErrorExpired           = errors.New("Meta Expired")
 	ErrorInvalidBlockNum   = errors.New("Invalid block number")
+	ErrorInvalidRoyalty    = errors.New("Invalid royalty")
+	ErrorCodeTypeDeprecate = errors.New("Deprecation should start with 0x0003")
+	ErrorDeprecated        = errors.New("Meta Deprecated")
 )
 
+// DeprecationPrefix marks the payload of a deprecation transaction sent by the
+// author to an already uploaded model.
+var DeprecationPrefix = []byte{0x0, 0x3}
+
+// DeprecationTopic is the log topic emitted when a model gets deprecated, so
+// registries and explorers can index deprecated models without scanning state.
+var DeprecationTopic = crypto.Keccak256Hash([]byte("ModelDeprecated(address,uint256)"))
+
+// DeprecationKey is the storage slot of a model account that keeps the block
+// number from which the model is deprecated. Zero means never deprecated.
+var DeprecationKey = common.BytesToHash([]byte("deprecated"))
+
+// GenesisMetaKey is the storage slot of a model or input account that marks
+// the meta as registered in the genesis allocation. Uploaded metas never run
+// code, so only the genesis state can set it.
+var GenesisMetaKey = common.BytesToHash([]byte("genesis"))
+
 //InferMeta include ModelMeta struct and InputMeta type
 type InferMeta interface {
 	TypeCode() []byte
@@ -49,9 +71,21 @@ type ModelMeta struct {
 	AuthorAddress common.Address `json:"AuthorAddress"`
 	BlockNum      big.Int        `json:"BlockNum"`
 
+	// Royalties optionally splits the model gas among several beneficiaries
+	// instead of paying it to the author alone. The tail encoding keeps metas
+	// without royalties byte-identical to the ones created before the fork.
+	Royalties []Royalty `json:"Royalties,omitempty" rlp:"tail"`
+
 	//RawBytes []byte `json:"RawBytes"`
 }
 
+// Royalty is the share of a model's gas paid to a beneficiary, in basis points.
+// The beneficiary may as well be a contract, e.g. a DAO collecting royalties.
+type Royalty struct {
+	Beneficiary common.Address `json:"Beneficiary"`
+	Share       uint64         `json:"Share"`
+}
+
 type InputMeta struct {
 	Comment string         `json:"Comment"`
 	Hash    common.Address `json:"Hash"`
@@ -88,6 +122,55 @@ func (mm *ModelMeta) SetGas(gas uint64) error {
 	return nil
 }
 
+// ValidateRoyalties checks that the royalty specification names distinct,
+// non-empty beneficiaries whose shares add up to exactly 100%.
+func (mm *ModelMeta) ValidateRoyalties() error {
+	if len(mm.Royalties) > params.MAX_ROYALTY_RECEIVERS {
+		return ErrorInvalidRoyalty
+	}
+	var (
+		total uint64
+		seen  = make(map[common.Address]struct{})
+	)
+	for _, r := range mm.Royalties {
+		if r.Beneficiary == common.EmptyAddress || r.Share == 0 || r.Share > params.ROYALTY_BASIS_POINTS {
+			return ErrorInvalidRoyalty
+		}
+		if _, ok := seen[r.Beneficiary]; ok {
+			return ErrorInvalidRoyalty
+		}
+		seen[r.Beneficiary] = struct{}{}
+		total += r.Share
+	}
+	if len(mm.Royalties) > 0 && total != params.ROYALTY_BASIS_POINTS {
+		return ErrorInvalidRoyalty
+	}
+	return nil
+}
+
+// RoyaltyGas splits the model gas among the royalty beneficiaries. Shares are
+// rounded down, the rounding remainder goes to the first beneficiary so the
+// split is deterministic and always adds up to the model gas.
+func (mm *ModelMeta) RoyaltyGas() map[common.Address]uint64 {
+	split := make(map[common.Address]uint64, len(mm.Royalties))
+	if len(mm.Royalties) == 0 {
+		if mm.AuthorAddress != common.EmptyAddress {
+			split[mm.AuthorAddress] = mm.Gas
+		}
+		return split
+	}
+	paid := uint64(0)
+	for _, r := range mm.Royalties {
+		gas := new(big.Int).Mul(new(big.Int).SetUint64(mm.Gas), new(big.Int).SetUint64(r.Share))
+		gas.Div(gas, new(big.Int).SetUint64(params.ROYALTY_BASIS_POINTS))
+
+		split[r.Beneficiary] += gas.Uint64()
+		paid += gas.Uint64()
+	}
+	split[mm.Royalties[0].Beneficiary] += mm.Gas - paid
+	return split
+}
+
 /*func (im *InputMeta) SetRawBytes(rawBytes []byte) error {
 	im.RawBytes = rawBytes
 	return nil
@@ -116,6 +199,39 @@ func (im *InputMeta) DecodeJSON(s string) error {
 	return err
 }
 
+// Deprecation is the payload of a deprecation transaction. The model stops
+// being callable from the Effective block on; a block in the past means the
+// current block.
+type Deprecation struct {
+	Effective big.Int `json:"Effective"`
+}
+
+// ToBytes encodes the deprecation including its 0x0003 prefix, ready to be
+// used as transaction data.
+func (d Deprecation) ToBytes() ([]byte, error) {
+	array, err := rlp.EncodeToBytes(d)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, DeprecationPrefix...), array...), nil
+}
+
+// IsDeprecation reports whether data carries a deprecation payload.
+func IsDeprecation(data []byte) bool {
+	return len(data) >= 2 && data[0] == DeprecationPrefix[0] && data[1] == DeprecationPrefix[1]
+}
+
+func ParseDeprecation(data []byte) (*Deprecation, error) {
+	if !IsDeprecation(data) {
+		return nil, ErrorCodeTypeDeprecate
+	}
+	var deprecation Deprecation
+	if err := rlp.DecodeBytes(data[2:], &deprecation); err != nil {
+		return nil, err
+	}
+	return &deprecation, nil
+}
+
 func (mm ModelMeta) ToBytes() ([]byte, error) {
 	if array, err := rlp.EncodeToBytes(mm); err != nil {
 		return nil, err
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/big"
 	"unsafe"
 
 	"github.com/CortexFoundation/CortexTheseus/common"
@@ -28,6 +29,7 @@ import (
 )
 
 //go:generate gencodec -type Receipt -field-override receiptMarshaling -out gen_receipt_json.go
+//go:generate gencodec -type RoyaltyPayout -field-override royaltyPayoutMarshaling -out gen_royalty_json.go
 
 var (
 	receiptStatusFailedRLP     = []byte{}
@@ -56,6 +58,7 @@ type Receipt struct {
 	ContractAddress common.Address `json:"contractAddress"`
 	GasUsed         uint64         `json:"gasUsed" gencodec:"required"`
 	//AiCache         []uint64 `json:"aiCache" gencodec:"required"`
+	Royalties []*RoyaltyPayout `json:"royalties"`
 }
 
 type receiptMarshaling struct {
@@ -81,6 +84,20 @@ type receiptStorageRLP struct {
 	ContractAddress   common.Address
 	Logs              []*LogForStorage
 	GasUsed           uint64
+	Royalties         []*RoyaltyPayout `rlp:"tail"` // Absent in receipts stored by older versions
+}
+
+// RoyaltyPayout is the model gas reward a transaction paid to a model author or
+// to one of the model's royalty beneficiaries.
+type RoyaltyPayout struct {
+	Beneficiary common.Address `json:"beneficiary" gencodec:"required"`
+	Gas         uint64         `json:"gas"         gencodec:"required"`
+	Amount      *big.Int       `json:"amount"      gencodec:"required"`
+}
+
+type royaltyPayoutMarshaling struct {
+	Gas    hexutil.Uint64
+	Amount *hexutil.Big
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
@@ -165,6 +182,7 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 		ContractAddress:   r.ContractAddress,
 		Logs:              make([]*LogForStorage, len(r.Logs)),
 		GasUsed:           r.GasUsed,
+		Royalties:         r.Royalties,
 	}
 	for i, log := range r.Logs {
 		enc.Logs[i] = (*LogForStorage)(log)
@@ -190,6 +208,7 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	}
 	// Assign the implementation fields
 	r.TxHash, r.ContractAddress, r.GasUsed = dec.TxHash, dec.ContractAddress, dec.GasUsed
+	r.Royalties = dec.Royalties
 	return nil
 }
 
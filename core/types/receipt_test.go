@@ -0,0 +1,81 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+func TestReceiptStorageRoyalties(t *testing.T) {
+	receipt := &Receipt{
+		Status:            ReceiptStatusSuccessful,
+		CumulativeGasUsed: 1,
+		Logs:              []*Log{},
+		TxHash:            common.HexToHash("0x01"),
+		ContractAddress:   common.HexToAddress("0x02"),
+		GasUsed:           1,
+		Royalties: []*RoyaltyPayout{
+			{Beneficiary: common.HexToAddress("0x0a"), Gas: 250, Amount: big.NewInt(2500)},
+			{Beneficiary: common.HexToAddress("0x0b"), Gas: 750, Amount: big.NewInt(7500)},
+		},
+	}
+	enc, err := rlp.EncodeToBytes((*ReceiptForStorage)(receipt))
+	if err != nil {
+		t.Fatalf("failed to encode receipt: %v", err)
+	}
+	var dec ReceiptForStorage
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatalf("failed to decode receipt: %v", err)
+	}
+	if !reflect.DeepEqual(dec.Royalties, receipt.Royalties) {
+		t.Errorf("royalties mismatch: have %v, want %v", dec.Royalties, receipt.Royalties)
+	}
+
+	// Receipts without royalties keep the layout of older versions
+	receipt.Royalties = nil
+	enc, err = rlp.EncodeToBytes((*ReceiptForStorage)(receipt))
+	if err != nil {
+		t.Fatalf("failed to encode receipt: %v", err)
+	}
+	legacy, err := rlp.EncodeToBytes(&struct {
+		PostStateOrStatus []byte
+		CumulativeGasUsed uint64
+		Bloom             Bloom
+		TxHash            common.Hash
+		ContractAddress   common.Address
+		Logs              []*LogForStorage
+		GasUsed           uint64
+	}{receiptStatusSuccessfulRLP, 1, Bloom{}, receipt.TxHash, receipt.ContractAddress, []*LogForStorage{}, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(enc, legacy) {
+		t.Errorf("encoding mismatch: have %x, want %x", enc, legacy)
+	}
+	dec = ReceiptForStorage{}
+	if err := rlp.DecodeBytes(legacy, &dec); err != nil {
+		t.Fatalf("failed to decode legacy receipt: %v", err)
+	}
+	if len(dec.Royalties) != 0 || dec.TxHash != receipt.TxHash || dec.GasUsed != receipt.GasUsed {
+		t.Errorf("legacy receipt mismatch: %+v", dec)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+func TestValidateRoyalties(t *testing.T) {
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	many := make([]Royalty, params.MAX_ROYALTY_RECEIVERS+1)
+	for i := range many {
+		many[i] = Royalty{common.BytesToAddress([]byte{byte(i + 1)}), 1}
+	}
+	tests := []struct {
+		royalties []Royalty
+		valid     bool
+	}{
+		{nil, true},
+		{[]Royalty{{a, 10000}}, true},
+		{[]Royalty{{a, 2500}, {b, 7500}}, true},
+		{[]Royalty{{a, 2500}, {b, 7499}}, false},      // short of 100%
+		{[]Royalty{{a, 2500}, {b, 7501}}, false},      // above 100%
+		{[]Royalty{{a, 10001}}, false},                // share above 100%
+		{[]Royalty{{a, 0}, {b, 10000}}, false},        // empty share
+		{[]Royalty{{common.Address{}, 10000}}, false}, // no beneficiary
+		{[]Royalty{{a, 5000}, {a, 5000}}, false},      // duplicated beneficiary
+		{many, false},                                 // too many beneficiaries
+	}
+	for i, tt := range tests {
+		meta := &ModelMeta{Royalties: tt.royalties}
+		if err := meta.ValidateRoyalties(); (err == nil) != tt.valid {
+			t.Errorf("test %d: have %v, want valid %v", i, err, tt.valid)
+		}
+	}
+}
+
+func TestRoyaltyGas(t *testing.T) {
+	var (
+		author = common.HexToAddress("0x01")
+		a      = common.HexToAddress("0x0a")
+		b      = common.HexToAddress("0x0b")
+		c      = common.HexToAddress("0x0c")
+	)
+	tests := []struct {
+		meta ModelMeta
+		want map[common.Address]uint64
+	}{
+		// Without royalties the author earns all of the model gas
+		{ModelMeta{Gas: 1000, AuthorAddress: author}, map[common.Address]uint64{author: 1000}},
+		{ModelMeta{Gas: 1000}, map[common.Address]uint64{}},
+		{
+			ModelMeta{Gas: 1000, AuthorAddress: author, Royalties: []Royalty{{a, 2500}, {b, 7500}}},
+			map[common.Address]uint64{a: 250, b: 750},
+		},
+		// The rounding remainder goes to the first beneficiary
+		{
+			ModelMeta{Gas: 100, Royalties: []Royalty{{a, 3333}, {b, 3333}, {c, 3334}}},
+			map[common.Address]uint64{a: 34, b: 33, c: 33},
+		},
+		{
+			ModelMeta{Gas: 1, Royalties: []Royalty{{a, 5000}, {b, 5000}}},
+			map[common.Address]uint64{a: 1, b: 0},
+		},
+	}
+	for i, tt := range tests {
+		if have := tt.meta.RoyaltyGas(); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that model metas published before royalties still decode, and that
+// the royalties survive an encoding round trip.
+func TestModelMetaRoyaltiesRLP(t *testing.T) {
+	legacy := struct {
+		Comment       string
+		Hash          common.Address
+		RawSize       uint64
+		InputShape    []uint64
+		OutputShape   []uint64
+		Gas           uint64
+		AuthorAddress common.Address
+		BlockNum      uint64
+	}{Hash: common.HexToAddress("0x01"), RawSize: 1000, InputShape: []uint64{1}, OutputShape: []uint64{1}, Gas: 10, AuthorAddress: common.HexToAddress("0x02"), BlockNum: 5}
+	code, err := rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseModelMeta(append([]byte{0, 1}, code...))
+	if err != nil {
+		t.Fatalf("failed to parse legacy meta: %v", err)
+	}
+	if len(meta.Royalties) != 0 || meta.AuthorAddress != legacy.AuthorAddress || meta.BlockNum.Uint64() != legacy.BlockNum {
+		t.Fatalf("legacy meta mismatch: %v", meta)
+	}
+
+	meta.Royalties = []Royalty{{common.HexToAddress("0x0a"), 2500}, {common.HexToAddress("0x0b"), 7500}}
+	code, err = meta.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := ParseModelMeta(append([]byte{0, 1}, code...))
+	if err != nil {
+		t.Fatalf("failed to parse meta: %v", err)
+	}
+	if !reflect.DeepEqual(dec.Royalties, meta.Royalties) {
+		t.Errorf("royalties mismatch: have %v, want %v", dec.Royalties, meta.Royalties)
+	}
+}
@@ -532,18 +532,34 @@ func (cvm *CVM) DataSync(meta common.Address, dir string, errCh chan error) {
 	//return
 }*/
 
+// available checks that the data of infoHash is stored. Rpc calls first wait
+// for the data fetched on request in lazy storage mode, block processing
+// never does: the storage starts fetching the missing data, and the block
+// fails with ErrRuntime to be imported again once the data is stored.
+func (cvm *CVM) available(infoHash string, rawSize int64) error {
+	if cvm.vmConfig.RPC_FetchStorage {
+		if err := synapse.Engine().Fetch(infoHash); err != nil {
+			return err
+		}
+	}
+	return synapse.Engine().Available(infoHash, rawSize)
+}
+
 // infer function that returns an int64 as output, can be used a categorical output
 func (cvm *CVM) Infer(modelInfoHash, inputInfoHash string, modelRawSize, inputRawSize uint64) ([]byte, error) {
 	//log.Info("Inference Information", "Model Hash", modelInfoHash, "Input Hash", inputInfoHash)
 	if !cvm.vmConfig.DebugInferVM {
-		if err := synapse.Engine().Available(modelInfoHash, int64(modelRawSize)); err != nil {
-			log.Warn("Infer", "Torrent file model not available, blockchain and torrent not match, modelInfoHash", modelInfoHash, "err", err)
-			return nil, err
+		// Both are checked so that the missing data of either is fetched
+		modelErr := cvm.available(modelInfoHash, int64(modelRawSize))
+		inputErr := cvm.available(inputInfoHash, int64(inputRawSize))
+		if modelErr != nil {
+			log.Warn("Infer", "Torrent file model not available, blockchain and torrent not match, modelInfoHash", modelInfoHash, "err", modelErr)
+			return nil, modelErr
 		}
 
-		if err := synapse.Engine().Available(inputInfoHash, int64(inputRawSize)); err != nil {
+		if inputErr != nil {
 			//log.Warn("File non available", "inputInfoHash:", inputInfoHash, "err", err)
-			return nil, err
+			return nil, inputErr
 		}
 	}
 
@@ -576,7 +592,7 @@ func (cvm *CVM) InferArray(modelInfoHash string, inputArray []byte, modelRawSize
 		fmt.Println("Model Hash", modelInfoHash, "number", cvm.BlockNumber, "Input Content", hexutil.Encode(inputArray))
 	}
 	if !cvm.vmConfig.DebugInferVM {
-		if err := synapse.Engine().Available(modelInfoHash, int64(modelRawSize)); err != nil {
+		if err := cvm.available(modelInfoHash, int64(modelRawSize)); err != nil {
 			log.Warn("InferArray", "modelInfoHash", modelInfoHash, "not Available", err)
 			return nil, err
 		}
@@ -609,7 +625,7 @@ func (cvm *CVM) OpsInfer(addr common.Address) (opsRes uint64, errRes error) {
 	modelRawSize := modelMeta.RawSize
 
 	if !cvm.vmConfig.DebugInferVM {
-		if err := synapse.Engine().Available(modelMeta.Hash.Hex(), int64(modelRawSize)); err != nil {
+		if err := cvm.available(modelMeta.Hash.Hex(), int64(modelRawSize)); err != nil {
 			log.Debug("cvm", "modelMeta", modelMeta, "modelRawSize", modelRawSize, "err", err)
 			return 0, err
 		}
@@ -670,3 +686,24 @@ func (cvm *CVM) GetInputMeta(addr common.Address) (meta *types.InputMeta, err er
 		return inputMeta, nil
 	}
 }
+
+// IsGenesisMeta reports whether the meta at addr was registered in the
+// genesis allocation.
+func (cvm *CVM) IsGenesisMeta(addr common.Address) bool {
+	return cvm.StateDB.GetState(addr, types.GenesisMetaKey) != (common.Hash{})
+}
+
+// DeprecatedAt returns the block number from which the model at addr is
+// deprecated, or zero if its author never deprecated it.
+func (cvm *CVM) DeprecatedAt(addr common.Address) *big.Int {
+	return cvm.StateDB.GetState(addr, types.DeprecationKey).Big()
+}
+
+// IsDeprecated reports whether the model at addr is deprecated at the current block.
+func (cvm *CVM) IsDeprecated(addr common.Address) bool {
+	if !cvm.ChainConfig().IsDeprecation(cvm.BlockNumber) {
+		return false
+	}
+	at := cvm.DeprecatedAt(addr)
+	return at.Sign() > 0 && at.Cmp(cvm.BlockNumber) <= 0
+}
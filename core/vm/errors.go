@@ -33,6 +33,9 @@ var (
 	ErrInvalidMetaRawSize      = errors.New("invalid meta raw size")
 	ErrNoCompatibleInterpreter = errors.New("no compatible interpreter")
 	ErrInvalidMetaAuthor       = errors.New("invalid meta author")
+	ErrInvalidMetaRoyalty      = errors.New("invalid meta royalty")
+	ErrInvalidDeprecation      = errors.New("invalid model deprecation")
+	ErrMetaDeprecated          = errors.New("cvm: model deprecated")
 
 	ErrDownloading    = errors.New("downloading")
 	ErrFileNotExist   = errors.New("file not exist")
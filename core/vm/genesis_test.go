@@ -0,0 +1,262 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/inference"
+	"github.com/CortexFoundation/CortexTheseus/inference/synapse"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// The tests of package core don't build on their own, so the genesis meta
+// registration is covered here along with the CVM that consumes it.
+func TestGenesisMetaRegistration(t *testing.T) {
+	var (
+		modelAddr = common.BytesToAddress([]byte{0x10})
+		inputAddr = common.BytesToAddress([]byte{0x11})
+		author    = common.BytesToAddress([]byte{0x12})
+		plain     = common.BytesToAddress([]byte{0x13})
+	)
+	g := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: core.GenesisAlloc{
+			modelAddr: {
+				Balance: big.NewInt(0),
+				Model: &types.ModelMeta{
+					Hash:          common.HexToAddress("0x5c4d1f84063be8e25e83da6452b1821926548b3c"),
+					RawSize:       10000,
+					InputShape:    []uint64{1, 28, 28},
+					OutputShape:   []uint64{10},
+					Gas:           100,
+					AuthorAddress: author,
+				},
+			},
+			inputAddr: {
+				Balance: big.NewInt(0),
+				Input: &types.InputMeta{
+					Hash:    common.HexToAddress("0x8c1c6e4c5e5de2c5a4aa4b8ac1dbd1fa6a0b7e0c"),
+					RawSize: 784,
+					Shape:   []uint64{1, 28, 28},
+				},
+			},
+			plain: {Balance: big.NewInt(1)},
+		},
+	}
+	if err := g.Validate(); err != nil {
+		t.Fatalf("valid genesis rejected: %v", err)
+	}
+
+	db := rawdb.NewMemoryDatabase()
+	block := g.MustCommit(db)
+	statedb, err := state.New(block.Root(), state.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := types.ParseModelMeta(statedb.GetCode(modelAddr))
+	if err != nil {
+		t.Fatalf("failed to parse genesis model: %v", err)
+	}
+	// Born at block 1, as the CVM rejects a zero birth block
+	if model.AuthorAddress != author || model.BlockNum.Cmp(common.Big1) != 0 {
+		t.Errorf("model meta mismatch: %v", model)
+	}
+	input, err := types.ParseInputMeta(statedb.GetCode(inputAddr))
+	if err != nil {
+		t.Fatalf("failed to parse genesis input: %v", err)
+	}
+	if input.BlockNum.Cmp(common.Big1) != 0 {
+		t.Errorf("input meta mismatch: %v", input)
+	}
+	cvm := vm.NewCVM(vm.Context{BlockNumber: big.NewInt(1)}, statedb, g.Config, vm.Config{})
+	for _, addr := range []common.Address{modelAddr, inputAddr} {
+		if statedb.Uploading(addr) {
+			t.Errorf("%x: still uploading", addr)
+		}
+		if statedb.GetNum(addr).Cmp(common.Big1) != 0 {
+			t.Errorf("%x: birth block %v, want 1", addr, statedb.GetNum(addr))
+		}
+		if !cvm.IsGenesisMeta(addr) {
+			t.Errorf("%x: not marked as genesis meta", addr)
+		}
+	}
+	if statedb.GetNum(plain).Sign() != 0 {
+		t.Errorf("%x: plain account birth block %v", plain, statedb.GetNum(plain))
+	}
+	if cvm.IsGenesisMeta(plain) {
+		t.Errorf("%x: plain account marked as genesis meta", plain)
+	}
+
+	// A genesis past block 0 is the birth block of its metas
+	g.Number = 1000
+	if statedb, err = state.New(g.ToBlock(db).Root(), state.NewDatabase(db)); err != nil {
+		t.Fatal(err)
+	}
+	if num := statedb.GetNum(modelAddr); num.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("birth block %v, want 1000", num)
+	}
+
+	// A meta account must not carry code as well.
+	bad := g.Alloc[modelAddr]
+	bad.Code = []byte{0x1}
+	g.Alloc[modelAddr] = bad
+	if err := g.Validate(); err == nil {
+		t.Error("genesis meta with code accepted")
+	}
+}
+
+func TestGenesisInvalidMeta(t *testing.T) {
+	g := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: core.GenesisAlloc{
+			common.BytesToAddress([]byte{0x10}): {
+				Balance: big.NewInt(0),
+				Model:   &types.ModelMeta{RawSize: 10000},
+			},
+		},
+	}
+	db := rawdb.NewMemoryDatabase()
+	if _, _, err := core.SetupGenesisBlock(db, g); err == nil {
+		t.Error("genesis with invalid model meta accepted")
+	}
+	if _, err := g.Commit(db); err == nil {
+		t.Error("genesis with invalid model meta committed")
+	}
+	if hash := rawdb.ReadCanonicalHash(db, 0); hash != (common.Hash{}) {
+		t.Errorf("genesis with invalid model meta written: %x", hash)
+	}
+	// Left out of the state of the block built without validation
+	statedb, err := state.New(g.ToBlock(db).Root(), state.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := statedb.GetCode(common.BytesToAddress([]byte{0x10})); len(code) != 0 {
+		t.Errorf("invalid model meta registered: %x", code)
+	}
+}
+
+// TestGenesisMetaInfer runs INFER on the metas of a genesis at block 1 of a
+// chain whose uploaded metas take params.MatureBlks blocks to mature.
+func TestGenesisMetaInfer(t *testing.T) {
+	// Remote inference engine answering the ops and inference requests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		res := inference.InferResult{Info: inference.RES_OK}
+		switch inference.RetriveType(body) {
+		case inference.GAS_BY_H:
+			res.Data = make(hexutil.Bytes, 8)
+		case inference.INFER_BY_IH:
+			res.Data = hexutil.Bytes{7}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+	if synapse.New(&synapse.Config{IsRemoteInfer: true, InferURI: srv.URL}) == nil {
+		t.Fatal("failed to start the inference engine")
+	}
+
+	var (
+		modelAddr  = common.BytesToAddress([]byte{0x10})
+		inputAddr  = common.BytesToAddress([]byte{0x11})
+		callerAddr = common.BytesToAddress([]byte{0x12})
+	)
+	// Infers into the array at memory 0, then stores the INFER result in
+	// slot 0 and the first output word in slot 1:
+	// PUSH1 1 PUSH1 0 MSTORE PUSH1 0 PUSH1 32 MSTORE
+	// PUSH1 0 PUSH20 input PUSH20 model INFER
+	// PUSH1 0 SSTORE PUSH1 32 MLOAD PUSH1 1 SSTORE
+	code := []byte{0x60, 1, 0x60, 0, 0x52, 0x60, 0, 0x60, 32, 0x52, 0x60, 0, 0x73}
+	code = append(code, inputAddr.Bytes()...)
+	code = append(code, 0x73)
+	code = append(code, modelAddr.Bytes()...)
+	code = append(code, 0xc0, 0x60, 0, 0x55, 0x60, 32, 0x51, 0x60, 1, 0x55)
+
+	g := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: core.GenesisAlloc{
+			modelAddr: {
+				Balance: big.NewInt(0),
+				Model: &types.ModelMeta{
+					Hash:          common.HexToAddress("0x5c4d1f84063be8e25e83da6452b1821926548b3c"),
+					RawSize:       10000,
+					InputShape:    []uint64{1, 28, 28},
+					OutputShape:   []uint64{10},
+					AuthorAddress: common.BytesToAddress([]byte{0x20}),
+				},
+			},
+			inputAddr: {
+				Balance: big.NewInt(0),
+				Input: &types.InputMeta{
+					Hash:    common.HexToAddress("0x8c1c6e4c5e5de2c5a4aa4b8ac1dbd1fa6a0b7e0c"),
+					RawSize: 784,
+					Shape:   []uint64{1, 28, 28},
+				},
+			},
+			callerAddr: {Balance: big.NewInt(0), Code: code},
+		},
+	}
+	if g.Config.GetMatureBlock() <= 1 {
+		t.Fatalf("metas of chain %v mature right away", g.Config.ChainID)
+	}
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(g.MustCommit(db).Root(), state.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer := func() error {
+		cvm := vm.NewCVM(vm.Context{
+			CanTransfer: func(vm.StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(vm.StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(1),
+		}, statedb, g.Config, vm.Config{})
+		_, _, _, err := cvm.Call(vm.AccountRef(common.BytesToAddress([]byte{0x30})), callerAddr, nil, 1000000, big.NewInt(0))
+		return err
+	}
+	if err := infer(); err != nil {
+		t.Fatalf("inference on genesis metas failed: %v", err)
+	}
+	if ok := statedb.GetState(callerAddr, common.Hash{}); ok != common.BigToHash(common.Big1) {
+		t.Errorf("INFER result %x, want 1", ok)
+	}
+	if out := statedb.GetState(callerAddr, common.BigToHash(common.Big1)); out != (common.Hash{7}) {
+		t.Errorf("inference output %x, want %x", out, common.Hash{7})
+	}
+
+	// The same model born at block 1 by an upload isn't mature yet, which
+	// the gas of INFER already fails on
+	statedb.SetState(modelAddr, types.GenesisMetaKey, common.Hash{})
+	statedb.SetState(callerAddr, common.Hash{}, common.Hash{})
+	if err := infer(); err == nil {
+		t.Error("inference on an immature model succeeded")
+	}
+	if ok := statedb.GetState(callerAddr, common.Hash{}); ok != (common.Hash{}) {
+		t.Errorf("INFER result %x on an immature model", ok)
+	}
+}
@@ -802,18 +802,13 @@ func checkModel(cvm *CVM, stack *Stack, modelAddr common.Address) (*types.ModelM
 		return nil, errors.New("MODEL IS NOT UPLOADED ERROR")
 	}
 
-	matureBlockNumber := cvm.ChainConfig().GetMatureBlock()
 	log.Debug("checkModel", "modelAddr blocknum", cvm.StateDB.GetNum(modelAddr), "modelMeta", modelMeta)
-	if cvm.StateDB.GetNum(modelAddr).Cmp(big0) <= 0 {
-		return nil, errMetaInfoBlockNum
-	}
-	if cvm.StateDB.GetNum(modelAddr).Cmp(new(big.Int).Sub(cvm.BlockNumber, big.NewInt(matureBlockNumber))) > 0 {
-		log.Debug("instructions", "modelAddr", modelAddr, "modelAddrBlkNum", cvm.StateDB.GetNum(modelAddr), "Current", cvm.BlockNumber, "MB", matureBlockNumber)
-		return nil, ErrMetaInfoNotMature
+	if err := checkMetaNum(cvm, modelAddr); err != nil {
+		return nil, err
 	}
 
-	if cvm.StateDB.GetNum(modelAddr).Cmp(new(big.Int).Sub(cvm.BlockNumber, big.NewInt(params.ExpiredBlks))) < 0 {
-		return nil, errMetaInfoExpired
+	if cvm.IsDeprecated(modelAddr) {
+		return nil, ErrMetaDeprecated
 	}
 
 	if modelMeta.Gas > params.MODEL_GAS_LIMIT {
@@ -837,21 +832,32 @@ func checkInputMeta(cvm *CVM, stack *Stack, inputAddr common.Address) (*types.In
 	}
 
 	log.Debug("checkInput", "modelAddr blocknum", cvm.StateDB.GetNum(inputAddr), "inputMeta", inputMeta)
-	if cvm.StateDB.GetNum(inputAddr).Cmp(big0) <= 0 {
-		return nil, errMetaInfoBlockNum
+	if err := checkMetaNum(cvm, inputAddr); err != nil {
+		return nil, err
 	}
 
-	matureBlockNumber := cvm.ChainConfig().GetMatureBlock()
-	if cvm.StateDB.GetNum(inputAddr).Cmp(new(big.Int).Sub(cvm.BlockNumber, big.NewInt(matureBlockNumber))) > 0 {
-		log.Debug("instructions", "inputAddr", inputAddr, "inputAddrBlkNum", cvm.StateDB.GetNum(inputAddr), "Current", cvm.BlockNumber, "Uploading", cvm.StateDB.Uploading(inputAddr), "MB", matureBlockNumber)
-		return nil, ErrMetaInfoNotMature
+	return inputMeta, nil
+}
+
+// checkMetaNum checks the birth block of the meta at addr against the maturity
+// and expiry windows. A meta registered in the genesis allocation is mature
+// right away.
+func checkMetaNum(cvm *CVM, addr common.Address) error {
+	num := cvm.StateDB.GetNum(addr)
+	if num.Cmp(big0) <= 0 {
+		return errMetaInfoBlockNum
 	}
 
-	if cvm.StateDB.GetNum(inputAddr).Cmp(new(big.Int).Sub(cvm.BlockNumber, big.NewInt(params.ExpiredBlks))) < 0 {
-		return nil, errMetaInfoExpired
+	matureBlockNumber := cvm.ChainConfig().GetMatureBlock()
+	if !cvm.IsGenesisMeta(addr) && num.Cmp(new(big.Int).Sub(cvm.BlockNumber, big.NewInt(matureBlockNumber))) > 0 {
+		log.Debug("instructions", "addr", addr, "addrBlkNum", num, "Current", cvm.BlockNumber, "MB", matureBlockNumber)
+		return ErrMetaInfoNotMature
 	}
 
-	return inputMeta, nil
+	if num.Cmp(new(big.Int).Sub(cvm.BlockNumber, big.NewInt(params.ExpiredBlks))) < 0 {
+		return errMetaInfoExpired
+	}
+	return nil
 }
 
 func opInferArray(pc *uint64, interpreter *CVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
@@ -55,6 +55,8 @@ type Config struct {
 	// InferURI string
 	// rpc getInternalTransaction flag
 	RPC_GetInternalTransaction bool
+	// rpc flag waiting for the data fetched on request in lazy storage mode
+	RPC_FetchStorage bool
 
 	// opCall flag
 	CallFakeVM   bool
@@ -165,6 +167,65 @@ func IsInputMeta(code []byte) bool {
 	return false
 }
 
+// deprecateModel handles a deprecation transaction sent to a model account.
+// Only the model author may deprecate a finished upload, and an already
+// scheduled deprecation can only be brought forward, never postponed.
+func (in *CVMInterpreter) deprecateModel(contract *Contract, input []byte) error {
+	if in.readOnly {
+		return errWriteProtection
+	}
+	modelMeta, err := types.ParseModelMeta(contract.Code)
+	if err != nil {
+		return err
+	}
+	deprecation, err := types.ParseDeprecation(input)
+	if err != nil {
+		return ErrInvalidDeprecation
+	}
+	if contract.Caller() != modelMeta.AuthorAddress {
+		return ErrInvalidMetaAuthor
+	}
+	addr := contract.Address()
+	if in.cvm.StateDB.Uploading(addr) {
+		return ErrInvalidDeprecation
+	}
+
+	effective := new(big.Int).Set(&deprecation.Effective)
+	if effective.Cmp(in.cvm.BlockNumber) < 0 {
+		effective.Set(in.cvm.BlockNumber)
+	}
+	if current := in.cvm.DeprecatedAt(addr); current.Sign() > 0 && current.Cmp(effective) <= 0 {
+		return ErrInvalidDeprecation
+	}
+	if !contract.UseGas(params.SstoreSetGas) {
+		return ErrOutOfGas
+	}
+
+	in.cvm.StateDB.SetState(addr, types.DeprecationKey, common.BigToHash(effective))
+	in.cvm.StateDB.AddLog(&types.Log{
+		Address:     addr,
+		Topics:      []common.Hash{types.DeprecationTopic, common.BytesToHash(modelMeta.AuthorAddress.Bytes())},
+		Data:        common.BigToHash(effective).Bytes(),
+		BlockNumber: in.cvm.BlockNumber.Uint64(),
+	})
+	log.Debug("Model deprecated", "addr", addr, "author", modelMeta.AuthorAddress, "effective", effective)
+	return nil
+}
+
+// earnModelGas credits the gas of an inference of the model to its author, or
+// to its royalty beneficiaries from the royalty fork on.
+func (in *CVMInterpreter) earnModelGas(contract *Contract, modelMeta *types.ModelMeta) {
+	if len(modelMeta.Royalties) > 0 && in.cvm.ChainConfig().IsRoyalty(in.cvm.BlockNumber) {
+		for beneficiary, gas := range modelMeta.RoyaltyGas() {
+			contract.ModelGas[beneficiary] += gas
+			log.Debug("Model royalty earn", "beneficiary", beneficiary.Hex(), "gas", gas)
+		}
+	} else if modelMeta.AuthorAddress != common.EmptyAddress {
+		contract.ModelGas[modelMeta.AuthorAddress] += modelMeta.Gas
+		log.Debug("Model gas earn", "author", modelMeta.AuthorAddress.Hex(), "gas", modelMeta.Gas)
+	}
+}
+
 // Run loops and evaluates the contract's code with the given input data and returns
 // the return byte-slice and an error if one occurred.
 //
@@ -205,6 +266,10 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			return nil, nil
 		}
 
+		if types.IsDeprecation(input) && in.cvm.ChainConfig().IsDeprecation(in.cvm.BlockNumber) {
+			return nil, in.deprecateModel(contract, input)
+		}
+
 		if input != nil {
 			log.Debug("Readonly for model meta")
 			return nil, nil
@@ -245,6 +310,15 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 					return nil, ErrInvalidMetaAuthor
 				}
 
+				if len(modelMeta.Royalties) > 0 {
+					if !in.cvm.ChainConfig().IsRoyalty(in.cvm.BlockNumber) {
+						return nil, ErrInvalidMetaRoyalty
+					}
+					if err := modelMeta.ValidateRoyalties(); err != nil {
+						return nil, ErrInvalidMetaRoyalty
+					}
+				}
+
 				//todo Hash check
 
 				if modelMeta.Gas == uint64(0) {
@@ -407,10 +481,7 @@ func (in *CVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 				return nil, err
 			}
 			//todo model validation
-			if modelMeta.AuthorAddress != common.EmptyAddress {
-				contract.ModelGas[modelMeta.AuthorAddress] += modelMeta.Gas
-				log.Debug("Model gas earn", "author", modelMeta.AuthorAddress.Hex(), "gas", modelMeta.Gas)
-			}
+			in.earnModelGas(contract, modelMeta)
 			var overflow bool
 			if cost, overflow = math.SafeAdd(cost, modelMeta.Gas); overflow {
 				log.Warn("overflow", "cost", cost, "gas", modelMeta.Gas)
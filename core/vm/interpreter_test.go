@@ -0,0 +1,212 @@
+// Copyright 2018 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+var (
+	testModelAddr = common.BytesToAddress([]byte{0x10})
+	testAuthor    = common.BytesToAddress([]byte{0x12})
+)
+
+// newModelCVM returns a CVM at the given block over a state holding a finished
+// model upload of testAuthor at testModelAddr.
+func newModelCVM(t *testing.T, config *params.ChainConfig, number int64, meta types.ModelMeta) (*CVM, *state.StateDB) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	code, err := meta.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(testModelAddr, append([]byte{0, 1}, code...))
+	statedb.SetNum(testModelAddr, big.NewInt(1))
+	env := NewCVM(Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(number),
+	}, statedb, config, Config{})
+	return env, statedb
+}
+
+func testModelMeta() types.ModelMeta {
+	return types.ModelMeta{
+		Hash:          common.HexToAddress("0x5c4d1f84063be8e25e83da6452b1821926548b3c"),
+		RawSize:       10000,
+		InputShape:    []uint64{1, 28, 28},
+		OutputShape:   []uint64{10},
+		AuthorAddress: testAuthor,
+		BlockNum:      *big.NewInt(1),
+	}
+}
+
+func deprecate(env *CVM, caller common.Address, effective int64) error {
+	input, err := types.Deprecation{Effective: *big.NewInt(effective)}.ToBytes()
+	if err != nil {
+		return err
+	}
+	_, _, _, err = env.Call(AccountRef(caller), testModelAddr, input, 100000, new(big.Int))
+	return err
+}
+
+func TestDeprecateModel(t *testing.T) {
+	env, statedb := newModelCVM(t, params.TestChainConfig, 1000, testModelMeta())
+
+	if err := deprecate(env, common.BytesToAddress([]byte{0x13}), 2000); err != ErrInvalidMetaAuthor {
+		t.Fatalf("deprecation by another account: have %v, want %v", err, ErrInvalidMetaAuthor)
+	}
+	if at := env.DeprecatedAt(testModelAddr); at.Sign() != 0 {
+		t.Fatalf("model deprecated by another account at %v", at)
+	}
+
+	if err := deprecate(env, testAuthor, 2000); err != nil {
+		t.Fatalf("deprecation by the author failed: %v", err)
+	}
+	if at := env.DeprecatedAt(testModelAddr); at.Cmp(big.NewInt(2000)) != 0 {
+		t.Fatalf("deprecated at %v, want 2000", at)
+	}
+	if logs := statedb.Logs(); len(logs) != 1 || logs[0].Topics[0] != types.DeprecationTopic {
+		t.Fatalf("deprecation log missing: %v", logs)
+	}
+	if env.IsDeprecated(testModelAddr) {
+		t.Error("model deprecated before the effective block")
+	}
+
+	// A scheduled deprecation can be brought forward, never postponed.
+	if err := deprecate(env, testAuthor, 3000); err != ErrInvalidDeprecation {
+		t.Errorf("postponed deprecation: have %v, want %v", err, ErrInvalidDeprecation)
+	}
+	if err := deprecate(env, testAuthor, 2000); err != ErrInvalidDeprecation {
+		t.Errorf("repeated deprecation: have %v, want %v", err, ErrInvalidDeprecation)
+	}
+	if err := deprecate(env, testAuthor, 1500); err != nil {
+		t.Errorf("brought forward deprecation failed: %v", err)
+	}
+	// An effective block in the past means the current block.
+	if err := deprecate(env, testAuthor, 500); err != nil {
+		t.Errorf("immediate deprecation failed: %v", err)
+	}
+	if at := env.DeprecatedAt(testModelAddr); at.Cmp(env.BlockNumber) != 0 {
+		t.Errorf("deprecated at %v, want %v", at, env.BlockNumber)
+	}
+	if !env.IsDeprecated(testModelAddr) {
+		t.Error("model not deprecated at the effective block")
+	}
+	if _, err := checkModel(env, nil, testModelAddr); err != ErrMetaDeprecated {
+		t.Errorf("deprecated model checked: have %v, want %v", err, ErrMetaDeprecated)
+	}
+
+	// Still uploading models can't be deprecated.
+	env, statedb = newModelCVM(t, params.TestChainConfig, 1000, testModelMeta())
+	statedb.SetUpload(testModelAddr, big.NewInt(1))
+	if err := deprecate(env, testAuthor, 2000); err != ErrInvalidDeprecation {
+		t.Errorf("deprecation of an upload: have %v, want %v", err, ErrInvalidDeprecation)
+	}
+}
+
+func TestDeprecateModelFork(t *testing.T) {
+	config := *params.TestChainConfig
+	config.DeprecationBlock = big.NewInt(100)
+
+	// Before the fork a deprecation is an ordinary call of the model.
+	env, _ := newModelCVM(t, &config, 99, testModelMeta())
+	if err := deprecate(env, testAuthor, 99); err != nil {
+		t.Fatalf("call before the fork failed: %v", err)
+	}
+	if at := env.DeprecatedAt(testModelAddr); at.Sign() != 0 {
+		t.Fatalf("model deprecated before the fork at %v", at)
+	}
+
+	env, statedb := newModelCVM(t, &config, 100, testModelMeta())
+	if err := deprecate(env, testAuthor, 100); err != nil {
+		t.Fatalf("deprecation at the fork failed: %v", err)
+	}
+	if !env.IsDeprecated(testModelAddr) {
+		t.Fatal("model not deprecated at the fork")
+	}
+	// The deprecation only counts from the fork on.
+	env = NewCVM(Context{BlockNumber: big.NewInt(99)}, statedb, &config, Config{})
+	if env.IsDeprecated(testModelAddr) {
+		t.Error("model deprecated before the fork")
+	}
+}
+
+// createModel runs the creation of a model whose meta is given as init code.
+func createModel(env *CVM, meta types.ModelMeta) error {
+	code, err := meta.ToBytes()
+	if err != nil {
+		return err
+	}
+	_, _, _, _, err = env.Create(AccountRef(testAuthor), append([]byte{0, 1}, code...), 1000000, new(big.Int))
+	return err
+}
+
+func TestRoyaltyFork(t *testing.T) {
+	var (
+		a = common.HexToAddress("0x0a")
+		b = common.HexToAddress("0x0b")
+	)
+	config := *params.TestChainConfig
+	config.RoyaltyBlock = big.NewInt(100)
+
+	plain := testModelMeta()
+	plain.BlockNum, plain.Gas = big.Int{}, 1000
+	royalty := plain
+	royalty.Royalties = []types.Royalty{{Beneficiary: a, Share: 2500}, {Beneficiary: b, Share: 7500}}
+	invalid := plain
+	invalid.Royalties = []types.Royalty{{Beneficiary: a, Share: 2500}, {Beneficiary: b, Share: 2500}}
+
+	tests := []struct {
+		number int64
+		meta   types.ModelMeta
+		err    error
+		earned map[common.Address]uint64
+	}{
+		// Before the fork only plain models are published and the author
+		// earns all of the model gas.
+		{99, plain, nil, map[common.Address]uint64{testAuthor: 1000}},
+		{99, royalty, ErrInvalidMetaRoyalty, map[common.Address]uint64{testAuthor: 1000}},
+		// From the fork on royalties split the model gas.
+		{100, plain, nil, map[common.Address]uint64{testAuthor: 1000}},
+		{100, royalty, nil, map[common.Address]uint64{a: 250, b: 750}},
+		{100, invalid, ErrInvalidMetaRoyalty, nil},
+	}
+	for i, tt := range tests {
+		env, _ := newModelCVM(t, &config, tt.number, testModelMeta())
+		if err := createModel(env, tt.meta); err != tt.err {
+			t.Errorf("test %d: create error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if tt.earned == nil {
+			continue
+		}
+		in := NewCVMInterpreter(env, env.vmConfig)
+		contract := NewContract(AccountRef(testAuthor), AccountRef(testModelAddr), new(big.Int), 0)
+		meta := tt.meta
+		in.earnModelGas(contract, &meta)
+		if !reflect.DeepEqual(contract.ModelGas, tt.earned) {
+			t.Errorf("test %d: earned gas mismatch: have %v, want %v", i, contract.ModelGas, tt.earned)
+		}
+	}
+}
@@ -602,7 +602,8 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 	// Run the transaction with tracing enabled.
 	vmenv := vm.NewCVM(vmctx, statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
 
-	ret, gas, _, failed, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()), new(big.Int).SetUint64(math.MaxUint64))
+	st := core.NewStateTransition(vmenv, message, new(core.GasPool).AddGas(message.Gas()), new(big.Int).SetUint64(math.MaxUint64))
+	ret, gas, _, failed, err := st.TransitionDb()
 	if err != nil {
 		return nil, fmt.Errorf("tracing failed: %v", err)
 	}
@@ -614,6 +615,7 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 			Failed:      failed,
 			ReturnValue: fmt.Sprintf("%x", ret),
 			StructLogs:  ctxcapi.FormatLogs(tracer.StructLogs()),
+			Royalties:   st.Royalties(),
 		}, nil
 
 	case *tracers.Tracer:
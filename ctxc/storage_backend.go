@@ -0,0 +1,164 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
+)
+
+// StorageBackend implements torrentfs.ChainBackend, letting the storage
+// service follow the local chain without going through RPC.
+type StorageBackend struct {
+	ctxc *Cortex
+}
+
+// NewStorageBackend creates a storage backend reading from the given Cortex.
+func NewStorageBackend(ctxc *Cortex) *StorageBackend {
+	return &StorageBackend{ctxc: ctxc}
+}
+
+func (b *StorageBackend) CurrentNumber() (uint64, error) {
+	return b.ctxc.blockchain.CurrentBlock().NumberU64(), nil
+}
+
+func (b *StorageBackend) GetBlockByNumber(number uint64) (*torrentfs.Block, error) {
+	block := b.ctxc.blockchain.GetBlockByNumber(number)
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	signer := types.MakeSigner(b.ctxc.blockchain.Config(), block.Number())
+	txs := make([]torrentfs.Transaction, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		from, _ := types.Sender(signer, tx)
+		hash := tx.Hash()
+		txs[i] = torrentfs.Transaction{
+			Price:     tx.GasPrice(),
+			Amount:    tx.Value(),
+			GasLimit:  tx.Gas(),
+			Payload:   tx.Data(),
+			From:      &from,
+			Recipient: tx.To(),
+			Hash:      &hash,
+		}
+	}
+	return &torrentfs.Block{
+		Number:     number,
+		Hash:       block.Hash(),
+		ParentHash: block.ParentHash(),
+		Txs:        txs,
+	}, nil
+}
+
+func (b *StorageBackend) GetReceipt(txHash common.Hash) (*torrentfs.TxReceipt, error) {
+	receipt, _, _, _ := rawdb.ReadReceipt(b.ctxc.chainDb, txHash, b.ctxc.blockchain.Config())
+	if receipt == nil {
+		return nil, fmt.Errorf("receipt %x not found", txHash)
+	}
+	r := &torrentfs.TxReceipt{
+		TxHash:  &txHash,
+		GasUsed: receipt.GasUsed,
+		Status:  receipt.Status,
+		Logs:    receipt.Logs,
+	}
+	// If the ContractAddress is 20 0x0 bytes, assume it is not a contract creation
+	if receipt.ContractAddress != (common.Address{}) {
+		addr := receipt.ContractAddress
+		r.ContractAddr = &addr
+	}
+	return r, nil
+}
+
+func (b *StorageBackend) GetUpload(addr common.Address) (uint64, error) {
+	state, err := b.ctxc.blockchain.State()
+	if err != nil {
+		return 0, err
+	}
+	return state.GetUpload(addr).Uint64(), state.Error()
+}
+
+func (b *StorageBackend) GenesisMetas() ([]torrentfs.GenesisMeta, error) {
+	state, err := b.ctxc.blockchain.StateAt(b.ctxc.blockchain.Genesis().Root())
+	if err != nil {
+		return nil, err
+	}
+	return torrentfs.ParseGenesisDump(state.RawDump()), nil
+}
+
+// SubscribeNewTxsEvent forwards the transactions entering the tx pool.
+func (b *StorageBackend) SubscribeNewTxsEvent(ch chan<- torrentfs.NewTxsEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		txsCh := make(chan core.NewTxsEvent, 64)
+		sub := b.ctxc.txPool.SubscribeNewTxsEvent(txsCh)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-txsCh:
+				txs := make([]torrentfs.Transaction, len(ev.Txs))
+				for i, tx := range ev.Txs {
+					hash := tx.Hash()
+					txs[i] = torrentfs.Transaction{
+						Price:     tx.GasPrice(),
+						Amount:    tx.Value(),
+						GasLimit:  tx.Gas(),
+						Payload:   tx.Data(),
+						Recipient: tx.To(),
+						Hash:      &hash,
+					}
+				}
+				select {
+				case ch <- torrentfs.NewTxsEvent{Txs: txs}:
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
+
+// SubscribeChainHeadEvent forwards the chain head events of the blockchain.
+func (b *StorageBackend) SubscribeChainHeadEvent(ch chan<- torrentfs.ChainHeadEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		heads := make(chan core.ChainHeadEvent, 16)
+		sub := b.ctxc.blockchain.SubscribeChainHeadEvent(heads)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case head := <-heads:
+				select {
+				case ch <- torrentfs.ChainHeadEvent{Number: head.Block.NumberU64(), Hash: head.Block.Hash()}:
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package dashboard
+
+// indexHTML is the dashboard page. It opens the websocket of the dashboard
+// and renders every pushed state; the values sent by the peers are only ever
+// inserted as text.
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Cortex Dashboard</title>
+<style>
+body { margin: 0; font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; background: #f3f4f6; color: #1f2937; }
+header { display: flex; justify-content: space-between; align-items: baseline; padding: 12px 24px; background: #111827; color: #f9fafb; }
+header h1 { margin: 0; font-size: 18px; font-weight: 600; }
+header span { font-size: 12px; color: #9ca3af; }
+#status.live { color: #34d399; }
+#status.down { color: #f87171; }
+main { display: grid; grid-template-columns: repeat(auto-fill, minmax(300px, 1fr)); gap: 16px; padding: 16px 24px; }
+section { background: #fff; border-radius: 6px; padding: 12px 16px; box-shadow: 0 1px 2px rgba(0, 0, 0, .08); }
+section.wide { grid-column: 1 / -1; }
+h2 { margin: 0 0 8px; font-size: 13px; text-transform: uppercase; letter-spacing: .05em; color: #6b7280; }
+dl { display: grid; grid-template-columns: max-content 1fr; gap: 2px 12px; margin: 0; }
+dt { color: #6b7280; }
+dd { margin: 0; font-family: Menlo, Consolas, monospace; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+table { width: 100%; border-collapse: collapse; font-size: 12px; }
+th, td { padding: 4px 6px; text-align: left; border-bottom: 1px solid #e5e7eb; white-space: nowrap; }
+td.hash { font-family: Menlo, Consolas, monospace; }
+.bar { height: 6px; min-width: 80px; background: #e5e7eb; border-radius: 3px; overflow: hidden; }
+.bar div { height: 100%; background: #3b82f6; }
+.empty { color: #9ca3af; }
+</style>
+</head>
+<body>
+<header><h1>Cortex Dashboard</h1><span><span id="version"></span> &middot; <span id="status" class="down">connecting</span></span></header>
+<main>
+<section><h2>Chain head</h2><dl id="chain"></dl></section>
+<section><h2>Upload quota</h2><dl id="quota"></dl><div class="bar"><div id="quota-bar" style="width: 0"></div></div></section>
+<section><h2>Transaction pool</h2><dl id="txpool"></dl></section>
+<section><h2>Inference engine</h2><dl id="synapse"></dl></section>
+<section><h2>Storage</h2><dl id="storage"></dl></section>
+<section class="wide"><h2>Torrents</h2><table id="torrents"></table></section>
+<section class="wide"><h2>Peers</h2><table id="peers"></table></section>
+</main>
+<script>
+"use strict";
+
+function el(tag, text, cls) {
+	const e = document.createElement(tag);
+	if (text !== undefined) e.textContent = text;
+	if (cls) e.className = cls;
+	return e;
+}
+
+function list(id, rows) {
+	const dl = document.getElementById(id);
+	dl.textContent = "";
+	if (!rows) {
+		dl.appendChild(el("dd", "not available", "empty"));
+		return;
+	}
+	for (const [k, v] of rows) {
+		dl.appendChild(el("dt", k));
+		const dd = el("dd", String(v));
+		dd.title = String(v);
+		dl.appendChild(dd);
+	}
+}
+
+function table(id, head, rows) {
+	const t = document.getElementById(id);
+	t.textContent = "";
+	if (!rows || rows.length === 0) {
+		t.appendChild(el("tr")).appendChild(el("td", rows ? "none" : "not available", "empty"));
+		return;
+	}
+	const tr = t.appendChild(el("tr"));
+	for (const h of head) tr.appendChild(el("th", h));
+	for (const row of rows) {
+		const tr = t.appendChild(el("tr"));
+		for (const cell of row) {
+			if (cell instanceof Node) tr.appendChild(el("td")).appendChild(cell);
+			else tr.appendChild(el("td", String(cell), typeof cell === "string" && cell.startsWith("0x") ? "hash" : ""));
+		}
+	}
+}
+
+function bytes(n) {
+	const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+	let i = 0;
+	for (; n >= 1024 && i < units.length - 1; i++) n /= 1024;
+	return n.toFixed(i ? 1 : 0) + " " + units[i];
+}
+
+function ratio(hits, misses) {
+	const total = hits + misses;
+	return total ? (100 * hits / total).toFixed(1) + "% of " + total : "-";
+}
+
+function bar(done, total) {
+	const b = el("div", undefined, "bar"), fill = b.appendChild(el("div"));
+	fill.style.width = (total ? Math.min(100, 100 * done / total) : 0) + "%";
+	b.title = total ? (100 * done / total).toFixed(1) + "%" : "";
+	return b;
+}
+
+function short(hash) {
+	return hash.length > 18 ? hash.slice(0, 10) + "…" + hash.slice(-6) : hash;
+}
+
+function age(time, now) {
+	const s = Math.max(0, now - time);
+	return s < 60 ? s + "s ago" : Math.floor(s / 60) + "m " + s % 60 + "s ago";
+}
+
+function render(msg) {
+	const now = msg.general.time;
+	document.getElementById("version").textContent = msg.general.version;
+
+	const c = msg.chain;
+	list("chain", c && [
+		["Number", c.number], ["Hash", c.hash], ["Age", age(c.time, now)],
+		["Difficulty", c.difficulty], ["Gas", c.gasUsed + " / " + c.gasLimit], ["Transactions", c.txs],
+	]);
+	list("quota", c && [["Used", c.quotaUsed], ["Total", c.quota]]);
+	const quota = c ? Number(c.quota) : 0;
+	document.getElementById("quota-bar").style.width = (quota ? Math.min(100, 100 * Number(c.quotaUsed) / quota) : 0) + "%";
+
+	const p = msg.txpool;
+	list("txpool", p && [["Pending", p.pending], ["Queued", p.queued]]);
+
+	const y = msg.synapse;
+	list("synapse", y && [
+		["Loaded models", y.models], ["Model memory", bytes(y.memory)],
+		["Result cache hits", ratio(y.inferHits, y.inferMisses)], ["Model cache hits", ratio(y.modelHits, y.modelMisses)],
+	]);
+
+	const s = msg.storage;
+	if (s) {
+		const rows = [
+			["Files", s.status.files],
+			["Disk usage", bytes(s.status.diskUsage) + (s.status.diskQuota ? " / " + bytes(s.status.diskQuota) : "")],
+			["Evicted", s.status.evicted],
+			["Listened block", Number(s.status.lastListenBlockNumber)],
+		];
+		for (const state of Object.keys(s.states).sort()) rows.push(["Torrents " + state, s.states[state]]);
+		if (s.status.key) rows.push(["Key", s.status.key]);
+		list("storage", rows);
+	} else {
+		list("storage");
+	}
+	table("torrents", ["Info hash", "State", "Progress", "Completed", "Requested", "Length", "Peers", "Speed"],
+		s && s.torrents.map(t => [
+			short(t.infoHash), t.state, bar(t.bytesCompleted, t.bytesRequested || t.length),
+			bytes(t.bytesCompleted), bytes(t.bytesRequested), bytes(t.length),
+			t.peers + " (" + t.seeders + " seeding)", bytes(t.speed) + "/s",
+		]));
+
+	const n = msg.network;
+	table("peers", ["ID", "Name", "Address", "Direction", "Protocols"],
+		n && n.peers.map(p => [short(p.id), p.name, p.address, p.inbound ? "inbound" : "outbound", (p.caps || []).join(", ")]));
+}
+
+function connect() {
+	const status = document.getElementById("status");
+	const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/api");
+	ws.onopen = () => { status.textContent = "live"; status.className = "live"; };
+	ws.onmessage = event => render(JSON.parse(event.data));
+	ws.onclose = () => {
+		status.textContent = "disconnected";
+		status.className = "down";
+		setTimeout(connect, 3000);
+	};
+}
+
+connect();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,184 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package dashboard
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
+)
+
+// collect gathers the node state. The sections read over RPC are left out
+// when the node RPC is not available yet, or when the service behind them is
+// not running.
+func (db *Dashboard) collect(client *rpc.Client) *Message {
+	msg := &Message{
+		General: &GeneralMessage{
+			Version: db.version(),
+			Commit:  db.commit,
+			Time:    time.Now().Unix(),
+		},
+		Synapse: collectSynapse(metrics.DefaultRegistry),
+	}
+	if client == nil {
+		return msg
+	}
+	msg.Chain = collectChain(client)
+	msg.Network = collectNetwork(client)
+	msg.TxPool = collectTxPool(client)
+	msg.Storage = collectStorage(client)
+	return msg
+}
+
+// call runs an RPC query bounded by rpcTimeout, logging its failure.
+func call(client *rpc.Client, result interface{}, method string, args ...interface{}) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	if err := client.CallContext(ctx, result, method, args...); err != nil {
+		log.Debug("Dashboard query failed", "method", method, "err", err)
+		return false
+	}
+	return true
+}
+
+func collectChain(client *rpc.Client) *ChainMessage {
+	var head *struct {
+		Number       *hexutil.Big   `json:"number"`
+		Hash         common.Hash    `json:"hash"`
+		Time         *hexutil.Big   `json:"timestamp"`
+		Difficulty   *hexutil.Big   `json:"difficulty"`
+		GasUsed      hexutil.Uint64 `json:"gasUsed"`
+		GasLimit     hexutil.Uint64 `json:"gasLimit"`
+		Transactions []common.Hash  `json:"transactions"`
+		Quota        *big.Int       `json:"quota"`
+		QuotaUsed    *big.Int       `json:"quotaUsed"`
+	}
+	if !call(client, &head, "ctxc_getBlockByNumber", "latest", false) || head == nil {
+		return nil
+	}
+	msg := &ChainMessage{
+		Hash:       head.Hash.Hex(),
+		Difficulty: "0",
+		GasUsed:    uint64(head.GasUsed),
+		GasLimit:   uint64(head.GasLimit),
+		Txs:        len(head.Transactions),
+		Quota:      "0",
+		QuotaUsed:  "0",
+	}
+	if head.Number != nil {
+		msg.Number = head.Number.ToInt().Uint64()
+	}
+	if head.Time != nil {
+		msg.Time = head.Time.ToInt().Uint64()
+	}
+	if head.Difficulty != nil {
+		msg.Difficulty = head.Difficulty.ToInt().String()
+	}
+	if head.Quota != nil {
+		msg.Quota = head.Quota.String()
+	}
+	if head.QuotaUsed != nil {
+		msg.QuotaUsed = head.QuotaUsed.String()
+	}
+	return msg
+}
+
+func collectNetwork(client *rpc.Client) *NetworkMessage {
+	var peers []*p2p.PeerInfo
+	if !call(client, &peers, "admin_peers") {
+		return nil
+	}
+	msg := &NetworkMessage{Peers: make([]*PeerMessage, 0, len(peers))}
+	for _, p := range peers {
+		msg.Peers = append(msg.Peers, &PeerMessage{
+			ID:      p.ID,
+			Name:    p.Name,
+			Address: p.Network.RemoteAddress,
+			Inbound: p.Network.Inbound,
+			Caps:    p.Caps,
+		})
+	}
+	return msg
+}
+
+func collectTxPool(client *rpc.Client) *TxPoolMessage {
+	var status map[string]hexutil.Uint
+	if !call(client, &status, "txpool_status") {
+		return nil
+	}
+	return &TxPoolMessage{
+		Pending: uint64(status["pending"]),
+		Queued:  uint64(status["queued"]),
+	}
+}
+
+func collectStorage(client *rpc.Client) *StorageMessage {
+	var status *torrentfs.StorageStatus
+	if !call(client, &status, "torrent_storage") {
+		return nil
+	}
+	var torrents []*torrentfs.TorrentStatus
+	if !call(client, &torrents, "torrent_torrents", "") {
+		return nil
+	}
+	msg := &StorageMessage{
+		Status:   status,
+		States:   make(map[string]int),
+		Torrents: torrents,
+	}
+	for _, t := range torrents {
+		msg.States[t.State]++
+	}
+	return msg
+}
+
+// collectSynapse reads the metrics of the inference engine from r, if the
+// engine is built into the node.
+func collectSynapse(r metrics.Registry) *SynapseMessage {
+	if !metrics.Enabled || r.Get("synapse/model/loaded") == nil {
+		return nil
+	}
+	count := func(name string) int64 {
+		if m, ok := r.Get(name).(metrics.Meter); ok {
+			return m.Count()
+		}
+		return 0
+	}
+	value := func(name string) int64 {
+		if g, ok := r.Get(name).(metrics.Gauge); ok {
+			return g.Value()
+		}
+		return 0
+	}
+	return &SynapseMessage{
+		Models:      value("synapse/model/loaded"),
+		Memory:      value("synapse/model/memory"),
+		InferHits:   count("synapse/infer/cache/hit"),
+		InferMisses: count("synapse/infer/cache/miss"),
+		ModelHits:   count("synapse/model/cache/hit"),
+		ModelMisses: count("synapse/model/cache/miss"),
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dashboard implements a read-only web dashboard of the node.
+//
+// The dashboard collects the chain head, the peers, the transaction pool, the
+// torrentfs tasks and the inference engine caches through the in-process RPC
+// and the metrics registry, and pushes them to the browsers over websockets.
+// The web page is embedded into the binary.
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	clientQueue = 16              // number of pending updates per browser before dropping
+	minRefresh  = time.Second     // lowest collection rate allowed
+	rpcTimeout  = 5 * time.Second // time allowed to each RPC query of a collection
+)
+
+var (
+	errClosed = errors.New("dashboard closed")
+	errOrigin = errors.New("cross-origin request refused")
+	nextID    uint32 // next connection id
+)
+
+// Dashboard contains the dashboard internals.
+type Dashboard struct {
+	config *Config
+	commit string
+	attach func() (*rpc.Client, error) // dials the in-process RPC of the node
+
+	listener net.Listener
+	conns    map[uint32]*client // currently live websocket connections
+	last     *Message           // latest collected state, sent to new connections
+	lock     sync.RWMutex       // lock protecting the dashboard's internals
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// client represents active websocket connection with a remote browser.
+type client struct {
+	conn   *websocket.Conn // particular live websocket connection
+	msg    chan *Message   // message queue for the update messages
+	logger log.Logger      // logger for the particular live websocket connection
+}
+
+// New creates a new dashboard instance with the given configuration, reading
+// the node state through the RPC clients returned by attach.
+func New(config *Config, commit string, attach func() (*rpc.Client, error)) *Dashboard {
+	return &Dashboard{
+		config: config,
+		commit: commit,
+		attach: attach,
+		conns:  make(map[uint32]*client),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Protocols implements the node.Service interface.
+func (db *Dashboard) Protocols() []p2p.Protocol { return nil }
+
+// APIs implements the node.Service interface.
+func (db *Dashboard) APIs() []rpc.API { return nil }
+
+// Start starts the data collection thread and the listening server of the dashboard.
+// Implements the node.Service interface.
+func (db *Dashboard) Start(server *p2p.Server) error {
+	log.Info("Starting dashboard")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", db.webHandler)
+	mux.Handle("/api", websocket.Server{Handshake: checkOrigin, Handler: db.apiHandler})
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", db.config.Host, db.config.Port))
+	if err != nil {
+		return err
+	}
+	db.listener = listener
+
+	db.wg.Add(1)
+	go db.collectData()
+	go http.Serve(listener, mux)
+
+	log.Info("Dashboard started", "url", fmt.Sprintf("http://%s", listener.Addr()))
+	return nil
+}
+
+// Stop stops the data collection thread and the connection listener of the dashboard.
+// Implements the node.Service interface.
+func (db *Dashboard) Stop() error {
+	err := db.listener.Close()
+	close(db.quit)
+
+	// Close the connections, their handlers return once the reads fail
+	db.lock.Lock()
+	for _, c := range db.conns {
+		if err := c.conn.Close(); err != nil {
+			c.logger.Warn("Failed to close connection", "err", err)
+		}
+	}
+	db.lock.Unlock()
+
+	db.wg.Wait()
+	log.Info("Dashboard stopped")
+	return err
+}
+
+// webHandler handles all non-api requests, simply serving the embedded page.
+func (db *Dashboard) webHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("Request", "URL", r.URL)
+
+	if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write([]byte(indexHTML))
+}
+
+// checkOrigin refuses the websocket connections opened by the pages of other
+// sites, which would otherwise read the node state through the browser. The
+// clients sending no origin are not browsers and are accepted.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	if r.Header.Get("Origin") == "" {
+		return nil
+	}
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host != r.Host {
+		return errOrigin
+	}
+	config.Origin = origin
+	return nil
+}
+
+// apiHandler handles requests for the dashboard. The connection is read-only,
+// anything sent by the browser is discarded.
+func (db *Dashboard) apiHandler(conn *websocket.Conn) {
+	id := atomic.AddUint32(&nextID, 1)
+	client := &client{
+		conn:   conn,
+		msg:    make(chan *Message, clientQueue),
+		logger: log.New("id", id),
+	}
+	done := make(chan struct{})
+
+	// Start listening for messages to send.
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			case msg := <-client.msg:
+				if err := websocket.JSON.Send(client.conn, msg); err != nil {
+					client.logger.Debug("Failed to send the message", "err", err)
+					client.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	// Send the latest state to the new client and register it for the updates.
+	db.lock.Lock()
+	select {
+	case <-db.quit:
+		db.lock.Unlock()
+		close(done)
+		return
+	default:
+	}
+	if db.last != nil {
+		client.msg <- db.last
+	}
+	db.conns[id] = client
+	db.lock.Unlock()
+	defer func() {
+		db.lock.Lock()
+		delete(db.conns, id)
+		db.lock.Unlock()
+	}()
+	for {
+		var msg interface{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			close(done)
+			return
+		}
+	}
+}
+
+// collectData collects the node state and pushes it to the connected browsers
+// on every refresh.
+func (db *Dashboard) collectData() {
+	defer db.wg.Done()
+
+	refresh := db.config.Refresh
+	if refresh < minRefresh {
+		refresh = minRefresh
+	}
+	var rpcClient *rpc.Client
+	defer func() {
+		if rpcClient != nil {
+			rpcClient.Close()
+		}
+	}()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-db.quit:
+			return
+		case <-timer.C:
+		}
+		if rpcClient == nil {
+			client, err := db.dial()
+			if err == errClosed {
+				return
+			}
+			if err != nil {
+				log.Debug("Dashboard waiting for the node RPC", "err", err)
+			}
+			rpcClient = client
+		}
+		db.sendToAll(db.collect(rpcClient))
+		timer.Reset(refresh)
+	}
+}
+
+// dial attaches to the in-process RPC of the node. The node holds its lock
+// while it starts or stops the services, so the attachment is given up when
+// the dashboard is stopped meanwhile.
+func (db *Dashboard) dial() (*rpc.Client, error) {
+	type result struct {
+		client *rpc.Client
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client, err := db.attach()
+		done <- result{client, err}
+	}()
+	select {
+	case res := <-done:
+		return res.client, res.err
+	case <-db.quit:
+		go func() {
+			if res := <-done; res.client != nil {
+				res.client.Close()
+			}
+		}()
+		return nil, errClosed
+	}
+}
+
+// sendToAll records msg as the latest state and sends it to the active
+// clients. The update is dropped for the clients too slow to keep up.
+func (db *Dashboard) sendToAll(msg *Message) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.last = msg
+	for _, c := range db.conns {
+		select {
+		case c.msg <- msg:
+		default:
+			c.logger.Debug("Dashboard client too slow, update dropped")
+		}
+	}
+}
+
+// version returns the version string of the node build.
+func (db *Dashboard) version() string {
+	return params.VersionWithCommit(db.commit)
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package dashboard
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
+	"golang.org/x/net/websocket"
+)
+
+type TestChain struct{}
+
+func (TestChain) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) map[string]interface{} {
+	return map[string]interface{}{
+		"number":       (*hexutil.Big)(big.NewInt(1024)),
+		"hash":         common.HexToHash("0x01"),
+		"timestamp":    (*hexutil.Big)(big.NewInt(1570000000)),
+		"difficulty":   (*hexutil.Big)(big.NewInt(123456)),
+		"gasUsed":      hexutil.Uint64(21000),
+		"gasLimit":     hexutil.Uint64(8000000),
+		"transactions": []common.Hash{common.HexToHash("0x02")},
+		"quota":        new(big.Int).Lsh(big.NewInt(1), 70),
+		"quotaUsed":    big.NewInt(4096),
+	}
+}
+
+type TestAdmin struct{}
+
+func (TestAdmin) Peers() []*p2p.PeerInfo {
+	peer := &p2p.PeerInfo{ID: "a1b2", Name: "<b>cortex</b>", Caps: []string{"ctxc/63"}}
+	peer.Network.RemoteAddress = "10.0.0.1:40404"
+	peer.Network.Inbound = true
+	return []*p2p.PeerInfo{peer}
+}
+
+type TestTxPool struct{}
+
+func (TestTxPool) Status() map[string]hexutil.Uint {
+	return map[string]hexutil.Uint{"pending": 3, "queued": 1}
+}
+
+type TestTorrent struct{}
+
+func (TestTorrent) Storage() *torrentfs.StorageStatus {
+	return &torrentfs.StorageStatus{Files: 2, DiskUsage: 1 << 20}
+}
+
+func (TestTorrent) Torrents(state string) []*torrentfs.TorrentStatus {
+	return []*torrentfs.TorrentStatus{
+		{State: "running", BytesRequested: 100, BytesCompleted: 50, Length: 200},
+		{State: "seeding", BytesRequested: 300, BytesCompleted: 300, Length: 300},
+		{State: "seeding", BytesRequested: 10, BytesCompleted: 10, Length: 10},
+	}
+}
+
+// newTestServer returns an RPC server exposing the queries of the dashboard,
+// the torrent ones only if storage is set.
+func newTestServer(t *testing.T, storage bool) *rpc.Server {
+	server := rpc.NewServer()
+	services := map[string]interface{}{
+		"ctxc":   TestChain{},
+		"admin":  TestAdmin{},
+		"txpool": TestTxPool{},
+	}
+	if storage {
+		services["torrent"] = TestTorrent{}
+	}
+	for name, service := range services {
+		if err := server.RegisterName(name, service); err != nil {
+			t.Fatalf("failed to register %s: %v", name, err)
+		}
+	}
+	return server
+}
+
+func TestCollect(t *testing.T) {
+	server := newTestServer(t, true)
+	defer server.Stop()
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	db := New(&Config{}, "abcdef", nil)
+	msg := db.collect(client)
+
+	if msg.General == nil || msg.General.Commit != "abcdef" {
+		t.Errorf("general section mismatch: %+v", msg.General)
+	}
+	want := &ChainMessage{
+		Number:     1024,
+		Hash:       common.HexToHash("0x01").Hex(),
+		Time:       1570000000,
+		Difficulty: "123456",
+		GasUsed:    21000,
+		GasLimit:   8000000,
+		Txs:        1,
+		Quota:      "1180591620717411303424",
+		QuotaUsed:  "4096",
+	}
+	if msg.Chain == nil || *msg.Chain != *want {
+		t.Errorf("chain section mismatch: have %+v, want %+v", msg.Chain, want)
+	}
+	if msg.Network == nil || len(msg.Network.Peers) != 1 || msg.Network.Peers[0].Address != "10.0.0.1:40404" || !msg.Network.Peers[0].Inbound {
+		t.Errorf("network section mismatch: %+v", msg.Network)
+	}
+	if msg.TxPool == nil || *msg.TxPool != (TxPoolMessage{Pending: 3, Queued: 1}) {
+		t.Errorf("txpool section mismatch: %+v", msg.TxPool)
+	}
+	if msg.Storage == nil || msg.Storage.Status.Files != 2 || len(msg.Storage.Torrents) != 3 {
+		t.Fatalf("storage section mismatch: %+v", msg.Storage)
+	}
+	if states := msg.Storage.States; len(states) != 2 || states["running"] != 1 || states["seeding"] != 2 {
+		t.Errorf("torrent states mismatch: %v", states)
+	}
+
+	// The sections of the missing services are left out
+	server = newTestServer(t, false)
+	defer server.Stop()
+	client = rpc.DialInProc(server)
+	defer client.Close()
+	if msg := db.collect(client); msg.Storage != nil || msg.Chain == nil {
+		t.Errorf("unexpected sections: storage %+v, chain %+v", msg.Storage, msg.Chain)
+	}
+	if msg := db.collect(nil); msg.General == nil || msg.Chain != nil || msg.Network != nil {
+		t.Errorf("unexpected sections without RPC: %+v", msg)
+	}
+}
+
+func TestCollectSynapse(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	r := metrics.NewRegistry()
+	if msg := collectSynapse(r); msg != nil {
+		t.Errorf("synapse section without the engine: %+v", msg)
+	}
+	metrics.NewRegisteredGauge("synapse/model/loaded", r).Update(2)
+	metrics.NewRegisteredGauge("synapse/model/memory", r).Update(1 << 30)
+	metrics.NewRegisteredMeter("synapse/infer/cache/hit", r).Mark(7)
+	metrics.NewRegisteredMeter("synapse/infer/cache/miss", r).Mark(3)
+	metrics.NewRegisteredMeter("synapse/model/cache/hit", r).Mark(2)
+
+	want := SynapseMessage{Models: 2, Memory: 1 << 30, InferHits: 7, InferMisses: 3, ModelHits: 2}
+	if msg := collectSynapse(r); msg == nil || *msg != want {
+		t.Errorf("synapse section mismatch: have %+v, want %+v", msg, want)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	server := newTestServer(t, true)
+	defer server.Stop()
+
+	db := New(&Config{Host: "127.0.0.1", Refresh: time.Second}, "", func() (*rpc.Client, error) {
+		return rpc.DialInProc(server), nil
+	})
+	if err := db.Start(nil); err != nil {
+		t.Fatalf("failed to start dashboard: %v", err)
+	}
+	defer db.Stop()
+	addr := db.listener.Addr().String()
+
+	// A page of another site can't open the websocket
+	if _, err := websocket.Dial(fmt.Sprintf("ws://%s/api", addr), "", "http://example.com"); err == nil {
+		t.Error("cross-origin connection accepted")
+	}
+	conn, err := websocket.Dial(fmt.Sprintf("ws://%s/api", addr), "", fmt.Sprintf("http://%s", addr))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// The state is pushed on connection, then on every refresh
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 2; i++ {
+		var msg Message
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("failed to receive message %d: %v", i, err)
+		}
+		if msg.Chain == nil || msg.Chain.Number != 1024 || msg.Storage == nil {
+			t.Errorf("message %d mismatch: %+v", i, msg)
+		}
+	}
+}
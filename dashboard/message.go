@@ -0,0 +1,91 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package dashboard
+
+import (
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
+)
+
+// Message is the snapshot of the node state pushed to the browsers on every
+// refresh. The sections whose source is not available on the node are left
+// out.
+type Message struct {
+	General *GeneralMessage `json:"general"`
+	Chain   *ChainMessage   `json:"chain,omitempty"`
+	Network *NetworkMessage `json:"network,omitempty"`
+	TxPool  *TxPoolMessage  `json:"txpool,omitempty"`
+	Storage *StorageMessage `json:"storage,omitempty"`
+	Synapse *SynapseMessage `json:"synapse,omitempty"`
+}
+
+// GeneralMessage identifies the node build.
+type GeneralMessage struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Time    int64  `json:"time"` // unix time of the collection
+}
+
+// ChainMessage describes the chain head and its upload quota usage.
+type ChainMessage struct {
+	Number     uint64 `json:"number"`
+	Hash       string `json:"hash"`
+	Time       uint64 `json:"time"`
+	Difficulty string `json:"difficulty"`
+	GasUsed    uint64 `json:"gasUsed"`
+	GasLimit   uint64 `json:"gasLimit"`
+	Txs        int    `json:"txs"`
+	Quota      string `json:"quota"`     // total upload quota of the chain
+	QuotaUsed  string `json:"quotaUsed"` // upload quota consumed by the chain
+}
+
+// NetworkMessage lists the connected peers.
+type NetworkMessage struct {
+	Peers []*PeerMessage `json:"peers"`
+}
+
+// PeerMessage describes a connected peer.
+type PeerMessage struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Inbound bool     `json:"inbound"`
+	Caps    []string `json:"caps"`
+}
+
+// TxPoolMessage reports the number of transactions in the pool.
+type TxPoolMessage struct {
+	Pending uint64 `json:"pending"`
+	Queued  uint64 `json:"queued"`
+}
+
+// StorageMessage reports the torrentfs tasks and the local file storage.
+type StorageMessage struct {
+	Status   *torrentfs.StorageStatus   `json:"status"`
+	States   map[string]int             `json:"states"` // number of torrents per state
+	Torrents []*torrentfs.TorrentStatus `json:"torrents"`
+}
+
+// SynapseMessage reports the models loaded by the inference engine and the
+// efficiency of its caches.
+type SynapseMessage struct {
+	Models      int64 `json:"models"`
+	Memory      int64 `json:"memory"` // bytes held by the loaded models
+	InferHits   int64 `json:"inferHits"`
+	InferMisses int64 `json:"inferMisses"`
+	ModelHits   int64 `json:"modelHits"`
+	ModelMisses int64 `json:"modelMisses"`
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/inference"
 	"github.com/CortexFoundation/CortexTheseus/inference/synapse/kernel"
 	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs"
 )
 
 const (
@@ -16,6 +18,15 @@ const (
 	PARAM_PATH  string = "/data/params"
 )
 
+var (
+	inferCacheHitMeter  = metrics.NewRegisteredMeter("synapse/infer/cache/hit", nil)
+	inferCacheMissMeter = metrics.NewRegisteredMeter("synapse/infer/cache/miss", nil)
+	modelCacheHitMeter  = metrics.NewRegisteredMeter("synapse/model/cache/hit", nil)
+	modelCacheMissMeter = metrics.NewRegisteredMeter("synapse/model/cache/miss", nil)
+	modelLoadedGauge    = metrics.NewRegisteredGauge("synapse/model/loaded", nil)
+	modelMemoryGauge    = metrics.NewRegisteredGauge("synapse/model/memory", nil)
+)
+
 func getReturnByStatusCode(ret interface{}, status int) (interface{}, error) {
 	switch status {
 	case kernel.ERROR_RUNTIME:
@@ -81,6 +92,7 @@ func (s *Synapse) inferByInfoHash(modelInfoHash, inputInfoHash string) (res []by
 
 	if v, ok := s.simpleCache.Load(cacheKey); ok && !s.config.IsNotCache {
 		log.Debug("Infer Success via Cache", "result", v.([]byte))
+		inferCacheHitMeter.Mark(1)
 		return v.([]byte), nil
 	}
 
@@ -119,8 +131,10 @@ func (s *Synapse) inferByInputContent(modelInfoHash, inputInfoHash string, input
 	cacheKey := RLPHashString(modelHash + "_" + inputHash)
 	if v, ok := s.simpleCache.Load(cacheKey); ok && !s.config.IsNotCache {
 		log.Debug("Infer Succeed via Cache", "result", v.([]byte))
+		inferCacheHitMeter.Mark(1)
 		return v.([]byte), nil
 	}
+	inferCacheMissMeter.Mark(1)
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	// lazy initialization of model cache
@@ -147,6 +161,7 @@ func (s *Synapse) inferByInputContent(modelInfoHash, inputInfoHash string, input
 
 	model_tmp, has_model := s.caches[s.config.DeviceId].Get(modelHash)
 	if !has_model {
+		modelCacheMissMeter.Mark(1)
 		modelJson, modelJson_err := s.config.Storagefs.GetFile(modelHash, SYMBOL_PATH)
 		if modelJson_err != nil || modelJson == nil {
 			log.Warn("inferByInputContent: model loaded failed",
@@ -168,8 +183,12 @@ func (s *Synapse) inferByInputContent(modelInfoHash, inputInfoHash string, input
 		if _, err := getReturnByStatusCode(model, status); err != nil {
 			return nil, KERNEL_RUNTIME_ERROR
 		}
-		s.caches[s.config.DeviceId].Add(modelHash, model, int64(model.Size()))
+		cache := s.caches[s.config.DeviceId]
+		cache.Add(modelHash, model, int64(model.Size()))
+		modelLoadedGauge.Update(int64(cache.Len()))
+		modelMemoryGauge.Update(cache.CurrentWeight)
 	} else {
+		modelCacheHitMeter.Mark(1)
 		model = model_tmp.(*kernel.Model)
 	}
 
@@ -186,6 +205,27 @@ func (s *Synapse) inferByInputContent(modelInfoHash, inputInfoHash string, input
 	return result, nil
 }
 
+// Fetch waits a while for the data of infoHash when the storage only
+// downloads it once requested. Block processing never waits, rpc calls fetch
+// the data before checking it is available.
+func (s *Synapse) Fetch(infoHash string) error {
+	if s.config.IsRemoteInfer {
+		return nil
+	}
+	lazy, ok := s.config.Storagefs.(torrentfs.LazyStorage)
+	if !ok {
+		return nil
+	}
+	if len(infoHash) < 2 || !strings.HasPrefix(infoHash, "0x") {
+		return KERNEL_RUNTIME_ERROR
+	}
+	if err := lazy.Fetch(strings.ToLower(infoHash[2:])); err != nil {
+		log.Debug("File fetch failed", "infoHash", infoHash, "error", err)
+		return KERNEL_RUNTIME_ERROR
+	}
+	return nil
+}
+
 func (s *Synapse) Available(infoHash string, rawSize int64) error {
 	if s.config.IsRemoteInfer {
 		errRes := s.remoteAvailable(
@@ -511,6 +511,16 @@ func (s *PublicBlockChainAPI) GetNum(ctx context.Context, address common.Address
 	return (*hexutil.Big)(state.GetNum(address)), state.Error()
 }
 
+// GetDeprecation returns the block number from which the model at address is
+// deprecated by its author, or zero if it has never been deprecated.
+func (s *PublicBlockChainAPI) GetDeprecation(ctx context.Context, address common.Address, blockNr rpc.BlockNumber) (*hexutil.Big, error) {
+	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(state.GetState(address, types.DeprecationKey).Big()), state.Error()
+}
+
 // GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned. When fullTx is true all
 // transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
 func (s *PublicBlockChainAPI) GetBlockByNumber(ctx context.Context, blockNr rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
@@ -742,13 +752,13 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 // Call executes the given transaction on the state for the given block number.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
 func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{}, 5*time.Second)
+	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{RPC_FetchStorage: true}, 5*time.Second)
 	return (hexutil.Bytes)(result), err
 }
 
 // same as Call, except for RPC_GetInternalTransaction flag with overwritten returns.
 func (s *PublicBlockChainAPI) GetInternalTransaction(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (string, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{RPC_GetInternalTransaction: true}, 5*time.Second)
+	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{RPC_GetInternalTransaction: true, RPC_FetchStorage: true}, 5*time.Second)
 	return (string)(result), err
 }
 
@@ -774,29 +784,31 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	cap = hi
 
 	// Create a helper to check if a gas allowance results in an executable transaction
-	executable := func(gas uint64) bool {
+	executable := func(gas uint64, config vm.Config) bool {
 		args.Gas = hexutil.Uint64(gas)
 
-		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, vm.Config{}, 0)
+		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, config, 0)
 		if err != nil || failed {
 			return false
 		}
 		return true
 	}
+	// Wait for the data fetched on request in lazy sync mode only once, at the
+	// highest allowance, the search runs against what is stored by then.
+	capable := executable(cap, vm.Config{RPC_FetchStorage: true})
+
 	// Execute the binary search and hone in on an executable gas limit
 	for lo+1 < hi {
 		mid := (hi + lo) / 2
-		if !executable(mid) {
+		if !executable(mid, vm.Config{}) {
 			lo = mid
 		} else {
 			hi = mid
 		}
 	}
 	// Reject the transaction as invalid if it still fails at the highest allowance
-	if hi == cap {
-		if !executable(hi) {
-			return 0, fmt.Errorf("gas required exceeds allowance or always failing transaction")
-		}
+	if hi == cap && !capable {
+		return 0, fmt.Errorf("gas required exceeds allowance or always failing transaction")
 	}
 	return hexutil.Uint64(hi), nil
 }
@@ -805,10 +817,11 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 // while replaying a transaction in debug mode as well as transaction
 // execution status, the amount of gas used and the return value
 type ExecutionResult struct {
-	Gas         uint64         `json:"gas"`
-	Failed      bool           `json:"failed"`
-	ReturnValue string         `json:"returnValue"`
-	StructLogs  []StructLogRes `json:"structLogs"`
+	Gas         uint64                 `json:"gas"`
+	Failed      bool                   `json:"failed"`
+	ReturnValue string                 `json:"returnValue"`
+	StructLogs  []StructLogRes         `json:"structLogs"`
+	Royalties   []*types.RoyaltyPayout `json:"royalties,omitempty"`
 }
 
 // StructLogRes stores a structured log emitted by the CVM while replaying a
@@ -1157,6 +1170,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 		"contractAddress":   nil,
 		"logs":              receipt.Logs,
 		"logsBloom":         receipt.Bloom,
+		"royalties":         receipt.Royalties,
 	}
 
 	// Assign receipt status or post state.
@@ -1168,6 +1182,9 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 	if receipt.Logs == nil {
 		fields["logs"] = [][]*types.Log{}
 	}
+	if receipt.Royalties == nil {
+		fields["royalties"] = []*types.RoyaltyPayout{}
+	}
 	// If the ContractAddress is 20 0x0 bytes, assume it is not a contract creation
 	if receipt.ContractAddress != (common.Address{}) {
 		fields["contractAddress"] = receipt.ContractAddress
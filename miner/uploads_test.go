@@ -0,0 +1,126 @@
+// Copyright 2019 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// testChain is the head of the chain the transaction pool runs on.
+type testChain struct {
+	statedb       *state.StateDB
+	number        *big.Int
+	chainHeadFeed *event.Feed
+}
+
+func (bc *testChain) CurrentBlock() *types.Block {
+	return types.NewBlock(&types.Header{
+		Number:   bc.number,
+		GasLimit: 1000000,
+	}, nil, nil, nil)
+}
+
+func (bc *testChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return bc.CurrentBlock()
+}
+
+func (bc *testChain) StateAt(common.Hash) (*state.StateDB, error) {
+	return bc.statedb, nil
+}
+
+func (bc *testChain) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return bc.chainHeadFeed.Subscribe(ch)
+}
+
+// Tests that the upload lane the worker fills blocks from only offers uploads
+// to targets ready for seeding, caps them per target by the remaining upload
+// and the configured slots, and orders them so that uploads fitting into the
+// block quota go first.
+func TestUploadLane(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+
+	// Create a small, a large and a not yet seeded upload target
+	var (
+		small = common.Address{0x01}
+		large = common.Address{0x02}
+		young = common.Address{0x03}
+	)
+	statedb.SetUpload(small, big.NewInt(1024))
+	statedb.SetNum(small, big.NewInt(1))
+	statedb.SetUpload(large, new(big.Int).SetUint64(4*params.PER_UPLOAD_BYTES))
+	statedb.SetNum(large, big.NewInt(1))
+	statedb.SetUpload(young, big.NewInt(1024))
+	statedb.SetNum(young, big.NewInt(100))
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		statedb.AddBalance(crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(10000000))
+	}
+	config := core.DefaultTxPoolConfig
+	config.Journal = ""
+	config.UploadSlots = 2
+
+	pool := core.NewTxPool(config, params.TestChainConfig, &testChain{statedb, big.NewInt(99), new(event.Feed)})
+	defer pool.Stop()
+
+	upload := func(nonce uint64, to common.Address, key *ecdsa.PrivateKey) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(0), params.UploadGas, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+		return tx
+	}
+	txs := types.Transactions{
+		upload(0, large, keys[0]), upload(1, large, keys[0]), upload(2, large, keys[0]),
+		upload(0, small, keys[1]), upload(1, small, keys[1]),
+		upload(0, young, keys[2]),
+	}
+	for i, err := range pool.AddRemotes(txs) {
+		if err != nil {
+			t.Fatalf("tx %d: failed to add upload: %v", i, err)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		if pending, _ := pool.Stats(); pending == len(txs) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("uploads not promoted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lane := pool.Uploads()
+	expect := types.Transactions{txs[3], txs[0], txs[1]}
+	if len(lane) != len(expect) {
+		t.Fatalf("upload lane length mismatch: have %d, want %d", len(lane), len(expect))
+	}
+	for i := range expect {
+		if lane[i].Hash() != expect[i].Hash() {
+			t.Errorf("upload lane %d: transaction mismatch: have %x, want %x", i, lane[i].Hash(), expect[i].Hash())
+		}
+	}
+}
@@ -767,6 +767,11 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 			// Reorg notification data race between the transaction pool and miner, skip account =
 			log.Trace("Skipping account with hight nonce", "sender", from, "nonce", tx.Nonce())
 			txs.Pop()
+
+		case core.ErrQuotaLimitReached:
+			// Pop the upload without enough block quota left, the account's later transactions depend on it
+			log.Trace("Quota limit exceeded for current block", "sender", from, "to", tx.To())
+			txs.Pop()
 		case nil:
 			// Everything ok, collect the logs and shift in the next transaction from the same account
 			coalescedLogs = append(coalescedLogs, logs...)
@@ -804,6 +809,81 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 	return false
 }
 
+// commitUploads fills the remaining upload quota of the block from the upload
+// lane of the transaction pool. Uploads are tried in lane order; the ones whose
+// nonce isn't executable yet are retried once an earlier upload of the same
+// account went in, until no further progress can be made.
+func (w *worker) commitUploads(uploads types.Transactions, coinbase common.Address, interrupt *int32) bool {
+	// Short circuit if current is nil
+	if w.current == nil {
+		return true
+	}
+
+	if w.current.gasPool == nil {
+		w.current.gasPool = new(core.GasPool).AddGas(w.current.header.GasLimit)
+	}
+
+	var coalescedLogs []*types.Log
+
+	for progress := true; progress && len(uploads) > 0; {
+		progress = false
+
+		var retries types.Transactions
+		for _, tx := range uploads {
+			// Leave the interrupt handling to the ordinary transaction filling
+			if interrupt != nil && atomic.LoadInt32(interrupt) != commitInterruptNone {
+				return atomic.LoadInt32(interrupt) == commitInterruptNewHead
+			}
+			if w.current.gasPool.Gas() < params.UploadGas {
+				log.Trace("Not enough gas for further uploads", "have", w.current.gasPool, "want", params.UploadGas)
+				break
+			}
+			quota := new(big.Int).Sub(w.current.header.Quota, w.current.header.QuotaUsed)
+			if quota.Sign() <= 0 {
+				log.Trace("Block quota exhausted", "quota", w.current.header.Quota, "used", w.current.header.QuotaUsed)
+				break
+			}
+			from, _ := types.Sender(w.current.signer, tx)
+			if nonce := w.current.state.GetNonce(from); tx.Nonce() != nonce {
+				if tx.Nonce() > nonce {
+					retries = append(retries, tx)
+				}
+				continue
+			}
+			// The target may have been completed by an upload earlier in this block
+			to := *tx.To()
+			if !w.current.state.Uploading(to) {
+				continue
+			}
+			if cost := core.Min(new(big.Int).SetUint64(params.PER_UPLOAD_BYTES), w.current.state.Upload(to)); quota.Cmp(cost) < 0 {
+				log.Trace("Skipping upload over block quota", "to", to, "cost", cost, "quota", quota)
+				continue
+			}
+			w.current.state.Prepare(tx.Hash(), common.Hash{}, w.current.tcount)
+
+			logs, err := w.commitTransaction(tx, coinbase)
+			if err != nil {
+				log.Debug("Upload transaction failed", "hash", tx.Hash(), "to", to, "err", err)
+				continue
+			}
+			coalescedLogs = append(coalescedLogs, logs...)
+			w.current.tcount++
+			progress = true
+		}
+		uploads = retries
+	}
+
+	if !w.isRunning() && len(coalescedLogs) > 0 {
+		cpy := make([]*types.Log, len(coalescedLogs))
+		for i, l := range coalescedLogs {
+			cpy[i] = new(types.Log)
+			*cpy[i] = *l
+		}
+		go w.mux.Post(core.PendingLogsEvent{Logs: cpy})
+	}
+	return false
+}
+
 func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
@@ -891,6 +971,13 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		w.updateSnapshot()
 		return
 	}
+	// Spend the block quota on the upload lane first, the ordinary transaction
+	// filling below skips the uploads already included by nonce.
+	if uploads := w.ctxc.TxPool().Uploads(); len(uploads) > 0 {
+		if w.commitUploads(uploads, w.coinbase, interrupt) {
+			return
+		}
+	}
 	// Split the pending transactions into locals and remotes
 	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
 	for _, account := range w.ctxc.TxPool().Locals() {
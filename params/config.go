@@ -166,6 +166,8 @@ var (
 		PetersburgBlock:     big.NewInt(0),
 		IstanbulBlock:       nil,
 		EWASMBlock:          nil,
+		RoyaltyBlock:        big.NewInt(0),
+		DeprecationBlock:    big.NewInt(0),
 		Cuckoo:              new(CuckooConfig),
 		Clique:              nil}
 
@@ -176,7 +178,7 @@ var (
 	// adding flags to the config to also have to set these fields.
 	// AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(CuckooConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, big.NewInt(0), big.NewInt(0), new(CuckooConfig), nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -205,6 +207,8 @@ type ChainConfig struct {
 	PetersburgBlock     *big.Int `json:"petersburgBlock,omitempty"`     // Petersburg switch block (nil = same as Constantinople)
 	IstanbulBlock       *big.Int `json:"istanbulBlock,omitempty"`       // Istanbul switch block (nil = no fork, 0 = already on istanbul)
 	EWASMBlock          *big.Int `json:"ewasmBlock,omitempty"`          // EWASM switch block (nil = no fork, 0 = already activated)
+	RoyaltyBlock        *big.Int `json:"royaltyBlock,omitempty"`        // Model royalty distribution switch block (nil = no fork, 0 = already activated)
+	DeprecationBlock    *big.Int `json:"deprecationBlock,omitempty"`    // Model deprecation switch block (nil = no fork, 0 = already activated)
 	// Various consensus engines
 	Cuckoo *CuckooConfig `json:"cuckoo,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
@@ -239,7 +243,7 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v TangerineWhistle(EIP150): %v SpuriousDragon(EIP155): %v SpuriousDragon(EIP158): %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v TangerineWhistle(EIP150): %v SpuriousDragon(EIP155): %v SpuriousDragon(EIP158): %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v Royalty: %v Deprecation: %v Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -251,6 +255,8 @@ func (c *ChainConfig) String() string {
 		c.ConstantinopleBlock,
 		c.PetersburgBlock,
 		c.IstanbulBlock,
+		c.RoyaltyBlock,
+		c.DeprecationBlock,
 		engine,
 	)
 }
@@ -306,6 +312,16 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
 }
 
+// IsRoyalty returns whether num is either equal to the model royalty fork block or greater.
+func (c *ChainConfig) IsRoyalty(num *big.Int) bool {
+	return isForked(c.RoyaltyBlock, num)
+}
+
+// IsDeprecation returns whether num is either equal to the model deprecation fork block or greater.
+func (c *ChainConfig) IsDeprecation(num *big.Int) bool {
+	return isForked(c.DeprecationBlock, num)
+}
+
 // GasTable returns the gas table corresponding to the current phase (homestead or homestead reprice).
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
@@ -415,6 +431,12 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	if isForkIncompatible(c.RoyaltyBlock, newcfg.RoyaltyBlock, head) {
+		return newCompatError("royalty fork block", c.RoyaltyBlock, newcfg.RoyaltyBlock)
+	}
+	if isForkIncompatible(c.DeprecationBlock, newcfg.DeprecationBlock, head) {
+		return newCompatError("deprecation fork block", c.DeprecationBlock, newcfg.DeprecationBlock)
+	}
 	return nil
 }
 
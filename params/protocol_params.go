@@ -168,9 +168,10 @@ const (
 	SeedingBlks = 6   // TESTING: for torrent seed spreading
 	MatureBlks  = 100 // Blocks between model uploading tx and model ready for use.
 	// For the full node to synchronize the models
-	BernardMatureBlks = 10                  // TESTING: For the full node to synchronize the models, in dolores testnet
-	DoloresMatureBlks = 1                   // TESTING: For the full node to synchronize the models, in dolores testnet
-	ExpiredBlks       = 1000000000000000000 // TESTING: Model expire blocks. Not effective. 8409600
+	BernardMatureBlks    = 10                  // TESTING: For the full node to synchronize the models, in dolores testnet
+	DoloresMatureBlks    = 1                   // TESTING: For the full node to synchronize the models, in dolores testnet
+	ExpiredBlks          = 1000000000000000000 // TESTING: Model expire blocks. Not effective. 8409600
+	DeprecationGraceBlks = 40320               // Blocks a deprecated model keeps being seeded after it takes effect
 
 	PER_UPLOAD_BYTES       uint64 = 1 * 512 * 1024     // Step of each progress update about how many bytes per upload tx
 	DEFAULT_UPLOAD_BYTES   uint64 = 0                  // Default upload bytes
@@ -178,6 +179,8 @@ const (
 	MODEL_MAX_UPLOAD_BYTES uint64 = 1024 * 1024 * 1024 // Maximum size of a model
 	MODEL_GAS_LIMIT        uint64 = 20000              // Max gas limit for a model inference's reward to the author
 	MODEL_GAS_UP_LIMIT     uint64 = 400000
+	ROYALTY_BASIS_POINTS   uint64 = 10000 // Royalty shares of a model are expressed in basis points of its model gas
+	MAX_ROYALTY_RECEIVERS         = 16    // Maximum number of royalty beneficiaries of a model
 
 	//CONFIRM_TIME   = -60                 // TESTING:* time.Second block should be protected past this time
 	//CONFIRM_BLOCKS = 12                  // TESTING
@@ -0,0 +1,372 @@
+package torrentfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	statePending = "pending"
+	stateActive  = "active"
+	statePaused  = "paused"
+	stateSeeding = "seeding"
+	// Failed scrubbing, fetched again before being served
+	stateQuarantined = "quarantined"
+)
+
+// TorrentStatus is the progress report of a single torrent.
+type TorrentStatus struct {
+	InfoHash       metainfo.Hash   `json:"infoHash"`
+	ContractAddr   *common.Address `json:"contractAddr,omitempty"`
+	State          string          `json:"state"`
+	BytesRequested uint64          `json:"bytesRequested"`
+	BytesCompleted uint64          `json:"bytesCompleted"`
+	Length         uint64          `json:"length"`
+	Pieces         int             `json:"pieces"`
+	Peers          int             `json:"peers"`
+	Seeders        int             `json:"seeders"`
+	Speed          uint64          `json:"speed"` // bytes per second since the previous query
+	Boosting       bool            `json:"boosting"`
+	Forced         bool            `json:"forced"`
+	Scrubbed       int64           `json:"scrubbed,omitempty"` // unix time of the last verification
+	Priority       int             `json:"priority"`
+}
+
+// StorageStatus reports the state of the local file storage.
+type StorageStatus struct {
+	CheckPoint            hexutil.Uint64 `json:"checkPoint"`
+	LastListenBlockNumber hexutil.Uint64 `json:"lastListenBlockNumber"`
+	Root                  common.Hash    `json:"root"`
+	Files                 int            `json:"files"`
+	DiskUsage             uint64         `json:"diskUsage"`
+	DiskQuota             uint64         `json:"diskQuota"` // 0 when unlimited
+	Evicted               int            `json:"evicted"`
+	Key                   string         `json:"key,omitempty"` // id of the key encrypting the data at rest
+}
+
+// StorageFile is a file recorded in the storage, whether its torrent is
+// loaded or not.
+type StorageFile struct {
+	InfoHash     metainfo.Hash   `json:"infoHash"`
+	ContractAddr *common.Address `json:"contractAddr,omitempty"`
+}
+
+type progressSample struct {
+	bytes int64
+	at    mclock.AbsTime
+}
+
+// PrivateTorrentAPI provides the torrent_ namespace to inspect and steer the
+// downloads of the torrent file system.
+type PrivateTorrentAPI struct {
+	fs *TorrentFS
+
+	lock    sync.Mutex
+	samples map[metainfo.Hash]progressSample
+}
+
+// NewPrivateTorrentAPI creates a new torrent API.
+func NewPrivateTorrentAPI(fs *TorrentFS) *PrivateTorrentAPI {
+	return &PrivateTorrentAPI{
+		fs:      fs,
+		samples: make(map[metainfo.Hash]progressSample),
+	}
+}
+
+// resolve maps target, either an info hash or the address of a model or input
+// contract, to the info hash of its torrent.
+func (api *PrivateTorrentAPI) resolve(target string) (metainfo.Hash, error) {
+	var ih metainfo.Hash
+	if !strings.HasPrefix(target, "0x") && !strings.HasPrefix(target, "0X") {
+		target = "0x" + target
+	}
+	b, err := hexutil.Decode(target)
+	if err != nil {
+		return ih, err
+	}
+	if len(b) != len(ih) {
+		return ih, fmt.Errorf("invalid info hash or address length %d", len(b))
+	}
+	if f := api.fs.monitor.fs.GetFileByAddr(common.BytesToAddress(b)); f != nil {
+		return f.Meta.InfoHash, nil
+	}
+	copy(ih[:], b)
+	return ih, nil
+}
+
+func (api *PrivateTorrentAPI) torrent(target string) (*Torrent, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return nil, err
+	}
+	if t := api.fs.monitor.dl.GetTorrent(ih); t != nil {
+		return t, nil
+	}
+	return nil, errTorrentNotFound
+}
+
+func (api *PrivateTorrentAPI) status(t *Torrent, addrs map[metainfo.Hash]*common.Address) *TorrentStatus {
+	s := api.fs.monitor.dl.Status(t)
+	ih := s.InfoHash
+	s.ContractAddr = addrs[ih]
+	stats := t.Torrent.Stats()
+	s.Peers, s.Seeders = stats.ActivePeers, stats.ConnectedSeeders
+	if t.Torrent.Info() == nil {
+		return s
+	}
+	completed := t.BytesCompleted()
+	s.BytesCompleted, s.Length, s.Pieces = uint64(completed), uint64(t.Length()), t.Torrent.NumPieces()
+
+	now := mclock.Now()
+	api.lock.Lock()
+	if last, ok := api.samples[ih]; ok && completed > last.bytes {
+		if elapsed := time.Duration(now - last.at).Seconds(); elapsed > 0 {
+			s.Speed = uint64(float64(completed-last.bytes) / elapsed)
+		}
+	}
+	api.samples[ih] = progressSample{completed, now}
+	api.lock.Unlock()
+	return s
+}
+
+func (api *PrivateTorrentAPI) addrs() map[metainfo.Hash]*common.Address {
+	addrs := make(map[metainfo.Hash]*common.Address)
+	fs := api.fs.monitor.fs
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	for _, f := range fs.filesContractAddr {
+		addrs[f.Meta.InfoHash] = f.ContractAddr
+	}
+	return addrs
+}
+
+// Torrents lists the torrents in the given state, one of pending, active,
+// paused, seeding or quarantined. An empty state lists all of them.
+func (api *PrivateTorrentAPI) Torrents(state string) ([]*TorrentStatus, error) {
+	switch state {
+	case "", statePending, stateActive, statePaused, stateSeeding, stateQuarantined:
+	default:
+		return nil, fmt.Errorf("unknown torrent state %q", state)
+	}
+	addrs := api.addrs()
+	list := []*TorrentStatus{}
+	for _, t := range api.fs.monitor.dl.Torrents() {
+		if t.Dropped() {
+			continue
+		}
+		if s := api.status(t, addrs); state == "" || s.State == state {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// Files lists the files created on chain and recorded in the storage,
+// including those whose torrents are evicted or deferred in lazy sync mode.
+func (api *PrivateTorrentAPI) Files() []*StorageFile {
+	list := []*StorageFile{}
+	for ih, addr := range api.addrs() {
+		list = append(list, &StorageFile{InfoHash: ih, ContractAddr: addr})
+	}
+	return list
+}
+
+// Torrent reports the progress of the torrent of an info hash or contract address.
+func (api *PrivateTorrentAPI) Torrent(target string) (*TorrentStatus, error) {
+	t, err := api.torrent(target)
+	if err != nil {
+		return nil, err
+	}
+	return api.status(t, api.addrs()), nil
+}
+
+// Pause stops downloading the torrent until it is resumed.
+func (api *PrivateTorrentAPI) Pause(target string) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	err = api.fs.monitor.dl.PauseTorrent(ih)
+	return err == nil, err
+}
+
+// Resume continues downloading a paused torrent.
+func (api *PrivateTorrentAPI) Resume(target string) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	err = api.fs.monitor.dl.ResumeTorrent(ih)
+	return err == nil, err
+}
+
+// Verify re-checks the pieces of the torrent against their hashes.
+func (api *PrivateTorrentAPI) Verify(target string) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	err = api.fs.monitor.dl.VerifyTorrent(ih)
+	return err == nil, err
+}
+
+// Scrub re-verifies the seeded data of the torrent and its metainfo in the
+// background. Corrupt data is quarantined and fetched again.
+func (api *PrivateTorrentAPI) Scrub(target string) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	err = api.fs.monitor.dl.ScrubTorrent(ih)
+	return err == nil, err
+}
+
+// Prioritize downloads the torrent ahead of the others for a while, with
+// more connections.
+func (api *PrivateTorrentAPI) Prioritize(target string) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	err = api.fs.monitor.dl.PrioritizeTorrent(ih, priorityRPC)
+	return err == nil, err
+}
+
+// Fetch downloads the torrent, deferred in lazy sync mode or evicted, and
+// waits up to timeout seconds for it to complete. It reports whether the
+// data is available.
+func (api *PrivateTorrentAPI) Fetch(target string, timeout int) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	switch err = api.fs.monitor.dl.FetchTorrent(ih, priorityRPC, time.Duration(timeout)*time.Second); err {
+	case nil:
+		return true, nil
+	case errFetchTimeout:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Seed downloads the whole torrent regardless of its upload quota and seeds it.
+func (api *PrivateTorrentAPI) Seed(target string) (bool, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return false, err
+	}
+	err = api.fs.monitor.dl.SeedTorrent(ih)
+	return err == nil, err
+}
+
+// Shaping reports the bandwidth and connection limits of the torrent client.
+func (api *PrivateTorrentAPI) Shaping() Shaping {
+	return api.fs.monitor.dl.Shaping()
+}
+
+// SetShaping replaces the bandwidth and connection limits of the torrent
+// client, and returns them as applied.
+func (api *PrivateTorrentAPI) SetShaping(s Shaping) (Shaping, error) {
+	if err := api.fs.monitor.dl.SetShaping(s); err != nil {
+		return Shaping{}, err
+	}
+	return api.fs.monitor.dl.Shaping(), nil
+}
+
+// Blocklist lists the torrents blocked from being fetched or served.
+func (api *PrivateTorrentAPI) Blocklist() []BlockedTorrent {
+	return api.fs.monitor.dl.Blocklist().List()
+}
+
+// ReloadBlocklist reads the local blocklist and fetches the remote one
+// without waiting for them to change.
+func (api *PrivateTorrentAPI) ReloadBlocklist() ([]BlockedTorrent, error) {
+	list := api.fs.monitor.dl.Blocklist()
+	if list == nil {
+		return nil, errors.New("no blocklist")
+	}
+	err := list.Reload()
+	return list.List(), err
+}
+
+// Storage reports the checkpoint and Merkle root of the file storage, and the
+// disk usage of the torrent data.
+func (api *PrivateTorrentAPI) Storage() *StorageStatus {
+	fs := api.fs.monitor.fs
+	fs.lock.RLock()
+	files := len(fs.filesContractAddr)
+	fs.lock.RUnlock()
+	usage, quota, evicted := api.fs.monitor.dl.DiskUsage()
+	return &StorageStatus{
+		CheckPoint:            hexutil.Uint64(fs.CheckPoint),
+		LastListenBlockNumber: hexutil.Uint64(fs.LastListenBlockNumber),
+		Root:                  fs.Root(),
+		Files:                 files,
+		DiskUsage:             uint64(usage),
+		DiskQuota:             uint64(quota),
+		Evicted:               evicted,
+		Key:                   api.fs.monitor.dl.StorageKey(),
+	}
+}
+
+// RotateKey encrypts the torrent data under a new storage key, re-encrypting
+// the stored data in the background, and returns the id of the key.
+func (api *PrivateTorrentAPI) RotateKey() (string, error) {
+	return api.fs.monitor.dl.RotateStorageKey()
+}
+
+// Catalog exports the blocks and files of the storage, for another node to
+// import instead of scanning the chain.
+func (api *PrivateTorrentAPI) Catalog() *Catalog {
+	return api.fs.monitor.fs.Catalog()
+}
+
+// Proof returns the inclusion proof of the record block at number, and of the
+// files it created, against the root of the storage at root, or its
+// checkpoint if nil.
+func (api *PrivateTorrentAPI) Proof(number hexutil.Uint64, root *hexutil.Uint64) (*BlockProof, error) {
+	fs := api.fs.monitor.fs
+	at := fs.CheckPoint
+	if root != nil {
+		at = uint64(*root)
+	}
+	return fs.Proof(uint64(number), at)
+}
+
+// FileProof returns the inclusion proof of the record block which created the
+// file of target, either an info hash or a contract address, against the root
+// of the storage at root, or its checkpoint if nil.
+func (api *PrivateTorrentAPI) FileProof(target string, root *hexutil.Uint64) (*BlockProof, error) {
+	ih, err := api.resolve(target)
+	if err != nil {
+		return nil, err
+	}
+	fs := api.fs.monitor.fs
+	f := fs.GetFileByHash(ih)
+	if f == nil {
+		return nil, errTorrentNotFound
+	}
+	at := fs.CheckPoint
+	if root != nil {
+		at = uint64(*root)
+	}
+	return fs.FileProof(f, at)
+}
+
+// VerifyProof checks a proof returned by Proof or FileProof against a trusted
+// root.
+func (api *PrivateTorrentAPI) VerifyProof(proof BlockProof, root common.Hash) (bool, error) {
+	if err := VerifyBlockProof(&proof, root); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,126 @@
+package torrentfs
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// ChainHeadEvent is posted by a ChainBackend when the head of the chain moves.
+type ChainHeadEvent struct {
+	Number uint64
+	Hash   common.Hash
+}
+
+// chainReader is what the Monitor reads from the chain it follows.
+type chainReader interface {
+	CurrentNumber() (uint64, error)
+	GetBlockByNumber(number uint64) (*Block, error)
+	GetReceipt(txHash common.Hash) (*TxReceipt, error)
+	GetUpload(addr common.Address) (uint64, error)
+	GenesisMetas() ([]GenesisMeta, error)
+}
+
+// GenesisMeta is a model or input registered in the genesis allocation. No
+// create transaction announces it, so the Monitor seeds it at startup.
+type GenesisMeta struct {
+	Addr common.Address
+	Meta *FileMeta
+}
+
+// ParseGenesisDump returns the metas registered in the genesis allocation out
+// of a dump of the genesis state. They are the accounts marked with
+// types.GenesisMetaKey, whose code is laid out as the payload of the
+// transaction creating an uploaded meta.
+func ParseGenesisDump(dump state.Dump) []GenesisMeta {
+	key := common.Bytes2Hex(types.GenesisMetaKey[:])
+	var metas []GenesisMeta
+	for addr, account := range dump.Accounts {
+		if _, ok := account.Storage[key]; !ok {
+			continue
+		}
+		code, err := hexutil.Decode(account.Code)
+		if err != nil {
+			continue
+		}
+		if meta := (&Transaction{Payload: code}).Parse(); meta != nil {
+			metas = append(metas, GenesisMeta{Addr: common.HexToAddress(addr), Meta: meta})
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return bytes.Compare(metas[i].Addr[:], metas[j].Addr[:]) < 0
+	})
+	return metas
+}
+
+// ChainBackend gives the Monitor of a torrentfs embedded in a node direct
+// access to the blockchain, in place of the RPC connection.
+type ChainBackend interface {
+	chainReader
+	SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription
+}
+
+// NewTxsEvent is posted by a TxPoolBackend when transactions enter the pool.
+type NewTxsEvent struct {
+	Txs []Transaction
+}
+
+// TxPoolBackend is optionally implemented by a ChainBackend to report the
+// pending transactions, so the data they are about to use is fetched first.
+type TxPoolBackend interface {
+	SubscribeNewTxsEvent(ch chan<- NewTxsEvent) event.Subscription
+}
+
+// rpcBackend reads the chain over the IPC or HTTP endpoint of a node.
+type rpcBackend struct {
+	cl *rpc.Client
+}
+
+func (b *rpcBackend) CurrentNumber() (uint64, error) {
+	var number hexutil.Uint64
+	if err := b.cl.Call(&number, "ctxc_blockNumber"); err != nil {
+		return 0, err
+	}
+	return uint64(number), nil
+}
+
+func (b *rpcBackend) GetBlockByNumber(number uint64) (*Block, error) {
+	block := &Block{}
+	if err := b.cl.Call(block, "ctxc_getBlockByNumber", "0x"+strconv.FormatUint(number, 16), true); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (b *rpcBackend) GetReceipt(txHash common.Hash) (*TxReceipt, error) {
+	receipt := &TxReceipt{}
+	if err := b.cl.Call(receipt, "ctxc_getTransactionReceipt", txHash.String()); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+func (b *rpcBackend) GetUpload(addr common.Address) (uint64, error) {
+	var remaining hexutil.Uint64
+	if err := b.cl.Call(&remaining, "ctxc_getUpload", addr.String(), "latest"); err != nil {
+		return 0, err
+	}
+	return uint64(remaining), nil
+}
+
+// GenesisMetas reads the genesis state over the debug API, which the node may
+// not expose over HTTP.
+func (b *rpcBackend) GenesisMetas() ([]GenesisMeta, error) {
+	var dump state.Dump
+	if err := b.cl.Call(&dump, "debug_dumpBlock", "0x0"); err != nil {
+		return nil, err
+	}
+	return ParseGenesisDump(dump), nil
+}
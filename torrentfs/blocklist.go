@@ -0,0 +1,418 @@
+package torrentfs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Sources of the blocked torrents.
+const (
+	blockBuiltin = "builtin"
+	blockFile    = "file"
+	blockRemote  = "remote"
+)
+
+const (
+	// blocklistCheckInterval is how often the local list is checked for
+	// changes.
+	blocklistCheckInterval = 10 * time.Second
+	// maxBlocklistSize bounds the remote list downloaded.
+	maxBlocklistSize = 4 * 1024 * 1024
+	// blocklistVersionFile keeps the version of the last remote list
+	// accepted across restarts.
+	blocklistVersionFile = ".blocklist_version"
+)
+
+var (
+	errBlocked = errors.New("content blocked")
+
+	blockedGauge = metrics.NewRegisteredGauge("torrent/blocked", nil)
+)
+
+// BlockedTorrent is an entry of the blocklist.
+type BlockedTorrent struct {
+	InfoHash metainfo.Hash `json:"infoHash"`
+	Source   string        `json:"source"`
+	Reason   string        `json:"reason,omitempty"`
+}
+
+// Blocklist holds the torrents which must not be fetched nor served. They
+// come from the compiled-in BadFiles, a local file and a remote list signed
+// by a trusted key, all of them reloaded as they change.
+//
+// Both lists hold one hex info hash per line, optionally followed by the
+// reason of the block; empty lines and those starting with # are skipped.
+// The remote list at url is signed at url + ".sig", the hex encoded
+// signature of the Keccak256 hash of the list by the signer. It starts with a
+// "# version N" line, and a list older than the one accepted last is refused
+// so that a stale list cannot be replayed to unblock torrents.
+type Blocklist struct {
+	file        string
+	url         string
+	signer      common.Address
+	refresh     time.Duration
+	versionFile string
+
+	// Called with the torrents entering and leaving the list
+	onBlock, onUnblock func(ih metainfo.Hash)
+
+	updating sync.Mutex // serializes update, the loop and Reload both call it
+
+	lock    sync.RWMutex
+	entries map[metainfo.Hash]BlockedTorrent
+	local   map[metainfo.Hash]BlockedTorrent
+	remote  map[metainfo.Hash]BlockedTorrent // last verified remote list
+	version uint64                           // of the remote list
+	modTime time.Time                        // of the local list loaded
+	fetched time.Time                        // of the remote list
+}
+
+// NewBlocklist creates the blocklist of config, holding the built-in entries
+// until loaded.
+func NewBlocklist(config *Config) (*Blocklist, error) {
+	b := &Blocklist{
+		file:    config.BlocklistFile,
+		url:     config.BlocklistURL,
+		refresh: time.Duration(config.BlocklistRefresh) * time.Second,
+		entries: make(map[metainfo.Hash]BlockedTorrent),
+	}
+	if b.url != "" {
+		if !common.IsHexAddress(config.BlocklistSigner) {
+			return nil, fmt.Errorf("invalid blocklist signer %q", config.BlocklistSigner)
+		}
+		b.signer = common.HexToAddress(config.BlocklistSigner)
+		if b.refresh <= 0 {
+			b.refresh = defaultBlocklistRefresh * time.Second
+		}
+		if config.DataDir != "" {
+			b.versionFile = path.Join(config.DataDir, blocklistVersionFile)
+			if data, err := ioutil.ReadFile(b.versionFile); err == nil {
+				b.version, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+			}
+		}
+	}
+	b.update()
+	return b, nil
+}
+
+// Blocked reports whether ih is blocked.
+func (b *Blocklist) Blocked(ih metainfo.Hash) bool {
+	if b == nil {
+		return false
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	_, ok := b.entries[ih]
+	return ok
+}
+
+// List returns the blocked torrents, ordered by info hash.
+func (b *Blocklist) List() []BlockedTorrent {
+	list := []BlockedTorrent{}
+	if b == nil {
+		return list
+	}
+	b.lock.RLock()
+	for _, e := range b.entries {
+		list = append(list, e)
+	}
+	b.lock.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].InfoHash[:], list[j].InfoHash[:]) < 0
+	})
+	return list
+}
+
+// Reload reads the local list and fetches the remote one again. The
+// previous entries of a list failing to load are kept.
+func (b *Blocklist) Reload() error {
+	var errs []string
+	if err := b.loadFile(true); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := b.fetch(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	b.update()
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// loop reloads the lists as they change until quit is closed.
+func (b *Blocklist) loop(quit chan struct{}) {
+	ticker := time.NewTicker(blocklistCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.loadFile(false); err != nil {
+				log.Warn("Blocklist file not loaded", "file", b.file, "err", err)
+			}
+			if b.url != "" && time.Since(b.fetched) >= b.refresh {
+				if err := b.fetch(); err != nil {
+					log.Warn("Blocklist not fetched", "url", b.url, "err", err)
+				}
+			}
+			b.update()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// loadFile reads the local list when it changed, or always when forced. A
+// removed file empties it.
+func (b *Blocklist) loadFile(force bool) error {
+	if b.file == "" {
+		return nil
+	}
+	stat, err := os.Stat(b.file)
+	if os.IsNotExist(err) {
+		b.lock.Lock()
+		b.local, b.modTime = nil, time.Time{}
+		b.lock.Unlock()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	b.lock.RLock()
+	unchanged := stat.ModTime().Equal(b.modTime)
+	b.lock.RUnlock()
+	if unchanged && !force {
+		return nil
+	}
+	f, err := os.Open(b.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := parseBlocklist(f, blockFile)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.local, b.modTime = entries, stat.ModTime()
+	b.lock.Unlock()
+	log.Info("Blocklist file loaded", "file", b.file, "entries", len(entries))
+	return nil
+}
+
+// fetch downloads the remote list and keeps it if properly signed.
+func (b *Blocklist) fetch() error {
+	if b.url == "" {
+		return nil
+	}
+	b.lock.Lock()
+	b.fetched = time.Now()
+	b.lock.Unlock()
+
+	list, err := download(b.url)
+	if err != nil {
+		return err
+	}
+	sig, err := download(b.url + ".sig")
+	if err != nil {
+		return err
+	}
+	if err := verifyBlocklist(list, sig, b.signer); err != nil {
+		return err
+	}
+	version, err := blocklistVersion(list)
+	if err != nil {
+		return err
+	}
+	entries, err := parseBlocklist(bytes.NewReader(list), blockRemote)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if version < b.version {
+		return fmt.Errorf("blocklist version %d older than %d", version, b.version)
+	}
+	if version > b.version && b.versionFile != "" {
+		if err := ioutil.WriteFile(b.versionFile, []byte(strconv.FormatUint(version, 10)), 0600); err != nil {
+			return err
+		}
+	}
+	b.remote, b.version = entries, version
+	log.Info("Blocklist fetched", "url", b.url, "version", version, "entries", len(entries))
+	return nil
+}
+
+// update merges the lists, and notifies the torrents entering and leaving.
+func (b *Blocklist) update() {
+	b.updating.Lock()
+	defer b.updating.Unlock()
+
+	entries := make(map[metainfo.Hash]BlockedTorrent)
+	for hex := range BadFiles {
+		ih := metainfo.NewHashFromHex(hex)
+		entries[ih] = BlockedTorrent{InfoHash: ih, Source: blockBuiltin}
+	}
+	b.lock.Lock()
+	for _, list := range []map[metainfo.Hash]BlockedTorrent{b.remote, b.local} {
+		for ih, e := range list {
+			if _, ok := entries[ih]; !ok {
+				entries[ih] = e
+			}
+		}
+	}
+	var blocked, unblocked []metainfo.Hash
+	for ih := range entries {
+		if _, ok := b.entries[ih]; !ok {
+			blocked = append(blocked, ih)
+		}
+	}
+	for ih := range b.entries {
+		if _, ok := entries[ih]; !ok {
+			unblocked = append(unblocked, ih)
+		}
+	}
+	b.entries = entries
+	b.lock.Unlock()
+	blockedGauge.Update(int64(len(entries)))
+
+	for _, ih := range blocked {
+		if b.onBlock != nil {
+			b.onBlock(ih)
+		}
+	}
+	for _, ih := range unblocked {
+		log.Info("Seed unblocked", "hash", ih)
+		if b.onUnblock != nil {
+			b.onUnblock(ih)
+		}
+	}
+}
+
+func parseBlocklist(r io.Reader, source string) (map[metainfo.Hash]BlockedTorrent, error) {
+	entries := make(map[metainfo.Hash]BlockedTorrent)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var ih metainfo.Hash
+		if err := ih.FromHexString(strings.TrimPrefix(strings.ToLower(fields[0]), "0x")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid info hash %q", n, fields[0])
+		}
+		entries[ih] = BlockedTorrent{
+			InfoHash: ih,
+			Source:   source,
+			Reason:   strings.TrimSpace(strings.TrimPrefix(line, fields[0])),
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// blocklistVersion returns the version of list, given by its first line.
+func blocklistVersion(list []byte) (uint64, error) {
+	line := list
+	if i := bytes.IndexByte(list, '\n'); i >= 0 {
+		line = list[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) != 3 || fields[0] != "#" || fields[1] != "version" {
+		return 0, errors.New("blocklist version missing")
+	}
+	version, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid blocklist version %q", fields[2])
+	}
+	return version, nil
+}
+
+// verifyBlocklist checks that sig, the hex encoded signature of list, was
+// made by signer.
+func verifyBlocklist(list, sig []byte, signer common.Address) error {
+	raw, err := hexutil.Decode(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid blocklist signature: %v", err)
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(list), raw)
+	if err != nil {
+		return fmt.Errorf("invalid blocklist signature: %v", err)
+	}
+	if addr := crypto.PubkeyToAddress(*pub); addr != signer {
+		return fmt.Errorf("blocklist signed by %x, want %x", addr, signer)
+	}
+	return nil
+}
+
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxBlocklistSize))
+}
+
+// Blocklist returns the blocklist of the torrent manager.
+func (tm *TorrentManager) Blocklist() *Blocklist {
+	return tm.blocklist
+}
+
+// blockTorrent drops ih and purges its data from the disk. It is set aside
+// as deferred, to be fetched again on demand if unblocked.
+func (tm *TorrentManager) blockTorrent(ih metainfo.Hash) {
+	tm.lock.Lock()
+	t, ok := tm.torrents[ih]
+	if ok {
+		t.status = torrentDropped
+		t.Torrent.Drop()
+		delete(tm.torrents, ih)
+	}
+	delete(tm.evicted, ih)
+	delete(tm.priorities, ih)
+	if _, seen := tm.bytes[ih]; seen {
+		tm.deferred[ih] = struct{}{}
+	}
+	tm.lock.Unlock()
+
+	for _, dir := range []string{tm.DataDir, tm.TmpDataDir} {
+		tm.forgetKeys(path.Join(dir, ih.HexString()))
+		if err := os.RemoveAll(path.Join(dir, ih.HexString())); err != nil {
+			log.Warn("Blocked seed not purged", "hash", ih, "err", err)
+		}
+	}
+	if ok {
+		log.Warn("Blocked seed dropped and purged", "hash", ih)
+	}
+}
+
+// unblockTorrent fetches ih again, unless it is left to be requested in lazy
+// mode.
+func (tm *TorrentManager) unblockTorrent(ih metainfo.Hash) {
+	if !tm.lazy {
+		tm.AccessTorrent(ih)
+	}
+}
@@ -0,0 +1,204 @@
+package torrentfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	list := `
+# comment
+0x0102030405060708091011121314151617181920 abuse report 42
+  a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4
+`
+	entries, err := parseBlocklist(strings.NewReader(list), blockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	e := entries[metainfo.NewHashFromHex("0102030405060708091011121314151617181920")]
+	if e.Source != blockFile || e.Reason != "abuse report 42" {
+		t.Fatalf("entry %+v", e)
+	}
+	if _, err := parseBlocklist(strings.NewReader("nothex\n"), blockFile); err == nil {
+		t.Fatal("invalid info hash accepted")
+	}
+}
+
+func TestBlocklistFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "blocklist")
+
+	b, err := NewBlocklist(&Config{BlocklistFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocked, unblocked []metainfo.Hash
+	b.onBlock = func(ih metainfo.Hash) { blocked = append(blocked, ih) }
+	b.onUnblock = func(ih metainfo.Hash) { unblocked = append(unblocked, ih) }
+
+	ih := metainfo.Hash{1}
+	if err := ioutil.WriteFile(file, []byte(ih.HexString()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Blocked(ih) || len(blocked) != 1 || blocked[0] != ih {
+		t.Fatalf("not blocked: %v", blocked)
+	}
+	// Unchanged files are not reloaded, a later one is
+	if err := b.loadFile(false); err != nil {
+		t.Fatal(err)
+	}
+	b.update()
+	if len(blocked) != 1 {
+		t.Fatalf("blocked again: %v", blocked)
+	}
+	later := time.Now().Add(time.Minute)
+	ioutil.WriteFile(file, nil, 0644)
+	os.Chtimes(file, later, later)
+	if err := b.loadFile(false); err != nil {
+		t.Fatal(err)
+	}
+	b.update()
+	if b.Blocked(ih) || len(unblocked) != 1 || unblocked[0] != ih {
+		t.Fatalf("not unblocked: %v", unblocked)
+	}
+	for hex := range BadFiles {
+		if !b.Blocked(metainfo.NewHashFromHex(hex)) {
+			t.Fatalf("built-in entry %s not blocked", hex)
+		}
+	}
+}
+
+func TestBlocklistRemote(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	ih := metainfo.Hash{2}
+	list := []byte("# version 2\n" + ih.HexString() + " shared\n")
+
+	signer := key
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list":
+			w.Write(list)
+		case "/list.sig":
+			sig, _ := crypto.Sign(crypto.Keccak256(list), signer)
+			w.Write([]byte(hexutil.Encode(sig)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := NewBlocklist(&Config{BlocklistURL: srv.URL + "/list"}); err == nil {
+		t.Fatal("remote list without signer accepted")
+	}
+	dir, err := ioutil.TempDir("", "torrentfs-blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := &Config{
+		DataDir:         dir,
+		BlocklistURL:    srv.URL + "/list",
+		BlocklistSigner: crypto.PubkeyToAddress(key.PublicKey).Hex(),
+	}
+	b, err := NewBlocklist(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Blocked(ih) {
+		t.Fatal("remote entry not blocked")
+	}
+	// A list signed by another key is refused, the verified one kept
+	signer = other
+	list = []byte("# version 3\n" + metainfo.Hash{3}.HexString() + "\n")
+	if err := b.Reload(); err == nil {
+		t.Fatal("list of another signer accepted")
+	}
+	if !b.Blocked(ih) || b.Blocked(metainfo.Hash{3}) {
+		t.Fatal("verified list not kept")
+	}
+	// Older and unversioned lists are refused, even after a restart
+	signer = key
+	for _, stale := range []string{"# version 1\n", ""} {
+		list = []byte(stale + metainfo.Hash{3}.HexString() + "\n")
+		if err := b.Reload(); err == nil {
+			t.Fatalf("list %q accepted", stale)
+		}
+		if !b.Blocked(ih) || b.Blocked(metainfo.Hash{3}) {
+			t.Fatalf("list %q replaced the verified one", stale)
+		}
+	}
+	list = []byte("# version 1\n" + metainfo.Hash{3}.HexString() + "\n")
+	restarted, err := NewBlocklist(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Reload(); err == nil || restarted.Blocked(metainfo.Hash{3}) {
+		t.Fatalf("older list accepted after a restart: %v", err)
+	}
+	// A newer list replaces it
+	list = []byte("# version 3\n" + metainfo.Hash{3}.HexString() + "\n")
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Blocked(ih) || !b.Blocked(metainfo.Hash{3}) {
+		t.Fatal("newer list not applied")
+	}
+}
+
+// Tests that concurrent updates notify every torrent entering the list once.
+func TestBlocklistUpdateOnce(t *testing.T) {
+	b, err := NewBlocklist(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		lock    sync.Mutex
+		blocked = make(map[metainfo.Hash]int)
+	)
+	b.onBlock = func(ih metainfo.Hash) {
+		lock.Lock()
+		blocked[ih]++
+		lock.Unlock()
+	}
+	b.lock.Lock()
+	b.local = map[metainfo.Hash]BlockedTorrent{{4}: {InfoHash: metainfo.Hash{4}, Source: blockFile}}
+	b.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.update()
+		}()
+	}
+	wg.Wait()
+	if n := blocked[metainfo.Hash{4}]; n != 1 {
+		t.Fatalf("blocked %d times, want once", n)
+	}
+}
@@ -0,0 +1,228 @@
+package torrentfs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/anacrolix/torrent/metainfo"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// Requests a client may burst, and recharge per second
+	boostRequestBurst    = 16
+	boostRequestRecharge = 4
+	boostClients         = 1024
+	// Largest write charged to the bandwidth budget at once
+	boostChunkSize = 32 * 1024
+)
+
+// BoostServer serves the completed torrents of the node to the boost fetchers
+// of other nodes, in the layout they expect: the metainfo at
+// /files/<infohash>/torrent and the data at /files/<infohash>/<path>.
+//
+// Refusals carry the same 404 page as the foundation boost nodes, which the
+// fetchers look for whatever the status code.
+type BoostServer struct {
+	addr  string
+	dir   string
+	tm    TorrentManagerAPI
+	allow []*net.IPNet
+
+	bandwidth *flowControl // nil when unlimited
+	clients   *lru.Cache   // flowControl of the requests per client IP
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewBoostServer creates a boost server listening on config.BoostAddr, serving
+// the data of tm from config.DataDir.
+func NewBoostServer(config *Config, tm TorrentManagerAPI) (*BoostServer, error) {
+	s := &BoostServer{
+		addr: config.BoostAddr,
+		dir:  config.DataDir,
+		tm:   tm,
+	}
+	for _, entry := range config.BoostAllow {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boost allow entry %q: %v", entry, err)
+		}
+		s.allow = append(s.allow, network)
+	}
+	if config.BoostRate > 0 {
+		rate := uint64(config.BoostRate) * 1024
+		s.bandwidth = newFlowControl(rate, rate)
+	}
+	s.clients, _ = lru.New(boostClients)
+	return s, nil
+}
+
+// Start opens the listener and serves in the background.
+func (s *BoostServer) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:      s,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Minute,
+	}
+	go s.server.Serve(listener)
+	log.Info("Boost server started", "addr", listener.Addr(), "allow", len(s.allow))
+	return nil
+}
+
+// Stop closes the listener and the open connections.
+func (s *BoostServer) Stop() error {
+	if s.server == nil {
+		return nil
+	}
+	log.Info("Boost server stopped", "addr", s.listener.Addr())
+	return s.server.Close()
+}
+
+func (s *BoostServer) refuse(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	w.Write(Str404NotFound)
+}
+
+// allowed reports whether the client may request, and charges its budget.
+func (s *BoostServer) allowed(r *http.Request) (bool, int) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false, http.StatusForbidden
+	}
+	if len(s.allow) > 0 {
+		var ok bool
+		for _, network := range s.allow {
+			if network.Contains(ip) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false, http.StatusForbidden
+		}
+	}
+	fc, ok := s.clients.Get(ip.String())
+	if !ok {
+		fc = newFlowControl(boostRequestBurst, boostRequestRecharge)
+		s.clients.Add(ip.String(), fc)
+	}
+	if _, ok := fc.(*flowControl).accept(1); !ok {
+		return false, http.StatusTooManyRequests
+	}
+	return true, 0
+}
+
+// resolve maps the path of a request to the torrent and file to serve.
+func (s *BoostServer) resolve(urlPath string) (metainfo.Hash, string, error) {
+	var ih metainfo.Hash
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, "/files/"), "/", 2)
+	if !strings.HasPrefix(urlPath, "/files/") || len(parts) != 2 || parts[1] == "" {
+		return ih, "", errors.New("invalid path")
+	}
+	if err := ih.FromHexString(parts[0]); err != nil {
+		return ih, "", err
+	}
+	t := s.tm.GetTorrent(ih)
+	if t == nil || !t.Seeding() || s.tm.Quarantined(t) {
+		return ih, "", errTorrentNotFound
+	}
+	name := path.Clean("/" + parts[1])[1:]
+	if name != parts[1] {
+		return ih, "", errors.New("invalid path")
+	}
+	return ih, name, nil
+}
+
+func (s *BoostServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.refuse(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if ok, code := s.allowed(r); !ok {
+		s.refuse(w, code)
+		return
+	}
+	ih, name, err := s.resolve(r.URL.Path)
+	if err != nil {
+		s.refuse(w, http.StatusNotFound)
+		return
+	}
+	// Decrypted when stored encrypted
+	f, stat, err := s.tm.OpenFile(ih, name)
+	if err != nil {
+		s.refuse(w, http.StatusNotFound)
+		return
+	}
+	log.Debug("Boost serving", "path", r.URL.Path, "range", r.Header.Get("Range"), "remote", r.RemoteAddr)
+	if s.bandwidth != nil {
+		w = &throttledWriter{ResponseWriter: w, fc: s.bandwidth, done: r.Context().Done()}
+	}
+	// ServeContent answers the range requests
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
+
+// throttledWriter holds the writes of a response within a bandwidth budget.
+type throttledWriter struct {
+	http.ResponseWriter
+	fc   *flowControl
+	done <-chan struct{}
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > boostChunkSize {
+			chunk = chunk[:boostChunkSize]
+		}
+		if uint64(len(chunk)) > w.fc.limit {
+			chunk = chunk[:w.fc.limit]
+		}
+		for {
+			if _, ok := w.fc.accept(uint64(len(chunk))); ok {
+				break
+			}
+			select {
+			case <-time.After(w.fc.wait(uint64(len(chunk)))):
+			case <-w.done:
+				return written, errors.New("request canceled")
+			}
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
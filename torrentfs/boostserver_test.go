@@ -0,0 +1,169 @@
+package torrentfs
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// boostGet serves a GET of urlPath from remote, with the given range if any.
+func boostGet(s *BoostServer, remote, urlPath, rng string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "http://boost"+urlPath, nil)
+	r.RemoteAddr = remote
+	if rng != "" {
+		r.Header.Set("Range", rng)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestBoostServerAllow(t *testing.T) {
+	config := DefaultConfig
+	config.BoostAllow = []string{"10.1.0.0/16", " 192.168.1.5 ", "", "fd00::1"}
+	s, err := NewBoostServer(&config, &testManager{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Allowed clients get past the filter to a missing torrent
+	urlPath := "/files/" + metainfo.Hash{1}.HexString() + "/torrent"
+	tests := []struct {
+		remote string
+		code   int
+	}{
+		{"10.1.2.3:1000", http.StatusNotFound},
+		{"10.2.0.1:1000", http.StatusForbidden},
+		{"192.168.1.5:1000", http.StatusNotFound},
+		{"192.168.1.6:1000", http.StatusForbidden},
+		{"[fd00::1]:1000", http.StatusNotFound},
+		{"[fd00::2]:1000", http.StatusForbidden},
+		{"garbage", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		w := boostGet(s, test.remote, urlPath, "")
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d", test.remote, w.Code, test.code)
+		}
+		if !bytes.Equal(w.Body.Bytes(), Str404NotFound) {
+			t.Errorf("%s: refused without the boost 404 page", test.remote)
+		}
+	}
+
+	config.BoostAllow = []string{"10.1.0.0/33"}
+	if _, err := NewBoostServer(&config, &testManager{}); err == nil {
+		t.Fatal("invalid allow entry accepted")
+	}
+}
+
+func TestBoostServerRateLimit(t *testing.T) {
+	s, err := NewBoostServer(&DefaultConfig, &testManager{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlPath := "/files/" + metainfo.Hash{1}.HexString() + "/torrent"
+	for i := 0; i < boostRequestBurst; i++ {
+		if w := boostGet(s, "10.0.0.1:1000", urlPath, ""); w.Code != http.StatusNotFound {
+			t.Fatalf("request %d: status %d, want %d", i, w.Code, http.StatusNotFound)
+		}
+	}
+	if w := boostGet(s, "10.0.0.1:1000", urlPath, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// The budget is kept per client IP, whatever the port
+	if w := boostGet(s, "10.0.0.1:2000", urlPath, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request from another port: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := boostGet(s, "10.0.0.2:1000", urlPath, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("request from another client: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// Tests that a seeded torrent is served, ranges included, and that torrents
+// not seeding or quarantined, and non-canonical paths are refused.
+func TestBoostServerServe(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	n := s.addNode(nil)
+
+	symbol := randomData(t, 3000)
+	ih, size := s.seed(n, map[string][]byte{"symbol": symbol})
+	s.publish(ih, size)
+	s.waitAvailable(n, ih, size)
+
+	// Published but held by no one
+	missing := metainfo.HashBytes(randomData(t, 20))
+	s.publish(missing, 1024)
+	s.waitFor("torrent "+missing.HexString(), func() bool { return n.tm.GetTorrent(missing) != nil })
+
+	server, err := NewBoostServer(&DefaultConfig, n.tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each request comes from another client to stay within the rate limit
+	var client int
+	get := func(urlPath, rng string) *httptest.ResponseRecorder {
+		client++
+		return boostGet(server, fmt.Sprintf("10.0.0.%d:1000", client), urlPath, rng)
+	}
+	base := "/files/" + ih.HexString()
+
+	w := get(base+"/data/symbol", "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), symbol) {
+		t.Fatalf("file: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	w = get(base+"/data/symbol", "bytes=100-199")
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), symbol[100:200]) {
+		t.Fatalf("range: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if want := "bytes 100-199/3000"; w.Header().Get("Content-Range") != want {
+		t.Fatalf("range: content range %q, want %q", w.Header().Get("Content-Range"), want)
+	}
+	w = get(base+"/torrent", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("metainfo: status %d", w.Code)
+	}
+	mi, err := metainfo.Load(bytes.NewReader(w.Body.Bytes()))
+	if err != nil || mi.HashInfoBytes() != ih {
+		t.Fatalf("metainfo: %v", err)
+	}
+
+	for _, urlPath := range []string{
+		base + "/data/../data/symbol",
+		base + "/data//symbol",
+		base + "/./data/symbol",
+		base + "/data/symbol/",
+		base + "/",
+		base,
+		"/files/" + missing.HexString() + "/torrent",
+		"/files/" + metainfo.Hash{1}.HexString() + "/torrent",
+		"/files/nothex/torrent",
+		"/other/" + ih.HexString() + "/torrent",
+	} {
+		if w := get(urlPath, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", urlPath, w.Code, http.StatusNotFound)
+		}
+	}
+
+	tor := n.tm.GetTorrent(ih)
+	n.tm.lock.Lock()
+	tor.quarantined = true
+	n.tm.lock.Unlock()
+	if w := get(base+"/data/symbol", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("quarantined torrent served with status %d", w.Code)
+	}
+	n.tm.lock.Lock()
+	tor.quarantined = false
+	n.tm.lock.Unlock()
+
+	r := httptest.NewRequest(http.MethodPost, "http://boost"+base+"/data/symbol", nil)
+	r.RemoteAddr = "10.0.1.1:1000"
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || !bytes.Equal(w.Body.Bytes(), Str404NotFound) {
+		t.Fatalf("post: status %d", w.Code)
+	}
+}
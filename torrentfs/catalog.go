@@ -0,0 +1,421 @@
+package torrentfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	bolt "github.com/etcd-io/bbolt"
+)
+
+// catalogVersion is the version of the catalog format.
+const catalogVersion = 1
+
+// Catalog is a portable copy of the file storage: the record blocks, the
+// files they created and the Merkle root committing to them. A node imports
+// it instead of scanning the chain up to LastListenBlockNumber.
+type Catalog struct {
+	Version               int         `json:"version"`
+	StorageVersion        string      `json:"storageVersion"`
+	CheckPoint            uint64      `json:"checkPoint"`
+	LastListenBlockNumber uint64      `json:"lastListenBlockNumber"`
+	Root                  common.Hash `json:"root"`
+	Blocks                []*Block    `json:"blocks"` // oldest first
+	Files                 []*FileInfo `json:"files"`
+}
+
+// Catalog exports the blocks and files of the storage.
+func (fs *FileStorage) Catalog() *Catalog {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	c := &Catalog{
+		Version:               catalogVersion,
+		StorageVersion:        fs.version,
+		CheckPoint:            fs.CheckPoint,
+		LastListenBlockNumber: fs.LastListenBlockNumber,
+		Root:                  fs.Root(),
+		Blocks:                append([]*Block(nil), fs.blocks...),
+	}
+	for _, f := range fs.filesContractAddr {
+		if f.TxHash == nil {
+			// Registered in the genesis allocation
+			continue
+		}
+		c.Files = append(c.Files, f)
+	}
+	sort.Slice(c.Files, func(i, j int) bool {
+		return c.Files[i].ContractAddr.Hex() < c.Files[j].ContractAddr.Hex()
+	})
+	return c
+}
+
+// WriteCatalog writes c to w as gzipped JSON.
+func WriteCatalog(w io.Writer, c *Catalog) error {
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(c); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadCatalog reads a catalog written by WriteCatalog.
+func ReadCatalog(r io.Reader) (*Catalog, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var c Catalog
+	if err := json.NewDecoder(zr).Decode(&c); err != nil {
+		return nil, err
+	}
+	if c.Version != catalogVersion {
+		return nil, fmt.Errorf("unsupported catalog version %d", c.Version)
+	}
+	return &c, nil
+}
+
+// roots returns the Merkle roots of the catalog after each of its blocks.
+func (c *Catalog) roots() ([]common.Hash, error) {
+	leaves := []Content{BlockContent{x: params.MainnetGenesisHash.String()}}
+	tree, err := NewTree(leaves)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]common.Hash, len(c.Blocks))
+	for i, b := range c.Blocks {
+		leaves = append(leaves, BlockContent{x: b.Hash.String()})
+		if err := tree.RebuildTreeWith(leaves); err != nil {
+			return nil, err
+		}
+		roots[i] = common.BytesToHash(tree.MerkleRoot())
+	}
+	return roots, nil
+}
+
+// Truncate drops the blocks after number, and the files they created, so
+// that the chain is scanned again from there.
+func (c *Catalog) Truncate(number uint64) error {
+	if number > c.LastListenBlockNumber {
+		return fmt.Errorf("catalog only reaches block %d", c.LastListenBlockNumber)
+	}
+	i := sort.Search(len(c.Blocks), func(i int) bool { return c.Blocks[i].Number > number })
+	c.Blocks = c.Blocks[:i]
+	txs := c.txs()
+	files := c.Files[:0]
+	for _, f := range c.Files {
+		if f.TxHash != nil && txs[*f.TxHash] != nil {
+			files = append(files, f)
+		}
+	}
+	c.Files = files
+	roots, err := c.roots()
+	if err != nil {
+		return err
+	}
+	c.CheckPoint, c.Root = 0, common.Hash{}
+	if len(c.Blocks) > 0 {
+		c.CheckPoint, c.Root = c.Blocks[len(c.Blocks)-1].Number, roots[len(roots)-1]
+	}
+	c.LastListenBlockNumber = number
+	return nil
+}
+
+func (c *Catalog) txs() map[common.Hash]*Transaction {
+	txs := make(map[common.Hash]*Transaction)
+	for _, b := range c.Blocks {
+		for i := range b.Txs {
+			if tx := &b.Txs[i]; tx.Hash != nil {
+				txs[*tx.Hash] = tx
+			}
+		}
+	}
+	return txs
+}
+
+// Verify checks that the root of the catalog commits to its blocks, and
+// matches the trusted roots of the blocks it reaches, along with the one of
+// the built-in checkpoint. Files must be created by a transaction of the
+// blocks. It returns the number of trusted roots matched.
+//
+// The leaves of the Merkle tree are the block hashes only, so even a trusted
+// root doesn't authenticate the transactions and files of the catalog. Those
+// are checked against the chain by checkCatalog when the node starts.
+func (c *Catalog) Verify(trusted map[uint64]common.Hash) (int, error) {
+	if c.StorageVersion != version {
+		return 0, fmt.Errorf("catalog of storage version %s, want %s", c.StorageVersion, version)
+	}
+	anchors := make(map[uint64]common.Hash)
+	if ckp, ok := params.TrustedCheckpoints[params.MainnetGenesisHash]; ok {
+		anchors[ckp.TfsCheckPoint] = ckp.TfsRoot
+	}
+	for number, root := range trusted {
+		anchors[number] = root
+	}
+	for i, b := range c.Blocks {
+		if i > 0 && b.Number <= c.Blocks[i-1].Number {
+			return 0, errors.New("catalog blocks out of order")
+		}
+		if b.Number > c.LastListenBlockNumber {
+			return 0, fmt.Errorf("block %d beyond the last listened one", b.Number)
+		}
+	}
+	roots, err := c.roots()
+	if err != nil {
+		return 0, err
+	}
+	root, checkPoint := common.BytesToHash(nil), uint64(0)
+	if len(roots) > 0 {
+		root, checkPoint = roots[len(roots)-1], c.Blocks[len(c.Blocks)-1].Number
+	}
+	if len(roots) > 0 && (root != c.Root || checkPoint != c.CheckPoint) {
+		return 0, fmt.Errorf("catalog root %x at %d, blocks commit to %x at %d", c.Root, c.CheckPoint, root, checkPoint)
+	}
+	var matched int
+	for i, b := range c.Blocks {
+		if want, ok := anchors[b.Number]; ok {
+			if roots[i] != want {
+				return matched, fmt.Errorf("root %x at block %d, trusted %x", roots[i], b.Number, want)
+			}
+			matched++
+		}
+	}
+	for number := range trusted {
+		if number > c.CheckPoint {
+			return matched, fmt.Errorf("trusted block %d beyond the catalog checkpoint %d", number, c.CheckPoint)
+		}
+		if c.recordedAt(number) == nil {
+			return matched, fmt.Errorf("trusted block %d not in the catalog", number)
+		}
+	}
+
+	txs := c.txs()
+	for _, f := range c.Files {
+		if f.Meta == nil || f.TxHash == nil || f.ContractAddr == nil {
+			return matched, errors.New("incomplete catalog file")
+		}
+		tx, ok := txs[*f.TxHash]
+		if !ok {
+			return matched, fmt.Errorf("file %x created out of the catalog blocks", f.Meta.InfoHash)
+		}
+		if meta := tx.Parse(); meta == nil || meta.InfoHash != f.Meta.InfoHash || meta.RawSize != f.Meta.RawSize {
+			return matched, fmt.Errorf("file %x not created by transaction %x", f.Meta.InfoHash, *f.TxHash)
+		}
+		if f.LeftSize > f.Meta.RawSize {
+			return matched, fmt.Errorf("file %x left size over its raw size", f.Meta.InfoHash)
+		}
+	}
+	return matched, nil
+}
+
+func (c *Catalog) recordedAt(number uint64) *Block {
+	i := sort.Search(len(c.Blocks), func(i int) bool { return c.Blocks[i].Number >= number })
+	if i < len(c.Blocks) && c.Blocks[i].Number == number {
+		return c.Blocks[i]
+	}
+	return nil
+}
+
+// ImportCatalog replaces the content of the storage with a catalog checked
+// by Verify. The catalog must extend the storage: the roots stored for the
+// blocks of the storage have to match those of the catalog. The imported
+// blocks and files are checked against the chain before the first scan,
+// which then goes on from the LastListenBlockNumber of the catalog.
+func (fs *FileStorage) ImportCatalog(c *Catalog) error {
+	if c.LastListenBlockNumber < fs.LastListenBlockNumber {
+		return fmt.Errorf("catalog at block %d behind the storage at %d", c.LastListenBlockNumber, fs.LastListenBlockNumber)
+	}
+	roots, err := c.roots()
+	if err != nil {
+		return err
+	}
+	for _, b := range fs.blocks {
+		local := common.BytesToHash(fs.GetRootByNumber(b.Number))
+		block := c.recordedAt(b.Number)
+		if block == nil || block.Hash != b.Hash {
+			return fmt.Errorf("storage block %d missing from the catalog", b.Number)
+		}
+		i := sort.Search(len(c.Blocks), func(i int) bool { return c.Blocks[i].Number >= b.Number })
+		if local != roots[i] {
+			return fmt.Errorf("storage root %x at block %d, catalog %x", local, b.Number, roots[i])
+		}
+	}
+
+	err = fs.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"blocks_", "files_", "version_", "txs_", "catalog_"} {
+			if tx.Bucket([]byte(name+fs.version)) != nil {
+				if err := tx.DeleteBucket([]byte(name + fs.version)); err != nil {
+					return err
+				}
+			}
+		}
+		blocks, err := tx.CreateBucket([]byte("blocks_" + fs.version))
+		if err != nil {
+			return err
+		}
+		versions, err := tx.CreateBucket([]byte("version_" + fs.version))
+		if err != nil {
+			return err
+		}
+		txs, err := tx.CreateBucket([]byte("txs_" + fs.version))
+		if err != nil {
+			return err
+		}
+		for i, b := range c.Blocks {
+			k, _ := json.Marshal(b.Number)
+			v, err := json.Marshal(b)
+			if err != nil {
+				return err
+			}
+			if err := blocks.Put(k, v); err != nil {
+				return err
+			}
+			if err := versions.Put([]byte(fmt.Sprintf("%x", b.Number)), roots[i].Bytes()); err != nil {
+				return err
+			}
+			if err := indexTxs(txs, b); err != nil {
+				return err
+			}
+		}
+		unchecked, err := tx.CreateBucket([]byte("catalog_" + fs.version))
+		if err != nil {
+			return err
+		}
+		if err := unchecked.Put([]byte("unchecked"), []byte{1}); err != nil {
+			return err
+		}
+		files, err := tx.CreateBucket([]byte("files_" + fs.version))
+		if err != nil {
+			return err
+		}
+		for _, f := range c.Files {
+			k, _ := json.Marshal(f.Meta.InfoHash)
+			v, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+			// The most uploaded copy of the data is kept, as WriteFile does
+			if bef := files.Get(k); bef != nil {
+				var info FileInfo
+				if json.Unmarshal(bef, &info) == nil && info.LeftSize <= f.LeftSize {
+					continue
+				}
+			}
+			if err := files.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.lock.Lock()
+	fs.blocks = append([]*Block(nil), c.Blocks...)
+	fs.files = append([]*FileInfo(nil), c.Files...)
+	fs.filesContractAddr = make(map[common.Address]*FileInfo)
+	for _, f := range c.Files {
+		fs.filesContractAddr[*f.ContractAddr] = f
+	}
+	fs.leaves = []Content{BlockContent{x: params.MainnetGenesisHash.String()}}
+	for _, b := range fs.blocks {
+		fs.leaves = append(fs.leaves, BlockContent{x: b.Hash.String()})
+	}
+	err = fs.tree.RebuildTreeWith(fs.leaves)
+	fs.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	fs.CheckPoint, fs.LastListenBlockNumber = c.CheckPoint, c.LastListenBlockNumber
+	if err := fs.writeCheckPoint(); err != nil {
+		return err
+	}
+	if err := fs.writeBlockNumber(); err != nil {
+		return err
+	}
+	log.Info("Storage catalog imported", "blocks", len(c.Blocks), "files", len(c.Files), "checkpoint", c.CheckPoint, "number", c.LastListenBlockNumber, "root", fs.Root())
+	return nil
+}
+
+// catalogUnchecked reports whether the storage holds an imported catalog not
+// checked against the chain yet.
+func (fs *FileStorage) catalogUnchecked() (unchecked bool) {
+	fs.db.View(func(tx *bolt.Tx) error {
+		if buk := tx.Bucket([]byte("catalog_" + fs.version)); buk != nil {
+			unchecked = buk.Get([]byte("unchecked")) != nil
+		}
+		return nil
+	})
+	return unchecked
+}
+
+// checkCatalog checks the blocks and files of an imported catalog against the
+// chain: the blocks must be canonical, their transactions must be those of the
+// chain, and each file must be the contract created by its transaction.
+func (fs *FileStorage) checkCatalog(chain chainReader) error {
+	for _, b := range fs.Blocks() {
+		canon, err := chain.GetBlockByNumber(b.Number)
+		if err != nil {
+			return err
+		}
+		if canon.Hash != b.Hash {
+			return fmt.Errorf("catalog block %d is %x, chain %x", b.Number, b.Hash, canon.Hash)
+		}
+		txs := make(map[common.Hash]*Transaction, len(canon.Txs))
+		for i := range canon.Txs {
+			if tx := &canon.Txs[i]; tx.Hash != nil {
+				txs[*tx.Hash] = tx
+			}
+		}
+		for _, tx := range b.Txs {
+			if tx.Hash == nil {
+				return fmt.Errorf("catalog transaction without hash in block %d", b.Number)
+			}
+			if !sameTx(&tx, txs[*tx.Hash]) {
+				return fmt.Errorf("catalog transaction %x of block %d not in the chain", *tx.Hash, b.Number)
+			}
+		}
+	}
+	for _, f := range fs.Files() {
+		receipt, err := chain.GetReceipt(*f.TxHash)
+		if err != nil {
+			return err
+		}
+		if receipt.Status != 1 || receipt.ContractAddr == nil || *receipt.ContractAddr != *f.ContractAddr {
+			return fmt.Errorf("catalog file %x not created at %x by transaction %x", f.Meta.InfoHash, *f.ContractAddr, *f.TxHash)
+		}
+	}
+	err := fs.db.Update(func(tx *bolt.Tx) error {
+		if buk := tx.Bucket([]byte("catalog_" + fs.version)); buk != nil {
+			return buk.Delete([]byte("unchecked"))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Storage catalog checked against the chain", "blocks", len(fs.Blocks()), "files", len(fs.Files()))
+	return nil
+}
+
+// sameTx reports whether the catalog transaction tx is the chain one.
+func sameTx(tx, canon *Transaction) bool {
+	if canon == nil || !bytes.Equal(tx.Payload, canon.Payload) || tx.GasLimit != canon.GasLimit {
+		return false
+	}
+	if (tx.Recipient == nil) != (canon.Recipient == nil) || (tx.Recipient != nil && *tx.Recipient != *canon.Recipient) {
+		return false
+	}
+	if (tx.Amount == nil) != (canon.Amount == nil) || (tx.Amount != nil && tx.Amount.Cmp(canon.Amount) != 0) {
+		return false
+	}
+	return true
+}
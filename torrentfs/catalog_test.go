@@ -0,0 +1,228 @@
+package torrentfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// catalogBlock returns a record block at number creating an input of data
+// hash ih.
+func catalogBlock(t *testing.T, number uint64, ih common.Address) (*Block, *FileInfo) {
+	meta := types.InputMeta{Hash: ih, RawSize: 1024}
+	meta.BlockNum.SetUint64(number)
+	data, err := rlp.EncodeToBytes(&meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash := common.BigToHash(big.NewInt(int64(number)))
+	from := common.Address{1}
+	tx := Transaction{
+		Price:    new(big.Int),
+		Amount:   new(big.Int),
+		GasLimit: 0,
+		Payload:  append([]byte{0, opCreateInput}, data...),
+		From:     &from,
+		Hash:     &txHash,
+	}
+	b := &Block{
+		Number: number,
+		Hash:   common.BigToHash(big.NewInt(int64(number) << 32)),
+		Txs:    []Transaction{tx},
+	}
+	addr := common.BigToAddress(big.NewInt(int64(number)))
+	return b, &FileInfo{Meta: tx.Parse(), TxHash: &txHash, ContractAddr: &addr, LeftSize: 1024}
+}
+
+func newCatalogStorage(t *testing.T) (*FileStorage, func()) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewFileStorage(&Config{DataDir: dir})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return fs, func() {
+		fs.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCatalogRoundTrip(t *testing.T) {
+	src, closeSrc := newCatalogStorage(t)
+	defer closeSrc()
+	for _, n := range []uint64{10, 20, 30} {
+		b, f := catalogBlock(t, n, common.BigToAddress(big.NewInt(int64(n)+1000)))
+		if err := src.WriteBlock(b, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.WriteBlock(&Block{Number: 35}, false); err != nil {
+		t.Fatal(err)
+	}
+	trusted := map[uint64]common.Hash{20: common.BytesToHash(src.GetRootByNumber(20))}
+
+	var buf bytes.Buffer
+	if err := WriteCatalog(&buf, src.Catalog()); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadCatalog(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched, err := c.Verify(trusted); err != nil || matched != 1 {
+		t.Fatalf("verify: %d matched, %v", matched, err)
+	}
+	if _, err := c.Verify(map[uint64]common.Hash{20: {1}}); err == nil {
+		t.Fatal("wrong trusted root accepted")
+	}
+	tampered, _ := ReadCatalog(bytes.NewReader(buf.Bytes()))
+	tampered.Files[0].Meta.RawSize++
+	if _, err := tampered.Verify(nil); err == nil {
+		t.Fatal("tampered file accepted")
+	}
+
+	dst, closeDst := newCatalogStorage(t)
+	defer closeDst()
+	if err := dst.ImportCatalog(c); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Root() != src.Root() || dst.CheckPoint != 30 || dst.LastListenBlockNumber != 35 {
+		t.Fatalf("imported root %x at %d/%d, want %x at 30/35", dst.Root(), dst.CheckPoint, dst.LastListenBlockNumber, src.Root())
+	}
+	for _, n := range []uint64{10, 20, 30} {
+		if !bytes.Equal(dst.GetRootByNumber(n), src.GetRootByNumber(n)) {
+			t.Fatalf("root at %d not imported", n)
+		}
+	}
+	if len(dst.Files()) != 3 || dst.GetFileByAddr(*c.Files[0].ContractAddr) == nil {
+		t.Fatalf("%d files imported, want 3", len(dst.Files()))
+	}
+
+	// A checkpoint in the middle only keeps what precedes it
+	c, _ = ReadCatalog(bytes.NewReader(buf.Bytes()))
+	if err := c.Truncate(25); err != nil {
+		t.Fatal(err)
+	}
+	if matched, err := c.Verify(trusted); err != nil || matched != 1 {
+		t.Fatalf("verify truncated: %d matched, %v", matched, err)
+	}
+	if len(c.Blocks) != 2 || len(c.Files) != 2 || c.CheckPoint != 20 || c.Root != trusted[20] {
+		t.Fatalf("truncated to %d blocks, %d files, root %x at %d", len(c.Blocks), len(c.Files), c.Root, c.CheckPoint)
+	}
+	if err := dst.ImportCatalog(c); err == nil {
+		t.Fatal("catalog behind the storage imported")
+	}
+}
+
+func TestCatalogCheckChain(t *testing.T) {
+	src, closeSrc := newCatalogStorage(t)
+	defer closeSrc()
+	chain := newTestChain()
+	for n := uint64(0); n <= 35; n++ {
+		chain.blocks = append(chain.blocks, &Block{Number: n, Hash: common.BigToHash(big.NewInt(int64(n) << 32))})
+	}
+	for _, n := range []uint64{10, 20, 30} {
+		b, f := catalogBlock(t, n, common.BigToAddress(big.NewInt(int64(n)+1000)))
+		if err := src.WriteBlock(b, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		chain.blocks[n] = b
+		chain.receipts[*f.TxHash] = &TxReceipt{TxHash: f.TxHash, ContractAddr: f.ContractAddr, Status: 1}
+	}
+	if err := src.WriteBlock(&Block{Number: 35}, false); err != nil {
+		t.Fatal(err)
+	}
+	if src.catalogUnchecked() {
+		t.Fatal("scanned storage marked unchecked")
+	}
+
+	imported := func() (*FileStorage, func()) {
+		dst, closeDst := newCatalogStorage(t)
+		if err := dst.ImportCatalog(src.Catalog()); err != nil {
+			closeDst()
+			t.Fatal(err)
+		}
+		if !dst.catalogUnchecked() {
+			closeDst()
+			t.Fatal("imported catalog not marked unchecked")
+		}
+		return dst, closeDst
+	}
+
+	dst, closeDst := imported()
+	defer closeDst()
+	if err := dst.checkCatalog(chain); err != nil {
+		t.Fatalf("matching chain rejected: %v", err)
+	}
+	if dst.catalogUnchecked() {
+		t.Fatal("checked catalog still marked unchecked")
+	}
+
+	// The payload of a transaction isn't committed to by the roots
+	canon := *chain.blocks[20]
+	canon.Txs = []Transaction{chain.blocks[20].Txs[0]}
+	canon.Txs[0].Payload = append([]byte{}, canon.Txs[0].Payload...)
+	canon.Txs[0].Payload[len(canon.Txs[0].Payload)-1]++
+	orig := chain.blocks[20]
+	chain.blocks[20] = &canon
+	tampered, closeTampered := imported()
+	defer closeTampered()
+	if err := tampered.checkCatalog(chain); err == nil {
+		t.Fatal("catalog with a payload not in the chain accepted")
+	}
+	if !tampered.catalogUnchecked() {
+		t.Fatal("rejected catalog no longer marked unchecked")
+	}
+	chain.blocks[20] = orig
+
+	// Neither is the contract created by a transaction
+	f := tampered.Files()[0]
+	addr := common.Address{0xff}
+	chain.receipts[*f.TxHash] = &TxReceipt{TxHash: f.TxHash, ContractAddr: &addr, Status: 1}
+	if err := tampered.checkCatalog(chain); err == nil {
+		t.Fatal("catalog with a file not created by its transaction accepted")
+	}
+}
+
+func TestAPIFiles(t *testing.T) {
+	fs, closeFs := newCatalogStorage(t)
+	defer closeFs()
+	want := make(map[metainfo.Hash]common.Address)
+	for _, n := range []uint64{10, 20} {
+		b, f := catalogBlock(t, n, common.BigToAddress(big.NewInt(int64(n)+1000)))
+		if err := fs.WriteBlock(b, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		want[f.Meta.InfoHash] = *f.ContractAddr
+	}
+	// No torrent is loaded, the files are listed from the storage
+	api := NewPrivateTorrentAPI(&TorrentFS{monitor: &Monitor{fs: fs}})
+	files := api.Files()
+	if len(files) != len(want) {
+		t.Fatalf("%d files listed, want %d", len(files), len(want))
+	}
+	for _, f := range files {
+		if addr, ok := want[f.InfoHash]; !ok || f.ContractAddr == nil || *f.ContractAddr != addr {
+			t.Fatalf("unexpected file %x at %v", f.InfoHash, f.ContractAddr)
+		}
+	}
+}
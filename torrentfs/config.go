@@ -16,32 +16,95 @@ type Config struct {
 	DisableDHT      bool     `toml:",omitempty"`
 	DefaultTrackers []string `toml:",omitempty"`
 	BoostNodes      []string `toml:",omitempty"`
-	SyncMode        string   `toml:",omitempty"`
+	SyncMode        string   `toml:",omitempty"` // "full" or "lazy"
 	MaxSeedingNum   int      `toml:",omitempty"`
 	MaxActiveNum    int      `toml:",omitempty"`
 	FullSeed        bool
+	// ScrubInterval is the number of seconds between two verifications of
+	// the seeded data, 0 disables the periodic scrubbing.
+	ScrubInterval int `toml:",omitempty"`
+	// MaxDiskUsage caps the torrent data in megabytes, 0 for no limit. Over
+	// it, the seeded data least used by inference is evicted, except for
+	// the data referenced in the last ProtectBlocks blocks.
+	MaxDiskUsage  uint64 `toml:",omitempty"`
+	ProtectBlocks uint64 `toml:",omitempty"`
+	// LazyTimeout is the number of seconds the CVM waits for missing data
+	// in lazy sync mode, below the timeout of ctxc_call.
+	LazyTimeout int `toml:",omitempty"`
+
+	// UploadRate and DownloadRate cap the torrent traffic in KB/s, and the
+	// Torrent ones that of each torrent, 0 for no limit. They are lifted in
+	// the OffPeak window, "HH:MM-HH:MM" in local time. MaxConns and
+	// MaxHalfOpenConns bound the connections of each torrent, the torrent
+	// client defaults when 0.
+	UploadRate          int    `toml:",omitempty"`
+	DownloadRate        int    `toml:",omitempty"`
+	TorrentUploadRate   int    `toml:",omitempty"`
+	TorrentDownloadRate int    `toml:",omitempty"`
+	MaxConns            int    `toml:",omitempty"`
+	MaxHalfOpenConns    int    `toml:",omitempty"`
+	OffPeak             string `toml:",omitempty"`
+
+	// BlocklistFile lists the info hashes never fetched nor served, and
+	// BlocklistURL a shared list signed by BlocklistSigner, fetched every
+	// BlocklistRefresh seconds. Both are reloaded as they change.
+	BlocklistFile    string `toml:",omitempty"`
+	BlocklistURL     string `toml:",omitempty"`
+	BlocklistSigner  string `toml:",omitempty"`
+	BlocklistRefresh int    `toml:",omitempty"`
+
+	// BoostAddr is the listening address of the boost server mirroring the
+	// completed torrents, empty to disable it. BoostRate caps its upload in
+	// KB/s and BoostAllow lists the IPs or CIDRs allowed, all when empty.
+	BoostAddr  string   `toml:",omitempty"`
+	BoostRate  int      `toml:",omitempty"`
+	BoostAllow []string `toml:",omitempty"`
+
+	// Storage selects where the CVM reads the data from: the torrent client
+	// (default), a local directory ("dir") or an S3 compatible bucket ("s3").
+	Storage     string `toml:",omitempty"`
+	S3Endpoint  string `toml:",omitempty"`
+	S3Bucket    string `toml:",omitempty"`
+	S3Region    string `toml:",omitempty"`
+	S3AccessKey string `toml:",omitempty"`
+	S3SecretKey string `toml:",omitempty"`
+
+	// StorageKeyFile lists the keys encrypting the torrent data at rest, one
+	// hex encoded 32 byte key per line, the current one first. Relative to
+	// DataDir, it is created with a random key when missing. The data is
+	// stored in the clear when empty.
+	StorageKeyFile string `toml:",omitempty"`
 }
 
 // DefaultConfig contains default settings for the storage.
 var DefaultConfig = Config{
-	Port:            0,
-	DefaultTrackers: params.MainnetTrackers,
-	BoostNodes:      params.TorrentBoostNodes,
-	SyncMode:        "full",
-	DisableUTP:      false,
-	DisableDHT:      false,
-	MaxSeedingNum:   1024,
-	MaxActiveNum:    1024,
-	FullSeed:        false,
+	Port:             0,
+	DefaultTrackers:  params.MainnetTrackers,
+	BoostNodes:       params.TorrentBoostNodes,
+	SyncMode:         "full",
+	DisableUTP:       false,
+	DisableDHT:       false,
+	MaxSeedingNum:    1024,
+	MaxActiveNum:     1024,
+	FullSeed:         false,
+	ScrubInterval:    defaultScrubInterval,
+	ProtectBlocks:    defaultProtectBlocks,
+	LazyTimeout:      defaultLazyTimeout,
+	BlocklistRefresh: defaultBlocklistRefresh,
 }
 
 const (
-	queryTimeInterval              = 1
-	expansionFactor        float64 = 1.2
-	defaultSeedInterval            = 600
-	torrentWaitingTime             = 1800
-	downloadWaitingTime            = 2700
-	defaultBytesLimitation         = 512 * 1024
-	defaultTmpFilePath             = ".tmp"
-	version                        = "1"
+	queryTimeInterval               = 1
+	expansionFactor         float64 = 1.2
+	defaultSeedInterval             = 600
+	torrentWaitingTime              = 1800
+	downloadWaitingTime             = 2700
+	defaultBytesLimitation          = 512 * 1024
+	defaultTmpFilePath              = ".tmp"
+	defaultScrubInterval            = 6 * 3600
+	defaultProtectBlocks            = 5760 // about a day of blocks
+	defaultEvictInterval            = 60
+	defaultLazyTimeout              = 3
+	defaultBlocklistRefresh         = 3600
+	version                         = "1"
 )
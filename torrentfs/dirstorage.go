@@ -0,0 +1,123 @@
+package torrentfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var errDataNotFound = errors.New("data not found")
+
+// DirStorage serves the data of the CVM from a local directory laid out like
+// the seeding directory of the torrent client, i.e. <infohash>/data for an
+// input and <infohash>/data/symbol, <infohash>/data/params for a model.
+//
+// When a metainfo is found next to the data, at <infohash>/torrent, the data
+// is checked against its piece hashes before being served.
+type DirStorage struct {
+	dir string
+
+	lock     sync.Mutex
+	verified map[string]bool
+}
+
+func NewDirStorage(dir string) *DirStorage {
+	return &DirStorage{
+		dir:      dir,
+		verified: make(map[string]bool),
+	}
+}
+
+// dataSize returns the total size of the data of infohash.
+func (s *DirStorage) dataSize(infohash string) (int64, error) {
+	root := filepath.Join(s.dir, infohash, "data")
+	if _, err := os.Stat(root); err != nil {
+		return 0, errDataNotFound
+	}
+	var size int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// verify checks the data of infohash against its metainfo, if there is one.
+func (s *DirStorage) verify(infohash string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.verified[infohash] {
+		return nil
+	}
+	mi, err := metainfo.LoadFromFile(filepath.Join(s.dir, infohash, "torrent"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ih := mi.HashInfoBytes(); !strings.EqualFold(ih.HexString(), infohash) {
+		return fmt.Errorf("metainfo of %s is for %s", infohash, ih.HexString())
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	pieces := info.Pieces
+	root := filepath.Join(s.dir, infohash, info.Name)
+	err = info.GeneratePieces(func(fi metainfo.FileInfo) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(root, filepath.Join(fi.Path...)))
+	})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(info.Pieces, pieces) {
+		return fmt.Errorf("data of %s does not match its metainfo", infohash)
+	}
+	log.Debug("Data verified", "hash", infohash, "pieces", len(pieces)/20)
+	s.verified[infohash] = true
+	return nil
+}
+
+func (s *DirStorage) Available(infohash string, rawSize int64) (bool, error) {
+	size, err := s.dataSize(infohash)
+	if err != nil {
+		return false, err
+	}
+	if size > rawSize {
+		return false, nil
+	}
+	if err := s.verify(infohash); err != nil {
+		log.Warn("Data verification failed", "hash", infohash, "err", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *DirStorage) GetFile(infohash string, subpath string) ([]byte, error) {
+	if err := s.verify(infohash); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, infohash, filepath.FromSlash(subpath)))
+	if os.IsNotExist(err) {
+		return nil, errDataNotFound
+	}
+	return data, err
+}
+
+func (s *DirStorage) Stop() error {
+	return nil
+}
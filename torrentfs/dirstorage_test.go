@@ -0,0 +1,90 @@
+package torrentfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// writeModel lays out a model under dir and returns its info hash.
+func writeModel(t *testing.T, dir string, symbol, params []byte) string {
+	tmp, err := ioutil.TempDir("", "torrentfs-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	data := filepath.Join(tmp, "data")
+	os.MkdirAll(data, 0700)
+	ioutil.WriteFile(filepath.Join(data, "symbol"), symbol, 0600)
+	ioutil.WriteFile(filepath.Join(data, "params"), params, 0600)
+
+	info := metainfo.Info{PieceLength: 16}
+	if err := info.BuildFromFilePath(data); err != nil {
+		t.Fatalf("failed to build metainfo: %v", err)
+	}
+	mi := &metainfo.MetaInfo{}
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatalf("failed to encode metainfo: %v", err)
+	}
+	ih := mi.HashInfoBytes().HexString()
+
+	root := filepath.Join(dir, ih, "data")
+	os.MkdirAll(root, 0700)
+	ioutil.WriteFile(filepath.Join(root, "symbol"), symbol, 0600)
+	ioutil.WriteFile(filepath.Join(root, "params"), params, 0600)
+	f, err := os.Create(filepath.Join(dir, ih, "torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := mi.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return ih
+}
+
+func TestDirStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrentfs-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	symbol, params := []byte(`{"nodes": []}`), []byte("0123456789abcdef0123456789")
+	ih := writeModel(t, dir, symbol, params)
+	size := int64(len(symbol) + len(params))
+
+	s := NewDirStorage(dir)
+	if ok, err := s.Available(ih, size); !ok || err != nil {
+		t.Fatalf("model not available: %v", err)
+	}
+	if ok, _ := s.Available(ih, size-1); ok {
+		t.Error("model larger than its raw size available")
+	}
+	if data, err := s.GetFile(ih, "/data/params"); err != nil || string(data) != string(params) {
+		t.Errorf("params mismatch: have %q, %v", data, err)
+	}
+	if _, err := s.Available("0000000000000000000000000000000000000000", size); err == nil {
+		t.Error("missing model available")
+	}
+
+	// Corrupt the data, the metainfo no longer matches
+	ioutil.WriteFile(filepath.Join(dir, ih, "data", "params"), []byte("0123456789abcdef0123456780"), 0600)
+	s = NewDirStorage(dir)
+	if ok, err := s.Available(ih, size); ok || err == nil {
+		t.Error("corrupted model available")
+	}
+	if _, err := s.GetFile(ih, "/data/symbol"); err == nil {
+		t.Error("corrupted model read")
+	}
+
+	// Without the metainfo, the data is served as is
+	os.Remove(filepath.Join(dir, ih, "torrent"))
+	if ok, err := s.Available(ih, size); !ok || err != nil {
+		t.Errorf("unverified model not available: %v", err)
+	}
+}
@@ -0,0 +1,1084 @@
+package torrentfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+var (
+	errNoStorageKey  = errors.New("storage encryption disabled")
+	errUnknownKey    = errors.New("unknown storage key")
+	errStorageKeyLen = errors.New("storage key must be 32 bytes")
+)
+
+const (
+	storageKeyLen = 32
+	keyMarker     = ".keys"   // key ids of the files of a torrent directory
+	nonceDir      = ".nonces" // nonces of the chunks of the files
+	rekeySuffix   = ".rekey"  // file being re-encrypted
+	sealChunk     = 16 * 1024 // data encrypted under a nonce of its own
+	nonceLen      = 16
+	rekeyChunk    = 64 * sealChunk
+)
+
+var zeroNonce [nonceLen]byte
+
+// storageKey is a key encrypting the torrent data at rest. The AES key and
+// the key deriving the IVs are both derived from the raw key.
+type storageKey struct {
+	id    string
+	raw   []byte
+	mac   []byte
+	block cipher.Block
+}
+
+func newStorageKey(raw []byte) (*storageKey, error) {
+	if len(raw) != storageKeyLen {
+		return nil, errStorageKeyLen
+	}
+	block, err := aes.NewCipher(deriveKey(raw, "torrentfs data"))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &storageKey{id: hex.EncodeToString(sum[:8]), raw: raw, mac: deriveKey(raw, "torrentfs iv"), block: block}, nil
+}
+
+func deriveKey(raw []byte, label string) []byte {
+	mac := hmac.New(sha256.New, raw)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// xor encrypts or decrypts b, found at off in a chunk of the file name of a
+// directory salted with salt. Each chunk is XORed with an AES-CTR keystream
+// drawn from a nonce renewed whenever the chunk is written, so that any range
+// is read or written alone, the files keep their size and no keystream is
+// used twice. Their integrity is left to the piece hashes, checked on the
+// plaintext. A nil key, or the zero nonce of a chunk never written, leaves
+// the data in the clear.
+func (k *storageKey) xor(salt []byte, name string, nonce []byte, off int64, b []byte) {
+	if k == nil || len(b) == 0 || bytes.Equal(nonce, zeroNonce[:]) {
+		return
+	}
+	mac := hmac.New(sha256.New, k.mac)
+	mac.Write(salt)
+	mac.Write([]byte(name))
+	mac.Write(nonce)
+	iv := mac.Sum(nil)[:aes.BlockSize]
+
+	// Move the counter to the block of off
+	carry := uint64(off / aes.BlockSize)
+	for i := len(iv) - 1; i >= 0 && carry > 0; i-- {
+		carry += uint64(iv[i])
+		iv[i] = byte(carry)
+		carry >>= 8
+	}
+	stream := cipher.NewCTR(k.block, iv)
+	if skip := int(off % aes.BlockSize); skip > 0 {
+		var pad [aes.BlockSize]byte
+		stream.XORKeyStream(pad[:skip], pad[:skip])
+	}
+	stream.XORKeyStream(b, b)
+}
+
+// chunkSpan returns the first chunk of a file holding [off, off+n) and how
+// many there are. The chunks are aligned on the torrent data, phase being the
+// offset of the file in it modulo sealChunk, so that a piece never shares a
+// chunk with another.
+func chunkSpan(phase, off, n int64) (int64, int64) {
+	first := (off + phase) / sealChunk
+	last := (off + phase + n - 1) / sealChunk
+	return first, last - first + 1
+}
+
+// chunkStart returns the offset in a file of its chunk c.
+func chunkStart(phase, c int64) int64 {
+	if start := c*sealChunk - phase; start > 0 {
+		return start
+	}
+	return 0
+}
+
+// readNonces returns the nonces of count chunks of a file from first on,
+// zero for the chunks never written.
+func readNonces(path string, first, count int64) ([]byte, error) {
+	nonces := make([]byte, count*nonceLen)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nonces, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.ReadAt(nonces, first*nonceLen); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return nonces, nil
+}
+
+// newNonces returns fresh nonces for count chunks.
+func newNonces(count int64) ([]byte, error) {
+	nonces := make([]byte, count*nonceLen)
+	if _, err := rand.Read(nonces); err != nil {
+		return nil, err
+	}
+	return nonces, nil
+}
+
+// writeAt writes b at off in file, created as needed.
+func writeAt(file string, off int64, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(b, off); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncFile(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// keyring holds the keys encrypting the torrent data at rest, read from a key
+// file listing one hex encoded 32 byte key per line. The first key encrypts
+// the data, the others only decrypt the data not re-encrypted since the key
+// was rotated, and are dropped once all of it is.
+type keyring struct {
+	file string
+
+	lock    sync.RWMutex
+	current *storageKey
+	keys    map[string]*storageKey
+	dirs    map[string]*sealedDir // by resolved path
+}
+
+// newKeyring loads the keys of file, created with a random key when missing.
+func newKeyring(file string) (*keyring, error) {
+	k := &keyring{
+		file: file,
+		keys: make(map[string]*storageKey),
+		dirs: make(map[string]*sealedDir),
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		key, err := generateStorageKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := k.store([]*storageKey{key}); err != nil {
+			return nil, err
+		}
+		log.Info("Generated storage key", "file", file, "id", key.id)
+	}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func generateStorageKey() (*storageKey, error) {
+	raw := make([]byte, storageKeyLen)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return newStorageKey(raw)
+}
+
+// load reads the keys of the key file.
+func (k *keyring) load() error {
+	f, err := os.Open(k.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var (
+		current *storageKey
+		keys    = make(map[string]*storageKey)
+		scanner = bufio.NewScanner(f)
+	)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		raw, err := hex.DecodeString(strings.TrimPrefix(text, "0x"))
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid storage key: %v", k.file, line, err)
+		}
+		key, err := newStorageKey(raw)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", k.file, line, err)
+		}
+		if current == nil {
+			current = key
+		}
+		keys[key.id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("no storage key in %s", k.file)
+	}
+	k.lock.Lock()
+	k.current, k.keys = current, keys
+	k.lock.Unlock()
+	return nil
+}
+
+// store replaces the key file with keys, the current one first.
+func (k *keyring) store(keys []*storageKey) error {
+	if err := os.MkdirAll(filepath.Dir(k.file), 0700); err != nil {
+		return err
+	}
+	var content strings.Builder
+	for _, key := range keys {
+		content.WriteString(hex.EncodeToString(key.raw) + "\n")
+	}
+	tmp := k.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.file)
+}
+
+// rotate makes a new key current, keeping the others to decrypt the data
+// until it is re-encrypted.
+func (k *keyring) rotate() (*storageKey, error) {
+	key, err := generateStorageKey()
+	if err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	keys := []*storageKey{key, k.current}
+	for id, old := range k.keys {
+		if id != k.current.id {
+			keys = append(keys, old)
+		}
+	}
+	if err := k.store(keys); err != nil {
+		return nil, err
+	}
+	k.current = key
+	k.keys[key.id] = key
+	log.Info("Rotated storage key", "id", key.id, "keys", len(k.keys))
+	return key, nil
+}
+
+// prune drops the keys other than the current one and keep, once the data
+// they decrypt is re-encrypted, from the keyring and the key file.
+func (k *keyring) prune(keep *storageKey) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	keys := []*storageKey{k.current}
+	if keep.id != k.current.id {
+		keys = append(keys, keep)
+	}
+	if len(keys) == len(k.keys) {
+		return nil
+	}
+	if err := k.store(keys); err != nil {
+		return err
+	}
+	dropped := len(k.keys) - len(keys)
+	k.keys = make(map[string]*storageKey)
+	for _, key := range keys {
+		k.keys[key.id] = key
+	}
+	log.Info("Dropped rotated storage keys", "dropped", dropped, "keys", len(k.keys))
+	return nil
+}
+
+func (k *keyring) currentKey() *storageKey {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.current
+}
+
+// key returns the key of id, nil for the data stored in the clear.
+func (k *keyring) key(id string) (*storageKey, error) {
+	if id == "" {
+		return nil, nil
+	}
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%v %s", errUnknownKey, id)
+}
+
+// resolveDir returns the real path of root, which is a link from the seeding
+// directory to the temporary one once fetched.
+func resolveDir(root string) string {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		return real
+	}
+	return filepath.Clean(root)
+}
+
+// dir returns the keys of the files of the torrent directory root. Unless
+// create is set, it returns nil when root has none, i.e. holds no data or the
+// data was stored in the clear before encryption was enabled.
+func (k *keyring) dir(root string, create bool) (*sealedDir, error) {
+	if create {
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	real := resolveDir(root)
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if d, ok := k.dirs[real]; ok {
+		return d, nil
+	}
+	d := &sealedDir{
+		ring:    k,
+		root:    real,
+		files:   make(map[string]string),
+		phases:  make(map[string]int64),
+		pending: make(map[string]string),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(real, keyMarker))
+	switch {
+	case os.IsNotExist(err):
+		if !create {
+			return nil, nil
+		}
+		d.salt = make([]byte, 16)
+		if _, err := rand.Read(d.salt); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := d.recover(data); err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %v", keyMarker, real, err)
+		}
+	}
+	k.dirs[real] = d
+	return d, nil
+}
+
+// forget drops the keys of root, about to be removed.
+func (k *keyring) forget(root string) {
+	real := resolveDir(root)
+	k.lock.Lock()
+	delete(k.dirs, real)
+	k.lock.Unlock()
+}
+
+// sealedDir tracks the keys of the files of a torrent directory, in a marker
+// file next to them, and the nonces of their chunks in the nonce directory.
+// The files found there unlisted were stored before the encryption was
+// enabled, and are read in the clear until re-encrypted.
+type sealedDir struct {
+	ring *keyring
+	root string
+	salt []byte
+
+	io    sync.RWMutex // held for writing while chunks are re-encrypted
+	write sync.Mutex   // held while chunks are written
+
+	lock     sync.Mutex
+	files    map[string]string // key ids by file path, "" when in the clear
+	phases   map[string]int64  // alignment of the chunks by file path
+	pending  map[string]string // key ids of the files being re-encrypted
+	rekeying string            // file being re-encrypted
+	dirty    map[int64]bool    // its chunks written meanwhile
+}
+
+type sealedMarker struct {
+	Salt    hexutil.Bytes     `json:"salt"`
+	Files   map[string]string `json:"files"`
+	Phases  map[string]int64  `json:"phases,omitempty"`
+	Pending map[string]string `json:"pending,omitempty"`
+}
+
+// recover loads the marker of the directory. A file whose re-encryption was
+// interrupted keeps its former key if the new copy was not renamed over it,
+// and gets its new nonces otherwise.
+func (d *sealedDir) recover(data []byte) error {
+	var marker sealedMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return err
+	}
+	d.salt = marker.Salt
+	for name, id := range marker.Files {
+		d.files[name] = id
+	}
+	for name, phase := range marker.Phases {
+		d.phases[name] = phase
+	}
+	if len(marker.Pending) == 0 {
+		return nil
+	}
+	for name, id := range marker.Pending {
+		tmp := d.path(name) + rekeySuffix
+		if _, err := os.Stat(tmp); err == nil {
+			os.Remove(tmp)
+			os.Remove(d.noncePath(name) + rekeySuffix)
+			continue
+		}
+		if err := os.Rename(d.noncePath(name)+rekeySuffix, d.noncePath(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		d.files[name] = id
+	}
+	return d.save()
+}
+
+// save writes the marker of the directory, the lock held.
+func (d *sealedDir) save() error {
+	data, err := json.Marshal(&sealedMarker{Salt: d.salt, Files: d.files, Phases: d.phases, Pending: d.pending})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(d.root, keyMarker+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.root, keyMarker))
+}
+
+func (d *sealedDir) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+func (d *sealedDir) noncePath(name string) string {
+	return filepath.Join(d.root, nonceDir, filepath.FromSlash(name))
+}
+
+// lookup returns the key of the file name and the alignment of its chunks.
+func (d *sealedDir) lookup(name string) (*storageKey, int64, error) {
+	d.lock.Lock()
+	id, phase := d.files[name], d.phases[name]
+	d.lock.Unlock()
+	key, err := d.ring.key(id)
+	return key, phase, err
+}
+
+// assign chooses the keys of the files not listed yet, given with the
+// alignment of their chunks.
+func (d *sealedDir) assign(phases map[string]int64) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var changed bool
+	for name, phase := range phases {
+		if _, ok := d.files[name]; ok {
+			continue
+		}
+		id := d.ring.currentKey().id
+		if _, err := os.Stat(d.path(name)); err == nil {
+			// Stored before the encryption was enabled
+			id = ""
+		}
+		d.files[name] = id
+		if phase != 0 {
+			d.phases[name] = phase
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return d.save()
+}
+
+// fileName returns the path of the file fi of info in the torrent directory.
+func fileName(info *metainfo.Info, fi metainfo.FileInfo) string {
+	return strings.Join(append([]string{info.Name}, fi.Path...), "/")
+}
+
+// xorChunks encrypts or decrypts b, found at off in the file name, with the
+// nonces of its chunks from first on.
+func (d *sealedDir) xorChunks(key *storageKey, name string, phase int64, nonces []byte, first, off int64, b []byte) {
+	for c := first; len(b) > 0; c++ {
+		n := chunkStart(phase, c+1) - off
+		if n > int64(len(b)) {
+			n = int64(len(b))
+		}
+		i := (c - first) * nonceLen
+		key.xor(d.salt, name, nonces[i:i+nonceLen], off-chunkStart(phase, c), b[:n])
+		b, off = b[n:], off+n
+	}
+}
+
+// open decrypts b, read at off in the file name.
+func (d *sealedDir) open(name string, off int64, b []byte) error {
+	key, phase, err := d.lookup(name)
+	if err != nil || key == nil || len(b) == 0 {
+		return err
+	}
+	first, count := chunkSpan(phase, off, int64(len(b)))
+	nonces, err := readNonces(d.noncePath(name), first, count)
+	if err != nil {
+		return err
+	}
+	d.xorChunks(key, name, phase, nonces, first, off, b)
+	return nil
+}
+
+// seal encrypts b under fresh nonces and writes it at off in the file name.
+// The chunks it only partly covers are read back and written whole.
+func (d *sealedDir) seal(name string, off int64, b []byte) error {
+	d.io.RLock()
+	defer d.io.RUnlock()
+	d.write.Lock()
+	defer d.write.Unlock()
+
+	key, phase, err := d.lookup(name)
+	if err != nil {
+		return err
+	}
+	file := d.path(name)
+	if key == nil || len(b) == 0 {
+		return writeAt(file, off, b)
+	}
+	first, count := chunkSpan(phase, off, int64(len(b)))
+	start := chunkStart(phase, first)
+	buf := make([]byte, chunkStart(phase, first+count)-start)
+	size := off + int64(len(b)) - start
+	if start < off || size < int64(len(buf)) {
+		f, err := os.Open(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if f != nil {
+			n, err := f.ReadAt(buf, start)
+			f.Close()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			nonces, err := readNonces(d.noncePath(name), first, count)
+			if err != nil {
+				return err
+			}
+			d.xorChunks(key, name, phase, nonces, first, start, buf[:n])
+			if int64(n) > size {
+				size = int64(n)
+			}
+		}
+	}
+	buf = buf[:size]
+	copy(buf[off-start:], b)
+	nonces, err := newNonces(count)
+	if err != nil {
+		return err
+	}
+	d.xorChunks(key, name, phase, nonces, first, start, buf)
+	if err := writeAt(file, start, buf); err != nil {
+		return err
+	}
+	if err := writeAt(d.noncePath(name), first*nonceLen, nonces); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	if d.rekeying == name {
+		for c := first; c < first+count; c++ {
+			d.dirty[c] = true
+		}
+	}
+	d.lock.Unlock()
+	return nil
+}
+
+// spans calls fn with the part of each file of info holding [off, off+len(b)).
+func spans(info *metainfo.Info, off int64, b []byte, fn func(name string, off int64, b []byte) error) error {
+	for _, fi := range info.UpvertedFiles() {
+		if len(b) == 0 {
+			break
+		}
+		if off >= fi.Length {
+			off -= fi.Length
+			continue
+		}
+		n := int64(len(b))
+		if n > fi.Length-off {
+			n = fi.Length - off
+		}
+		if err := fn(fileName(info, fi), off, b[:n]); err != nil {
+			return err
+		}
+		b, off = b[n:], 0
+	}
+	return nil
+}
+
+// rekey re-encrypts the files of the directory under the current key, one at
+// a time, and returns how many it rewrote.
+func (d *sealedDir) rekey(quit <-chan struct{}) (int, error) {
+	current := d.ring.currentKey()
+	d.lock.Lock()
+	var names []string
+	for name, id := range d.files {
+		if id != current.id {
+			names = append(names, name)
+		}
+	}
+	d.lock.Unlock()
+	sort.Strings(names)
+
+	for i, name := range names {
+		select {
+		case <-quit:
+			return i, nil
+		default:
+		}
+		if err := d.rekeyFile(name, current); err != nil {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
+
+// rekeyFile re-encrypts the file name under key. The new copy is written
+// aside a batch of chunks at a time, letting the writes in between, then the
+// chunks written meanwhile are copied again and the copy renamed over the
+// old one, the marker telling which of the two is in place should the node
+// stop in between.
+func (d *sealedDir) rekeyFile(name string, key *storageKey) error {
+	file, nonces := d.path(name), d.noncePath(name)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		// Fetched under the new key, if ever
+		return d.settle(name, key.id, false)
+	} else if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	d.rekeying, d.dirty = name, make(map[int64]bool)
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		d.rekeying, d.dirty = "", nil
+		d.lock.Unlock()
+	}()
+	fail := func(err error) error {
+		os.Remove(file + rekeySuffix)
+		os.Remove(nonces + rekeySuffix)
+		return err
+	}
+
+	for first := int64(0); ; first += rekeyChunk / sealChunk {
+		d.io.Lock()
+		done, err := d.rekeyChunks(name, key, first, rekeyChunk/sealChunk)
+		d.io.Unlock()
+		if err != nil {
+			return fail(err)
+		} else if done {
+			break
+		}
+	}
+	d.io.Lock()
+	defer d.io.Unlock()
+	for c := range d.dirty {
+		if _, err := d.rekeyChunks(name, key, c, 1); err != nil {
+			return fail(err)
+		}
+	}
+	for _, f := range []string{file, nonces} {
+		if err := syncFile(f + rekeySuffix); err != nil {
+			return fail(err)
+		}
+	}
+	if err := d.settle(name, key.id, true); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(file+rekeySuffix, file); err != nil {
+		return err
+	}
+	if err := os.Rename(nonces+rekeySuffix, nonces); err != nil {
+		return err
+	}
+	return d.settle(name, key.id, false)
+}
+
+// rekeyChunks copies count chunks of the file name from first on to its new
+// copy under key, and reports whether the file ends there. d.io is held for
+// writing.
+func (d *sealedDir) rekeyChunks(name string, key *storageKey, first, count int64) (bool, error) {
+	old, phase, err := d.lookup(name)
+	if err != nil {
+		return false, err
+	}
+	start := chunkStart(phase, first)
+	buf := make([]byte, chunkStart(phase, first+count)-start)
+	src, err := os.Open(d.path(name))
+	if err != nil {
+		return false, err
+	}
+	n, err := src.ReadAt(buf, start)
+	src.Close()
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	done := n < len(buf)
+	buf = buf[:n]
+	nonces, err := readNonces(d.noncePath(name), first, count)
+	if err != nil {
+		return false, err
+	}
+	d.xorChunks(old, name, phase, nonces, first, start, buf)
+	if nonces, err = newNonces(count); err != nil {
+		return false, err
+	}
+	d.xorChunks(key, name, phase, nonces, first, start, buf)
+	if err := writeAt(d.path(name)+rekeySuffix, start, buf); err != nil {
+		return false, err
+	}
+	return done, writeAt(d.noncePath(name)+rekeySuffix, first*nonceLen, nonces)
+}
+
+// settle records id as the key of the file name, or as its key once the new
+// copy is renamed over it when pending.
+func (d *sealedDir) settle(name, id string, pending bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if pending {
+		d.pending[name] = id
+	} else {
+		d.files[name] = id
+		delete(d.pending, name)
+	}
+	return d.save()
+}
+
+// sealedStorage wraps the file storage of a torrent directory, encrypting the
+// data written by the torrent client and decrypting the data it reads, be it
+// to check the piece hashes or to seed it.
+type sealedStorage struct {
+	storage.ClientImpl
+	ring *keyring
+	dir  string
+}
+
+func (s *sealedStorage) OpenTorrent(info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	d, err := s.ring.dir(s.dir, true)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		phases = make(map[string]int64)
+		off    int64
+	)
+	for _, fi := range info.UpvertedFiles() {
+		phases[fileName(info, fi)] = off % sealChunk
+		off += fi.Length
+	}
+	// Before the file storage creates the empty files
+	if err := d.assign(phases); err != nil {
+		return nil, err
+	}
+	t, err := s.ClientImpl.OpenTorrent(info, ih)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedTorrent{TorrentImpl: t, info: info, dir: d}, nil
+}
+
+type sealedTorrent struct {
+	storage.TorrentImpl
+	info *metainfo.Info
+	dir  *sealedDir
+}
+
+func (t *sealedTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	return &sealedPiece{PieceImpl: t.TorrentImpl.Piece(p), t: t, off: p.Offset()}
+}
+
+type sealedPiece struct {
+	storage.PieceImpl
+	t   *sealedTorrent
+	off int64
+}
+
+func (p *sealedPiece) ReadAt(b []byte, off int64) (int, error) {
+	p.t.dir.io.RLock()
+	defer p.t.dir.io.RUnlock()
+	n, err := p.PieceImpl.ReadAt(b, off)
+	if xerr := spans(p.t.info, p.off+off, b[:n], p.t.dir.open); xerr != nil {
+		return 0, xerr
+	}
+	return n, err
+}
+
+// WriteAt writes the files itself, as the chunks partly covered are read
+// back to be encrypted whole.
+func (p *sealedPiece) WriteAt(b []byte, off int64) (int, error) {
+	if err := spans(p.t.info, p.off+off, b, p.t.dir.seal); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// dataFile reads a file of a torrent directory, decrypted when dir is set.
+// The file is opened at each read, as it is replaced when re-encrypted.
+type dataFile struct {
+	dir  *sealedDir
+	name string
+	file string
+}
+
+func (f *dataFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.dir != nil {
+		f.dir.io.RLock()
+		defer f.dir.io.RUnlock()
+	}
+	fd, err := os.Open(f.file)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	n, err := fd.ReadAt(b, off)
+	if f.dir != nil {
+		if oerr := f.dir.open(f.name, off, b[:n]); oerr != nil {
+			return 0, oerr
+		}
+	}
+	return n, err
+}
+
+// newStorage returns the storage of the torrent data in dir, encrypted when a
+// storage key is configured.
+func (tm *TorrentManager) newStorage(dir string) storage.ClientImpl {
+	s := storage.NewFile(dir)
+	if tm.keys == nil {
+		return s
+	}
+	return &sealedStorage{ClientImpl: s, ring: tm.keys, dir: dir}
+}
+
+// openData opens the file name of the torrent directory root.
+func (tm *TorrentManager) openData(root, name string) (*io.SectionReader, os.FileInfo, error) {
+	name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+name)), "/")
+	f := &dataFile{name: name, file: filepath.Join(root, filepath.FromSlash(name))}
+	stat, err := os.Stat(f.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a file", name)
+	}
+	if tm.keys != nil {
+		if f.dir, err = tm.keys.dir(root, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	return io.NewSectionReader(f, 0, stat.Size()), stat, nil
+}
+
+// OpenFile opens the file name of the seeded data of ih, decrypted.
+func (tm *TorrentManager) OpenFile(ih metainfo.Hash, name string) (*io.SectionReader, os.FileInfo, error) {
+	return tm.openData(filepath.Join(tm.DataDir, ih.HexString()), name)
+}
+
+// ReadFile returns the content of the file name of the seeded data of ih,
+// decrypted.
+func (tm *TorrentManager) ReadFile(ih metainfo.Hash, name string) ([]byte, error) {
+	r, _, err := tm.OpenFile(ih, name)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, r.Size())
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeFile stores data as the file name of the torrent directory root,
+// encrypted when a storage key is configured.
+func (tm *TorrentManager) writeFile(root, name string, data []byte) error {
+	file := filepath.Join(root, filepath.FromSlash(name))
+	if tm.keys == nil {
+		return ioutil.WriteFile(file, data, 0666)
+	}
+	d, err := tm.keys.dir(root, true)
+	if err != nil {
+		return err
+	}
+	if err := d.assign(map[string]int64{name: 0}); err != nil {
+		return err
+	}
+	// Written anew, under fresh nonces
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return d.seal(name, 0, data)
+}
+
+// verifySealed checks the data of info in root, decrypted by d, against its
+// piece hashes.
+func verifySealed(info *metainfo.Info, root string, d *sealedDir) error {
+	var readers []io.Reader
+	for _, fi := range info.UpvertedFiles() {
+		name := fileName(info, fi)
+		f := &dataFile{dir: d, name: name, file: filepath.Join(root, filepath.FromSlash(name))}
+		stat, err := os.Stat(f.file)
+		if err != nil {
+			return err
+		}
+		if stat.Size() != fi.Length {
+			return fmt.Errorf("file %q has wrong length, %d / %d", f.file, stat.Size(), fi.Length)
+		}
+		readers = append(readers, io.NewSectionReader(f, 0, fi.Length))
+	}
+	data := io.MultiReader(readers...)
+	for i := 0; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		hash := sha1.New()
+		if _, err := io.CopyN(hash, data, p.Length()); err != nil {
+			return err
+		}
+		if !bytes.Equal(hash.Sum(nil), p.Hash().Bytes()) {
+			return fmt.Errorf("hash mismatch at piece %d", i)
+		}
+	}
+	return nil
+}
+
+// forgetKeys drops the keys of the torrent directories about to be removed.
+func (tm *TorrentManager) forgetKeys(dirs ...string) {
+	if tm.keys == nil {
+		return
+	}
+	for _, dir := range dirs {
+		tm.keys.forget(dir)
+	}
+}
+
+// StorageKey returns the id of the key encrypting the torrent data, empty
+// when it is stored in the clear.
+func (tm *TorrentManager) StorageKey() string {
+	tm.lock.RLock()
+	keys := tm.keys
+	tm.lock.RUnlock()
+	if keys == nil {
+		return ""
+	}
+	return keys.currentKey().id
+}
+
+// RotateStorageKey encrypts the torrent data under a new key and returns its
+// id. The stored data is re-encrypted in the background, read with the older
+// keys kept in the key file meanwhile, which are dropped once it is done.
+func (tm *TorrentManager) RotateStorageKey() (string, error) {
+	if tm.keys == nil {
+		return "", errNoStorageKey
+	}
+	key, err := tm.keys.rotate()
+	if err != nil {
+		return "", err
+	}
+	select {
+	case tm.rekeyChan <- struct{}{}:
+	default:
+	}
+	return key.id, nil
+}
+
+// rekeyLoop re-encrypts the torrent data under the current key, at start and
+// whenever the key is rotated.
+func (tm *TorrentManager) rekeyLoop() {
+	defer tm.wg.Done()
+	for {
+		tm.rekey()
+		select {
+		case <-tm.rekeyChan:
+		case <-tm.closeAll:
+			return
+		}
+	}
+}
+
+// rekey re-encrypts the data of the torrent directories under the current key,
+// then drops the older keys once none of the data is left under them.
+func (tm *TorrentManager) rekey() {
+	var (
+		files, failed int
+		current       = tm.keys.currentKey()
+	)
+	for _, base := range []string{tm.TmpDataDir, tm.DataDir} {
+		entries, err := ioutil.ReadDir(base)
+		if err != nil {
+			log.Warn("Storage keys not rotated", "dir", base, "err", err)
+			continue
+		}
+		for _, entry := range entries {
+			var ih metainfo.Hash
+			// Links to the temporary directories are not dirs here
+			if !entry.IsDir() || ih.FromHexString(entry.Name()) != nil {
+				continue
+			}
+			d, err := tm.keys.dir(filepath.Join(base, entry.Name()), false)
+			if d == nil {
+				if err != nil {
+					log.Warn("Storage keys not loaded", "hash", entry.Name(), "err", err)
+					failed++
+				}
+				continue
+			}
+			n, err := d.rekey(tm.closeAll)
+			files += n
+			if err != nil {
+				log.Warn("Storage key not rotated", "hash", entry.Name(), "err", err)
+				failed++
+			}
+		}
+	}
+	if files > 0 || failed > 0 {
+		log.Info("Re-encrypted torrent data", "key", tm.StorageKey(), "files", files, "failed", failed)
+	}
+	select {
+	case <-tm.closeAll:
+		// Interrupted, some data may be left under the older keys
+		return
+	default:
+	}
+	if failed == 0 {
+		if err := tm.keys.prune(current); err != nil {
+			log.Warn("Rotated storage keys not dropped", "err", err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package torrentfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// newSealedManager creates a torrent manager encrypting its data under dir,
+// with the keys of dir/keys.
+func newSealedManager(t *testing.T, dir string) *TorrentManager {
+	keys, err := newKeyring(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	os.MkdirAll(filepath.Join(dir, defaultTmpFilePath), 0700)
+	return &TorrentManager{
+		DataDir:    dir,
+		TmpDataDir: filepath.Join(dir, defaultTmpFilePath),
+		keys:       keys,
+		closeAll:   make(chan struct{}),
+		rekeyChan:  make(chan struct{}, 1),
+	}
+}
+
+// loadModel returns the metainfo of the model ih laid out by writeModel.
+func loadModel(t *testing.T, dir, ih string) (metainfo.Hash, *metainfo.Info) {
+	mi, err := metainfo.LoadFromFile(filepath.Join(dir, ih, "torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mi.HashInfoBytes(), &info
+}
+
+// checkModel checks that tm serves symbol and params for ih, verified.
+func checkModel(t *testing.T, tm *TorrentManager, ih metainfo.Hash, info *metainfo.Info, symbol, params []byte) {
+	t.Helper()
+	for name, want := range map[string][]byte{"/data/symbol": symbol, "/data/params": params} {
+		if have, err := tm.ReadFile(ih, name); err != nil || !bytes.Equal(have, want) {
+			t.Errorf("%s mismatch: have %q, %v", name, have, err)
+		}
+	}
+	if err := tm.verifyTorrent(info, filepath.Join(tm.DataDir, ih.HexString())); err != nil {
+		t.Errorf("model failed verification: %v", err)
+	}
+}
+
+// readPieces reads the data of info through the pieces of ts.
+func readPieces(t *testing.T, ts storage.TorrentImpl, info *metainfo.Info) []byte {
+	t.Helper()
+	var data []byte
+	for i := 0; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		b := make([]byte, p.Length())
+		if _, err := ts.Piece(p).ReadAt(b, 0); err != nil {
+			t.Fatalf("failed to read piece %d: %v", i, err)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+func TestSealedStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrentfs-sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	symbol, params := []byte(`{"nodes": []}`), []byte("0123456789abcdef0123456789")
+	ih, info := loadModel(t, dir, writeModel(t, dir, symbol, params))
+	os.RemoveAll(filepath.Join(dir, ih.HexString()))
+
+	// Fetched into the temporary directory, then linked as once completed
+	tm := newSealedManager(t, dir)
+	s := tm.newStorage(filepath.Join(tm.TmpDataDir, ih.HexString()))
+	defer s.Close()
+	ts, err := s.OpenTorrent(info, ih)
+	if err != nil {
+		t.Fatalf("failed to open torrent: %v", err)
+	}
+	plain := append(append([]byte{}, params...), symbol...)
+	for i := 0; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		if _, err := ts.Piece(p).WriteAt(plain[p.Offset():p.Offset()+p.Length()], 0); err != nil {
+			t.Fatalf("failed to write piece %d: %v", i, err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(defaultTmpFilePath, ih.HexString()), filepath.Join(dir, ih.HexString())); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(tm.TmpDataDir, ih.HexString(), "data", "params")
+	sealed, err := ioutil.ReadFile(file)
+	if err != nil || len(sealed) != len(params) || bytes.Equal(sealed, params) {
+		t.Fatalf("params stored in the clear: %q, %v", sealed, err)
+	}
+	if data := readPieces(t, ts, info); !bytes.Equal(data, plain) {
+		t.Errorf("pieces mismatch: have %q", data)
+	}
+	checkModel(t, tm, ih, info, symbol, params)
+
+	// Rotate the key, the data is re-encrypted and read as before
+	old := tm.StorageKey()
+	id, err := tm.RotateStorageKey()
+	if err != nil || id == old {
+		t.Fatalf("key not rotated: %s, %v", id, err)
+	}
+	tm.rekey()
+	if resealed, _ := ioutil.ReadFile(file); bytes.Equal(resealed, sealed) || bytes.Equal(resealed, params) {
+		t.Errorf("params not re-encrypted: %q", resealed)
+	}
+	if data := readPieces(t, ts, info); !bytes.Equal(data, plain) {
+		t.Errorf("pieces mismatch after rotation: have %q", data)
+	}
+	checkModel(t, tm, ih, info, symbol, params)
+
+	// The rotated out key is dropped once the data is re-encrypted
+	keys, err := newKeyring(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatalf("failed to reload keyring: %v", err)
+	}
+	if keys.currentKey().id != id || len(keys.keys) != 1 {
+		t.Errorf("keyring mismatch: current %s, %d keys", keys.currentKey().id, len(keys.keys))
+	}
+	if _, err := keys.key(old); err == nil {
+		t.Errorf("rotated out key %s loaded", old)
+	}
+	if _, err := tm.keys.key(old); err == nil {
+		t.Errorf("rotated out key %s kept", old)
+	}
+	d, err := keys.dir(filepath.Join(dir, ih.HexString()), false)
+	if err != nil || d == nil {
+		t.Fatalf("keys of the model not found: %v", err)
+	}
+	for name, key := range d.files {
+		if key != id {
+			t.Errorf("file %s under key %s, want %s", name, key, id)
+		}
+	}
+}
+
+// Tests that the data stored before the encryption was enabled is read in the
+// clear, then encrypted once the keys are rotated.
+func TestSealedStorageLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrentfs-sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	symbol, params := []byte(`{"nodes": []}`), []byte("0123456789abcdef0123456789")
+	ih, info := loadModel(t, dir, writeModel(t, dir, symbol, params))
+
+	tm := newSealedManager(t, dir)
+	checkModel(t, tm, ih, info, symbol, params)
+	s := tm.newStorage(filepath.Join(dir, ih.HexString()))
+	defer s.Close()
+	ts, err := s.OpenTorrent(info, ih)
+	if err != nil {
+		t.Fatalf("failed to open torrent: %v", err)
+	}
+	plain := append(append([]byte{}, params...), symbol...)
+	if data := readPieces(t, ts, info); !bytes.Equal(data, plain) {
+		t.Errorf("pieces mismatch: have %q", data)
+	}
+
+	tm.rekey()
+	if sealed, _ := ioutil.ReadFile(filepath.Join(dir, ih.HexString(), "data", "params")); bytes.Equal(sealed, params) {
+		t.Error("params left in the clear")
+	}
+	if data := readPieces(t, ts, info); !bytes.Equal(data, plain) {
+		t.Errorf("pieces mismatch after encryption: have %q", data)
+	}
+	checkModel(t, tm, ih, info, symbol, params)
+}
+
+// Tests that a file whose re-encryption was interrupted is read with the key
+// of the copy left in place.
+func TestSealedStorageRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrentfs-sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	symbol, params := []byte(`{"nodes": []}`), []byte("0123456789abcdef0123456789")
+	ih, info := loadModel(t, dir, writeModel(t, dir, symbol, params))
+	root := filepath.Join(dir, ih.HexString())
+	name, file := "data/params", filepath.Join(root, "data", "params")
+
+	tm := newSealedManager(t, dir)
+	s := tm.newStorage(root)
+	defer s.Close()
+	if _, err := s.OpenTorrent(info, ih); err != nil {
+		t.Fatalf("failed to open torrent: %v", err)
+	}
+	tm.rekey()
+	old := tm.keys.currentKey()
+	key, err := tm.keys.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := tm.keys.dir(root, false)
+
+	// Stopped before the new copy was renamed over the old one
+	ioutil.WriteFile(file+rekeySuffix, []byte("partial"), 0644)
+	if err := d.settle(name, key.id, true); err != nil {
+		t.Fatal(err)
+	}
+	tm = newSealedManager(t, dir)
+	checkModel(t, tm, ih, info, symbol, params)
+	if _, err := os.Stat(file + rekeySuffix); !os.IsNotExist(err) {
+		t.Errorf("partial copy left: %v", err)
+	}
+
+	// Stopped once the data was renamed, before its nonces were
+	nonces := filepath.Join(root, nonceDir, "data", "params")
+	former, _ := ioutil.ReadFile(nonces)
+	d, _ = tm.keys.dir(root, false)
+	if err := d.rekeyFile(name, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(nonces, nonces+rekeySuffix); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(nonces, former, 0644)
+	if err := d.settle(name, old.id, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.settle(name, key.id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tm = newSealedManager(t, dir)
+	checkModel(t, tm, ih, info, symbol, params)
+	if _, err := os.Stat(nonces + rekeySuffix); !os.IsNotExist(err) {
+		t.Errorf("new nonces not renamed: %v", err)
+	}
+}
+
+// Tests that data written again at the same place is encrypted under a fresh
+// nonce, and that a write partly covering a chunk keeps the rest of it.
+func TestSealedStorageRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrentfs-sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tm := newSealedManager(t, dir)
+	root := filepath.Join(dir, "rewrite")
+	d, err := tm.keys.dir(root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, file := "data/params", filepath.Join(root, "data", "params")
+	if err := d.assign(map[string]int64{name: 100}); err != nil {
+		t.Fatal(err)
+	}
+	plain := bytes.Repeat([]byte("0123456789abcdef"), 3*sealChunk/16)
+	if err := d.seal(name, 0, plain); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ioutil.ReadFile(file)
+
+	// A chunk written again with other data doesn't leak the XOR of both
+	other := append([]byte(nil), plain...)
+	for i := sealChunk; i < 2*sealChunk; i++ {
+		other[i] ^= 0xff
+	}
+	if err := d.seal(name, sealChunk, other[sealChunk:2*sealChunk]); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := ioutil.ReadFile(file)
+	leak := make([]byte, sealChunk)
+	for i := range leak {
+		leak[i] = first[sealChunk+i] ^ second[sealChunk+i]
+	}
+	if bytes.Equal(leak, bytes.Repeat([]byte{0xff}, sealChunk)) {
+		t.Fatal("keystream reused for the rewritten data")
+	}
+
+	// Partly covered chunks keep their other bytes
+	copy(other[10:20], "xxxxxxxxxx")
+	if err := d.seal(name, 10, other[10:20]); err != nil {
+		t.Fatal(err)
+	}
+	have := make([]byte, len(other))
+	if n, err := (&dataFile{dir: d, name: name, file: file}).ReadAt(have, 0); n != len(have) || err != nil {
+		t.Fatalf("failed to read the data: %d, %v", n, err)
+	}
+	if !bytes.Equal(have, other) {
+		t.Error("data mismatch after partial write")
+	}
+}
+
+// Tests that the data written while a file is re-encrypted is kept.
+func TestSealedStorageRekeyWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrentfs-sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tm := newSealedManager(t, dir)
+	root := filepath.Join(dir, "rekey")
+	d, err := tm.keys.dir(root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, file := "data/params", filepath.Join(root, "data", "params")
+	if err := d.assign(map[string]int64{name: 0}); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*rekeyChunk/16)
+	if err := d.seal(name, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	key, err := tm.keys.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 64; i++ {
+			off := int64(i*(len(data)/64) + i)
+			b := []byte(fmt.Sprintf("write %d", i))
+			copy(data[off:], b)
+			if err := d.seal(name, off, b); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	if err := d.rekeyFile(name, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if id := d.files[name]; id != key.id {
+		t.Errorf("file under key %s, want %s", id, key.id)
+	}
+	have := make([]byte, len(data))
+	if n, err := (&dataFile{dir: d, name: name, file: file}).ReadAt(have, 0); n != len(have) || err != nil {
+		t.Fatalf("failed to read the data: %d, %v", n, err)
+	}
+	if !bytes.Equal(have, data) {
+		t.Error("data written during the re-encryption lost")
+	}
+}
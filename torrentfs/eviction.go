@@ -0,0 +1,201 @@
+package torrentfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// evictedMarker is left in the directory of an evicted torrent, so it
+	// stays evicted across restarts until requested again.
+	evictedMarker = "evicted"
+	// Each inference served weighs as much as an hour of recency when
+	// picking the data to evict.
+	citedBonus = 3600
+)
+
+var (
+	evictedMeter   = metrics.NewRegisteredMeter("torrent/evicted", nil)
+	diskUsageGauge = metrics.NewRegisteredGauge("torrent/disk/usage", nil)
+)
+
+// UpdateHead sets the number of the latest block dealt by the monitor, which
+// bounds the window of protected torrents.
+func (tm *TorrentManager) UpdateHead(number uint64) {
+	atomic.StoreUint64(&tm.head, number)
+}
+
+// reference marks ih as referenced by the given block.
+func (tm *TorrentManager) reference(ih metainfo.Hash, number uint64) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	if number > tm.refs[ih] {
+		tm.refs[ih] = number
+	}
+}
+
+// AccessTorrent records an inference access to ih. When the data of ih was
+// evicted, or deferred in lazy mode, it is fetched and true is returned.
+func (tm *TorrentManager) AccessTorrent(ih metainfo.Hash) bool {
+	head := atomic.LoadUint64(&tm.head)
+	tm.lock.Lock()
+	tm.access[ih] = time.Now()
+	if head > tm.refs[ih] {
+		tm.refs[ih] = head
+	}
+	_, known := tm.torrents[ih]
+	_, deferred := tm.deferred[ih]
+	evicted := !known && tm.isEvicted(ih)
+	if evicted {
+		delete(tm.evicted, ih)
+		os.Remove(path.Join(tm.TmpDataDir, ih.HexString(), evictedMarker))
+	}
+	bytesRequested := tm.bytes[ih]
+	tm.lock.Unlock()
+
+	switch {
+	case evicted:
+		log.Info("Evicted seed requested, fetching again", "hash", ih)
+	case deferred && !known:
+		log.Info("Deferred seed requested, fetching", "hash", ih)
+	default:
+		return false
+	}
+	tm.UpdateTorrent(FlowControlMeta{
+		InfoHash:       ih,
+		BytesRequested: uint64(bytesRequested),
+		IsCreate:       true,
+		IsFetch:        true,
+	})
+	return true
+}
+
+// isEvicted reports whether the data of ih was evicted, in this run or a
+// previous one. The caller must hold tm.lock.
+func (tm *TorrentManager) isEvicted(ih metainfo.Hash) bool {
+	if _, ok := tm.evicted[ih]; ok {
+		return true
+	}
+	if _, err := os.Stat(path.Join(tm.TmpDataDir, ih.HexString(), evictedMarker)); err == nil {
+		tm.evicted[ih] = struct{}{}
+		return true
+	}
+	return false
+}
+
+// DiskUsage returns the bytes of torrent data stored and the quota, 0 when
+// unlimited, along with the number of evicted torrents.
+func (tm *TorrentManager) DiskUsage() (usage int64, quota int64, evicted int) {
+	for _, t := range tm.Torrents() {
+		if t.Dropped() || t.Torrent.Info() == nil {
+			continue
+		}
+		usage += t.BytesCompleted()
+	}
+	tm.lock.RLock()
+	quota, evicted = tm.maxDiskUsage, len(tm.evicted)
+	tm.lock.RUnlock()
+	return usage, quota, evicted
+}
+
+// enforceQuota evicts seeded data until the disk usage fits the quota. The
+// data least recently used by inference goes first, each inference served
+// counting as an hour of recency. Forced and quarantined torrents, and those
+// referenced in the last protectBlocks blocks are kept.
+func (tm *TorrentManager) enforceQuota() {
+	usage, quota, _ := tm.DiskUsage()
+	diskUsageGauge.Update(usage)
+	if quota <= 0 || usage <= quota {
+		return
+	}
+	head := atomic.LoadUint64(&tm.head)
+	var (
+		candidates []*Torrent
+		scores     = make(map[*Torrent]int64)
+	)
+	tm.lock.RLock()
+	for ih, t := range tm.seedingTorrents {
+		if t.Dropped() || !t.Seeding() || t.forced || t.quarantined {
+			continue
+		}
+		if ref, ok := tm.refs[ih]; ok && ref+tm.protectBlocks > head {
+			continue
+		}
+		candidates = append(candidates, t)
+		scores[t] = tm.access[ih].Unix() + t.cited*citedBonus
+	}
+	tm.lock.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return scores[candidates[i]] < scores[candidates[j]]
+	})
+	for _, t := range candidates {
+		if usage <= quota {
+			break
+		}
+		size := t.BytesCompleted()
+		if err := tm.evict(t); err != nil {
+			log.Warn("Evict seed failed", "hash", t.Torrent.InfoHash(), "err", err)
+			continue
+		}
+		usage -= size
+	}
+	diskUsageGauge.Update(usage)
+	if usage > quota {
+		log.Warn("Disk quota exceeded by protected seeds", "usage", common.StorageSize(usage), "quota", common.StorageSize(quota))
+	}
+}
+
+// evict drops the seeded data of t from the disk, keeping its metainfo and
+// quota so it can be fetched again on demand.
+func (tm *TorrentManager) evict(t *Torrent) error {
+	ih := t.Torrent.InfoHash()
+	root := path.Join(tm.TmpDataDir, ih.HexString())
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path.Join(root, "torrent"))
+	if err != nil {
+		return err
+	}
+	err = t.Metainfo().Write(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	name := t.Torrent.Info().Name
+	size := t.BytesCompleted()
+
+	tm.lock.Lock()
+	t.status = torrentDropped
+	t.Torrent.Drop()
+	delete(tm.torrents, ih)
+	tm.evicted[ih] = struct{}{}
+	delete(tm.seedingTorrents, ih)
+	tm.lock.Unlock()
+
+	// The seeding directory is either a link to the temporary one or holds
+	// the data itself, the data is fetched into the temporary one again.
+	tm.forgetKeys(path.Join(tm.DataDir, ih.HexString()))
+	if err := os.RemoveAll(path.Join(tm.DataDir, ih.HexString())); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(root, name)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(root, evictedMarker), nil, 0644); err != nil {
+		return err
+	}
+	evictedMeter.Mark(1)
+	log.Info("Seed evicted", "hash", ih, "size", common.StorageSize(size))
+	return nil
+}
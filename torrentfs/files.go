@@ -1,5 +1,6 @@
 package torrentfs
 
+// BadFiles are blocked by every node, on top of the configured Blocklist.
 var BadFiles = map[string]bool{
 	"3edcb8a793887d92db12d53124955681d5c20a43": true,
 }
@@ -0,0 +1,91 @@
+package torrentfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+)
+
+// flowControl is a request cost budget that recharges over time. The serving
+// side keeps one per peer to police its requests, and the requesting side
+// mirrors the budget the peer keeps for it, so that it waits instead of
+// sending requests that would be rejected.
+type flowControl struct {
+	limit    uint64 // Maximum value of the budget
+	recharge uint64 // Recharge rate in cost units per second
+	value    uint64
+	last     mclock.AbsTime
+	lock     sync.Mutex
+}
+
+func newFlowControl(limit, recharge uint64) *flowControl {
+	return &flowControl{
+		limit:    limit,
+		recharge: recharge,
+		value:    limit,
+		last:     mclock.Now(),
+	}
+}
+
+// recalc recharges the budget for the time passed since the last update.
+func (fc *flowControl) recalc() {
+	now := mclock.Now()
+	dt := time.Duration(now - fc.last)
+	fc.last = now
+	fc.value += uint64(float64(fc.recharge) * dt.Seconds())
+	if fc.value > fc.limit {
+		fc.value = fc.limit
+	}
+}
+
+// accept charges cost to the budget if it is large enough, returning the
+// remaining value.
+func (fc *flowControl) accept(cost uint64) (uint64, bool) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.recalc()
+	if cost > fc.value {
+		return fc.value, false
+	}
+	fc.value -= cost
+	return fc.value, true
+}
+
+// refund gives back the part of a charged cost that was not used.
+func (fc *flowControl) refund(cost uint64) uint64 {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.recalc()
+	fc.value += cost
+	if fc.value > fc.limit {
+		fc.value = fc.limit
+	}
+	return fc.value
+}
+
+// wait returns how long to wait until a request of the given cost fits into
+// the budget.
+func (fc *flowControl) wait(cost uint64) time.Duration {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.recalc()
+	if cost <= fc.value {
+		return 0
+	}
+	if fc.recharge == 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(float64(cost-fc.value) / float64(fc.recharge) * float64(time.Second))
+}
+
+// set updates the mirrored budget with the value reported by the peer.
+func (fc *flowControl) set(value uint64) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.last = mclock.Now()
+	if value > fc.limit {
+		value = fc.limit
+	}
+	fc.value = value
+}
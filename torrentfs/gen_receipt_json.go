@@ -7,6 +7,7 @@ import (
 
 	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
 )
 
 // MarshalJSON marshals as JSON.
@@ -16,12 +17,14 @@ func (r TxReceipt) MarshalJSON() ([]byte, error) {
 		TxHash       *common.Hash    `json:"TransactionHash"  gencodec:"required"`
 		GasUsed      hexutil.Uint64  `json:"gasUsed" gencodec:"required"`
 		Status       hexutil.Uint64  `json:"status"`
+		Logs         []*types.Log    `json:"logs"`
 	}
 	var enc Receipt
 	enc.ContractAddr = r.ContractAddr
 	enc.TxHash = r.TxHash
 	enc.GasUsed = hexutil.Uint64(r.GasUsed)
 	enc.Status = hexutil.Uint64(r.Status)
+	enc.Logs = r.Logs
 	return json.Marshal(&enc)
 }
 
@@ -32,6 +35,7 @@ func (r *TxReceipt) UnmarshalJSON(input []byte) error {
 		TxHash       *common.Hash    `json:"TransactionHash"  gencodec:"required"`
 		GasUsed      hexutil.Uint64  `json:"gasUsed" gencodec:"required"`
 		Status       hexutil.Uint64  `json:"status"`
+		Logs         []*types.Log    `json:"logs"`
 	}
 	var dec Receipt
 	if err := json.Unmarshal(input, &dec); err != nil {
@@ -49,5 +53,8 @@ func (r *TxReceipt) UnmarshalJSON(input []byte) error {
 	//if dec.Status != nil {
 	r.Status = uint64(dec.Status)
 	//}
+	if dec.Logs != nil {
+		r.Logs = dec.Logs
+	}
 	return nil
 }
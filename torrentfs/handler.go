@@ -0,0 +1,358 @@
+package torrentfs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// PeerDataFetcher serves torrent metainfo and verified pieces to the node
+// peers over the ctfs protocol, and fetches them from those peers when the
+// swarm is too slow.
+type PeerDataFetcher struct {
+	tm *TorrentManager
+
+	peers   map[string]*fsPeer
+	lock    sync.RWMutex
+	reqID   uint64
+	serving chan struct{} // Semaphore of the piece requests being read
+	quit    chan struct{}
+}
+
+func NewPeerDataFetcher(tm *TorrentManager) *PeerDataFetcher {
+	return &PeerDataFetcher{
+		tm:      tm,
+		peers:   make(map[string]*fsPeer),
+		serving: make(chan struct{}, maxServingPieces),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Protocols returns the ctfs protocol for each supported version.
+func (f *PeerDataFetcher) Protocols() []p2p.Protocol {
+	protocols := make([]p2p.Protocol, 0, len(ProtocolVersions))
+	for _, version := range ProtocolVersions {
+		protocols = append(protocols, f.makeProtocol(version))
+	}
+	return protocols
+}
+
+func (f *PeerDataFetcher) makeProtocol(version uint) p2p.Protocol {
+	length, ok := protocolLengths[version]
+	if !ok {
+		panic("makeProtocol for unknown version")
+	}
+	return p2p.Protocol{
+		Name:    protocolName,
+		Version: version,
+		Length:  length,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			select {
+			case <-f.quit:
+				return p2p.DiscQuitting
+			default:
+			}
+			return f.handle(newFsPeer(int(version), p, rw))
+		},
+	}
+}
+
+// Close stops accepting new peers.
+func (f *PeerDataFetcher) Close() {
+	close(f.quit)
+}
+
+func (f *PeerDataFetcher) handle(p *fsPeer) error {
+	if err := p.Handshake(); err != nil {
+		p.Log().Debug("Fs handshake failed", "err", err)
+		return err
+	}
+	f.lock.Lock()
+	f.peers[p.id] = p
+	f.lock.Unlock()
+	p.Log().Debug("Fs peer connected", "name", p.Name())
+
+	defer func() {
+		f.lock.Lock()
+		delete(f.peers, p.id)
+		f.lock.Unlock()
+		p.close()
+		p.serving.Wait()
+	}()
+	for {
+		if err := f.handleMsg(p); err != nil {
+			p.Log().Debug("Fs message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+func (f *PeerDataFetcher) handleMsg(p *fsPeer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	switch msg.Code {
+	case StatusMsg:
+		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
+
+	case GetMetainfoMsg:
+		var req getMetainfoData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		bv, ok := p.fcClient.accept(baseRequestCost)
+		if !ok {
+			return errResp(ErrRequestRejected, "metainfo %x", req.InfoHash)
+		}
+		var data []byte
+		if t := f.tm.GetTorrent(req.InfoHash); t != nil && t.Torrent.Info() != nil {
+			var buf bytes.Buffer
+			mi := t.Torrent.Metainfo()
+			if err := mi.Write(&buf); err == nil {
+				data = buf.Bytes()
+			}
+		}
+		return p2p.Send(p.rw, MetainfoMsg, &metainfoData{ReqID: req.ReqID, BV: bv, Data: data})
+
+	case GetPiecesMsg:
+		var req getPiecesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		return f.servePieces(p, &req)
+
+	case MetainfoMsg:
+		var resp metainfoData
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if !p.deliver(resp.ReqID, resp.BV, &resp) {
+			p.Log().Debug("Unrequested metainfo", "id", resp.ReqID)
+		}
+
+	case PiecesMsg:
+		var resp piecesData
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if !p.deliver(resp.ReqID, resp.BV, &resp) {
+			p.Log().Debug("Unrequested pieces", "id", resp.ReqID)
+		}
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// servePieces replies with the completed pieces in the requested range. The
+// request is charged its full size up front and the unserved part refunded.
+// The pieces are read and sent by a worker, up to maxServingPieces requests
+// at once, not to hold the read loop of the peer.
+func (f *PeerDataFetcher) servePieces(p *fsPeer, req *getPiecesData) error {
+	amount := req.Amount
+	if amount > maxPiecesFetch {
+		amount = maxPiecesFetch
+	}
+	t := f.tm.GetTorrent(req.InfoHash)
+	var info *metainfo.Info
+	if t != nil {
+		info = t.Torrent.Info()
+	}
+	cost := uint64(baseRequestCost)
+	if info != nil {
+		cost += amount * uint64(info.PieceLength)
+	}
+	if _, ok := p.fcClient.accept(cost); !ok {
+		return errResp(ErrRequestRejected, "pieces %x %d+%d", req.InfoHash, req.Origin, req.Amount)
+	}
+	p.serving.Add(1)
+	go func() {
+		defer p.serving.Done()
+		select {
+		case f.serving <- struct{}{}:
+			defer func() { <-f.serving }()
+		case <-p.term:
+			return
+		}
+		var (
+			pieces [][]byte
+			served uint64
+		)
+		if info != nil {
+			pieces, served = f.readPieces(t, req.Origin, amount)
+		}
+		bv := p.fcClient.refund(cost - baseRequestCost - served)
+		if err := p2p.Send(p.rw, PiecesMsg, &piecesData{ReqID: req.ReqID, BV: bv, Pieces: pieces}); err != nil {
+			p.Log().Debug("Pieces reply failed", "hash", req.InfoHash, "err", err)
+		}
+	}()
+	return nil
+}
+
+// readPieces reads the completed pieces of t from origin, up to amount of
+// them or softResponseLimit bytes. Missing pieces are left nil. It returns
+// the pieces along with their total size.
+func (f *PeerDataFetcher) readPieces(t *Torrent, origin, amount uint64) (pieces [][]byte, served uint64) {
+	for i := origin; i < origin+amount && i < uint64(t.Torrent.NumPieces()); i++ {
+		if served >= softResponseLimit {
+			break
+		}
+		piece := t.Torrent.Piece(int(i))
+		if !t.Torrent.PieceState(int(i)).Complete {
+			pieces = append(pieces, nil)
+			continue
+		}
+		// The storage may report io.EOF along with a whole piece
+		data := make([]byte, piece.Info().Length())
+		if n, err := piece.Storage().ReadAt(data, 0); n < len(data) {
+			log.Warn("Read piece failed", "hash", t.Torrent.InfoHash(), "piece", i, "err", err)
+			pieces = append(pieces, nil)
+			continue
+		}
+		pieces = append(pieces, data)
+		served += uint64(len(data))
+	}
+	return pieces, served
+}
+
+// peerList returns the connected peers in random order.
+func (f *PeerDataFetcher) peerList() []*fsPeer {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	list := make([]*fsPeer, 0, len(f.peers))
+	for _, p := range f.peers {
+		list = append(list, p)
+	}
+	rand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+	return list
+}
+
+// GetTorrent asks the peers for the metainfo of ih and returns the first copy
+// matching the info hash.
+func (f *PeerDataFetcher) GetTorrent(ih metainfo.Hash) ([]byte, error) {
+	for _, p := range f.peerList() {
+		req := &getMetainfoData{ReqID: atomic.AddUint64(&f.reqID, 1), InfoHash: ih}
+		resp, err := p.request(req.ReqID, GetMetainfoMsg, req, baseRequestCost)
+		if err != nil {
+			p.Log().Debug("Metainfo request failed", "hash", ih, "err", err)
+			continue
+		}
+		data := resp.(*metainfoData).Data
+		if len(data) == 0 {
+			continue
+		}
+		mi, err := metainfo.Load(bytes.NewReader(data))
+		if err != nil || mi.HashInfoBytes() != ih {
+			p.Log().Warn("Peer sent invalid metainfo", "hash", ih)
+			p.Disconnect(p2p.DiscUselessPeer)
+			continue
+		}
+		log.Info("Metainfo fetched from peer", "hash", ih, "peer", p.id)
+		return data, nil
+	}
+	return nil, errors.New("metainfo not found on peers")
+}
+
+// FetchPieces downloads the missing pieces of t below limit from the peers,
+// checks them against the piece hashes of the metainfo and writes them to the
+// torrent storage. It returns the number of pieces written.
+func (f *PeerDataFetcher) FetchPieces(t *Torrent, limit int) (int, error) {
+	info := t.Torrent.Info()
+	if info == nil {
+		return 0, errors.New("torrent metadata not ready")
+	}
+	if limit > t.Torrent.NumPieces() {
+		limit = t.Torrent.NumPieces()
+	}
+	var missing []int
+	for i := 0; i < limit; i++ {
+		if !t.Torrent.PieceState(i).Complete {
+			missing = append(missing, i)
+		}
+	}
+	batch := softResponseLimit / int(info.PieceLength)
+	if batch < 1 {
+		batch = 1
+	}
+	if batch > maxPiecesFetch {
+		batch = maxPiecesFetch
+	}
+	written := 0
+	for len(missing) > 0 {
+		// Ask for the run of consecutive pieces at the head of the list
+		origin, amount := missing[0], 1
+		for amount < batch && amount < len(missing) && missing[amount] == origin+amount {
+			amount++
+		}
+		missing = missing[amount:]
+
+		n, err := f.fetchRange(t, info, origin, amount)
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (f *PeerDataFetcher) fetchRange(t *Torrent, info *metainfo.Info, origin, amount int) (int, error) {
+	ih := t.Torrent.InfoHash()
+	for _, p := range f.peerList() {
+		req := &getPiecesData{
+			ReqID:    atomic.AddUint64(&f.reqID, 1),
+			InfoHash: ih,
+			Origin:   uint64(origin),
+			Amount:   uint64(amount),
+		}
+		cost := uint64(baseRequestCost + amount*int(info.PieceLength))
+		resp, err := p.request(req.ReqID, GetPiecesMsg, req, cost)
+		if err != nil {
+			p.Log().Debug("Pieces request failed", "hash", ih, "err", err)
+			continue
+		}
+		pieces := resp.(*piecesData).Pieces
+		if len(pieces) > amount {
+			p.Disconnect(p2p.DiscUselessPeer)
+			continue
+		}
+		written, bad := 0, false
+		for j, data := range pieces {
+			if len(data) == 0 {
+				continue
+			}
+			piece := t.Torrent.Piece(origin + j)
+			if int64(len(data)) != piece.Info().Length() || metainfo.Hash(sha1.Sum(data)) != piece.Info().Hash() {
+				bad = true
+				break
+			}
+			if _, err := piece.Storage().WriteAt(data, 0); err != nil {
+				return written, err
+			}
+			piece.VerifyData()
+			written++
+		}
+		if bad {
+			p.Log().Warn("Peer sent invalid piece", "hash", ih)
+			p.Disconnect(p2p.DiscUselessPeer)
+		}
+		if written > 0 {
+			log.Debug("Pieces fetched from peer", "hash", ih, "origin", origin, "pieces", written, "peer", p.id)
+			return written, nil
+		}
+	}
+	return 0, nil
+}
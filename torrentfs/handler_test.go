@@ -0,0 +1,139 @@
+package torrentfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/p2p/discover"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// addSeededTorrent adds to cl a torrent of data already in its data dir, and
+// returns it verified.
+func addSeededTorrent(t *testing.T, cl *torrent.Client, dataDir string, data []byte) *Torrent {
+	name := filepath.Join(dataDir, "params")
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: swarmPieceLength}
+	if err := info.BuildFromFilePath(name); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+	tt, _, err := cl.AddTorrentSpec(&torrent.TorrentSpec{InfoHash: mi.HashInfoBytes(), InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.VerifyData()
+	if tt.BytesMissing() != 0 {
+		t.Fatalf("%d bytes missing", tt.BytesMissing())
+	}
+	return &Torrent{Torrent: tt}
+}
+
+// Tests that piece requests are served by workers, without holding the read
+// loop while the storage is busy, and that a closing peer stops them.
+func TestServePieces(t *testing.T) {
+	cl, dir, closeClient := newTestClient(t)
+	defer closeClient()
+	data := randomData(t, 3*swarmPieceLength+100)
+	tor := addSeededTorrent(t, cl, dir, data)
+	ih := tor.Torrent.InfoHash()
+
+	f := NewPeerDataFetcher(&TorrentManager{torrents: map[metainfo.Hash]*Torrent{ih: tor}})
+	local, remote := p2p.MsgPipe()
+	defer remote.Close()
+	p := newFsPeer(1, p2p.NewPeer(discover.NodeID{1}, "test", nil), local)
+
+	replies := make(chan *piecesData)
+	go func() {
+		for {
+			msg, err := remote.ReadMsg()
+			if err != nil {
+				close(replies)
+				return
+			}
+			var resp piecesData
+			if err := msg.Decode(&resp); err != nil {
+				t.Error(err)
+			}
+			replies <- &resp
+		}
+	}()
+
+	// The storage is busy with other requests
+	for i := 0; i < maxServingPieces; i++ {
+		f.serving <- struct{}{}
+	}
+	done := make(chan error)
+	go func() { done <- f.servePieces(p, &getPiecesData{ReqID: 1, InfoHash: ih, Origin: 1, Amount: 10}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request held the read loop")
+	}
+	select {
+	case <-replies:
+		t.Fatal("request served beyond maxServingPieces")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-f.serving
+
+	select {
+	case resp := <-replies:
+		if resp.ReqID != 1 || len(resp.Pieces) != 3 {
+			t.Fatalf("reply %d with %d pieces, want 1 with 3", resp.ReqID, len(resp.Pieces))
+		}
+		if have := bytes.Join(resp.Pieces, nil); !bytes.Equal(have, data[swarmPieceLength:]) {
+			t.Fatal("pieces mismatch")
+		}
+		// Only the served bytes are charged
+		if want := uint64(bufLimit - baseRequestCost - len(data) + swarmPieceLength); resp.BV < want {
+			t.Fatalf("buffer value %d, want at least %d", resp.BV, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request not served")
+	}
+
+	// Requests still queued are dropped once the peer disconnects
+	f.serving <- struct{}{}
+	if err := f.servePieces(p, &getPiecesData{ReqID: 2, InfoHash: ih, Amount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	p.close()
+	stopped := make(chan struct{})
+	go func() {
+		p.serving.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("worker of a closed peer still waiting")
+	}
+	<-f.serving
+	select {
+	case <-replies:
+		t.Fatal("request of a closed peer served")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Requests over the budget of the peer are refused
+	p.fcClient = newFlowControl(baseRequestCost, minRecharge)
+	if err := f.servePieces(p, &getPiecesData{ReqID: 3, InfoHash: ih, Amount: 1}); err == nil {
+		t.Fatal("request over the budget accepted")
+	}
+}
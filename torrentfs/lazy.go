@@ -0,0 +1,86 @@
+package torrentfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Sync modes of the torrent file system. In full mode every torrent created
+// on chain is fetched and seeded; in lazy mode only its metadata is tracked,
+// and the data is fetched when the CVM or an operator asks for it.
+const (
+	syncModeFull = "full"
+	syncModeLazy = "lazy"
+)
+
+// Lazy reports whether the config selects the lazy sync mode.
+func (c *Config) Lazy() bool {
+	return c.SyncMode == syncModeLazy
+}
+
+// fetchPollInterval is how often a blocked fetch checks the download.
+const fetchPollInterval = 200 * time.Millisecond
+
+var errFetchTimeout = errors.New("download not completed")
+
+// deferTorrent records ih as known but not fetched, unless its data was
+// fetched in a previous run. It reports whether the fetch is deferred.
+func (tm *TorrentManager) deferTorrent(ih metainfo.Hash, bytesRequested int64) bool {
+	if tm.GetTorrent(ih) != nil {
+		return false
+	}
+	for _, dir := range []string{tm.DataDir, tm.TmpDataDir} {
+		if _, err := os.Stat(path.Join(dir, ih.HexString())); err == nil {
+			return false
+		}
+	}
+	tm.lock.Lock()
+	tm.deferred[ih] = struct{}{}
+	tm.lock.Unlock()
+	tm.UpdateInfoHash(ih, bytesRequested)
+	return true
+}
+
+// undefer takes ih out of the deferred torrents once it is being fetched.
+func (tm *TorrentManager) undefer(ih metainfo.Hash) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	delete(tm.deferred, ih)
+}
+
+// FetchTorrent fetches the data of ih at the given priority if it was
+// deferred or evicted, and waits until it is available or the timeout
+// elapses. It returns errTorrentNotFound for the info hashes never seen on
+// chain and errFetchTimeout when the data is still missing.
+func (tm *TorrentManager) FetchTorrent(ih metainfo.Hash, level int, timeout time.Duration) error {
+	if tm.blocklist.Blocked(ih) {
+		return errBlocked
+	}
+	if t := tm.GetTorrent(ih); t != nil && tm.Available(t) {
+		return nil
+	}
+	if err := tm.PrioritizeTorrent(ih, level); err != nil {
+		return err
+	}
+	tm.AccessTorrent(ih)
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(fetchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if t := tm.GetTorrent(ih); t != nil && tm.Available(t) {
+				return nil
+			}
+		case <-deadline.C:
+			return errFetchTimeout
+		case <-tm.closeAll:
+			return errFetchTimeout
+		}
+	}
+}
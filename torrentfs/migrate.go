@@ -0,0 +1,186 @@
+package torrentfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	bolt "github.com/etcd-io/bbolt"
+)
+
+const (
+	// storageFile is the bolt database of the file storage in the data dir.
+	storageFile = ".file.bolt.db"
+	// schemaVersion is the layout of the storage database written by this
+	// release. Databases of an older layout are migrated at open.
+	schemaVersion = 2
+)
+
+var schemaBucket = []byte("schema")
+
+// migration upgrades the storage database to version. It runs in a single
+// transaction along with the update of the schema version.
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *bolt.Tx, suffix string) error
+}
+
+// migrations are the upgrades of the storage database, in order. The
+// database of a release without schema bucket is at version 1.
+var migrations = []migration{
+	{2, "index the transactions of the record blocks", migrateTxIndex},
+}
+
+// readSchema returns the schema version of db, 0 for an empty database.
+func readSchema(tx *bolt.Tx) (int, error) {
+	if buk := tx.Bucket(schemaBucket); buk != nil {
+		return strconv.Atoi(string(buk.Get([]byte("version"))))
+	}
+	for _, name := range []string{"blocks_", "files_", "currentBlockNumber_"} {
+		if tx.Bucket([]byte(name+version)) != nil {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func writeSchema(tx *bolt.Tx, v int) error {
+	buk, err := tx.CreateBucketIfNotExists(schemaBucket)
+	if err != nil {
+		return err
+	}
+	return buk.Put([]byte("version"), []byte(strconv.Itoa(v)))
+}
+
+// pending returns the migrations to run on a database at schema v.
+func pending(v int, ms []migration) []migration {
+	for i, m := range ms {
+		if m.version > v {
+			return ms[i:]
+		}
+	}
+	return nil
+}
+
+// migrateStorage brings db, stored at path, to the latest schema version.
+// The database is copied aside before the first migration, to path followed
+// by the schema version it had.
+func migrateStorage(db *bolt.DB, path string, ms []migration) error {
+	latest := schemaVersion
+	if len(ms) > 0 {
+		latest = ms[len(ms)-1].version
+	}
+	var v int
+	if err := db.View(func(tx *bolt.Tx) (err error) {
+		v, err = readSchema(tx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("invalid storage schema: %v", err)
+	}
+	switch {
+	case v == 0:
+		// New database, created at the latest layout
+		return db.Update(func(tx *bolt.Tx) error { return writeSchema(tx, latest) })
+	case v > latest:
+		return fmt.Errorf("storage schema %d newer than the supported %d", v, latest)
+	case v == latest:
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, v)
+	if err := db.View(func(tx *bolt.Tx) error { return tx.CopyFile(backup, 0600) }); err != nil {
+		return fmt.Errorf("storage backup failed: %v", err)
+	}
+	log.Info("Storage backed up before migration", "backup", backup, "schema", v)
+	for _, m := range pending(v, ms) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.apply(tx, version); err != nil {
+				return err
+			}
+			return writeSchema(tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("storage migration to schema %d (%s) failed: %v", m.version, m.name, err)
+		}
+		log.Info("Storage migrated", "schema", m.version, "migration", m.name)
+	}
+	return nil
+}
+
+// StorageSchema returns the schema version of the storage in dir, along
+// with the names of the migrations left to run on it. The storage must not
+// be in use.
+func StorageSchema(dir string) (int, []string, error) {
+	path := filepath.Join(dir, storageFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return 0, nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer db.Close()
+	var v int
+	if err := db.View(func(tx *bolt.Tx) (err error) {
+		v, err = readSchema(tx)
+		return err
+	}); err != nil {
+		return 0, nil, err
+	}
+	var names []string
+	for _, m := range pending(v, migrations) {
+		names = append(names, m.name)
+	}
+	return v, names, nil
+}
+
+// migrateTxIndex indexes the record block of each transaction by hash.
+func migrateTxIndex(tx *bolt.Tx, suffix string) error {
+	blocks := tx.Bucket([]byte("blocks_" + suffix))
+	txs, err := tx.CreateBucketIfNotExists([]byte("txs_" + suffix))
+	if err != nil || blocks == nil {
+		return err
+	}
+	return blocks.ForEach(func(k, v []byte) error {
+		var b Block
+		if err := json.Unmarshal(v, &b); err != nil {
+			return err
+		}
+		return indexTxs(txs, &b)
+	})
+}
+
+// indexTxs maps the transactions of b to its number in buk.
+func indexTxs(buk *bolt.Bucket, b *Block) error {
+	k, err := json.Marshal(b.Number)
+	if err != nil {
+		return err
+	}
+	for _, tx := range b.Txs {
+		if tx.Hash != nil {
+			if err := buk.Put(tx.Hash.Bytes(), k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// txBlock returns the number of the record block of the transaction hash.
+func (fs *FileStorage) txBlock(hash common.Hash) (number uint64, ok bool) {
+	fs.db.View(func(tx *bolt.Tx) error {
+		if buk := tx.Bucket([]byte("txs_" + fs.version)); buk != nil {
+			if v := buk.Get(hash.Bytes()); v != nil {
+				ok = json.Unmarshal(v, &number) == nil
+			}
+		}
+		return nil
+	})
+	return number, ok
+}
@@ -0,0 +1,133 @@
+package torrentfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	bolt "github.com/etcd-io/bbolt"
+)
+
+// fixtureDir returns a data dir holding the storage database of fixture.
+func fixtureDir(t *testing.T, fixture string) string {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, storageFile), data, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestMigrateV1 opens a storage written before the schema was versioned,
+// holding the record blocks 10, 20 and 30 listened up to block 35.
+func TestMigrateV1(t *testing.T) {
+	dir := fixtureDir(t, "storage-v1.db")
+	defer os.RemoveAll(dir)
+
+	v, names, err := StorageSchema(dir)
+	if err != nil || v != 1 || len(names) != len(migrations) {
+		t.Fatalf("schema %d with %d migrations left, %v", v, len(names), err)
+	}
+	fs, err := NewFileStorage(&Config{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := common.HexToHash("71f44152b0d8d553bd4ff37dd7d4b56469ec0f8c96074318b2be8b6baa9b7606")
+	if fs.Root() != want || fs.CheckPoint != 30 || fs.LastListenBlockNumber != 35 {
+		t.Errorf("root %x at %d/%d, want %x at 30/35", fs.Root(), fs.CheckPoint, fs.LastListenBlockNumber, want)
+	}
+	if len(fs.Blocks()) != 3 || len(fs.Files()) != 3 {
+		t.Errorf("%d blocks and %d files, want 3 and 3", len(fs.Blocks()), len(fs.Files()))
+	}
+	// The transactions of the old blocks are indexed
+	for _, f := range fs.Files() {
+		p, err := fs.FileProof(f, fs.CheckPoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyBlockProof(p, want); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	if v, names, err := StorageSchema(dir); err != nil || v != schemaVersion || len(names) != 0 {
+		t.Fatalf("migrated to schema %d with %d migrations left, %v", v, len(names), err)
+	}
+	backup, err := bolt.Open(filepath.Join(dir, storageFile+".v1.bak"), 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	backup.View(func(tx *bolt.Tx) error {
+		if v, _ := readSchema(tx); v != 1 {
+			t.Errorf("backup at schema %d, want 1", v)
+		}
+		return nil
+	})
+}
+
+func TestMigrateNew(t *testing.T) {
+	fs, closeFs := newCatalogStorage(t)
+	defer closeFs()
+	var v int
+	fs.db.View(func(tx *bolt.Tx) (err error) {
+		v, err = readSchema(tx)
+		return err
+	})
+	if v != schemaVersion {
+		t.Fatalf("new storage at schema %d, want %d", v, schemaVersion)
+	}
+	if _, err := os.Stat(filepath.Join(fs.dataDir, storageFile+".v0.bak")); !os.IsNotExist(err) {
+		t.Fatal("new storage backed up")
+	}
+}
+
+func TestMigrateFailed(t *testing.T) {
+	dir := fixtureDir(t, "storage-v1.db")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, storageFile)
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	failing := append(migrations[:len(migrations):len(migrations)], migration{
+		version: schemaVersion + 1,
+		name:    "failing",
+		apply: func(tx *bolt.Tx, suffix string) error {
+			tx.DeleteBucket([]byte("blocks_" + suffix))
+			return errors.New("failed")
+		},
+	})
+	if err := migrateStorage(db, path, failing); err == nil {
+		t.Fatal("failed migration reported done")
+	}
+	// The migrations before the failing one are kept, not the failing one
+	db.View(func(tx *bolt.Tx) error {
+		if v, _ := readSchema(tx); v != schemaVersion {
+			t.Errorf("schema %d after failure, want %d", v, schemaVersion)
+		}
+		if tx.Bucket([]byte("blocks_"+version)) == nil {
+			t.Error("failed migration not rolled back")
+		}
+		return nil
+	})
+
+	// Newer schemas are refused
+	db.Update(func(tx *bolt.Tx) error { return writeSchema(tx, schemaVersion+1) })
+	if err := migrateStorage(db, path, migrations); err == nil {
+		t.Fatal("newer schema opened")
+	}
+}
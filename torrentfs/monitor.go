@@ -6,18 +6,20 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/p2p"
 	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/CortexFoundation/CortexTheseus/rpc"
 	"github.com/anacrolix/torrent/metainfo"
 	lru "github.com/hashicorp/golang-lru"
+	"io"
+	"math/big"
 	"net"
 	"net/http"
-	//"os"
+	"os"
 	"runtime"
 	//"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -57,15 +59,40 @@ type TorrentManagerAPI interface {
 	UpdateTorrent(interface{}) error
 	UpdateDynamicTrackers(trackers []string)
 	GetTorrent(ih metainfo.Hash) *Torrent
+	Torrents() []*Torrent
+	Status(t *Torrent) *TorrentStatus
+	Available(t *Torrent) bool
+	Quarantined(t *Torrent) bool
+	PauseTorrent(ih metainfo.Hash) error
+	ResumeTorrent(ih metainfo.Hash) error
+	VerifyTorrent(ih metainfo.Hash) error
+	SeedTorrent(ih metainfo.Hash) error
+	ScrubTorrent(ih metainfo.Hash) error
+	AccessTorrent(ih metainfo.Hash) bool
+	PrioritizeTorrent(ih metainfo.Hash, level int) error
+	FetchTorrent(ih metainfo.Hash, level int, timeout time.Duration) error
+	Shaping() Shaping
+	SetShaping(s Shaping) error
+	Blocklist() *Blocklist
+	UpdateHead(number uint64)
+	DiskUsage() (int64, int64, int)
+	OpenFile(ih metainfo.Hash, name string) (*io.SectionReader, os.FileInfo, error)
+	ReadFile(ih metainfo.Hash, name string) ([]byte, error)
+	StorageKey() string
+	RotateStorageKey() (string, error)
+	Protocols() []p2p.Protocol
 }
 
 // Monitor observes the data changes on the blockchain and synchronizes.
 // cl for ipc/rpc communication, dl for download manager, and fs for data storage.
+// When backend is set, the chain is read in-process instead of over cl.
 type Monitor struct {
-	config *Config
-	cl     *rpc.Client
-	fs     *FileStorage
-	dl     TorrentManagerAPI
+	config  *Config
+	cl      *rpc.Client
+	backend ChainBackend
+	chain   chainReader
+	fs      *FileStorage
+	dl      TorrentManagerAPI
 
 	//listenID rpc.ID
 
@@ -90,6 +117,19 @@ type Monitor struct {
 	sizeCache   *lru.Cache
 	ckp         *params.TrustedCheckpoint
 	start       mclock.AbsTime
+
+	// deprecated keeps the block from which each deprecated file still seeded
+	// is no longer, i.e. the effective deprecation block plus the grace
+	// period. The schedule is persisted in the file storage and reloaded at
+	// startup.
+	deprecated map[metainfo.Hash]uint64
+
+	// After a reorg the storage is rolled back to rewindTo, the scanner is
+	// told to restart from there over rewindCh, and the blocks queued before
+	// are skipped until rewindTo+1 shows up again.
+	rewindCh  chan uint64
+	rewinding bool
+	rewindTo  uint64
 }
 
 // NewMonitor creates a new instance of monitor.
@@ -128,6 +168,13 @@ func NewMonitor(flag *Config) (m *Monitor, e error) {
 		dirty:      false,
 		taskCh:     make(chan *Block, batch),
 		start:      mclock.Now(),
+		deprecated: make(map[metainfo.Hash]uint64),
+		rewindCh:   make(chan uint64, 1),
+	}
+	if err := m.loadDeprecations(); err != nil {
+		log.Error("Failed to load deprecations", "err", err)
+		fs.Close()
+		return nil, err
 	}
 	m.blockCache, _ = lru.New(delay)
 	//m.healthPeers, _ = lru.New(0)
@@ -188,7 +235,7 @@ func NewMonitor(flag *Config) (m *Monitor, e error) {
 
 func (m *Monitor) storageInit() error {
 	log.Info("Loading storage data ... ...", "latest", m.fs.LastListenBlockNumber, "checkpoint", m.fs.CheckPoint, "root", m.fs.Root(), "version", m.fs.Version())
-	genesis, err := m.rpcBlockByNumber(0)
+	genesis, err := m.chain.GetBlockByNumber(0)
 	if err != nil {
 		return err
 	}
@@ -228,6 +275,13 @@ func (m *Monitor) storageInit() error {
 		return blocks[i].Number < blocks[j].Number
 	})*/
 
+	if m.fs.catalogUnchecked() {
+		if err := m.fs.checkCatalog(m.chain); err != nil {
+			log.Error("Imported catalog doesn't match the chain, import it again or remove the storage", "err", err)
+			return err
+		}
+	}
+
 	for _, block := range m.fs.Blocks() {
 		/*if b, err := m.rpcBlockByNumber(block.Number); err == nil && b.Hash != block.Hash {
 			m.lastNumber = 0
@@ -256,6 +310,9 @@ func (m *Monitor) storageInit() error {
 	pending := 0
 
 	for _, file := range fileMap {
+		if at, ok := m.fs.DeprecatedAt(file.Meta.InfoHash); ok && m.fs.LastListenBlockNumber >= at {
+			continue
+		}
 		var bytesRequested uint64
 		bytesRequested = 0
 		if file.Meta.RawSize > file.LeftSize {
@@ -277,9 +334,39 @@ func (m *Monitor) storageInit() error {
 		}
 	}
 	log.Info("Storage current state", "total", len(fileMap), "seed", seed, "pause", pause, "pending", pending, "capcity", common.StorageSize(capcity), "blocks", len(m.fs.Blocks()))
+	m.seedGenesis()
 	return nil
 }
 
+// seedGenesis indexes and requests the data of the metas registered in the
+// genesis allocation, which are complete from the start. The node can refuse
+// to dump its genesis state over RPC, then only uploaded data is served.
+func (m *Monitor) seedGenesis() {
+	metas, err := m.chain.GenesisMetas()
+	if err != nil {
+		log.Warn("Genesis metas unavailable", "err", err)
+		return
+	}
+	for _, g := range metas {
+		addr := g.Addr
+		info := m.fs.NewFileInfo(g.Meta)
+		info.LeftSize = 0
+		info.ContractAddr = &addr
+		if !m.fs.AddGenesisFile(info) {
+			continue
+		}
+		if at, ok := m.fs.DeprecatedAt(g.Meta.InfoHash); ok && m.fs.LastListenBlockNumber >= at {
+			continue
+		}
+		log.Debug("Genesis data", "addr", addr, "hash", g.Meta.InfoHash, "raw", common.StorageSize(g.Meta.RawSize))
+		m.dl.UpdateTorrent(FlowControlMeta{
+			InfoHash:       g.Meta.InfoHash,
+			BytesRequested: g.Meta.RawSize,
+			IsCreate:       true,
+		})
+	}
+}
+
 func (m *Monitor) taskLoop() {
 	defer m.wg.Done()
 	for {
@@ -320,19 +407,10 @@ func (m *Monitor) buildConnection(clientURI string) (*rpc.Client, error) {
 }
 
 func (m *Monitor) rpcBlockByNumber(blockNumber uint64) (*Block, error) {
-	block := &Block{}
-	blockNumberHex := "0x" + strconv.FormatUint(blockNumber, 16)
-
-	//for i := 0; i < fetchBlockTryTimes; i++ {
-	err := m.cl.Call(block, "ctxc_getBlockByNumber", blockNumberHex, true)
+	block, err := m.chain.GetBlockByNumber(blockNumber)
 	if err == nil {
 		return block, nil
 	}
-
-	//	time.Sleep(time.Second * fetchBlockTryInterval)
-	//	log.Warn("Torrent Fs Internal IPC ctx_getBlockByNumber", "retry", i, "error", err, "number", blockNumber)
-	//}
-
 	return nil, errors.New("[ Internal IPC Error ] try to get block out of times")
 }
 
@@ -381,6 +459,9 @@ func (m *Monitor) udp_tracker_build(ip, port string) string {
 }*/
 
 func (m *Monitor) peers() ([]*p2p.PeerInfo, error) {
+	if m.cl == nil {
+		return nil, errors.New("peers not available in-process")
+	}
 	var peers []*p2p.PeerInfo // = make([]*p2p.PeerInfo, 0, 25)
 	err := m.cl.Call(&peers, "admin_peers")
 	if err == nil && len(peers) > 0 {
@@ -489,26 +570,26 @@ func (m *Monitor) getBlockNumber() (hexutil.Uint64, error) {
 	return 0, errors.New("[ Internal IPC Error ] try to get block number out of times")
 }*/
 
-func (m *Monitor) getRemainingSize(address string) (uint64, error) {
+func (m *Monitor) getRemainingSize(addr common.Address) (uint64, error) {
+	address := addr.String()
 	if size, suc := m.sizeCache.Get(address); suc && size.(uint64) == 0 {
 		return size.(uint64), nil
 	}
-	var remainingSize hexutil.Uint64
-	if err := m.cl.Call(&remainingSize, "ctxc_getUpload", address, "latest"); err != nil {
+	remain, err := m.chain.GetUpload(addr)
+	if err != nil {
 		return 0, err
 	}
-	remain := uint64(remainingSize)
 	if remain == 0 {
 		m.sizeCache.Add(address, remain)
 	}
 	return remain, nil
 }
 
-func (m *Monitor) parseFileMeta(tx *Transaction, meta *FileMeta) error {
+func (m *Monitor) parseFileMeta(tx *Transaction, meta *FileMeta, number uint64) error {
 	log.Debug("Monitor", "FileMeta", meta)
 
-	var receipt TxReceipt
-	if err := m.cl.Call(&receipt, "ctxc_getTransactionReceipt", tx.Hash.String()); err != nil {
+	receipt, err := m.chain.GetReceipt(*tx.Hash)
+	if err != nil {
 		return err
 	}
 
@@ -537,6 +618,7 @@ func (m *Monitor) parseFileMeta(tx *Transaction, meta *FileMeta) error {
 				InfoHash:       meta.InfoHash,
 				BytesRequested: 0,
 				IsCreate:       true,
+				BlockNumber:    number,
 			})
 		}
 	}
@@ -580,6 +662,77 @@ func (m *Monitor) parseFileMeta(tx *Transaction, meta *FileMeta) error {
 	return nil
 }
 
+// parseDeprecation schedules the file of a successfully deprecated model to
+// stop being seeded once the grace period after the deprecation has passed.
+// The receipt status doesn't tell a deprecation from a payload ignored by the
+// model, before the fork or from another account than its author, so only the
+// deprecation log emitted by the CVM is trusted.
+func (m *Monitor) parseDeprecation(tx *Transaction, number uint64) error {
+	file := m.fs.GetFileByAddr(*tx.Recipient)
+	if file == nil {
+		return nil
+	}
+
+	receipt, err := m.chain.GetReceipt(*tx.Hash)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != 1 {
+		return nil
+	}
+	var effective *big.Int
+	for _, l := range receipt.Logs {
+		if l.Address == *tx.Recipient && len(l.Topics) > 0 && l.Topics[0] == types.DeprecationTopic {
+			effective = new(big.Int).SetBytes(l.Data)
+		}
+	}
+	if effective == nil || !effective.IsUint64() {
+		log.Debug("Deprecation ignored by the model", "addr", tx.Recipient, "tx", tx.Hash, "number", number)
+		return nil
+	}
+
+	drop := effective.Uint64() + params.DeprecationGraceBlks
+	if at, ok := m.fs.DeprecatedAt(file.Meta.InfoHash); ok && drop >= at {
+		return nil
+	}
+	if err := m.fs.WriteDeprecation(file.Meta.InfoHash, drop, number); err != nil {
+		return err
+	}
+	m.deprecated[file.Meta.InfoHash] = drop
+	log.Info("Data deprecated", "hash", file.Meta.InfoHash, "addr", tx.Recipient, "effective", effective, "drop", drop, "number", number)
+	return nil
+}
+
+// loadDeprecations schedules again the deprecated files whose grace period
+// was not over when the storage was closed.
+func (m *Monitor) loadDeprecations() error {
+	deprecations, err := m.fs.Deprecations()
+	if err != nil {
+		return err
+	}
+	for ih, at := range deprecations {
+		if at > m.fs.LastListenBlockNumber {
+			m.deprecated[ih] = at
+		}
+	}
+	return nil
+}
+
+// dropDeprecated stops seeding the deprecated files whose grace period is over.
+func (m *Monitor) dropDeprecated(number uint64) {
+	for ih, at := range m.deprecated {
+		if number < at {
+			continue
+		}
+		log.Info("Deprecated data dropped", "hash", ih, "number", number)
+		m.dl.UpdateTorrent(FlowControlMeta{
+			InfoHash: ih,
+			IsDrop:   true,
+		})
+		delete(m.deprecated, ih)
+	}
+}
+
 func (m *Monitor) parseBlockTorrentInfo(b *Block) (bool, error) {
 	record := false
 	if len(b.Txs) > 0 {
@@ -587,11 +740,17 @@ func (m *Monitor) parseBlockTorrentInfo(b *Block) (bool, error) {
 		for _, tx := range b.Txs {
 			if meta := tx.Parse(); meta != nil {
 				log.Debug("Data encounter", "hash", meta.InfoHash, "number", b.Number)
-				if err := m.parseFileMeta(&tx, meta); err != nil {
+				if err := m.parseFileMeta(&tx, meta, b.Number); err != nil {
 					log.Error("Parse file meta error", "err", err, "number", b.Number)
 					return false, err
 				}
 				record = true
+			} else if tx.ParseDeprecation() != nil {
+				if err := m.parseDeprecation(&tx, b.Number); err != nil {
+					log.Error("Parse deprecation error", "err", err, "number", b.Number)
+					return false, err
+				}
+				record = true
 			} else if tx.IsFlowControl() {
 				if tx.Recipient == nil {
 					continue
@@ -603,7 +762,7 @@ func (m *Monitor) parseBlockTorrentInfo(b *Block) (bool, error) {
 					continue
 				}
 
-				remainingSize, err := m.getRemainingSize(addr.String())
+				remainingSize, err := m.getRemainingSize(addr)
 				if err != nil {
 					return false, err
 				}
@@ -624,6 +783,7 @@ func (m *Monitor) parseBlockTorrentInfo(b *Block) (bool, error) {
 							InfoHash:       file.Meta.InfoHash,
 							BytesRequested: bytesRequested,
 							IsCreate:       false,
+							BlockNumber:    b.Number,
 						})
 					}
 				} else {
@@ -726,24 +886,30 @@ func (m *Monitor) startWork() error {
 	// Wait for ipc start...
 	//time.Sleep(time.Second)
 	//defer TorrentAPIAvailable.Unlock()
-	// Rpc Client
-	var clientURI string
-	if runtime.GOOS != "windows" && m.config.IpcPath != "" {
-		clientURI = m.config.IpcPath
+	if m.backend != nil {
+		log.Info("Fs monitor following the chain in-process")
+		m.chain = m.backend
 	} else {
-		if m.config.RpcURI == "" {
-			log.Warn("Fs rpc uri is empty")
-			return errors.New("fs RpcURI is empty")
+		// Rpc Client
+		var clientURI string
+		if runtime.GOOS != "windows" && m.config.IpcPath != "" {
+			clientURI = m.config.IpcPath
+		} else {
+			if m.config.RpcURI == "" {
+				log.Warn("Fs rpc uri is empty")
+				return errors.New("fs RpcURI is empty")
+			}
+			clientURI = m.config.RpcURI
 		}
-		clientURI = m.config.RpcURI
-	}
 
-	rpcClient, rpcErr := m.buildConnection(clientURI)
-	if rpcErr != nil {
-		log.Error("Fs rpc client is wrong", "uri", clientURI, "error", rpcErr, "config", m.config)
-		return rpcErr
+		rpcClient, rpcErr := m.buildConnection(clientURI)
+		if rpcErr != nil {
+			log.Error("Fs rpc client is wrong", "uri", clientURI, "error", rpcErr, "config", m.config)
+			return rpcErr
+		}
+		m.cl = rpcClient
+		m.chain = &rpcBackend{cl: rpcClient}
 	}
-	m.cl = rpcClient
 	m.lastNumber = m.fs.LastListenBlockNumber
 	//if err := m.validateStorage(); err != nil {
 	//	log.Error("Starting torrent fs ... ...", "error", err)
@@ -758,6 +924,10 @@ func (m *Monitor) startWork() error {
 	go m.taskLoop()
 	m.wg.Add(1)
 	go m.listenLatestBlock()
+	if pool, ok := m.backend.(TxPoolBackend); ok {
+		m.wg.Add(1)
+		go m.listenTxPool(pool)
+	}
 	m.init()
 	//m.wg.Add(1)
 	//go m.listenPeers()
@@ -850,9 +1020,32 @@ func (m *Monitor) listenLatestBlock() {
 	defer m.wg.Done()
 	timer := time.NewTimer(time.Second * queryTimeInterval)
 	defer timer.Stop()
+
+	// In-process, new heads wake the listener up instead of waiting for the timer.
+	var (
+		headCh  chan ChainHeadEvent
+		headErr <-chan error
+	)
+	if m.backend != nil {
+		headCh = make(chan ChainHeadEvent, 16)
+		sub := m.backend.SubscribeChainHeadEvent(headCh)
+		defer sub.Unsubscribe()
+		headErr = sub.Err()
+	}
 	progress := uint64(0)
 	for {
 		select {
+		case <-headCh:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(0)
+		case err := <-headErr:
+			log.Warn("Chain head subscription failed", "err", err)
+			headCh, headErr = nil, nil
 		case <-timer.C:
 			progress = m.syncLastBlock()
 			// Aviod sync in full mode, fresh interval may be less.
@@ -872,6 +1065,35 @@ func (m *Monitor) listenLatestBlock() {
 	}
 }
 
+// listenTxPool prioritizes the downloads of the files the pending
+// transactions are sent to or pass as arguments. The files a contract finds
+// by other means are only fetched once the block calling it is processed, see
+// InferTargets.
+func (m *Monitor) listenTxPool(pool TxPoolBackend) {
+	defer m.wg.Done()
+	txsCh := make(chan NewTxsEvent, 64)
+	sub := pool.SubscribeNewTxsEvent(txsCh)
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case ev := <-txsCh:
+			for _, tx := range ev.Txs {
+				for _, addr := range tx.InferTargets() {
+					if file := m.fs.GetFileByAddr(addr); file != nil {
+						log.Debug("Pending transaction data", "hash", file.Meta.InfoHash, "addr", addr)
+						m.dl.PrioritizeTorrent(file.Meta.InfoHash, priorityTxPool)
+					}
+				}
+			}
+		case err := <-sub.Err():
+			log.Warn("Tx pool subscription failed", "err", err)
+			return
+		case <-m.exitCh:
+			return
+		}
+	}
+}
+
 func (m *Monitor) listenPeers() {
 	defer m.wg.Done()
 	m.default_tracker_check()
@@ -994,12 +1216,19 @@ const (
 )
 
 func (m *Monitor) syncLastBlock() uint64 {
-	var currentNumber hexutil.Uint64
+	select {
+	case number := <-m.rewindCh:
+		log.Warn("Fs sync rewind", "number", number, "last", m.lastNumber)
+		m.lastNumber = number
+	default:
+	}
 
-	if err := m.cl.Call(&currentNumber, "ctxc_blockNumber"); err != nil {
+	number, err := m.chain.CurrentNumber()
+	if err != nil {
 		log.Error("Call ipc method ctx_blockNumber failed", "error", err)
 		return 0
 	}
+	currentNumber := hexutil.Uint64(number)
 
 	//if uint64(currentNumber) <= 0 {
 	//	return 0
@@ -1075,6 +1304,19 @@ func (m *Monitor) syncLastBlock() uint64 {
 
 func (m *Monitor) deal(block *Block) error {
 	i := block.Number
+	if m.rewinding {
+		if i != m.rewindTo+1 {
+			// Queued before the rewind, it will be scanned again
+			return nil
+		}
+		m.rewinding = false
+	}
+	if hash, ok := m.knownHash(i); ok && hash != block.Hash {
+		return m.rewind(i)
+	}
+	if hash, ok := m.knownHash(i - 1); i > 0 && ok && hash != block.ParentHash {
+		return m.rewind(i - 1)
+	}
 	if hash, suc := m.blockCache.Get(i); !suc || hash != block.Hash.Hex() {
 		if record, parseErr := m.parseBlockTorrentInfo(block); parseErr != nil {
 			log.Error("Parse new block", "number", block.Number, "block", block, "error", parseErr)
@@ -1086,7 +1328,7 @@ func (m *Monitor) deal(block *Block) error {
 			}
 			elapsed := time.Duration(mclock.Now()) - time.Duration(m.start)
 
-			if i == m.ckp.TfsCheckPoint && m.fs.Root() == m.ckp.TfsRoot {
+			if m.ckp != nil && i == m.ckp.TfsCheckPoint && m.fs.Root() == m.ckp.TfsRoot {
 				log.Info("Fs checkpoint goal ❄️ ", "number", i, "root", m.fs.Root(), "elapsed", elapsed)
 			} else {
 				log.Debug("Fs root version commit", "number", i, "root", m.fs.Root(), "elapsed", elapsed)
@@ -1105,7 +1347,9 @@ func (m *Monitor) deal(block *Block) error {
 		}
 
 		m.blockCache.Add(i, block.Hash.Hex())
+		m.dropDeprecated(i)
 	}
+	m.dl.UpdateHead(i)
 	return nil
 }
 
@@ -1121,3 +1365,160 @@ func (m *Monitor) deal(block *Block) error {
 	}
 	return nil
 }*/
+
+// knownHash returns the hash of the block the storage followed at number, as
+// far as it still remembers it.
+func (m *Monitor) knownHash(number uint64) (common.Hash, bool) {
+	if hash, ok := m.blockCache.Get(number); ok {
+		return common.HexToHash(hash.(string)), true
+	}
+	if block := m.fs.recordedBlock(number); block != nil {
+		return block.Hash, true
+	}
+	return common.Hash{}, false
+}
+
+// prevKnown returns the highest number below number whose hash is known.
+func (m *Monitor) prevKnown(number uint64) uint64 {
+	prev := uint64(0)
+	for _, k := range m.blockCache.Keys() {
+		if n := k.(uint64); n < number && n > prev {
+			prev = n
+		}
+	}
+	if block := m.fs.prevRecordedBlock(number); block != nil && block.Number > prev {
+		prev = block.Number
+	}
+	return prev
+}
+
+// findAncestor walks back from number to the latest followed block that is
+// still part of the canonical chain.
+func (m *Monitor) findAncestor(number uint64) (uint64, error) {
+	for ; number > 0; number = m.prevKnown(number) {
+		hash, ok := m.knownHash(number)
+		if !ok {
+			continue
+		}
+		block, err := m.chain.GetBlockByNumber(number)
+		if err != nil {
+			return 0, err
+		}
+		if block.Hash == hash {
+			return number, nil
+		}
+	}
+	return 0, nil
+}
+
+// rewind handles a reorg detected at number: the storage is rolled back to
+// the common ancestor, the files of the dropped blocks are undone and the
+// scanner restarts from the ancestor to apply the new branch.
+func (m *Monitor) rewind(number uint64) error {
+	ancestor, err := m.findAncestor(number)
+	if err != nil {
+		return err
+	}
+	log.Warn("Fs chain reorg detected", "number", number, "ancestor", ancestor)
+
+	stale, err := m.fs.Revert(ancestor)
+	if err != nil {
+		return err
+	}
+	for _, k := range m.blockCache.Keys() {
+		if k.(uint64) > ancestor {
+			m.blockCache.Remove(k)
+		}
+	}
+	if err := m.revertBlocks(stale); err != nil {
+		return err
+	}
+
+	m.rewinding, m.rewindTo = true, ancestor
+	select {
+	case <-m.rewindCh:
+	default:
+	}
+	m.rewindCh <- ancestor
+	return nil
+}
+
+// revertBlocks undoes the file changes of the given record blocks, which are
+// no longer part of the chain.
+func (m *Monitor) revertBlocks(blocks []*Block) error {
+	created := make(map[common.Hash]struct{})
+	for _, b := range blocks {
+		for _, tx := range b.Txs {
+			if tx.Hash != nil && tx.Parse() != nil {
+				created[*tx.Hash] = struct{}{}
+			}
+		}
+	}
+	removed, err := m.fs.RemoveFilesByTx(created)
+	if err != nil {
+		return err
+	}
+	for _, file := range removed {
+		m.sizeCache.Remove(file.ContractAddr.String())
+		if m.fs.GetFileByHash(file.Meta.InfoHash) != nil {
+			continue
+		}
+		log.Info("Data reverted", "hash", file.Meta.InfoHash, "addr", file.ContractAddr)
+		delete(m.deprecated, file.Meta.InfoHash)
+		if err := m.fs.RemoveDeprecation(file.Meta.InfoHash); err != nil {
+			return err
+		}
+		m.dl.UpdateTorrent(FlowControlMeta{
+			InfoHash: file.Meta.InfoHash,
+			IsDrop:   true,
+		})
+	}
+
+	for _, b := range blocks {
+		for _, tx := range b.Txs {
+			if tx.Recipient == nil {
+				continue
+			}
+			file := m.fs.GetFileByAddr(*tx.Recipient)
+			if file == nil {
+				continue
+			}
+			if tx.ParseDeprecation() != nil {
+				// Back to the schedule of the remaining blocks, scheduled
+				// again if the new branch carries the deprecation too
+				at, ok, err := m.fs.RevertDeprecation(file.Meta.InfoHash, blocks[0].Number)
+				if err != nil {
+					return err
+				}
+				if ok && at > m.fs.LastListenBlockNumber {
+					m.deprecated[file.Meta.InfoHash] = at
+				} else {
+					delete(m.deprecated, file.Meta.InfoHash)
+				}
+				continue
+			}
+			if !tx.IsFlowControl() {
+				continue
+			}
+			m.sizeCache.Remove(tx.Recipient.String())
+			remainingSize, err := m.getRemainingSize(*tx.Recipient)
+			if err != nil {
+				return err
+			}
+			if remainingSize <= file.LeftSize || remainingSize > file.Meta.RawSize {
+				continue
+			}
+			file.LeftSize = remainingSize
+			if err := m.fs.ResetFile(file); err != nil {
+				return err
+			}
+			log.Info("Data upload reverted", "hash", file.Meta.InfoHash, "addr", tx.Recipient, "remain", common.StorageSize(remainingSize), "number", b.Number)
+			m.dl.UpdateTorrent(FlowControlMeta{
+				InfoHash:       file.Meta.InfoHash,
+				BytesRequested: file.Meta.RawSize - file.LeftSize,
+				IsReset:        true,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,600 @@
+package torrentfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/anacrolix/torrent/metainfo"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const testUploadChunk = 400
+
+// testChain is a chainReader over a synthetic chain whose canonical branch
+// can be swapped to simulate a reorg.
+type testChain struct {
+	blocks    []*Block
+	receipts  map[common.Hash]*TxReceipt
+	rawSizes  map[common.Address]uint64
+	creations map[common.Hash]common.Address
+	logs      map[common.Hash][]*types.Log
+	genesis   []GenesisMeta
+}
+
+func newTestChain() *testChain {
+	return &testChain{
+		receipts:  make(map[common.Hash]*TxReceipt),
+		rawSizes:  make(map[common.Address]uint64),
+		creations: make(map[common.Hash]common.Address),
+		logs:      make(map[common.Hash][]*types.Log),
+	}
+}
+
+// extend appends blocks up to number on top of the canonical block parent,
+// dropping the blocks above it. txs gives the transactions of some of them.
+func (c *testChain) extend(parent, number uint64, branch byte, txs map[uint64][]Transaction) {
+	c.blocks = c.blocks[:parent+1]
+	for n := parent + 1; n <= number; n++ {
+		block := &Block{
+			Number:     n,
+			Hash:       crypto.Keccak256Hash([]byte{branch}, new(big.Int).SetUint64(n).Bytes()),
+			ParentHash: c.blocks[n-1].Hash,
+			Txs:        txs[n],
+		}
+		for _, tx := range block.Txs {
+			receipt := &TxReceipt{TxHash: tx.Hash, Status: 1, Logs: c.logs[*tx.Hash]}
+			if addr, ok := c.creations[*tx.Hash]; ok {
+				receipt.ContractAddr = &addr
+			}
+			c.receipts[*tx.Hash] = receipt
+		}
+		c.blocks = append(c.blocks, block)
+	}
+}
+
+// createTx publishes a model of the given size at addr.
+func (c *testChain) createTx(t *testing.T, addr common.Address, size uint64) Transaction {
+	return c.createModelTx(t, addr, common.BytesToAddress(crypto.Keccak256(addr.Bytes())), size)
+}
+
+// createModelTx publishes a model of data hash ih and the given size at addr.
+func (c *testChain) createModelTx(t *testing.T, addr common.Address, ih common.Address, size uint64) Transaction {
+	meta := &types.ModelMeta{
+		Hash:    ih,
+		RawSize: size,
+	}
+	data, err := rlp.EncodeToBytes(meta)
+	if err != nil {
+		t.Fatalf("failed to encode model meta: %v", err)
+	}
+	hash := crypto.Keccak256Hash([]byte("create"), addr.Bytes())
+	c.creations[hash] = addr
+	c.rawSizes[addr] = size
+	return Transaction{
+		Price:    new(big.Int),
+		Amount:   new(big.Int),
+		GasLimit: params.UploadGas,
+		Payload:  append([]byte{0, opCreateModel}, data...),
+		From:     &common.Address{},
+		Hash:     &hash,
+	}
+}
+
+// uploadTx uploads testUploadChunk bytes of the file at addr.
+func (c *testChain) uploadTx(addr common.Address, nonce int) Transaction {
+	hash := crypto.Keccak256Hash([]byte(fmt.Sprintf("upload-%d", nonce)), addr.Bytes())
+	return Transaction{
+		Price:     new(big.Int),
+		Amount:    new(big.Int),
+		GasLimit:  params.UploadGas,
+		From:      &common.Address{},
+		Recipient: &addr,
+		Hash:      &hash,
+	}
+}
+
+func (c *testChain) CurrentNumber() (uint64, error) {
+	return uint64(len(c.blocks) - 1), nil
+}
+
+func (c *testChain) GetBlockByNumber(number uint64) (*Block, error) {
+	if number >= uint64(len(c.blocks)) {
+		return nil, errors.New("block not found")
+	}
+	return c.blocks[number], nil
+}
+
+func (c *testChain) GetReceipt(txHash common.Hash) (*TxReceipt, error) {
+	receipt, ok := c.receipts[txHash]
+	if !ok {
+		return nil, errors.New("receipt not found")
+	}
+	return receipt, nil
+}
+
+// GetUpload returns the size left to upload at the head of the canonical branch.
+func (c *testChain) GetUpload(addr common.Address) (uint64, error) {
+	left := c.rawSizes[addr]
+	for _, block := range c.blocks {
+		for _, tx := range block.Txs {
+			if tx.Recipient != nil && *tx.Recipient == addr && tx.IsFlowControl() && left >= testUploadChunk {
+				left -= testUploadChunk
+			}
+		}
+	}
+	return left, nil
+}
+
+func (c *testChain) GenesisMetas() ([]GenesisMeta, error) {
+	return c.genesis, nil
+}
+
+// testManager records the updates the Monitor sends to the download manager.
+type testManager struct {
+	updates []FlowControlMeta
+}
+
+func (tm *testManager) Start() error                                        { return nil }
+func (tm *testManager) Close() error                                        { return nil }
+func (tm *testManager) UpdateDynamicTrackers(trackers []string)             {}
+func (tm *testManager) GetTorrent(ih metainfo.Hash) *Torrent                { return nil }
+func (tm *testManager) Torrents() []*Torrent                                { return nil }
+func (tm *testManager) Status(t *Torrent) *TorrentStatus                    { return &TorrentStatus{} }
+func (tm *testManager) Available(t *Torrent) bool                           { return false }
+func (tm *testManager) Quarantined(t *Torrent) bool                         { return false }
+func (tm *testManager) PauseTorrent(ih metainfo.Hash) error                 { return nil }
+func (tm *testManager) ResumeTorrent(ih metainfo.Hash) error                { return nil }
+func (tm *testManager) VerifyTorrent(ih metainfo.Hash) error                { return nil }
+func (tm *testManager) SeedTorrent(ih metainfo.Hash) error                  { return nil }
+func (tm *testManager) ScrubTorrent(ih metainfo.Hash) error                 { return nil }
+func (tm *testManager) AccessTorrent(ih metainfo.Hash) bool                 { return false }
+func (tm *testManager) UpdateHead(number uint64)                            {}
+func (tm *testManager) PrioritizeTorrent(ih metainfo.Hash, level int) error { return nil }
+func (tm *testManager) DiskUsage() (int64, int64, int)                      { return 0, 0, 0 }
+func (tm *testManager) Protocols() []p2p.Protocol                           { return nil }
+
+func (tm *testManager) FetchTorrent(ih metainfo.Hash, level int, timeout time.Duration) error {
+	return nil
+}
+
+func (tm *testManager) Shaping() Shaping           { return Shaping{} }
+func (tm *testManager) SetShaping(s Shaping) error { return nil }
+func (tm *testManager) Blocklist() *Blocklist      { return nil }
+
+func (tm *testManager) OpenFile(ih metainfo.Hash, name string) (*io.SectionReader, os.FileInfo, error) {
+	return nil, nil, errTorrentNotFound
+}
+
+func (tm *testManager) ReadFile(ih metainfo.Hash, name string) ([]byte, error) {
+	return nil, errTorrentNotFound
+}
+
+func (tm *testManager) StorageKey() string                { return "" }
+func (tm *testManager) RotateStorageKey() (string, error) { return "", errNoStorageKey }
+
+func (tm *testManager) UpdateTorrent(meta interface{}) error {
+	tm.updates = append(tm.updates, meta.(FlowControlMeta))
+	return nil
+}
+
+func newTestMonitor(t *testing.T, dir string, chain chainReader) (*Monitor, *testManager) {
+	fs, err := NewFileStorage(&Config{DataDir: dir})
+	if err != nil {
+		t.Fatalf("failed to create file storage: %v", err)
+	}
+	dl := new(testManager)
+	m := &Monitor{
+		config:     &Config{DataDir: dir},
+		fs:         fs,
+		dl:         dl,
+		chain:      chain,
+		exitCh:     make(chan struct{}),
+		taskCh:     make(chan *Block, batch),
+		deprecated: make(map[metainfo.Hash]uint64),
+		rewindCh:   make(chan uint64, 1),
+	}
+	m.blockCache, _ = lru.New(delay)
+	m.sizeCache, _ = lru.New(batch)
+	if err := m.loadDeprecations(); err != nil {
+		t.Fatalf("failed to load deprecations: %v", err)
+	}
+	return m, dl
+}
+
+// syncMonitor scans the chain and deals the blocks until the Monitor caught up.
+func syncMonitor(t *testing.T, m *Monitor) {
+	for {
+		m.syncLastBlock()
+		if len(m.taskCh) == 0 && len(m.rewindCh) == 0 {
+			return
+		}
+		for len(m.taskCh) > 0 {
+			if err := m.deal(<-m.taskCh); err != nil {
+				t.Fatalf("failed to deal block: %v", err)
+			}
+		}
+	}
+}
+
+func TestMonitorReorg(t *testing.T) {
+	var (
+		x = common.HexToAddress("0x01")
+		y = common.HexToAddress("0x02")
+		z = common.HexToAddress("0x03")
+	)
+	chain := newTestChain()
+	chain.blocks = []*Block{{Hash: crypto.Keccak256Hash([]byte("genesis"))}}
+	createX, createY, createZ := chain.createTx(t, x, 4000), chain.createTx(t, y, 2000), chain.createTx(t, z, 1000)
+
+	// Both branches share the upload at #10, the upload at #24 and the file
+	// at #22 are dropped by the reorg at #21 and the file at #23 replaces them.
+	chain.extend(0, 40, 'a', map[uint64][]Transaction{
+		5:  {createX},
+		10: {chain.uploadTx(x, 0)},
+		22: {createY},
+		24: {chain.uploadTx(x, 1)},
+	})
+	dir, err := ioutil.TempDir("", "torrentfs-reorg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, dl := newTestMonitor(t, dir, chain)
+	syncMonitor(t, m)
+
+	if file := m.fs.GetFileByAddr(x); file == nil || file.LeftSize != 4000-2*testUploadChunk {
+		t.Fatalf("file x before reorg: have %v, want left size %d", file, 4000-2*testUploadChunk)
+	}
+	fileY := m.fs.GetFileByAddr(y)
+	if fileY == nil {
+		t.Fatal("file y missing before reorg")
+	}
+	before := len(dl.updates)
+
+	chain.extend(20, 45, 'b', map[uint64][]Transaction{
+		23: {createZ},
+	})
+	syncMonitor(t, m)
+
+	if m.fs.GetFileByAddr(y) != nil {
+		t.Error("file y created on the dropped branch still stored")
+	}
+	if m.fs.GetFileByAddr(z) == nil {
+		t.Error("file z created on the new branch not stored")
+	}
+	if file := m.fs.GetFileByAddr(x); file == nil || file.LeftSize != 4000-testUploadChunk {
+		t.Errorf("file x after reorg: have %v, want left size %d", file, 4000-testUploadChunk)
+	}
+	for _, b := range m.fs.Blocks() {
+		if canon := chain.blocks[b.Number]; canon.Hash != b.Hash {
+			t.Errorf("stale block #%d still recorded", b.Number)
+		}
+	}
+	if have, want := m.fs.LastListenBlockNumber, uint64(23); have != want {
+		t.Errorf("last listen block mismatch: have %d, want %d", have, want)
+	}
+
+	var dropped, reset bool
+	for _, meta := range dl.updates[before:] {
+		switch {
+		case meta.IsDrop && meta.InfoHash == fileY.Meta.InfoHash:
+			dropped = true
+		case meta.IsDrop:
+			t.Errorf("unexpected drop of %x", meta.InfoHash)
+		case meta.IsReset:
+			if meta.BytesRequested != testUploadChunk {
+				t.Errorf("reset quota mismatch: have %d, want %d", meta.BytesRequested, testUploadChunk)
+			}
+			reset = true
+		}
+	}
+	if !dropped {
+		t.Error("torrent of file y not dropped")
+	}
+	if !reset {
+		t.Error("quota of file x not reset")
+	}
+
+	// The storage must end up as if it had only ever seen the new branch
+	fresh, err := ioutil.TempDir("", "torrentfs-fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fresh)
+	ref, _ := newTestMonitor(t, fresh, chain)
+	syncMonitor(t, ref)
+	defer ref.fs.Close()
+
+	if have, want := m.fs.Root(), ref.fs.Root(); have != want {
+		t.Errorf("root mismatch: have %x, want %x", have, want)
+	}
+	if have, want := m.fs.CheckPoint, ref.fs.CheckPoint; have != want {
+		t.Errorf("checkpoint mismatch: have %d, want %d", have, want)
+	}
+
+	// And so must the reloaded database
+	root := m.fs.Root()
+	m.fs.Close()
+	fs, err := NewFileStorage(&Config{DataDir: dir})
+	if err != nil {
+		t.Fatalf("failed to reopen file storage: %v", err)
+	}
+	defer fs.Close()
+	if fs.Root() != root {
+		t.Errorf("reloaded root mismatch: have %x, want %x", fs.Root(), root)
+	}
+	if fs.GetFileByAddr(y) != nil {
+		t.Error("file y reloaded")
+	}
+	if file := fs.GetFileByAddr(x); file == nil || file.LeftSize != 4000-testUploadChunk {
+		t.Errorf("reloaded file x: have %v, want left size %d", file, 4000-testUploadChunk)
+	}
+	if len(fs.Blocks()) != len(ref.fs.Blocks()) {
+		t.Errorf("reloaded blocks mismatch: have %d, want %d", len(fs.Blocks()), len(ref.fs.Blocks()))
+	}
+}
+
+// Tests that a block reusing a recorded height with another hash, e.g. after a
+// restart, rolls the storage back as well.
+func TestMonitorReorgRecordedBlock(t *testing.T) {
+	x := common.HexToAddress("0x01")
+	chain := newTestChain()
+	chain.blocks = []*Block{{Hash: crypto.Keccak256Hash([]byte("genesis"))}}
+	createX := chain.createTx(t, x, 1000)
+	chain.extend(0, 30, 'a', map[uint64][]Transaction{15: {createX}})
+
+	dir, err := ioutil.TempDir("", "torrentfs-reorg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, _ := newTestMonitor(t, dir, chain)
+	syncMonitor(t, m)
+	m.fs.Close()
+
+	// Restart on a chain where the file moved to another block
+	chain.extend(10, 40, 'b', map[uint64][]Transaction{12: {createX}})
+	m, _ = newTestMonitor(t, dir, chain)
+	defer m.fs.Close()
+	m.lastNumber = 14
+	syncMonitor(t, m)
+
+	blocks := m.fs.Blocks()
+	if len(blocks) != 1 || blocks[0].Number != 12 || !bytes.Equal(blocks[0].Hash.Bytes(), chain.blocks[12].Hash.Bytes()) {
+		t.Fatalf("recorded blocks mismatch: have %v", blocks)
+	}
+	if m.fs.GetFileByAddr(x) == nil {
+		t.Error("file x lost")
+	}
+}
+
+// deprecateTx deprecates the model at addr from the given block on.
+func (c *testChain) deprecateTx(t *testing.T, addr common.Address, effective int64) Transaction {
+	tx := c.ignoredDeprecateTx(t, addr, effective)
+	c.logs[*tx.Hash] = []*types.Log{{
+		Address: addr,
+		Topics:  []common.Hash{types.DeprecationTopic, {}},
+		Data:    common.BigToHash(big.NewInt(effective)).Bytes(),
+	}}
+	return tx
+}
+
+// ignoredDeprecateTx sends a deprecation of the model at addr which succeeds
+// without deprecating it, as sent before the fork or by another account than
+// the author.
+func (c *testChain) ignoredDeprecateTx(t *testing.T, addr common.Address, effective int64) Transaction {
+	payload, err := types.Deprecation{Effective: *big.NewInt(effective)}.ToBytes()
+	if err != nil {
+		t.Fatalf("failed to encode deprecation: %v", err)
+	}
+	hash := crypto.Keccak256Hash(payload, addr.Bytes())
+	return Transaction{
+		Price:     new(big.Int),
+		Amount:    new(big.Int),
+		GasLimit:  params.UploadGas,
+		Payload:   payload,
+		From:      &common.Address{},
+		Recipient: &addr,
+		Hash:      &hash,
+	}
+}
+
+// Tests that reverting a deprecation restores the schedule of the remaining
+// blocks, both in the Monitor and in the reloaded storage.
+func TestMonitorReorgDeprecation(t *testing.T) {
+	x, y := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	chain := newTestChain()
+	chain.blocks = []*Block{{Hash: crypto.Keccak256Hash([]byte("genesis"))}}
+
+	// Both branches share the deprecation of x at #10, the one bringing it
+	// forward at #22 and the one of y at #24 are dropped by the reorg at #21.
+	chain.extend(0, 40, 'a', map[uint64][]Transaction{
+		5:  {chain.createTx(t, x, 1000), chain.createTx(t, y, 1000)},
+		10: {chain.deprecateTx(t, x, 100)},
+		22: {chain.deprecateTx(t, x, 30)},
+		24: {chain.deprecateTx(t, y, 50)},
+	})
+	dir, err := ioutil.TempDir("", "torrentfs-reorg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, _ := newTestMonitor(t, dir, chain)
+	syncMonitor(t, m)
+
+	ihX, ihY := m.fs.GetFileByAddr(x).Meta.InfoHash, m.fs.GetFileByAddr(y).Meta.InfoHash
+	if at := m.deprecated[ihX]; at != 30+params.DeprecationGraceBlks {
+		t.Fatalf("deprecation of x before reorg: have %d, want %d", at, 30+params.DeprecationGraceBlks)
+	}
+	if at := m.deprecated[ihY]; at != 50+params.DeprecationGraceBlks {
+		t.Fatalf("deprecation of y before reorg: have %d, want %d", at, 50+params.DeprecationGraceBlks)
+	}
+
+	chain.extend(20, 45, 'b', nil)
+	syncMonitor(t, m)
+
+	drop := uint64(100 + params.DeprecationGraceBlks)
+	if at := m.deprecated[ihX]; at != drop {
+		t.Errorf("deprecation of x after reorg: have %d, want %d", at, drop)
+	}
+	if at, ok := m.fs.DeprecatedAt(ihX); !ok || at != drop {
+		t.Errorf("stored deprecation of x after reorg: have %d (%v), want %d", at, ok, drop)
+	}
+	if at, ok := m.deprecated[ihY]; ok {
+		t.Errorf("deprecation of y still scheduled at %d", at)
+	}
+	if at, ok := m.fs.DeprecatedAt(ihY); ok {
+		t.Errorf("deprecation of y still stored at %d", at)
+	}
+
+	m.fs.Close()
+	m, _ = newTestMonitor(t, dir, chain)
+	defer m.fs.Close()
+	if len(m.deprecated) != 1 || m.deprecated[ihX] != drop {
+		t.Errorf("reloaded deprecations mismatch: have %v, want %x at %d", m.deprecated, ihX, drop)
+	}
+}
+
+// Tests that a deprecation schedule survives restarts, that the file is no
+// longer seeded once its grace period is over, and that deprecations ignored
+// by the model are ignored too.
+func TestMonitorDeprecation(t *testing.T) {
+	x, y := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	chain := newTestChain()
+	chain.blocks = []*Block{{Hash: crypto.Keccak256Hash([]byte("genesis"))}}
+	chain.extend(0, 40, 'a', map[uint64][]Transaction{
+		5:  {chain.createTx(t, x, 1000), chain.createTx(t, y, 1000)},
+		8:  {chain.ignoredDeprecateTx(t, y, 8)},
+		10: {chain.deprecateTx(t, x, 12)},
+		// Postponing doesn't move the schedule
+		15: {chain.deprecateTx(t, x, 100)},
+	})
+	dir, err := ioutil.TempDir("", "torrentfs-deprecation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, _ := newTestMonitor(t, dir, chain)
+	syncMonitor(t, m)
+
+	ih := m.fs.GetFileByAddr(x).Meta.InfoHash
+	drop := uint64(12 + params.DeprecationGraceBlks)
+	if at, ok := m.fs.DeprecatedAt(ih); !ok || at != drop {
+		t.Fatalf("stored deprecation mismatch: have %d (%v), want %d", at, ok, drop)
+	}
+	if at := m.deprecated[ih]; at != drop {
+		t.Fatalf("scheduled deprecation mismatch: have %d, want %d", at, drop)
+	}
+	if at, ok := m.fs.DeprecatedAt(m.fs.GetFileByAddr(y).Meta.InfoHash); ok {
+		t.Fatalf("ignored deprecation stored: drop at %d", at)
+	}
+
+	// Restarted before the grace period is over
+	m.fs.Close()
+	m, dl := newTestMonitor(t, dir, chain)
+	if at := m.deprecated[ih]; at != drop {
+		t.Fatalf("reloaded deprecation mismatch: have %d, want %d", at, drop)
+	}
+	m.dropDeprecated(drop - 1)
+	if len(dl.updates) != 0 {
+		t.Fatalf("dropped before the grace period is over: %v", dl.updates)
+	}
+	m.dropDeprecated(drop)
+	if len(dl.updates) != 1 || !dl.updates[0].IsDrop || dl.updates[0].InfoHash != ih {
+		t.Fatalf("deprecated file not dropped: %v", dl.updates)
+	}
+	if len(m.deprecated) != 0 {
+		t.Errorf("dropped file still scheduled: %v", m.deprecated)
+	}
+
+	// Restarted after it, the file is not seeded again
+	m.fs.LastListenBlockNumber = drop
+	m.fs.Close()
+	m, dl = newTestMonitor(t, dir, chain)
+	defer m.fs.Close()
+	if len(m.deprecated) != 0 {
+		t.Errorf("dropped file scheduled again: %v", m.deprecated)
+	}
+	if err := m.storageInit(); err != nil {
+		t.Fatalf("failed to init storage: %v", err)
+	}
+	for _, meta := range dl.updates {
+		if meta.InfoHash == ih && !meta.IsDrop {
+			t.Errorf("deprecated file seeded again: %v", meta)
+		}
+	}
+}
+
+// Tests that the metas registered in the genesis allocation are indexed and
+// requested in full at startup, but left out of the catalog.
+func TestMonitorGenesisMetas(t *testing.T) {
+	var (
+		model    = common.HexToAddress("0x01")
+		uploaded = common.HexToAddress("0x02")
+		contract = common.HexToAddress("0x03")
+	)
+	meta := &types.ModelMeta{Hash: common.HexToAddress("0xaa"), RawSize: 5000}
+	code, err := meta.ToBytes()
+	if err != nil {
+		t.Fatalf("failed to encode model meta: %v", err)
+	}
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	statedb.SetCode(model, append([]byte{0, 1}, code...))
+	statedb.SetState(model, types.GenesisMetaKey, common.BytesToHash([]byte{1}))
+	statedb.SetCode(uploaded, append([]byte{0, 1}, code...))
+	statedb.SetCode(contract, []byte{0x60, 0x00})
+	statedb.SetState(contract, types.GenesisMetaKey, common.BytesToHash([]byte{1}))
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit genesis state: %v", err)
+	}
+	if err := statedb.Database().TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to write genesis state: %v", err)
+	}
+	statedb, _ = state.New(root, state.NewDatabase(db))
+
+	chain := newTestChain()
+	chain.blocks = []*Block{{Hash: crypto.Keccak256Hash([]byte("genesis"))}}
+	chain.genesis = ParseGenesisDump(statedb.RawDump())
+	if len(chain.genesis) != 1 || chain.genesis[0].Addr != model {
+		t.Fatalf("genesis metas mismatch: have %v, want only %x", chain.genesis, model)
+	}
+
+	dir, err := ioutil.TempDir("", "torrentfs-genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, dl := newTestMonitor(t, dir, chain)
+	defer m.fs.Close()
+	if err := m.storageInit(); err != nil {
+		t.Fatalf("failed to init storage: %v", err)
+	}
+
+	file := m.fs.GetFileByAddr(model)
+	if file == nil || file.LeftSize != 0 || file.Meta.InfoHash != meta.InfoHash() {
+		t.Fatalf("genesis file mismatch: have %v", file)
+	}
+	if len(dl.updates) != 1 || dl.updates[0].InfoHash != meta.InfoHash() || dl.updates[0].BytesRequested != meta.RawSize {
+		t.Fatalf("genesis file not requested in full: %v", dl.updates)
+	}
+	if files := m.fs.Catalog().Files; len(files) != 0 {
+		t.Errorf("genesis file in the catalog: %v", files)
+	}
+}
@@ -0,0 +1,176 @@
+package torrentfs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+)
+
+var (
+	errPeerClosed   = errors.New("peer closed")
+	errPeerTimeout  = errors.New("peer request timed out")
+	errPeerNotReady = errors.New("peer flow control budget exhausted")
+)
+
+const (
+	handshakeTimeout = 5 * time.Second
+	respTimeout      = 30 * time.Second
+)
+
+// fsPeer is a node speaking the ctfs protocol.
+type fsPeer struct {
+	id string
+
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+
+	version int
+
+	fcServer *flowControl // Budget the remote keeps for our requests, mirrored locally
+	fcClient *flowControl // Budget we keep for the requests of the remote
+
+	lock    sync.Mutex
+	pending map[uint64]chan interface{}
+	term    chan struct{}
+	serving sync.WaitGroup // Piece requests of the remote being served
+}
+
+func newFsPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *fsPeer {
+	return &fsPeer{
+		Peer:     p,
+		rw:       rw,
+		version:  version,
+		id:       fmt.Sprintf("%x", p.ID().Bytes()[:8]),
+		fcClient: newFlowControl(bufLimit, minRecharge),
+		pending:  make(map[uint64]chan interface{}),
+		term:     make(chan struct{}),
+	}
+}
+
+// Handshake exchanges the protocol version and the flow control parameters.
+func (p *fsPeer) Handshake() error {
+	errc := make(chan error, 2)
+	var status statusData // safe to read after two values have been received from errc
+
+	go func() {
+		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
+			ProtocolVersion: uint32(p.version),
+			BufLimit:        bufLimit,
+			MinRecharge:     minRecharge,
+		})
+	}()
+	go func() {
+		errc <- p.readStatus(&status)
+	}()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+	p.fcServer = newFlowControl(status.BufLimit, status.MinRecharge)
+	return nil
+}
+
+func (p *fsPeer) readStatus(status *statusData) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	if err := msg.Decode(status); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	if int(status.ProtocolVersion) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
+	}
+	return nil
+}
+
+// request sends a request of the given cost once the flow control budget of
+// the remote allows it, and waits for the matching reply.
+func (p *fsPeer) request(reqID uint64, code uint64, data interface{}, cost uint64) (interface{}, error) {
+	if cost > p.fcServer.limit {
+		return nil, errPeerNotReady
+	}
+	wait := p.fcServer.wait(cost)
+	if wait > respTimeout {
+		return nil, errPeerNotReady
+	}
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-p.term:
+			return nil, errPeerClosed
+		}
+	}
+	if _, ok := p.fcServer.accept(cost); !ok {
+		return nil, errPeerNotReady
+	}
+	ch := make(chan interface{}, 1)
+	p.lock.Lock()
+	p.pending[reqID] = ch
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, reqID)
+		p.lock.Unlock()
+	}()
+
+	if err := p2p.Send(p.rw, code, data); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(respTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-timer.C:
+		return nil, errPeerTimeout
+	case <-p.term:
+		return nil, errPeerClosed
+	}
+}
+
+// deliver hands a reply over to the pending request, it reports whether the
+// request was still waiting.
+func (p *fsPeer) deliver(reqID uint64, bv uint64, resp interface{}) bool {
+	p.fcServer.set(bv)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ch, ok := p.pending[reqID]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- resp:
+	default:
+	}
+	return true
+}
+
+func (p *fsPeer) close() {
+	close(p.term)
+}
+
+// String implements fmt.Stringer.
+func (p *fsPeer) String() string {
+	return fmt.Sprintf("Peer %s [%s]", p.id,
+		fmt.Sprintf("%s/%2d", protocolName, p.version),
+	)
+}
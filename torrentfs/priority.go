@@ -0,0 +1,87 @@
+package torrentfs
+
+import (
+	"sort"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Download priorities, from the data of a pending transaction up to the data
+// missed by the CVM while processing a block.
+const (
+	priorityNone = iota
+	priorityTxPool
+	priorityRPC
+	priorityBlock
+)
+
+const (
+	// priorityTTL is how long a priority lasts unless signalled again.
+	priorityTTL = 600 * time.Second
+	// urgentWaitingTime replaces the waiting times before boosting for the
+	// torrents with a priority.
+	urgentWaitingTime = 60
+)
+
+type priority struct {
+	level   int
+	expires time.Time
+}
+
+// PrioritizeTorrent raises the download priority of ih to level for a while.
+func (tm *TorrentManager) PrioritizeTorrent(ih metainfo.Hash, level int) error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	_, deferred := tm.deferred[ih]
+	if _, ok := tm.torrents[ih]; !ok && !deferred && !tm.isEvicted(ih) {
+		return errTorrentNotFound
+	}
+	p := tm.priorities[ih]
+	if now := time.Now(); level >= p.level || now.After(p.expires) {
+		tm.priorities[ih] = priority{level, now.Add(priorityTTL)}
+	}
+	return nil
+}
+
+// priority returns the current download priority of ih. The caller must hold
+// tm.lock.
+func (tm *TorrentManager) priority(ih metainfo.Hash) int {
+	if p, ok := tm.priorities[ih]; ok && time.Now().Before(p.expires) {
+		return p.level
+	}
+	return priorityNone
+}
+
+// connLimit returns the connections allowed to t. Prioritized torrents get
+// more of them, the others the fewest while prioritized ones are running.
+func (t *Torrent) connLimit() int {
+	switch {
+	case t.priority > priorityNone:
+		return t.shapedLimit(t.maxEstablishedConns * t.priority)
+	case t.fast && !t.throttled:
+		return t.shapedLimit(t.maxEstablishedConns)
+	default:
+		return t.minEstablishedConns
+	}
+}
+
+// schedule runs the torrents by priority. While some have a priority, the
+// others are throttled and those beyond maxActiveTask paused.
+func (tm *TorrentManager) schedule(torrents []*Torrent) (running int, paused int) {
+	sort.SliceStable(torrents, func(i, j int) bool {
+		return torrents[i].priority > torrents[j].priority
+	})
+	urgent := len(torrents) > 0 && torrents[0].priority > priorityNone
+	for i, t := range torrents {
+		t.throttled = urgent && t.priority == priorityNone
+		if t.throttled && i >= tm.maxActiveTask {
+			t.Pause()
+			paused++
+			continue
+		}
+		t.Run(tm.slot)
+		running++
+	}
+	return running, paused
+}
@@ -0,0 +1,253 @@
+package torrentfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// newTestClient returns a torrent client on loopback without any peer source,
+// along with its data dir.
+func newTestClient(t *testing.T) (*torrent.Client, string, func()) {
+	dir, err := ioutil.TempDir("", "torrentfs-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dir
+	cfg.ListenHost = func(string) string { return "127.0.0.1" }
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.DisableUTP = true
+	cfg.DisableIPv6 = true
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return cl, dir, func() {
+		cl.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// addTestTorrent adds a torrent of random data to cl, with its info but
+// none of its data, wrapped with the given priority.
+func addTestTorrent(t *testing.T, cl *torrent.Client, level int) *Torrent {
+	dir, err := ioutil.TempDir("", "torrentfs-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "params"), randomData(t, 4*swarmPieceLength), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: swarmPieceLength}
+	if err := info.BuildFromFilePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+	tt, _, err := cl.AddTorrentSpec(&torrent.TorrentSpec{InfoHash: mi.HashInfoBytes(), InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Torrent{
+		Torrent:             tt,
+		maxEstablishedConns: 10,
+		minEstablishedConns: 1,
+		bytesRequested:      tt.Length(),
+		status:              torrentPending,
+		priority:            level,
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	cl, _, closeClient := newTestClient(t)
+	defer closeClient()
+
+	tm := &TorrentManager{maxActiveTask: 3}
+	var (
+		idle   = []*Torrent{addTestTorrent(t, cl, priorityNone), addTestTorrent(t, cl, priorityNone), addTestTorrent(t, cl, priorityNone)}
+		block  = addTestTorrent(t, cl, priorityBlock)
+		txpool = addTestTorrent(t, cl, priorityTxPool)
+	)
+	torrents := []*Torrent{idle[0], idle[1], block, idle[2], txpool}
+	running, paused := tm.schedule(torrents)
+	if running != 3 || paused != 2 {
+		t.Fatalf("%d running and %d paused, want 3 and 2", running, paused)
+	}
+	// Prioritized first, the others in their order
+	for i, want := range []*Torrent{block, txpool, idle[0], idle[1], idle[2]} {
+		if torrents[i] != want {
+			t.Fatalf("torrent %d has priority %d, want %d", i, torrents[i].priority, want.priority)
+		}
+	}
+	if !block.Running() || block.throttled || block.currentConns != 10*priorityBlock {
+		t.Errorf("block torrent: status %d, throttled %v, %d conns", block.status, block.throttled, block.currentConns)
+	}
+	if !txpool.Running() || txpool.throttled || txpool.currentConns != 10*priorityTxPool {
+		t.Errorf("tx pool torrent: status %d, throttled %v, %d conns", txpool.status, txpool.throttled, txpool.currentConns)
+	}
+	// Within maxActiveTask the idle torrents run throttled, past it they pause
+	if !idle[0].Running() || !idle[0].throttled || idle[0].currentConns != 1 {
+		t.Errorf("idle torrent: status %d, throttled %v, %d conns", idle[0].status, idle[0].throttled, idle[0].currentConns)
+	}
+	for _, tor := range idle[1:] {
+		if !tor.Paused() || !tor.throttled {
+			t.Errorf("idle torrent past maxActiveTask: status %d, throttled %v", tor.status, tor.throttled)
+		}
+	}
+
+	// Once the priorities expire, everything runs again unthrottled
+	for _, tor := range torrents {
+		tor.priority = priorityNone
+		tor.fast = true
+	}
+	if running, paused := tm.schedule(torrents); running != 5 || paused != 0 {
+		t.Fatalf("%d running and %d paused, want 5 and 0", running, paused)
+	}
+	for _, tor := range torrents {
+		if !tor.Running() || tor.throttled || tor.currentConns != 10 {
+			t.Errorf("torrent: status %d, throttled %v, %d conns", tor.status, tor.throttled, tor.currentConns)
+		}
+	}
+}
+
+func TestPrioritizeTorrent(t *testing.T) {
+	ih := metainfo.Hash{1}
+	tm := &TorrentManager{
+		torrents:   map[metainfo.Hash]*Torrent{ih: {}},
+		deferred:   make(map[metainfo.Hash]struct{}),
+		evicted:    make(map[metainfo.Hash]struct{}),
+		priorities: make(map[metainfo.Hash]priority),
+	}
+	if err := tm.PrioritizeTorrent(metainfo.Hash{2}, priorityBlock); err != errTorrentNotFound {
+		t.Fatalf("unknown torrent prioritized: %v", err)
+	}
+	if level := tm.priority(ih); level != priorityNone {
+		t.Fatalf("priority %d before any, want none", level)
+	}
+	if err := tm.PrioritizeTorrent(ih, priorityBlock); err != nil {
+		t.Fatal(err)
+	}
+	// A lower level doesn't override a live higher one
+	tm.PrioritizeTorrent(ih, priorityTxPool)
+	if level := tm.priority(ih); level != priorityBlock {
+		t.Fatalf("priority %d, want %d", level, priorityBlock)
+	}
+	if expires := tm.priorities[ih].expires; time.Until(expires) > priorityTTL || time.Until(expires) < priorityTTL-time.Minute {
+		t.Fatalf("priority expires in %v, want %v", time.Until(expires), priorityTTL)
+	}
+
+	// Expired, it is gone and any level replaces it
+	tm.priorities[ih] = priority{priorityBlock, time.Now().Add(-time.Second)}
+	if level := tm.priority(ih); level != priorityNone {
+		t.Fatalf("expired priority %d, want none", level)
+	}
+	tm.PrioritizeTorrent(ih, priorityTxPool)
+	if level := tm.priority(ih); level != priorityTxPool {
+		t.Fatalf("priority %d after expiry, want %d", level, priorityTxPool)
+	}
+
+	// Deferred and evicted torrents may be prioritized too
+	deferred, evicted := metainfo.Hash{3}, metainfo.Hash{4}
+	tm.deferred[deferred] = struct{}{}
+	tm.evicted[evicted] = struct{}{}
+	for _, ih := range []metainfo.Hash{deferred, evicted} {
+		if err := tm.PrioritizeTorrent(ih, priorityRPC); err != nil || tm.priority(ih) != priorityRPC {
+			t.Errorf("torrent %x not prioritized: %v", ih, err)
+		}
+	}
+}
+
+func TestInferTargets(t *testing.T) {
+	word := func(b ...byte) []byte {
+		w := make([]byte, common.HashLength)
+		copy(w[common.HashLength-len(b):], b)
+		return w
+	}
+	model, input := common.Address{0xaa, 1}, common.Address{0xbb, 2}
+	contract := common.Address{0xcc}
+
+	payload := []byte{0x12, 0x34, 0x56, 0x78} // selector
+	payload = append(payload, word(model.Bytes()...)...)
+	payload = append(payload, word(input.Bytes()...)...)
+	payload = append(payload, word()...)                                 // zero
+	payload = append(payload, common.Hash{1, 2, 3}.Bytes()...)           // not an address
+	payload = append(payload, append([]byte{1}, make([]byte, 31)...)...) // high byte set
+	payload = append(payload, 0xff, 0xff)                                // trailing bytes
+
+	tx := &Transaction{Recipient: &contract, Payload: payload}
+	targets := tx.InferTargets()
+	if len(targets) != 3 || targets[0] != contract || targets[1] != model || targets[2] != input {
+		t.Fatalf("targets %x, want %x, %x and %x", targets, contract, model, input)
+	}
+	// Small integers look like addresses, callers match them against files
+	tx.Payload = append([]byte{0x12, 0x34, 0x56, 0x78}, word(42)...)
+	if targets := tx.InferTargets(); len(targets) != 2 || targets[1] != common.BytesToAddress([]byte{42}) {
+		t.Fatalf("targets %x, want the small integer", targets)
+	}
+
+	if targets := (&Transaction{Payload: payload}).InferTargets(); targets != nil {
+		t.Fatalf("contract creation targets %x", targets)
+	}
+	if targets := (&Transaction{Recipient: &contract, Payload: payload[:4+common.HashLength-1]}).InferTargets(); len(targets) != 1 || targets[0] != contract {
+		t.Fatalf("targets %x without a whole argument, want the recipient", targets)
+	}
+	// Transactions sent to a file itself, e.g. its uploads, target it
+	if targets := (&Transaction{Recipient: &model}).InferTargets(); len(targets) != 1 || targets[0] != model {
+		t.Fatalf("targets %x, want the recipient %x", targets, model)
+	}
+}
+
+// Tests that the status of a torrent follows the queue holding it, with
+// quarantined and held torrents reported as such in any queue.
+func TestTorrentStatus(t *testing.T) {
+	cl, _, closeClient := newTestClient(t)
+	defer closeClient()
+
+	tm := &TorrentManager{
+		pendingTorrents: make(map[metainfo.Hash]*Torrent),
+		activeTorrents:  make(map[metainfo.Hash]*Torrent),
+		seedingTorrents: make(map[metainfo.Hash]*Torrent),
+	}
+	tor := addTestTorrent(t, cl, priorityBlock)
+	ih := tor.Torrent.InfoHash()
+
+	check := func(want string) {
+		t.Helper()
+		s := tm.Status(tor)
+		if s.State != want {
+			t.Errorf("state %q, want %q", s.State, want)
+		}
+		if s.InfoHash != ih || s.Priority != priorityBlock || s.BytesRequested != uint64(tor.Length()) {
+			t.Errorf("status mismatch: %+v", s)
+		}
+	}
+	check(statePending)
+	tm.pendingTorrents[ih] = tor
+	check(statePending)
+	delete(tm.pendingTorrents, ih)
+	tm.activeTorrents[ih] = tor
+	check(stateActive)
+	tor.held = true
+	check(statePaused)
+	tor.held = false
+	delete(tm.activeTorrents, ih)
+	tm.seedingTorrents[ih] = tor
+	check(stateSeeding)
+	tor.quarantined = true
+	check(stateQuarantined)
+}
@@ -0,0 +1,179 @@
+package torrentfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// Sides of the sibling hashes of a proof, as returned by GetMerklePath.
+const (
+	siblingLeft  = 0
+	siblingRight = 1
+)
+
+// BlockProof proves that a record block, and so the files created by its
+// transactions, was indexed by the storage committing to Root at Number.
+//
+// The leaves of the tree are the hashes of the record blocks, so the
+// transactions of Block must still be checked against the chain by the block
+// hash for the proof to cover the model set on chain.
+type BlockProof struct {
+	Number hexutil.Uint64  `json:"number"` // record block of the root
+	Root   common.Hash     `json:"root"`
+	Block  *Block          `json:"block"`
+	Files  []*FileInfo     `json:"files"`
+	Path   []hexutil.Bytes `json:"path"`  // sibling hashes, leaf first
+	Sides  []int64         `json:"sides"` // 0 when the sibling is on the left, 1 on the right
+}
+
+// Proof returns the inclusion proof of the record block at blockNum against
+// the root of the latest record block at or below number.
+func (fs *FileStorage) Proof(blockNum, number uint64) (*BlockProof, error) {
+	if number < blockNum {
+		return nil, fmt.Errorf("root at %d precedes block %d", number, blockNum)
+	}
+	fs.lock.RLock()
+	block, anchor := fs.recordedBlock(blockNum), fs.recordedBlock(number)
+	if anchor == nil {
+		anchor = fs.prevRecordedBlock(number)
+	}
+	var (
+		leaves []Content
+		files  []*FileInfo
+	)
+	if block != nil && anchor != nil {
+		leaves = []Content{BlockContent{x: params.MainnetGenesisHash.String()}}
+		for _, b := range fs.blocks {
+			if b.Number > anchor.Number {
+				break
+			}
+			leaves = append(leaves, BlockContent{x: b.Hash.String()})
+		}
+		txs := make(map[common.Hash]struct{})
+		for _, tx := range block.Txs {
+			if tx.Hash != nil {
+				txs[*tx.Hash] = struct{}{}
+			}
+		}
+		for _, f := range fs.filesContractAddr {
+			if f.TxHash == nil {
+				continue
+			}
+			if _, ok := txs[*f.TxHash]; ok {
+				files = append(files, f)
+			}
+		}
+	}
+	fs.lock.RUnlock()
+	if block == nil {
+		return nil, fmt.Errorf("no record block at %d", blockNum)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ContractAddr.Hex() < files[j].ContractAddr.Hex()
+	})
+
+	tree, err := NewTree(leaves)
+	if err != nil {
+		return nil, err
+	}
+	root := common.BytesToHash(fs.GetRootByNumber(anchor.Number))
+	if root != common.BytesToHash(tree.MerkleRoot()) {
+		return nil, fmt.Errorf("root %x stored at %d, blocks commit to %x", root, anchor.Number, tree.MerkleRoot())
+	}
+	path, sides, err := tree.GetMerklePath(BlockContent{x: block.Hash.String()})
+	if err != nil {
+		return nil, err
+	}
+	p := &BlockProof{
+		Number: hexutil.Uint64(anchor.Number),
+		Root:   root,
+		Block:  block,
+		Files:  files,
+		Sides:  sides,
+	}
+	for _, h := range path {
+		p.Path = append(p.Path, h)
+	}
+	return p, nil
+}
+
+// FileProof returns the inclusion proof of the record block which created
+// f, against the root of the latest record block at or below number.
+func (fs *FileStorage) FileProof(f *FileInfo, number uint64) (*BlockProof, error) {
+	var (
+		blockNum uint64
+		ok       bool
+	)
+	if f.TxHash != nil {
+		blockNum, ok = fs.txBlock(*f.TxHash)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no record block created %x", f.Meta.InfoHash)
+	}
+	return fs.Proof(blockNum, number)
+}
+
+// VerifyBlockProof checks that p leads from its block to root, and that its
+// files are created by the transactions of the block.
+func VerifyBlockProof(p *BlockProof, root common.Hash) error {
+	if p.Block == nil {
+		return errors.New("proof without block")
+	}
+	if p.Root != root {
+		return fmt.Errorf("proof against root %x, want %x", p.Root, root)
+	}
+	if uint64(p.Number) < p.Block.Number {
+		return fmt.Errorf("root at %d precedes block %d", p.Number, p.Block.Number)
+	}
+	if len(p.Path) != len(p.Sides) {
+		return errors.New("proof path and sides of different lengths")
+	}
+	hash, err := BlockContent{x: p.Block.Hash.String()}.CalculateHash()
+	if err != nil {
+		return err
+	}
+	for i, sibling := range p.Path {
+		h := sha256.New()
+		switch p.Sides[i] {
+		case siblingLeft:
+			h.Write(sibling)
+			h.Write(hash)
+		case siblingRight:
+			h.Write(hash)
+			h.Write(sibling)
+		default:
+			return fmt.Errorf("invalid proof side %d", p.Sides[i])
+		}
+		hash = h.Sum(nil)
+	}
+	if !bytes.Equal(hash, root.Bytes()) {
+		return fmt.Errorf("block %d leads to root %x, want %x", p.Block.Number, hash, root)
+	}
+
+	txs := make(map[common.Hash]*Transaction)
+	for i := range p.Block.Txs {
+		if tx := &p.Block.Txs[i]; tx.Hash != nil {
+			txs[*tx.Hash] = tx
+		}
+	}
+	for _, f := range p.Files {
+		if f.Meta == nil || f.TxHash == nil {
+			return errors.New("incomplete proof file")
+		}
+		tx, ok := txs[*f.TxHash]
+		if !ok {
+			return fmt.Errorf("file %x not created by block %d", f.Meta.InfoHash, p.Block.Number)
+		}
+		if meta := tx.Parse(); meta == nil || meta.InfoHash != f.Meta.InfoHash || meta.RawSize != f.Meta.RawSize {
+			return fmt.Errorf("file %x not created by transaction %x", f.Meta.InfoHash, *f.TxHash)
+		}
+	}
+	return nil
+}
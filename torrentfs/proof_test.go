@@ -0,0 +1,67 @@
+package torrentfs
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+func TestBlockProof(t *testing.T) {
+	fs, closeFs := newCatalogStorage(t)
+	defer closeFs()
+	files := make(map[uint64]*FileInfo)
+	for n := uint64(1); n <= 7; n++ {
+		b, f := catalogBlock(t, n*10, common.BigToAddress(big.NewInt(int64(n)+1000)))
+		if err := fs.WriteBlock(b, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+		files[n*10] = f
+	}
+
+	for _, at := range []uint64{40, 45, 70} {
+		root := common.BytesToHash(fs.GetRootByNumber(at - at%10))
+		for n := uint64(10); n <= at; n += 10 {
+			p, err := fs.Proof(n, at)
+			if err != nil {
+				t.Fatalf("proof of %d at %d: %v", n, at, err)
+			}
+			if err := VerifyBlockProof(p, root); err != nil {
+				t.Fatalf("proof of %d at %d: %v", n, at, err)
+			}
+			if len(p.Files) != 1 || p.Files[0].Meta.InfoHash != files[n].Meta.InfoHash {
+				t.Fatalf("proof of %d with files %v", n, p.Files)
+			}
+		}
+	}
+	if _, err := fs.Proof(50, 40); err == nil {
+		t.Fatal("proof against an earlier root returned")
+	}
+	if _, err := fs.Proof(15, 40); err == nil {
+		t.Fatal("proof of a block not recorded returned")
+	}
+
+	p, err := fs.FileProof(files[30], 70)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := fs.Root()
+	if p.Block.Number != 30 || VerifyBlockProof(p, root) != nil {
+		t.Fatalf("file proof of block %d", p.Block.Number)
+	}
+	if err := VerifyBlockProof(p, common.BytesToHash(fs.GetRootByNumber(60))); err == nil {
+		t.Fatal("proof verified against another root")
+	}
+	p.Files[0].Meta.RawSize++
+	if err := VerifyBlockProof(p, root); err == nil {
+		t.Fatal("tampered file verified")
+	}
+	p.Files[0].Meta.RawSize--
+	p.Block.Hash[0] ^= 1
+	if err := VerifyBlockProof(p, root); err == nil {
+		t.Fatal("tampered block verified")
+	}
+}
@@ -0,0 +1,120 @@
+package torrentfs
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	ctfs1 = 1
+)
+
+// protocolName is the official short name of the model data protocol used
+// during capability negotiation.
+var protocolName = "ctfs"
+
+// ProtocolVersions are the supported versions of the ctfs protocol (first is primary).
+var ProtocolVersions = []uint{ctfs1}
+
+// protocolLengths are the number of implemented message corresponding to different protocol versions.
+var protocolLengths = map[uint]uint64{ctfs1: 5}
+
+const ProtocolMaxMsgSize = 16 * 1024 * 1024 // Maximum cap on the size of a protocol message
+
+// ctfs protocol message codes
+const (
+	StatusMsg      = 0x00
+	GetMetainfoMsg = 0x01
+	MetainfoMsg    = 0x02
+	GetPiecesMsg   = 0x03
+	PiecesMsg      = 0x04
+)
+
+const (
+	// softResponseLimit is the target maximum size of a returned piece batch.
+	softResponseLimit = 8 * 1024 * 1024
+	// maxPiecesFetch is the number of pieces to ask a peer for in one request.
+	maxPiecesFetch = 64
+	// maxServingPieces is the number of piece requests read from the storage
+	// at once, across all peers.
+	maxServingPieces = 4
+	// baseRequestCost is charged against the flow control buffer of a peer
+	// for every request, on top of the bytes it asks for.
+	baseRequestCost = 16 * 1024
+	// bufLimit and minRecharge are the flow control parameters announced to
+	// peers: the burst they may ask for and the rate, in bytes per second, at
+	// which their credit recovers.
+	bufLimit    = 32 * 1024 * 1024
+	minRecharge = 4 * 1024 * 1024
+)
+
+type errCode int
+
+const (
+	ErrMsgTooLarge = iota
+	ErrDecode
+	ErrInvalidMsgCode
+	ErrProtocolVersionMismatch
+	ErrNoStatusMsg
+	ErrExtraStatusMsg
+	ErrRequestRejected
+	ErrUnexpectedResponse
+)
+
+func (e errCode) String() string {
+	return errorToString[int(e)]
+}
+
+var errorToString = map[int]string{
+	ErrMsgTooLarge:             "Message too long",
+	ErrDecode:                  "Invalid message",
+	ErrInvalidMsgCode:          "Invalid message code",
+	ErrProtocolVersionMismatch: "Protocol version mismatch",
+	ErrNoStatusMsg:             "No status message",
+	ErrExtraStatusMsg:          "Extra status message",
+	ErrRequestRejected:         "Request rejected by flow control",
+	ErrUnexpectedResponse:      "Unexpected response",
+}
+
+func errResp(code errCode, format string, v ...interface{}) error {
+	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+}
+
+// statusData is the network packet for the status message.
+type statusData struct {
+	ProtocolVersion uint32
+	BufLimit        uint64 // Burst of request cost the sender accepts
+	MinRecharge     uint64 // Rate at which the request cost budget recovers
+}
+
+// getMetainfoData asks a peer for the bencoded metainfo of a torrent.
+type getMetainfoData struct {
+	ReqID    uint64
+	InfoHash metainfo.Hash
+}
+
+// metainfoData is the reply to getMetainfoData, Data is empty if the peer
+// does not have the metainfo.
+type metainfoData struct {
+	ReqID uint64
+	BV    uint64 // Remaining flow control buffer of the requester
+	Data  []byte
+}
+
+// getPiecesData asks a peer for Amount consecutive pieces starting at Origin.
+type getPiecesData struct {
+	ReqID    uint64
+	InfoHash metainfo.Hash
+	Origin   uint64
+	Amount   uint64
+}
+
+// piecesData is the reply to getPiecesData. Pieces the peer does not have
+// are left empty, and the list may be cut short.
+type piecesData struct {
+	ReqID  uint64
+	BV     uint64
+	Pieces [][]byte
+}
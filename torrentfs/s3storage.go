@@ -0,0 +1,244 @@
+package torrentfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	s3DefaultRegion = "us-east-1"
+	s3Timeout       = 30 * time.Second
+	// sha256 of the empty payload of the GET requests
+	s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Storage serves the data of the CVM from a bucket of an S3 compatible
+// object store, with the same <infohash>/data/... keys as DirStorage. The
+// bucket is addressed path-style, which MinIO and most stand-ins support.
+type S3Storage struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+
+	client    *http.Client
+	fileCache *lru.Cache
+}
+
+func NewS3Storage(config *Config) (*S3Storage, error) {
+	if config.S3Endpoint == "" || config.S3Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket required")
+	}
+	endpoint, err := url.Parse(config.S3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", config.S3Endpoint)
+	}
+	s := &S3Storage{
+		endpoint:  endpoint,
+		bucket:    config.S3Bucket,
+		region:    config.S3Region,
+		accessKey: config.S3AccessKey,
+		secretKey: config.S3SecretKey,
+		client:    &http.Client{Timeout: s3Timeout},
+	}
+	if s.region == "" {
+		s.region = s3DefaultRegion
+	}
+	s.fileCache, _ = lru.New(8)
+	return s, nil
+}
+
+// s3Object is an entry of a ListObjectsV2 reply.
+type s3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type s3ListResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// do sends a signed request for the given key of the bucket.
+func (s *S3Storage) do(method, key string, query url.Values) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS signature version 4 to req.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", s3EmptyPayload)
+	if s.accessKey == "" {
+		// Anonymous access to a public bucket
+		return
+	}
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + s3EmptyPayload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyPayload,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := s3HMAC([]byte("AWS4"+s.secretKey), date)
+	key = s3HMAC(key, s.region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// list returns the objects of the bucket under prefix.
+func (s *S3Storage) list(prefix string) ([]s3Object, error) {
+	var (
+		objects []s3Object
+		token   string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("s3 list %s: %s", prefix, resp.Status)
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Available(infohash string, rawSize int64) (bool, error) {
+	objects, err := s.list(infohash + "/data")
+	if err != nil {
+		log.Warn("S3 list failed", "hash", infohash, "err", err)
+		return false, err
+	}
+	var (
+		size  int64
+		found bool
+	)
+	for _, obj := range objects {
+		if obj.Key == infohash+"/data" || strings.HasPrefix(obj.Key, infohash+"/data/") {
+			size += obj.Size
+			found = true
+		}
+	}
+	if !found {
+		return false, errDataNotFound
+	}
+	return size <= rawSize, nil
+}
+
+func (s *S3Storage) GetFile(infohash string, subpath string) ([]byte, error) {
+	key := infohash + "/" + strings.TrimPrefix(subpath, "/")
+	if data, ok := s.fileCache.Get(key); ok {
+		return data.([]byte), nil
+	}
+	resp, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errDataNotFound
+	default:
+		return nil, fmt.Errorf("s3 get %s: %s", key, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.fileCache.Add(key, data)
+	return data, nil
+}
+
+func (s *S3Storage) Stop() error {
+	return nil
+}
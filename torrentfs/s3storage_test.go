@@ -0,0 +1,66 @@
+package torrentfs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestS3 starts an S3 stand-in serving objects from the bucket "models".
+func newTestS3(t *testing.T, objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/models" && r.URL.Query().Get("list-type") == "2" {
+			prefix := r.URL.Query().Get("prefix")
+			fmt.Fprint(w, "<ListBucketResult>")
+			for key, data := range objects {
+				if strings.HasPrefix(key, prefix) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(data))
+				}
+			}
+			fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+			return
+		}
+		data, ok := objects[strings.TrimPrefix(r.URL.Path, "/models/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, data)
+	}))
+}
+
+func TestS3Storage(t *testing.T) {
+	ih := "3edcb8a793887d92db12d53124955681d5c20a44"
+	server := newTestS3(t, map[string]string{
+		ih + "/data/symbol": "symbol",
+		ih + "/data/params": "parameters",
+		ih + "/torrent":     "metainfo",
+	})
+	defer server.Close()
+
+	s, err := NewS3Storage(&Config{S3Endpoint: server.URL, S3Bucket: "models", S3AccessKey: "key", S3SecretKey: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create s3 storage: %v", err)
+	}
+	if ok, err := s.Available(ih, 16); !ok || err != nil {
+		t.Fatalf("model not available: %v", err)
+	}
+	if ok, _ := s.Available(ih, 15); ok {
+		t.Error("model larger than its raw size available")
+	}
+	if _, err := s.Available("3edcb8a793887d92db12d53124955681d5c20a45", 16); err == nil {
+		t.Error("missing model available")
+	}
+	if data, err := s.GetFile(ih, "/data/params"); err != nil || string(data) != "parameters" {
+		t.Errorf("params mismatch: have %q, %v", data, err)
+	}
+	if _, err := s.GetFile(ih, "/data/missing"); err != errDataNotFound {
+		t.Errorf("missing file error mismatch: have %v, want %v", err, errDataNotFound)
+	}
+}
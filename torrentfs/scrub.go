@@ -0,0 +1,135 @@
+package torrentfs
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var (
+	scrubVerifiedMeter = metrics.NewRegisteredMeter("torrent/scrub/verified", nil)
+	scrubCorruptMeter  = metrics.NewRegisteredMeter("torrent/scrub/corrupt", nil)
+	quarantinedCounter = metrics.NewRegisteredCounter("torrent/quarantined", nil)
+)
+
+// ScrubTorrent queues the seeded data of ih for re-verification.
+func (tm *TorrentManager) ScrubTorrent(ih metainfo.Hash) error {
+	t := tm.GetTorrent(ih)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	if !t.Seeding() && !tm.Quarantined(t) {
+		return fmt.Errorf("torrent not seeding")
+	}
+	select {
+	case tm.scrubChan <- t:
+		return nil
+	default:
+		return fmt.Errorf("scrub queue full")
+	}
+}
+
+// checkTorrent verifies the seeded data of ih against its metainfo, and the
+// metainfo itself against ih, which is the digest published in the meta of
+// the model or input.
+func (tm *TorrentManager) checkTorrent(ih metainfo.Hash) error {
+	root := path.Join(tm.DataDir, ih.HexString())
+	mi, err := metainfo.LoadFromFile(path.Join(root, "torrent"))
+	if err != nil {
+		return err
+	}
+	if h := mi.HashInfoBytes(); h != ih {
+		return fmt.Errorf("metainfo hash mismatch, %x", h)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	return tm.verifyTorrent(&info, root)
+}
+
+// scrub re-verifies the data of t. Corrupt data is quarantined, i.e. no longer
+// served to the CVM, and handed back to the active loop to be fetched again.
+// Quarantined data is released once it verifies again.
+func (tm *TorrentManager) scrub(t *Torrent) {
+	if t.Dropped() {
+		return
+	}
+	ih := t.Torrent.InfoHash()
+	start := time.Now()
+	err := tm.checkTorrent(ih)
+
+	tm.lock.Lock()
+	t.scrubbed = time.Now()
+	quarantined := t.quarantined
+	if err == nil {
+		t.quarantined = false
+	} else {
+		t.quarantined = true
+	}
+	tm.lock.Unlock()
+
+	if err == nil {
+		scrubVerifiedMeter.Mark(1)
+		if quarantined {
+			quarantinedCounter.Dec(1)
+			log.Info("Seed repaired", "hash", ih)
+		}
+		log.Debug("Seed scrubbed", "hash", ih, "size", common.StorageSize(t.Length()), "elapsed", common.PrettyDuration(time.Since(start)))
+		return
+	}
+	scrubCorruptMeter.Mark(1)
+	if quarantined {
+		// Still being fetched again
+		return
+	}
+	quarantinedCounter.Inc(1)
+	log.Warn("Seed corrupted, quarantined", "hash", ih, "err", err)
+
+	// Mark the bad pieces missing before downloading them again
+	t.Torrent.VerifyData()
+	select {
+	case tm.corruptChan <- t:
+	case <-tm.closeAll:
+	}
+}
+
+// scrubLoop re-verifies the seeded data every scrub interval, and the data
+// queued by ScrubTorrent in between.
+func (tm *TorrentManager) scrubLoop() {
+	defer tm.wg.Done()
+	var tick <-chan time.Time
+	if tm.scrubInterval > 0 {
+		ticker := time.NewTicker(tm.scrubInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			var n int
+			for _, t := range tm.Torrents() {
+				select {
+				case <-tm.closeAll:
+					return
+				default:
+				}
+				if t.Seeding() {
+					tm.scrub(t)
+					n++
+				}
+			}
+			log.Info("Seeds scrubbed", "count", n)
+		case t := <-tm.scrubChan:
+			tm.scrub(t)
+		case <-tm.closeAll:
+			log.Info("Scrub loop closed")
+			return
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package torrentfs
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// shapingInterval is how often the traffic of each torrent is measured
+	// against its rate limits.
+	shapingInterval = 5 * time.Second
+	// shapingBurst is the least burst of the global limiters, which must fit
+	// the largest read or write of the torrent client.
+	shapingBurst = 1024 * 1024
+)
+
+// Shaping bounds the bandwidth and connections of the torrent client. Rates
+// are in KB/s and 0 means no limit. The rate limits are lifted during the
+// OffPeak window, "HH:MM-HH:MM" in local time, empty for none.
+//
+// The global rates are enforced by the torrent client. The rates of each
+// torrent are approached by halving its connections while it is over them,
+// and doubling them back once under half of them.
+type Shaping struct {
+	UploadRate          int    `json:"uploadRate"`
+	DownloadRate        int    `json:"downloadRate"`
+	TorrentUploadRate   int    `json:"torrentUploadRate"`
+	TorrentDownloadRate int    `json:"torrentDownloadRate"`
+	MaxConns            int    `json:"maxConns"`         // established per torrent
+	MaxHalfOpenConns    int    `json:"maxHalfOpenConns"` // per torrent, set at startup only
+	OffPeak             string `json:"offPeak"`
+}
+
+// shapingFromConfig returns the shaping set in config.
+func shapingFromConfig(config *Config) Shaping {
+	return Shaping{
+		UploadRate:          config.UploadRate,
+		DownloadRate:        config.DownloadRate,
+		TorrentUploadRate:   config.TorrentUploadRate,
+		TorrentDownloadRate: config.TorrentDownloadRate,
+		MaxConns:            config.MaxConns,
+		MaxHalfOpenConns:    config.MaxHalfOpenConns,
+		OffPeak:             config.OffPeak,
+	}
+}
+
+// parseOffPeak returns the start and end of an "HH:MM-HH:MM" window as
+// offsets from midnight.
+func parseOffPeak(window string) (start, end time.Duration, err error) {
+	var h1, m1, h2, m2 int
+	if _, err := fmt.Sscanf(window, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil {
+		return 0, 0, fmt.Errorf("invalid off-peak window %q, want HH:MM-HH:MM", window)
+	}
+	for _, v := range [][2]int{{h1, m1}, {h2, m2}} {
+		if v[0] < 0 || v[0] > 23 || v[1] < 0 || v[1] > 59 {
+			return 0, 0, fmt.Errorf("invalid off-peak window %q", window)
+		}
+	}
+	start = time.Duration(h1)*time.Hour + time.Duration(m1)*time.Minute
+	end = time.Duration(h2)*time.Hour + time.Duration(m2)*time.Minute
+	return start, end, nil
+}
+
+// offPeak reports whether now falls in the off-peak window, which may wrap
+// around midnight.
+func (s *Shaping) offPeak(now time.Time) bool {
+	if s.OffPeak == "" {
+		return false
+	}
+	start, end, err := parseOffPeak(s.OffPeak)
+	if err != nil || start == end {
+		return false
+	}
+	y, m, d := now.Date()
+	at := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	if start < end {
+		return at >= start && at < end
+	}
+	return at >= start || at < end
+}
+
+func (s *Shaping) validate() error {
+	if s.UploadRate < 0 || s.DownloadRate < 0 || s.TorrentUploadRate < 0 || s.TorrentDownloadRate < 0 {
+		return fmt.Errorf("negative rate limit")
+	}
+	if s.MaxConns < 0 || s.MaxHalfOpenConns < 0 {
+		return fmt.Errorf("negative connection limit")
+	}
+	if s.OffPeak != "" {
+		if _, _, err := parseOffPeak(s.OffPeak); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limit returns the rate of a limiter capping at kbs KB/s, infinite for 0.
+func limit(kbs int) (rate.Limit, int) {
+	if kbs <= 0 {
+		return rate.Inf, 0
+	}
+	burst := kbs * 1024
+	if burst < shapingBurst {
+		burst = shapingBurst
+	}
+	return rate.Limit(kbs * 1024), burst
+}
+
+func newLimiter(kbs int) *rate.Limiter {
+	return rate.NewLimiter(limit(kbs))
+}
+
+func setLimiter(l *rate.Limiter, kbs int) {
+	r, burst := limit(kbs)
+	l.SetLimit(r)
+	l.SetBurst(burst)
+}
+
+// Shaping returns the current shaping of the torrent client.
+func (tm *TorrentManager) Shaping() Shaping {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	return tm.shaping
+}
+
+// SetShaping replaces the shaping of the torrent client. A MaxConns of 0
+// keeps the current connection limit, and MaxHalfOpenConns only takes
+// effect on restart.
+func (tm *TorrentManager) SetShaping(s Shaping) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	tm.lock.Lock()
+	if s.MaxConns == 0 {
+		s.MaxConns = tm.maxEstablishedConns
+	}
+	tm.maxEstablishedConns = s.MaxConns
+	tm.shaping = s
+	tm.lock.Unlock()
+
+	tm.applyShaping(time.Now())
+	log.Info("Fs shaping updated", "upload", s.UploadRate, "download", s.DownloadRate, "torrent_upload", s.TorrentUploadRate, "torrent_download", s.TorrentDownloadRate, "conns", s.MaxConns, "offpeak", s.OffPeak)
+	return nil
+}
+
+// activeShaping returns the shaping in force at now, without rate limits
+// in the off-peak window.
+func (tm *TorrentManager) activeShaping(now time.Time) Shaping {
+	s := tm.Shaping()
+	if s.offPeak(now) {
+		s.UploadRate, s.DownloadRate = 0, 0
+		s.TorrentUploadRate, s.TorrentDownloadRate = 0, 0
+	}
+	return s
+}
+
+// applyShaping sets the global limiters to the shaping in force at now.
+func (tm *TorrentManager) applyShaping(now time.Time) {
+	s := tm.activeShaping(now)
+	setLimiter(tm.uploadLimiter, s.UploadRate)
+	setLimiter(tm.downloadLimiter, s.DownloadRate)
+}
+
+// shape measures the traffic of t since the last call, and adapts its
+// connections to the per-torrent rates of s. The caller must own t.
+func (t *Torrent) shape(s *Shaping, now time.Time) {
+	elapsed := now.Sub(t.shapedAt)
+	if elapsed < shapingInterval {
+		return
+	}
+	stats := t.Torrent.Stats().ConnStats
+	read, written := stats.BytesReadUsefulData.Int64(), stats.BytesWrittenData.Int64()
+	first := t.shapedAt.IsZero()
+	down := float64(read-t.shapedRead) / elapsed.Seconds()
+	up := float64(written-t.shapedWritten) / elapsed.Seconds()
+	t.shapedRead, t.shapedWritten, t.shapedAt = read, written, now
+	if s.MaxConns > 0 {
+		t.maxEstablishedConns = s.MaxConns
+	}
+	if first {
+		return
+	}
+
+	over := func(rate float64, kbs int, ratio float64) bool {
+		return kbs > 0 && rate > float64(kbs)*1024*ratio
+	}
+	switch {
+	case over(down, s.TorrentDownloadRate, 1) || over(up, s.TorrentUploadRate, 1):
+		conns := t.currentConns
+		if t.shapedConns > 0 && t.shapedConns < conns {
+			conns = t.shapedConns
+		}
+		t.shapedConns = int(math.Max(1, float64(conns/2)))
+	case t.shapedConns > 0 && !over(down, s.TorrentDownloadRate, 0.5) && !over(up, s.TorrentUploadRate, 0.5):
+		if t.shapedConns *= 2; t.shapedConns >= t.maxEstablishedConns {
+			t.shapedConns = 0
+		}
+	}
+}
+
+// shapedLimit caps conns to the connections left to t by its shaping.
+func (t *Torrent) shapedLimit(conns int) int {
+	if t.shapedConns > 0 && conns > t.shapedConns {
+		return t.shapedConns
+	}
+	return conns
+}
+
+// seedConns returns the connections of a seeding torrent.
+func (t *Torrent) seedConns() int {
+	conns := t.maxEstablishedConns / 2
+	if conns < t.minEstablishedConns {
+		conns = t.minEstablishedConns
+	}
+	return t.shapedLimit(conns)
+}
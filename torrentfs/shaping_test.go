@@ -0,0 +1,50 @@
+package torrentfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShapingOffPeak(t *testing.T) {
+	at := func(h, m int) time.Time {
+		return time.Date(2020, 6, 1, h, m, 0, 0, time.Local)
+	}
+	tests := []struct {
+		window string
+		now    time.Time
+		want   bool
+	}{
+		{"", at(3, 0), false},
+		{"01:00-07:00", at(3, 0), true},
+		{"01:00-07:00", at(7, 0), false},
+		{"01:00-07:00", at(0, 59), false},
+		{"22:30-06:00", at(23, 0), true},
+		{"22:30-06:00", at(5, 59), true},
+		{"22:30-06:00", at(12, 0), false},
+		{"05:00-05:00", at(5, 0), false},
+	}
+	for _, tt := range tests {
+		s := Shaping{OffPeak: tt.window}
+		if got := s.offPeak(tt.now); got != tt.want {
+			t.Errorf("window %q at %s: got %v, want %v", tt.window, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestShapingValidate(t *testing.T) {
+	for _, s := range []Shaping{
+		{UploadRate: -1},
+		{MaxConns: -1},
+		{OffPeak: "1-7"},
+		{OffPeak: "24:00-07:00"},
+		{OffPeak: "01:00-07:60"},
+	} {
+		if err := s.validate(); err == nil {
+			t.Errorf("%+v accepted", s)
+		}
+	}
+	s := Shaping{UploadRate: 512, TorrentDownloadRate: 64, MaxConns: 20, OffPeak: "01:00-07:00"}
+	if err := s.validate(); err != nil {
+		t.Errorf("%+v refused: %v", s, err)
+	}
+}
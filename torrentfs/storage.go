@@ -1,11 +1,12 @@
 package torrentfs
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/CortexFoundation/CortexTheseus/params"
-	//"fmt"
 	"github.com/pborman/uuid"
 	"os"
 	"path/filepath"
@@ -15,7 +16,7 @@ import (
 	"strconv"
 	//"strings"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
-	//"sync"
+	"sync"
 	//"sync/atomic"
 	"time"
 
@@ -60,7 +61,7 @@ func (mc *MutexCounter) IsZero() bool {
 type FileStorage struct {
 	filesContractAddr map[common.Address]*FileInfo
 	files             []*FileInfo //only storage init files from local storage
-	blocks            []*Block    //only storage init ckp blocks from local storage
+	blocks            []*Block    //record blocks in local storage, oldest first
 	db                *bolt.DB
 	version           string
 
@@ -73,7 +74,7 @@ type FileStorage struct {
 	tree   *MerkleTree
 	//LastFileIndex         uint64
 
-	//lock      sync.RWMutex
+	lock sync.RWMutex
 	//bnLock    sync.Mutex
 	//opCounter MutexCounter
 	dataDir string
@@ -90,13 +91,17 @@ func NewFileStorage(config *Config) (*FileStorage, error) {
 		return nil, err
 	}
 
-	db, dbErr := bolt.Open(filepath.Join(config.DataDir,
-		".file.bolt.db"), 0600, &bolt.Options{
+	path := filepath.Join(config.DataDir, storageFile)
+	db, dbErr := bolt.Open(path, 0600, &bolt.Options{
 		Timeout: time.Second,
 	})
 	if dbErr != nil {
 		return nil, dbErr
 	}
+	if err := migrateStorage(db, path, migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
 	//db.NoSync = true
 
 	fs := &FileStorage{
@@ -216,11 +221,14 @@ func (fs *FileStorage) Root() common.Hash {
 
 func (fs *FileStorage) AddFile(x *FileInfo) (uint64, error) {
 	addr := *x.ContractAddr
+	fs.lock.Lock()
 	if _, ok := fs.filesContractAddr[addr]; ok {
+		fs.lock.Unlock()
 		return 0, nil
 	}
 
 	fs.filesContractAddr[addr] = x
+	fs.lock.Unlock()
 
 	update, err := fs.WriteFile(x)
 	if err != nil {
@@ -233,7 +241,22 @@ func (fs *FileStorage) AddFile(x *FileInfo) (uint64, error) {
 	return 1, nil
 }
 
+// AddGenesisFile indexes a file registered in the genesis allocation. It is
+// kept out of the database and the catalog, which only hold files created by
+// transactions, and seeded again from the chain at every start.
+func (fs *FileStorage) AddGenesisFile(x *FileInfo) bool {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if _, ok := fs.filesContractAddr[*x.ContractAddr]; ok {
+		return false
+	}
+	fs.filesContractAddr[*x.ContractAddr] = x
+	return true
+}
+
 func (fs *FileStorage) GetFileByAddr(addr common.Address) *FileInfo {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
 	if f, ok := fs.filesContractAddr[addr]; ok {
 		return f
 	}
@@ -267,7 +290,7 @@ func (fs *FileStorage) GetFileByAddr(addr common.Address) *FileInfo {
 		log.Info("Torrent not found", "hash", infohash)
 		return false, errors.New("download not completed")
 	} else {
-		if !torrent.IsAvailable() {
+		if !tm.Available(torrent) {
 			log.Warn("[Not available] Download not completed", "hash", infohash, "raw", rawSize, "complete", torrent.BytesCompleted())
 			return false, errors.New(fmt.Sprintf("download not completed: %d %d", torrent.BytesCompleted(), rawSize))
 		}
@@ -464,9 +487,16 @@ func (fs *FileStorage) WriteBlock(b *Block, record bool) error {
 			if err != nil {
 				return err
 			}
-
-			return buk.Put(k, v)
+			if err := buk.Put(k, v); err != nil {
+				return err
+			}
+			txs, err := tx.CreateBucketIfNotExists([]byte("txs_" + fs.version))
+			if err != nil {
+				return err
+			}
+			return indexTxs(txs, b)
 		}); err == nil {
+			fs.blocks = append(fs.blocks, b)
 			if err := fs.addLeaf(b); err == nil {
 				if err := fs.writeCheckPoint(); err == nil {
 					fs.CheckPoint = b.Number
@@ -481,6 +511,194 @@ func (fs *FileStorage) WriteBlock(b *Block, record bool) error {
 	return fs.writeBlockNumber()
 }
 
+// ResetFile writes f even if its left size went up, which only happens when
+// uploads are undone by a chain reorg.
+func (fs *FileStorage) ResetFile(f *FileInfo) error {
+	return fs.db.Update(func(tx *bolt.Tx) error {
+		buk, err := tx.CreateBucketIfNotExists([]byte("files_" + fs.version))
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		k, err := json.Marshal(f.Meta.InfoHash)
+		if err != nil {
+			return err
+		}
+		return buk.Put(k, v)
+	})
+}
+
+// RemoveFilesByTx forgets the files created by the given transactions and
+// returns them. When another contract still refers to the same data, it takes
+// over the database entry.
+func (fs *FileStorage) RemoveFilesByTx(txs map[common.Hash]struct{}) ([]*FileInfo, error) {
+	var removed []*FileInfo
+	fs.lock.Lock()
+	for addr, f := range fs.filesContractAddr {
+		if f.TxHash == nil {
+			continue
+		}
+		if _, ok := txs[*f.TxHash]; ok {
+			delete(fs.filesContractAddr, addr)
+			removed = append(removed, f)
+		}
+	}
+	files := fs.files[:0]
+	for _, f := range fs.files {
+		if f.TxHash == nil {
+			files = append(files, f)
+		} else if _, ok := txs[*f.TxHash]; !ok {
+			files = append(files, f)
+		}
+	}
+	fs.files = files
+	fs.lock.Unlock()
+
+	for _, f := range removed {
+		err := fs.db.Update(func(tx *bolt.Tx) error {
+			buk, err := tx.CreateBucketIfNotExists([]byte("files_" + fs.version))
+			if err != nil {
+				return err
+			}
+			k, err := json.Marshal(f.Meta.InfoHash)
+			if err != nil {
+				return err
+			}
+			var info FileInfo
+			if v := buk.Get(k); v == nil || json.Unmarshal(v, &info) != nil || info.TxHash == nil || *info.TxHash != *f.TxHash {
+				return nil
+			}
+			return buk.Delete(k)
+		})
+		if err != nil {
+			return removed, err
+		}
+		if other := fs.GetFileByHash(f.Meta.InfoHash); other != nil {
+			if _, err := fs.WriteFile(other); err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// GetFileByHash returns one of the files carrying the given data, if any.
+func (fs *FileStorage) GetFileByHash(ih metainfo.Hash) *FileInfo {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+	for _, f := range fs.filesContractAddr {
+		if f.Meta.InfoHash == ih {
+			return f
+		}
+	}
+	return nil
+}
+
+// recordedBlock returns the record block stored at number, if any.
+func (fs *FileStorage) recordedBlock(number uint64) *Block {
+	i := sort.Search(len(fs.blocks), func(i int) bool { return fs.blocks[i].Number >= number })
+	if i < len(fs.blocks) && fs.blocks[i].Number == number {
+		return fs.blocks[i]
+	}
+	return nil
+}
+
+// prevRecordedBlock returns the latest record block below number, if any.
+func (fs *FileStorage) prevRecordedBlock(number uint64) *Block {
+	i := sort.Search(len(fs.blocks), func(i int) bool { return fs.blocks[i].Number >= number })
+	if i > 0 {
+		return fs.blocks[i-1]
+	}
+	return nil
+}
+
+// Revert rolls the storage back to the given block number. The record blocks
+// above it and the roots they committed are deleted, and the merkle tree is
+// rebuilt from the remaining blocks. The deleted blocks are returned, oldest
+// first, for the caller to undo their files.
+func (fs *FileStorage) Revert(number uint64) ([]*Block, error) {
+	i := sort.Search(len(fs.blocks), func(i int) bool { return fs.blocks[i].Number > number })
+	stale := append([]*Block(nil), fs.blocks[i:]...)
+
+	err := fs.db.Update(func(tx *bolt.Tx) error {
+		buk, err := tx.CreateBucketIfNotExists([]byte("blocks_" + fs.version))
+		if err != nil {
+			return err
+		}
+		txs, err := tx.CreateBucketIfNotExists([]byte("txs_" + fs.version))
+		if err != nil {
+			return err
+		}
+		for _, b := range stale {
+			k, err := json.Marshal(b.Number)
+			if err != nil {
+				return err
+			}
+			if err := buk.Delete(k); err != nil {
+				return err
+			}
+			for _, t := range b.Txs {
+				if t.Hash != nil {
+					if err := txs.Delete(t.Hash.Bytes()); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		roots, err := tx.CreateBucketIfNotExists([]byte("version_" + fs.version))
+		if err != nil {
+			return err
+		}
+		var keys [][]byte
+		c := roots.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if n, err := strconv.ParseUint(string(k), 16, 64); err == nil && n > number {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range keys {
+			if err := roots.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.blocks = fs.blocks[:i]
+
+	fs.leaves = fs.leaves[:1]
+	for _, b := range fs.blocks {
+		fs.leaves = append(fs.leaves, BlockContent{x: b.Hash.String()})
+	}
+	if err := fs.tree.RebuildTreeWith(fs.leaves); err != nil {
+		return stale, err
+	}
+
+	checkPoint := uint64(0)
+	if len(fs.blocks) > 0 {
+		checkPoint = fs.blocks[len(fs.blocks)-1].Number
+	}
+	if checkPoint < fs.CheckPoint {
+		fs.CheckPoint = checkPoint
+		if err := fs.writeCheckPoint(); err != nil {
+			return stale, err
+		}
+	}
+	if number < fs.LastListenBlockNumber {
+		fs.LastListenBlockNumber = number
+		if err := fs.writeBlockNumber(); err != nil {
+			return stale, err
+		}
+	}
+	log.Info("Storage reverted", "number", number, "blocks", len(stale), "root", fs.Root(), "checkpoint", fs.CheckPoint)
+	return stale, nil
+}
+
 func (fs *FileStorage) Version() string {
 	return fs.version
 }
@@ -532,6 +750,149 @@ func (fs *FileStorage) initFiles() error {
 	})
 }
 
+// Deprecations returns the block from which each deprecated file is no longer
+// seeded, as written by WriteDeprecation.
+func (fs *FileStorage) Deprecations() (map[metainfo.Hash]uint64, error) {
+	deprecations := make(map[metainfo.Hash]uint64)
+	err := fs.db.View(func(tx *bolt.Tx) error {
+		buk := tx.Bucket([]byte("deprecated_" + fs.version))
+		if buk == nil {
+			return nil
+		}
+		return buk.ForEach(func(k, v []byte) error {
+			var ih metainfo.Hash
+			if err := ih.FromHexString(string(k)); err != nil {
+				return err
+			}
+			at, err := strconv.ParseUint(string(v), 16, 64)
+			if err != nil {
+				return err
+			}
+			deprecations[ih] = at
+			return nil
+		})
+	})
+	return deprecations, err
+}
+
+// DeprecatedAt returns the block from which the file is no longer seeded, if
+// it is deprecated.
+func (fs *FileStorage) DeprecatedAt(ih metainfo.Hash) (at uint64, ok bool) {
+	fs.db.View(func(tx *bolt.Tx) error {
+		buk := tx.Bucket([]byte("deprecated_" + fs.version))
+		if buk == nil {
+			return nil
+		}
+		if v := buk.Get([]byte(ih.HexString())); v != nil {
+			if n, err := strconv.ParseUint(string(v), 16, 64); err == nil {
+				at, ok = n, true
+			}
+		}
+		return nil
+	})
+	return at, ok
+}
+
+// WriteDeprecation records the block from which the file is no longer seeded,
+// as scheduled at the given block. The schedule it replaces is kept for
+// RevertDeprecation.
+func (fs *FileStorage) WriteDeprecation(ih metainfo.Hash, at, number uint64) error {
+	return fs.db.Update(func(tx *bolt.Tx) error {
+		buk, err := tx.CreateBucketIfNotExists([]byte("deprecated_" + fs.version))
+		if err != nil {
+			return err
+		}
+		history, err := tx.CreateBucketIfNotExists([]byte("deprecated_history_" + fs.version))
+		if err != nil {
+			return err
+		}
+		// An empty previous value means the file was not deprecated
+		prev := buk.Get([]byte(ih.HexString()))
+		if err := history.Put(deprecationKey(ih, number), append([]byte{}, prev...)); err != nil {
+			return err
+		}
+		return buk.Put([]byte(ih.HexString()), []byte(strconv.FormatUint(at, 16)))
+	})
+}
+
+// RevertDeprecation undoes the deprecations of the file scheduled at the
+// given block or later, e.g. after a reorg, restoring the schedule in effect
+// before them if any.
+func (fs *FileStorage) RevertDeprecation(ih metainfo.Hash, number uint64) (at uint64, ok bool, err error) {
+	err = fs.db.Update(func(tx *bolt.Tx) error {
+		buk := tx.Bucket([]byte("deprecated_" + fs.version))
+		history := tx.Bucket([]byte("deprecated_history_" + fs.version))
+		if buk == nil || history == nil {
+			return nil
+		}
+		var (
+			keys [][]byte
+			prev []byte
+		)
+		// Keys of a file sort by block, the first one reverted holds the
+		// schedule in effect before the reverted blocks
+		c := history.Cursor()
+		for k, v := c.Seek(deprecationKey(ih, number)); k != nil && bytes.HasPrefix(k, []byte(ih.HexString()+"/")); k, v = c.Next() {
+			if keys == nil {
+				prev = append([]byte{}, v...)
+			}
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+		}
+		if keys == nil {
+			prev = buk.Get([]byte(ih.HexString()))
+		} else if len(prev) == 0 {
+			return buk.Delete([]byte(ih.HexString()))
+		} else if err := buk.Put([]byte(ih.HexString()), prev); err != nil {
+			return err
+		}
+		if prev != nil {
+			n, err := strconv.ParseUint(string(prev), 16, 64)
+			if err != nil {
+				return err
+			}
+			at, ok = n, true
+		}
+		return nil
+	})
+	return at, ok, err
+}
+
+// RemoveDeprecation forgets the deprecation of the file along with its
+// history, e.g. after its creation is reverted.
+func (fs *FileStorage) RemoveDeprecation(ih metainfo.Hash) error {
+	return fs.db.Update(func(tx *bolt.Tx) error {
+		if history := tx.Bucket([]byte("deprecated_history_" + fs.version)); history != nil {
+			var keys [][]byte
+			c := history.Cursor()
+			prefix := []byte(ih.HexString() + "/")
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			for _, k := range keys {
+				if err := history.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		buk := tx.Bucket([]byte("deprecated_" + fs.version))
+		if buk == nil {
+			return nil
+		}
+		return buk.Delete([]byte(ih.HexString()))
+	})
+}
+
+// deprecationKey is the history key of a deprecation of the file scheduled at
+// the given block, sorting by block within the file.
+func deprecationKey(ih metainfo.Hash, number uint64) []byte {
+	return []byte(fmt.Sprintf("%s/%016x", ih.HexString(), number))
+}
+
 func (fs *FileStorage) readFsId() error {
 	return fs.db.View(func(tx *bolt.Tx) error {
 		buk := tx.Bucket([]byte("id_" + fs.version))
@@ -693,4 +1054,8 @@ type FlowControlMeta struct {
 	InfoHash       metainfo.Hash
 	BytesRequested uint64
 	IsCreate       bool
+	IsDrop         bool
+	IsReset        bool   // BytesRequested replaces the quota, even if lower
+	IsFetch        bool   // requested data, fetched even in lazy mode
+	BlockNumber    uint64 // block referencing the file, if any
 }
@@ -0,0 +1,609 @@
+package torrentfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/torrentfs/tracker"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	swarmPieceLength = 64 * 1024
+	swarmTimeout     = time.Minute
+)
+
+// swarmChain is a testChain shared by the nodes of a swarm. It is safe for
+// concurrent use and announces its new heads to the monitors.
+type swarmChain struct {
+	lock   sync.RWMutex
+	chain  *testChain
+	branch byte
+	files  int
+	feed   event.Feed
+}
+
+func newSwarmChain() *swarmChain {
+	chain := newTestChain()
+	chain.blocks = []*Block{{Hash: crypto.Keccak256Hash([]byte("genesis"))}}
+	return &swarmChain{chain: chain, branch: 'a'}
+}
+
+func (c *swarmChain) CurrentNumber() (uint64, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.chain.CurrentNumber()
+}
+
+func (c *swarmChain) GetBlockByNumber(number uint64) (*Block, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.chain.GetBlockByNumber(number)
+}
+
+func (c *swarmChain) GetReceipt(txHash common.Hash) (*TxReceipt, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.chain.GetReceipt(txHash)
+}
+
+func (c *swarmChain) GetUpload(addr common.Address) (uint64, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.chain.GetUpload(addr)
+}
+
+func (c *swarmChain) GenesisMetas() ([]GenesisMeta, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.chain.GenesisMetas()
+}
+
+func (c *swarmChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return c.feed.Subscribe(ch)
+}
+
+// publish returns the transactions creating a model of data ih, fully
+// uploaded, along with the address of the model.
+func (c *swarmChain) publish(t *testing.T, ih metainfo.Hash, size uint64) ([]Transaction, common.Address) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.files++
+	addr := common.BigToAddress(big.NewInt(int64(c.files)))
+	txs := []Transaction{c.chain.createModelTx(t, addr, common.BytesToAddress(ih.Bytes()), size)}
+	for i := 0; uint64(i*testUploadChunk) < size; i++ {
+		txs = append(txs, c.chain.uploadTx(addr, i))
+	}
+	return txs, addr
+}
+
+// mine appends a block with txs to the canonical branch, followed by the
+// blocks the monitors wait for before dealing it. It returns its number.
+func (c *swarmChain) mine(txs ...Transaction) uint64 {
+	c.lock.Lock()
+	head := uint64(len(c.chain.blocks) - 1)
+	c.chain.extend(head, head+1+delay, c.branch, map[uint64][]Transaction{head + 1: txs})
+	c.lock.Unlock()
+	c.announce()
+	return head + 1
+}
+
+// reorg replaces the blocks above parent with a longer branch, having txs
+// in its first block.
+func (c *swarmChain) reorg(parent uint64, txs ...Transaction) {
+	c.lock.Lock()
+	c.branch++
+	head := uint64(len(c.chain.blocks) - 1)
+	c.chain.extend(parent, head+1+delay, c.branch, map[uint64][]Transaction{parent + 1: txs})
+	c.lock.Unlock()
+	c.announce()
+}
+
+func (c *swarmChain) announce() {
+	c.lock.RLock()
+	head := c.chain.blocks[len(c.chain.blocks)-1]
+	c.lock.RUnlock()
+	c.feed.Send(ChainHeadEvent{Number: head.Number, Hash: head.Hash})
+}
+
+// swarmNode is a torrentfs instance of a swarm.
+type swarmNode struct {
+	fs  *TorrentFS
+	tm  *TorrentManager
+	dir string
+}
+
+// testSwarm runs torrentfs instances on loopback, finding each other over an
+// in-process tracker and following the same fake chain, DHT disabled.
+//
+// The torrent client announces once a minute at most, so a node only finds
+// the peers which announced before it, and a torrent keeps a single
+// connection until it is fetched, possibly to another node lacking the data.
+// Nodes fetching some data are thus added one at a time, once the nodes
+// holding it serve it.
+type testSwarm struct {
+	t       *testing.T
+	chain   *swarmChain
+	tracker *tracker.Tracker
+	nodes   []*swarmNode
+}
+
+func newTestSwarm(t *testing.T) *testSwarm {
+	if testing.Short() {
+		t.Skip("swarm tests skipped in short mode")
+	}
+	tr := tracker.New(tracker.Config{HTTPAddrs: []string{"127.0.0.1:0"}})
+	if err := tr.Start(); err != nil {
+		t.Fatalf("failed to start tracker: %v", err)
+	}
+	return &testSwarm{t: t, chain: newSwarmChain(), tracker: tr}
+}
+
+// addNode starts a node, configured by config when not nil, and returns it.
+func (s *testSwarm) addNode(config func(*Config)) *swarmNode {
+	dir, err := ioutil.TempDir("", "torrentfs-swarm")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	cfg.DataDir = dir
+	cfg.Port = 0
+	cfg.DisableDHT = true
+	cfg.DisableUTP = true
+	cfg.DefaultTrackers = s.tracker.URLs()
+	cfg.BoostNodes = nil
+	if config != nil {
+		config(&cfg)
+	}
+	fs, err := newTorrentFS(&cfg, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		s.t.Fatalf("failed to create node: %v", err)
+	}
+	fs.SetChainBackend(s.chain)
+	n := &swarmNode{fs: fs, tm: fs.monitor.dl.(*TorrentManager), dir: dir}
+	if cfg.MaxDiskUsage > 0 {
+		n.tm.evictInterval = 100 * time.Millisecond
+	}
+	if err := fs.Start(nil); err != nil {
+		os.RemoveAll(dir)
+		s.t.Fatalf("failed to start node: %v", err)
+	}
+	s.nodes = append(s.nodes, n)
+	return n
+}
+
+// stop stops the node n, keeping its data dir until the swarm is closed.
+func (s *testSwarm) stop(n *swarmNode) {
+	if n.fs != nil {
+		n.fs.Stop()
+		n.fs = nil
+	}
+}
+
+func (s *testSwarm) close() {
+	for _, n := range s.nodes {
+		s.stop(n)
+		os.RemoveAll(n.dir)
+	}
+	s.tracker.Stop()
+}
+
+// seed stores files under the data directory of a new torrent in the data
+// dir of n, as if n had fetched them, and returns the info hash. The files
+// are only seeded once published on chain.
+func (s *testSwarm) seed(n *swarmNode, files map[string][]byte) (metainfo.Hash, uint64) {
+	staging, err := ioutil.TempDir(n.dir, "staging")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer os.RemoveAll(staging)
+	var size uint64
+	for name, data := range files {
+		if err := os.MkdirAll(path.Join(staging, "data"), 0755); err != nil {
+			s.t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(staging, "data", name), data, 0644); err != nil {
+			s.t.Fatal(err)
+		}
+		size += uint64(len(data))
+	}
+	info := metainfo.Info{PieceLength: swarmPieceLength}
+	if err := info.BuildFromFilePath(path.Join(staging, "data")); err != nil {
+		s.t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+	ih := mi.HashInfoBytes()
+
+	// Laid out as the active loop leaves a completed download
+	tmp := path.Join(n.dir, defaultTmpFilePath, ih.HexString())
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		s.t.Fatal(err)
+	}
+	if err := os.Rename(path.Join(staging, "data"), path.Join(tmp, "data")); err != nil {
+		s.t.Fatal(err)
+	}
+	f, err := os.Create(path.Join(tmp, "torrent"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer f.Close()
+	if err := mi.Write(f); err != nil {
+		s.t.Fatal(err)
+	}
+	if err := os.Symlink(path.Join(defaultTmpFilePath, ih.HexString()), path.Join(n.dir, ih.HexString())); err != nil {
+		s.t.Fatal(err)
+	}
+	return ih, size
+}
+
+// publish creates the model of data ih on chain, fully uploaded so that the
+// nodes fetch all of it. It returns the address of the model and the number
+// of the block creating it.
+func (s *testSwarm) publish(ih metainfo.Hash, size uint64) (common.Address, uint64) {
+	// Only whole chunks are uploaded
+	size = (size + testUploadChunk - 1) / testUploadChunk * testUploadChunk
+	txs, addr := s.chain.publish(s.t, ih, size)
+	return addr, s.chain.mine(txs...)
+}
+
+// waitFor fails the test when cond does not hold within swarmTimeout.
+func (s *testSwarm) waitFor(what string, cond func() bool) {
+	deadline := time.Now().Add(swarmTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// waitAvailable waits until n serves the data of ih to the CVM.
+func (s *testSwarm) waitAvailable(n *swarmNode, ih metainfo.Hash, size uint64) {
+	s.waitFor("data of "+ih.HexString(), func() bool {
+		ok, err := n.fs.Available(ih.HexString(), int64(size))
+		return ok && err == nil
+	})
+}
+
+func randomData(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Tests that the data published on chain is fetched by every node from the
+// one holding it, and served once fetched, and that the nodes which fetched
+// it seed it in turn.
+func TestSwarmDownload(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	origin := s.addNode(nil)
+
+	files := map[string][]byte{
+		"symbol": randomData(t, 3000),
+		"params": randomData(t, 5*swarmPieceLength+100),
+	}
+	ih, size := s.seed(origin, files)
+	if ok, _ := origin.fs.Available(ih.HexString(), int64(size)); ok {
+		t.Fatal("data available before it was published")
+	}
+	if _, err := origin.fs.GetFile(ih.HexString(), "/data/symbol"); err == nil {
+		t.Fatal("file read before it was published")
+	}
+	s.publish(ih, size)
+	s.waitAvailable(origin, ih, size)
+
+	for i := 0; i < 3; i++ {
+		n := origin
+		if i > 0 {
+			n = s.addNode(nil)
+		}
+		s.waitAvailable(n, ih, size)
+		for name, want := range files {
+			have, err := n.fs.GetFile(ih.HexString(), "/data/"+name)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", name, err)
+			}
+			if !bytes.Equal(have, want) {
+				t.Fatalf("file %s mismatch", name)
+			}
+		}
+		if ok, err := n.fs.Available(ih.HexString(), int64(size)-1); ok || err != nil {
+			t.Fatalf("data over the raw size available: %v", err)
+		}
+	}
+
+	// A late node gets the data from those which fetched it
+	s.stop(origin)
+	late := s.addNode(nil)
+	s.waitAvailable(late, ih, size)
+	if have, err := late.fs.GetFile(ih.HexString(), "/data/params"); err != nil || !bytes.Equal(have, files["params"]) {
+		t.Fatalf("file read from the late node: %v", err)
+	}
+}
+
+// Tests that a node encrypting its data at rest serves it decrypted, and seeds
+// the original pieces to the other nodes, while its key is rotated.
+func TestSwarmEncrypted(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	origin := s.addNode(nil)
+
+	files := map[string][]byte{
+		"symbol": randomData(t, 3000),
+		"params": randomData(t, 5*swarmPieceLength+100),
+	}
+	ih, size := s.seed(origin, files)
+	s.publish(ih, size)
+	s.waitAvailable(origin, ih, size)
+
+	sealed := s.addNode(func(c *Config) {
+		c.StorageKeyFile = "keys"
+	})
+	s.waitAvailable(sealed, ih, size)
+	stored := func(name string) []byte {
+		data, err := ioutil.ReadFile(path.Join(sealed.dir, defaultTmpFilePath, ih.HexString(), "data", name))
+		if err != nil {
+			t.Fatalf("failed to read stored %s: %v", name, err)
+		}
+		return data
+	}
+	params := stored("params")
+	for name, want := range files {
+		if bytes.Equal(stored(name), want) {
+			t.Fatalf("file %s stored in the clear", name)
+		}
+		if have, err := sealed.fs.GetFile(ih.HexString(), "/data/"+name); err != nil || !bytes.Equal(have, want) {
+			t.Fatalf("file %s mismatch: %v", name, err)
+		}
+	}
+
+	// The original data is seeded while re-encrypted under a new key
+	if _, err := sealed.tm.RotateStorageKey(); err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+	s.stop(origin)
+	late := s.addNode(nil)
+	s.waitAvailable(late, ih, size)
+	if have, err := late.fs.GetFile(ih.HexString(), "/data/params"); err != nil || !bytes.Equal(have, files["params"]) {
+		t.Fatalf("file read from the encrypted node: %v", err)
+	}
+	s.waitFor("re-encryption", func() bool {
+		data := stored("params")
+		return !bytes.Equal(data, params) && !bytes.Equal(data, files["params"])
+	})
+	if err := sealed.tm.checkTorrent(ih); err != nil {
+		t.Fatalf("re-encrypted data failed verification: %v", err)
+	}
+}
+
+// Tests that the seeded data over the disk quota is evicted, and fetched
+// again once requested.
+func TestSwarmEviction(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	origin := s.addNode(nil)
+
+	// Together over the megabyte of quota, either fits alone
+	var (
+		ihs   []metainfo.Hash
+		sizes = make(map[metainfo.Hash]uint64)
+	)
+	for i := 0; i < 2; i++ {
+		ih, size := s.seed(origin, map[string][]byte{"params": randomData(t, 10*swarmPieceLength)})
+		ihs, sizes[ih] = append(ihs, ih), size
+		s.publish(ih, size)
+		s.waitAvailable(origin, ih, size)
+	}
+	n := s.addNode(func(c *Config) {
+		c.MaxDiskUsage = 1
+		c.ProtectBlocks = 0
+	})
+	evicted := func(ih metainfo.Hash) bool {
+		_, err := os.Stat(path.Join(n.dir, defaultTmpFilePath, ih.HexString(), evictedMarker))
+		return err == nil && n.tm.GetTorrent(ih) == nil
+	}
+	var kept, dropped metainfo.Hash
+	s.waitFor("eviction", func() bool {
+		for i, ih := range ihs {
+			other := ihs[1-i]
+			if evicted(ih) && !evicted(other) {
+				if tt := n.tm.GetTorrent(other); tt != nil && tt.Seeding() {
+					kept, dropped = other, ih
+					return true
+				}
+			}
+		}
+		return false
+	})
+	if _, err := os.Stat(path.Join(n.dir, dropped.HexString())); !os.IsNotExist(err) {
+		t.Fatalf("evicted data left in the data dir: %v", err)
+	}
+	if _, _, count := n.tm.DiskUsage(); count != 1 {
+		t.Fatalf("evicted count mismatch: have %d, want 1", count)
+	}
+	if _, err := n.fs.GetFile(dropped.HexString(), "/data/params"); err == nil {
+		t.Fatal("evicted file read")
+	}
+
+	// Requested again, it is fetched and the other one evicted in turn
+	if ok, _ := n.fs.Available(dropped.HexString(), int64(sizes[dropped])); ok {
+		t.Fatal("evicted data available")
+	}
+	s.waitAvailable(n, dropped, sizes[dropped])
+	s.waitFor("eviction of the data unused", func() bool { return evicted(kept) })
+	if ok, _ := origin.fs.Available(kept.HexString(), int64(sizes[kept])); !ok {
+		t.Fatal("data evicted from the node without quota")
+	}
+}
+
+// Tests that the data created by blocks dropped in a reorg stops being
+// fetched and served.
+func TestSwarmReorg(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	origin := s.addNode(nil)
+
+	kept, keptSize := s.seed(origin, map[string][]byte{"params": randomData(t, 3*swarmPieceLength)})
+	s.publish(kept, keptSize)
+	dropped, droppedSize := s.seed(origin, map[string][]byte{"params": randomData(t, 3*swarmPieceLength)})
+	addr, number := s.publish(dropped, droppedSize)
+	s.waitAvailable(origin, kept, keptSize)
+	s.waitAvailable(origin, dropped, droppedSize)
+
+	n := s.addNode(nil)
+	s.waitAvailable(n, kept, keptSize)
+	s.waitAvailable(n, dropped, droppedSize)
+
+	s.chain.reorg(number - 1)
+	for _, node := range []*swarmNode{origin, n} {
+		s.waitFor("drop of "+dropped.HexString(), func() bool {
+			return node.tm.GetTorrent(dropped) == nil && node.fs.monitor.fs.GetFileByAddr(addr) == nil
+		})
+		if ok, _ := node.fs.Available(dropped.HexString(), int64(droppedSize)); ok {
+			t.Fatal("data of a dropped block available")
+		}
+		if _, err := node.fs.GetFile(dropped.HexString(), "/data/params"); err == nil {
+			t.Fatal("file of a dropped block read")
+		}
+		if ok, err := node.fs.Available(kept.HexString(), int64(keptSize)); !ok || err != nil {
+			t.Fatalf("data before the reorg unavailable: %v", err)
+		}
+	}
+}
+
+// Tests that a node in lazy sync mode defers the data created on chain until
+// the CVM asks for it, then fetches it without holding the CVM unless asked
+// to wait, and that a fetch of data held by no one times out.
+func TestSwarmLazy(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	origin := s.addNode(nil)
+
+	symbol := randomData(t, 3000)
+	first, firstSize := s.seed(origin, map[string][]byte{"params": randomData(t, 3*swarmPieceLength)})
+	second, secondSize := s.seed(origin, map[string][]byte{"symbol": symbol})
+	missing := metainfo.HashBytes(randomData(t, 20))
+	s.publish(first, firstSize)
+	s.publish(second, secondSize)
+	s.publish(missing, 1024)
+	s.waitAvailable(origin, first, firstSize)
+	s.waitAvailable(origin, second, secondSize)
+
+	n := s.addNode(func(c *Config) {
+		c.SyncMode = syncModeLazy
+		c.LazyTimeout = int(swarmTimeout / time.Second)
+	})
+	deferred := func(ih metainfo.Hash) bool {
+		n.tm.lock.RLock()
+		defer n.tm.lock.RUnlock()
+		_, ok := n.tm.deferred[ih]
+		return ok
+	}
+	for _, ih := range []metainfo.Hash{first, second, missing} {
+		s.waitFor("deferral of "+ih.HexString(), func() bool { return deferred(ih) })
+		if n.tm.GetTorrent(ih) != nil {
+			t.Fatalf("deferred torrent %x added", ih)
+		}
+		if _, err := os.Stat(path.Join(n.dir, ih.HexString())); !os.IsNotExist(err) {
+			t.Fatalf("deferred data %x stored: %v", ih, err)
+		}
+	}
+
+	// Block processing does not wait for the data, only starts fetching it
+	if ok, err := n.fs.Available(first.HexString(), int64(firstSize)); ok || err == nil {
+		t.Fatal("deferred data available before being fetched")
+	}
+	if err := n.fs.Fetch(first.HexString()); err != nil {
+		t.Fatalf("failed to fetch deferred data: %v", err)
+	}
+	if deferred(first) {
+		t.Fatal("fetched torrent still deferred")
+	}
+	if ok, err := n.fs.Available(first.HexString(), int64(firstSize)); !ok || err != nil {
+		t.Fatalf("fetched data unavailable: %v", err)
+	}
+	// Nor does reading a file, rpc calls wait for the data first
+	if _, err := n.fs.GetFile(second.HexString(), "/data/symbol"); err == nil {
+		t.Fatal("deferred file read before being fetched")
+	}
+	if err := n.fs.Fetch(second.HexString()); err != nil {
+		t.Fatalf("failed to fetch deferred file: %v", err)
+	}
+	have, err := n.fs.GetFile(second.HexString(), "/data/symbol")
+	if err != nil {
+		t.Fatalf("failed to read deferred file: %v", err)
+	}
+	if !bytes.Equal(have, symbol) {
+		t.Fatal("deferred file mismatch")
+	}
+
+	if err := n.tm.FetchTorrent(missing, priorityRPC, time.Second); err != errFetchTimeout {
+		t.Fatalf("fetch of missing data: %v, want %v", err, errFetchTimeout)
+	}
+	if err := n.tm.FetchTorrent(metainfo.Hash{1}, priorityRPC, time.Second); err != errTorrentNotFound {
+		t.Fatalf("fetch of unknown data: %v, want %v", err, errTorrentNotFound)
+	}
+}
+
+// Tests that a node in lazy sync mode imports a block running inference on
+// deferred data: block processing never waits for the model and the input,
+// but the storage fetches the data it asks for, so that the block is imported
+// when tried again.
+func TestSwarmLazyImport(t *testing.T) {
+	s := newTestSwarm(t)
+	defer s.close()
+	origin := s.addNode(nil)
+
+	model, modelSize := s.seed(origin, map[string][]byte{"params": randomData(t, 3*swarmPieceLength)})
+	input, inputSize := s.seed(origin, map[string][]byte{"data": randomData(t, 3000)})
+	s.publish(model, modelSize)
+	s.publish(input, inputSize)
+	s.waitAvailable(origin, model, modelSize)
+	s.waitAvailable(origin, input, inputSize)
+
+	n := s.addNode(func(c *Config) {
+		c.SyncMode = syncModeLazy
+		c.LazyTimeout = int(swarmTimeout / time.Second)
+	})
+	for _, ih := range []metainfo.Hash{model, input} {
+		s.waitFor("deferral of "+ih.HexString(), func() bool {
+			n.tm.lock.RLock()
+			defer n.tm.lock.RUnlock()
+			_, ok := n.tm.deferred[ih]
+			return ok
+		})
+	}
+	// An import checks the model and the input as the CVM does, failing with
+	// an error for the block to be retried while either is missing
+	imported := func() bool {
+		modelOk, modelErr := n.fs.Available(model.HexString(), int64(modelSize))
+		inputOk, inputErr := n.fs.Available(input.HexString(), int64(inputSize))
+		if !modelOk && modelErr == nil || !inputOk && inputErr == nil {
+			t.Fatal("missing data reported as invalid")
+		}
+		return modelOk && inputOk
+	}
+	if imported() {
+		t.Fatal("block imported before its data was fetched")
+	}
+	s.waitFor("import of the block running inference", imported)
+}
@@ -3,6 +3,7 @@ package torrentfs
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"github.com/CortexFoundation/CortexTheseus/common/mclock"
 	"github.com/anacrolix/missinggo/slices"
@@ -20,12 +21,13 @@ import (
 
 	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
 	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/anacrolix/torrent"
 	//	"net"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/mmap_span"
-	"github.com/anacrolix/torrent/storage"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -38,6 +40,7 @@ const (
 	torrentRunning
 	torrentSeeding
 	torrentSeedingInQueue
+	torrentDropped
 )
 
 type Torrent struct {
@@ -59,10 +62,32 @@ type Torrent struct {
 	isBoosting          bool
 	fast                bool
 	start               mclock.AbsTime
+	// held is set when the torrent was paused over RPC, the active loop
+	// keeps it paused until it is resumed.
+	held bool
+	// forced torrents are fetched completely regardless of the upload quota.
+	forced bool
+	// quarantined is set when the data failed scrubbing, it is not served
+	// until fetched again and verified.
+	quarantined bool
+	scrubbed    time.Time
+	// priority is the download priority set by the active loop, throttled
+	// is set while other torrents have one.
+	priority  int
+	throttled bool
+	// shapedConns caps the connections while the torrent exceeds its rate
+	// limits, 0 when it does not, as measured from the traffic sampled at
+	// shapedAt.
+	shapedConns   int
+	shapedRead    int64
+	shapedWritten int64
+	shapedAt      time.Time
 }
 
 const block = int64(params.PER_UPLOAD_BYTES)
 
+var errTorrentNotFound = errors.New("torrent not found")
+
 func (tm *TorrentManager) GetLimitation(value int64) int64 {
 	return ((value + block - 1) / block) * block
 }
@@ -88,14 +113,10 @@ func (t *Torrent) ReloadFile(files []string, datas [][]byte, tm *TorrentManager)
 	log.Info("Try to boost files", "files", files)
 	for i, filename := range files {
 		filePath := path.Join(t.filepath, filename)
-		f, err := os.Create(filePath)
-		if err != nil {
-			return
-		}
-		defer f.Close()
 		log.Debug("Write file (Boost mode)", "path", filePath)
-		if _, err := f.Write(datas[i]); err != nil {
+		if err := tm.writeFile(t.filepath, filename, datas[i]); err != nil {
 			log.Error("Error while write data file", "error", err)
+			return
 		}
 	}
 	mi, err := metainfo.LoadFromFile(path.Join(t.filepath, "torrent"))
@@ -104,7 +125,7 @@ func (t *Torrent) ReloadFile(files []string, datas [][]byte, tm *TorrentManager)
 		return
 	}
 	spec := torrent.TorrentSpecFromMetaInfo(mi)
-	spec.Storage = storage.NewFile(t.filepath)
+	spec.Storage = tm.newStorage(t.filepath)
 	//spec.Trackers = append(spec.Trackers, tm.trackers...)
 	//spec.Trackers = tm.trackers
 	if torrent, _, err := tm.client.AddTorrentSpec(spec); err == nil {
@@ -138,7 +159,7 @@ func (t *Torrent) ReloadTorrent(data []byte, tm *TorrentManager) {
 		return
 	}
 	spec := torrent.TorrentSpecFromMetaInfo(mi)
-	spec.Storage = storage.NewFile(t.filepath)
+	spec.Storage = tm.newStorage(t.filepath)
 	//spec.Trackers = append(spec.Trackers, tm.trackers...)
 	//spec.Trackers = tm.trackers
 	if torrent, _, err := tm.client.AddTorrentSpec(spec); err == nil {
@@ -167,9 +188,6 @@ func (t *Torrent) ReloadTorrent(data []byte, tm *TorrentManager) {
 var maxCited int64 = 1
 
 func (t *Torrent) IsAvailable() bool {
-	if _, ok := BadFiles[t.InfoHash()]; ok {
-		return false
-	}
 	t.cited += 1
 	if t.cited > maxCited {
 		maxCited = t.cited
@@ -222,10 +240,44 @@ func (t *Torrent) SeedInQueue() {
 	//t.Torrent.Drop()
 }
 
-func (t *Torrent) BoostOff() {
+// boost marks t as boosting, it returns false if a boost is already running.
+func (tm *TorrentManager) boost(t *Torrent) bool {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	if t.isBoosting {
+		return false
+	}
+	t.isBoosting = true
+	return true
+}
+
+func (tm *TorrentManager) boostOff(t *Torrent) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
 	t.isBoosting = false
 }
 
+func (tm *TorrentManager) boosting(t *Torrent) bool {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	return t.isBoosting
+}
+
+// Quarantined reports whether the data of t failed its last verification.
+func (tm *TorrentManager) Quarantined(t *Torrent) bool {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	return t.quarantined
+}
+
+// Available reports whether the data of t can be served to the CVM.
+func (tm *TorrentManager) Available(t *Torrent) bool {
+	if tm.Quarantined(t) {
+		return false
+	}
+	return t.IsAvailable()
+}
+
 func (t *Torrent) Seed() {
 	if t.status == torrentSeeding {
 		return
@@ -238,10 +290,7 @@ func (t *Torrent) Seed() {
 
 	//t.Torrent.DownloadAll()
 	//if t.currentConns <= t.minEstablishedConns {
-	t.currentConns = t.maxEstablishedConns / 2
-	if t.currentConns < t.minEstablishedConns {
-		t.currentConns = t.minEstablishedConns
-	}
+	t.currentConns = t.seedConns()
 	t.Torrent.SetMaxEstablishedConns(t.currentConns)
 	//}
 	if t.Torrent.Seeding() {
@@ -275,6 +324,10 @@ func (t *Torrent) Paused() bool {
 	return t.status == torrentPaused
 }
 
+func (t *Torrent) Dropped() bool {
+	return t.status == torrentDropped
+}
+
 //func (t *Torrent) Length() int64 {
 //	return t.bytesCompleted + t.bytesMissing
 //}
@@ -289,21 +342,15 @@ func (t *Torrent) Run(slot int) {
 		limitPieces = t.Torrent.NumPieces()
 	}
 
+	if conns := t.connLimit(); conns != t.currentConns {
+		t.currentConns = conns
+		t.Torrent.SetMaxEstablishedConns(t.currentConns)
+	}
+
 	if limitPieces <= t.maxPieces && t.status == torrentRunning {
 		return
 	}
 
-	if t.fast {
-		if t.currentConns <= t.minEstablishedConns {
-			t.currentConns = t.maxEstablishedConns
-			t.Torrent.SetMaxEstablishedConns(t.currentConns)
-		}
-	} else {
-		if t.currentConns > t.minEstablishedConns {
-			t.currentConns = t.minEstablishedConns
-			t.Torrent.SetMaxEstablishedConns(t.currentConns)
-		}
-	}
 	//log.Info("Limit mode", "hash", t.infohash, "fast", t.fast, "conn", t.currentConns, "request", t.bytesRequested, "limit", limitPieces, "cur", t.maxPieces, "total", t.Torrent.NumPieces())
 	t.status = torrentRunning
 	if limitPieces != t.maxPieces {
@@ -397,6 +444,7 @@ func (t *Torrent) Pending() bool {
 }
 
 type TorrentManager struct {
+	head                uint64 // latest block dealt by the monitor, accessed atomically
 	client              *torrent.Client
 	bytes               map[metainfo.Hash]int64
 	torrents            map[metainfo.Hash]*Torrent
@@ -408,6 +456,7 @@ type TorrentManager struct {
 	maxActiveTask       int
 	trackers            [][]string
 	boostFetcher        *BoostDataFetcher
+	peerFetcher         *PeerDataFetcher
 	DataDir             string
 	TmpDataDir          string
 	closeAll            chan struct{}
@@ -419,17 +468,49 @@ type TorrentManager struct {
 	seedingChan chan *Torrent
 	activeChan  chan *Torrent
 	pendingChan chan *Torrent
+	scrubChan   chan *Torrent
+	corruptChan chan *Torrent
 	//closeOnce sync.Once
 	fullSeed bool
 	id       uint64
 	slot     int
 	//bucket int
+	scrubInterval time.Duration
+
+	// Inference accesses and latest referencing blocks drive the eviction
+	// of seeded data over maxDiskUsage.
+	access        map[metainfo.Hash]time.Time
+	refs          map[metainfo.Hash]uint64
+	evicted       map[metainfo.Hash]struct{}
+	maxDiskUsage  int64
+	protectBlocks uint64
+	evictInterval time.Duration
+
+	priorities map[metainfo.Hash]priority
+
+	// In lazy mode, the torrents created on chain are only fetched once
+	// requested, deferred until then.
+	lazy     bool
+	deferred map[metainfo.Hash]struct{}
+
+	shaping         Shaping
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+
+	blocklist *Blocklist
+
+	// keys encrypt the data at rest, nil when it is stored in the clear.
+	keys      *keyring
+	rekeyChan chan struct{}
 }
 
 func (tm *TorrentManager) CreateTorrent(t *torrent.Torrent, requested int64, status int, ih metainfo.Hash) *Torrent {
+	tm.lock.RLock()
+	conns := tm.maxEstablishedConns
+	tm.lock.RUnlock()
 	tt := &Torrent{
 		t,
-		tm.maxEstablishedConns, 1, tm.maxEstablishedConns,
+		conns, 1, conns,
 		requested,
 		//int64(float64(requested) * expansionFactor),
 		tm.GetLimitation(requested),
@@ -437,6 +518,9 @@ func (tm *TorrentManager) CreateTorrent(t *torrent.Torrent, requested int64, sta
 		ih.String(),
 		path.Join(tm.TmpDataDir, ih.String()),
 		0, 1, 0, 0, false, true, 0,
+		false, false, false, time.Time{},
+		priorityNone, false,
+		0, 0, 0, time.Time{},
 	}
 	tm.SetTorrent(ih, tt)
 	//tm.pendingChan <- tt
@@ -462,6 +546,7 @@ func (tm *TorrentManager) SetTorrent(ih metainfo.Hash, torrent *Torrent) {
 }
 
 func (tm *TorrentManager) Close() error {
+	tm.peerFetcher.Close()
 	close(tm.closeAll)
 	tm.wg.Wait()
 	tm.dropAll()
@@ -548,6 +633,13 @@ func mmapFile(name string) (mm mmap.MMap, err error) {
 }
 
 func (tm *TorrentManager) verifyTorrent(info *metainfo.Info, root string) error {
+	if tm.keys != nil {
+		if d, err := tm.keys.dir(root, false); err != nil {
+			return err
+		} else if d != nil {
+			return verifySealed(info, root, d)
+		}
+	}
 	span := new(mmap_span.MMapSpan)
 	for _, file := range info.UpvertedFiles() {
 		filename := filepath.Join(append([]string{root, info.Name}, file.Path...)...)
@@ -611,7 +703,7 @@ func (tm *TorrentManager) AddTorrent(filePath string, BytesRequested int64) *Tor
 
 	if useExistDir {
 		log.Trace("existing dir", "dir", ExistDir)
-		spec.Storage = storage.NewFile(ExistDir)
+		spec.Storage = tm.newStorage(ExistDir)
 		//for _, tracker := range tm.trackers {
 		//	spec.Trackers = append(spec.Trackers, tracker)
 		//}
@@ -630,7 +722,7 @@ func (tm *TorrentManager) AddTorrent(filePath string, BytesRequested int64) *Tor
 			log.Warn("Create error")
 		}
 	} else {
-		spec.Storage = storage.NewFile(TmpDir)
+		spec.Storage = tm.newStorage(TmpDir)
 		/*for _, tracker := range tm.trackers {
 			spec.Trackers = append(spec.Trackers, tracker)
 		}*/
@@ -673,7 +765,7 @@ func (tm *TorrentManager) AddInfoHash(ih metainfo.Hash, BytesRequested int64) *T
 		Trackers:    [][]string{}, //tm.trackers, //[][]string{},
 		DisplayName: ih.String(),
 		InfoHash:    ih,
-		Storage:     storage.NewFile(dataPath),
+		Storage:     tm.newStorage(dataPath),
 	}
 
 	//for _, tracker := range tm.trackers {
@@ -716,17 +808,141 @@ func (tm *TorrentManager) UpdateInfoHash(ih metainfo.Hash, BytesRequested int64)
 	}*/
 }
 
-// DropInfoHash ...
-/*func (tm *TorrentManager) DropInfoHash(ih metainfo.Hash) bool {
-	if t := tm.GetTorrent(ih); t != nil {
-		t.Torrent.Drop()
-		tm.lock.Lock()
-		delete(tm.torrents, ih)
-		tm.lock.Unlock()
-		return true
+// ResetInfoHash sets the quota of ih to BytesRequested, lowering it when
+// uploads were undone by a chain reorg.
+func (tm *TorrentManager) ResetInfoHash(ih metainfo.Hash, BytesRequested int64) {
+	log.Debug("Reset seed", "InfoHash", ih, "bytes", BytesRequested)
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	tm.bytes[ih] = BytesRequested
+	if t, ok := tm.torrents[ih]; ok && t.bytesRequested > BytesRequested {
+		t.bytesRequested = BytesRequested
+		t.bytesLimitation = tm.GetLimitation(BytesRequested)
 	}
-	return false
-}*/
+}
+
+// DropInfoHash stops seeding ih and forgets about it. The pending, active and
+// seeding loops prune the dropped torrent from their own queues.
+func (tm *TorrentManager) DropInfoHash(ih metainfo.Hash) bool {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	t, ok := tm.torrents[ih]
+	if !ok {
+		return false
+	}
+	t.status = torrentDropped
+	t.Torrent.Drop()
+	delete(tm.torrents, ih)
+	delete(tm.bytes, ih)
+	log.Info("Seed dropped", "hash", ih)
+	return true
+}
+
+// Torrents returns a snapshot of all the torrents known to the manager.
+func (tm *TorrentManager) Torrents() []*Torrent {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	torrents := make([]*Torrent, 0, len(tm.torrents))
+	for _, t := range tm.torrents {
+		torrents = append(torrents, t)
+	}
+	return torrents
+}
+
+// Status copies the state of t into a TorrentStatus under the manager lock.
+// The state follows the queue that holds t, a torrent in none of them is on
+// its way to the next one and counts as pending.
+func (tm *TorrentManager) Status(t *Torrent) *TorrentStatus {
+	ih := t.Torrent.InfoHash()
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	s := &TorrentStatus{
+		InfoHash:       ih,
+		State:          statePending,
+		BytesRequested: uint64(t.bytesRequested),
+		Boosting:       t.isBoosting,
+		Forced:         t.forced,
+		Priority:       t.priority,
+	}
+	if !t.scrubbed.IsZero() {
+		s.Scrubbed = t.scrubbed.Unix()
+	}
+	switch {
+	case t.quarantined:
+		s.State = stateQuarantined
+	case t.held:
+		s.State = statePaused
+	case tm.seedingTorrents[ih] != nil:
+		s.State = stateSeeding
+	case tm.activeTorrents[ih] != nil:
+		s.State = stateActive
+	}
+	return s
+}
+
+// PauseTorrent holds the download of ih until ResumeTorrent is called.
+func (tm *TorrentManager) PauseTorrent(ih metainfo.Hash) error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	t, ok := tm.torrents[ih]
+	if !ok {
+		return errTorrentNotFound
+	}
+	if t.Seeding() {
+		return errors.New("torrent already seeding")
+	}
+	t.held = true
+	log.Info("Seed paused", "hash", ih)
+	return nil
+}
+
+// ResumeTorrent releases a torrent held by PauseTorrent.
+func (tm *TorrentManager) ResumeTorrent(ih metainfo.Hash) error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	t, ok := tm.torrents[ih]
+	if !ok {
+		return errTorrentNotFound
+	}
+	t.held = false
+	log.Info("Seed resumed", "hash", ih)
+	return nil
+}
+
+// VerifyTorrent re-hashes all the pieces of ih in the background.
+func (tm *TorrentManager) VerifyTorrent(ih metainfo.Hash) error {
+	t := tm.GetTorrent(ih)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	if t.Torrent.Info() == nil {
+		return errors.New("torrent metadata not ready")
+	}
+	go func() {
+		log.Info("Seed verifying", "hash", ih)
+		t.Torrent.VerifyData()
+		log.Info("Seed verified", "hash", ih, "complete", common.StorageSize(t.BytesCompleted()))
+	}()
+	return nil
+}
+
+// SeedTorrent fetches the whole of ih no matter how much of it has been paid
+// for, so the node can seed it. Evicted data is fetched again.
+func (tm *TorrentManager) SeedTorrent(ih metainfo.Hash) error {
+	if tm.GetTorrent(ih) == nil && tm.AccessTorrent(ih) {
+		return nil
+	}
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	t, ok := tm.torrents[ih]
+	if !ok {
+		return errTorrentNotFound
+	}
+	t.held = false
+	t.forced = true
+	log.Info("Seed forced", "hash", ih)
+	return nil
+}
 
 //var CurrentTorrentManager *TorrentManager = nil
 
@@ -758,8 +974,21 @@ func NewTorrentManager(config *Config, fsid uint64) *TorrentManager {
 	//cfg.SetListenAddr(listenAddr.String())
 	cfg.HTTPUserAgent = "Cortex"
 	cfg.Seed = true
-	//cfg.EstablishedConnsPerTorrent = 10
-	//cfg.HalfOpenConnsPerTorrent = 5
+	if config.MaxConns > 0 {
+		cfg.EstablishedConnsPerTorrent = config.MaxConns
+	}
+	if config.MaxHalfOpenConns > 0 {
+		cfg.HalfOpenConnsPerTorrent = config.MaxHalfOpenConns
+	}
+	shaping := shapingFromConfig(config)
+	if err := shaping.validate(); err != nil {
+		log.Error("Invalid fs shaping", "err", err)
+		return nil
+	}
+	shaping.MaxConns = cfg.EstablishedConnsPerTorrent
+	shaping.MaxHalfOpenConns = cfg.HalfOpenConnsPerTorrent
+	cfg.UploadRateLimiter = newLimiter(0)
+	cfg.DownloadRateLimiter = newLimiter(0)
 	cfg.ListenPort = config.Port
 	//cfg.DropDuplicatePeerIds = true
 	//cfg.ListenHost = torrent.LoopbackListenHost
@@ -806,12 +1035,49 @@ func NewTorrentManager(config *Config, fsid uint64) *TorrentManager {
 		seedingChan:   make(chan *Torrent, torrentChanSize),
 		activeChan:    make(chan *Torrent, torrentChanSize),
 		pendingChan:   make(chan *Torrent, torrentChanSize),
+		scrubChan:     make(chan *Torrent, torrentChanSize),
+		corruptChan:   make(chan *Torrent, torrentChanSize),
 		//updateTorrent:       make(chan interface{}),
 		fullSeed: config.FullSeed,
 		id:       fsid,
 		//bucket:1024
-		slot: int(fsid % bucket),
+		slot:            int(fsid % bucket),
+		scrubInterval:   time.Duration(config.ScrubInterval) * time.Second,
+		access:          make(map[metainfo.Hash]time.Time),
+		refs:            make(map[metainfo.Hash]uint64),
+		evicted:         make(map[metainfo.Hash]struct{}),
+		maxDiskUsage:    int64(config.MaxDiskUsage) * 1024 * 1024,
+		protectBlocks:   config.ProtectBlocks,
+		evictInterval:   time.Second * defaultEvictInterval,
+		priorities:      make(map[metainfo.Hash]priority),
+		lazy:            config.SyncMode == syncModeLazy,
+		deferred:        make(map[metainfo.Hash]struct{}),
+		shaping:         shaping,
+		uploadLimiter:   cfg.UploadRateLimiter,
+		downloadLimiter: cfg.DownloadRateLimiter,
+		rekeyChan:       make(chan struct{}, 1),
+	}
+	TorrentManager.applyShaping(time.Now())
+
+	if TorrentManager.blocklist, err = NewBlocklist(config); err != nil {
+		log.Error("Invalid blocklist", "err", err)
+		return nil
 	}
+	TorrentManager.blocklist.onBlock = TorrentManager.blockTorrent
+	TorrentManager.blocklist.onUnblock = TorrentManager.unblockTorrent
+
+	if file := config.StorageKeyFile; file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(config.DataDir, file)
+		}
+		if TorrentManager.keys, err = newKeyring(file); err != nil {
+			log.Error("Invalid storage key file", "err", err)
+			return nil
+		}
+		log.Info("Torrent data encrypted at rest", "key", TorrentManager.StorageKey())
+	}
+
+	TorrentManager.peerFetcher = NewPeerDataFetcher(TorrentManager)
 
 	if len(config.DefaultTrackers) > 0 {
 		log.Debug("Tracker list", "trackers", config.DefaultTrackers)
@@ -824,6 +1090,11 @@ func NewTorrentManager(config *Config, fsid uint64) *TorrentManager {
 	return TorrentManager
 }
 
+// Protocols returns the devp2p protocols serving torrent data to node peers.
+func (tm *TorrentManager) Protocols() []p2p.Protocol {
+	return tm.peerFetcher.Protocols()
+}
+
 func (tm *TorrentManager) Start() error {
 	tm.wg.Add(1)
 	go tm.mainLoop()
@@ -833,21 +1104,80 @@ func (tm *TorrentManager) Start() error {
 	go tm.activeTorrentLoop()
 	tm.wg.Add(1)
 	go tm.seedingTorrentLoop()
+	tm.wg.Add(1)
+	go tm.scrubLoop()
+	tm.wg.Add(1)
+	go func() {
+		defer tm.wg.Done()
+		if err := tm.blocklist.Reload(); err != nil {
+			log.Warn("Blocklist not loaded", "err", err)
+		}
+		tm.blocklist.loop(tm.closeAll)
+	}()
+	if tm.keys != nil {
+		tm.wg.Add(1)
+		go tm.rekeyLoop()
+	}
 
 	return nil
 }
 
 func (tm *TorrentManager) seedingTorrentLoop() {
 	defer tm.wg.Done()
+	evictTicker := time.NewTicker(tm.evictInterval)
+	defer evictTicker.Stop()
+	shapeTicker := time.NewTicker(shapingInterval)
+	defer shapeTicker.Stop()
 	for {
 		select {
 		case t := <-tm.seedingChan:
+			if t.Dropped() {
+				continue
+			}
+			tm.lock.Lock()
 			tm.seedingTorrents[t.Torrent.InfoHash()] = t
+			tm.lock.Unlock()
 			t.Seed()
 			//log.Info("All seed status", "current", len(tm.seedingTorrents), "max", tm.maxSeedTask)
 			if len(tm.seedingTorrents) > tm.maxSeedTask {
 				tm.seedingTask()
 			}
+			if tm.Quarantined(t) {
+				// Fetched again, verify before serving it
+				select {
+				case tm.scrubChan <- t:
+				default:
+				}
+			}
+		case t := <-tm.corruptChan:
+			if t.Dropped() {
+				continue
+			}
+			tm.lock.Lock()
+			delete(tm.seedingTorrents, t.Torrent.InfoHash())
+			tm.lock.Unlock()
+			t.Pause()
+			log.Info("A <- S", "hash", t.Torrent.InfoHash())
+			tm.activeChan <- t
+		case <-evictTicker.C:
+			tm.enforceQuota()
+		case now := <-shapeTicker.C:
+			// The off-peak window opens and closes here
+			tm.applyShaping(now)
+			shaping := tm.activeShaping(now)
+			for _, t := range tm.seedingTorrents {
+				if t.Dropped() {
+					tm.lock.Lock()
+					delete(tm.seedingTorrents, t.Torrent.InfoHash())
+					tm.lock.Unlock()
+					continue
+				}
+				t.shape(&shaping, now)
+				if conns := t.seedConns(); t.status == torrentSeeding && conns != t.currentConns {
+					t.currentConns = conns
+					t.Torrent.SetMaxEstablishedConns(conns)
+				}
+			}
 		case <-tm.closeAll:
 			log.Info("Seeding loop closed")
 			return
@@ -867,15 +1197,48 @@ func (tm *TorrentManager) mainLoop() {
 		select {
 		case msg := <-tm.updateTorrent:
 			meta := msg.(FlowControlMeta)
-			if _, ok := BadFiles[meta.InfoHash.HexString()]; ok {
+			if tm.blocklist.Blocked(meta.InfoHash) {
+				// Set aside, in case it gets unblocked
+				if meta.IsCreate {
+					tm.deferTorrent(meta.InfoHash, int64(meta.BytesRequested))
+				}
 				continue
 			}
 
+			if meta.IsDrop {
+				tm.DropInfoHash(meta.InfoHash)
+				continue
+			}
+
+			if meta.IsReset {
+				tm.ResetInfoHash(meta.InfoHash, int64(meta.BytesRequested))
+				continue
+			}
+
+			if meta.BlockNumber > 0 {
+				tm.reference(meta.InfoHash, meta.BlockNumber)
+			}
+
 			if meta.IsCreate {
+				tm.lock.Lock()
+				evicted := tm.isEvicted(meta.InfoHash)
+				tm.lock.Unlock()
+				if evicted {
+					// Fetched again once requested
+					tm.UpdateInfoHash(meta.InfoHash, int64(meta.BytesRequested))
+					continue
+				}
+				if tm.lazy && !meta.IsFetch && tm.deferTorrent(meta.InfoHash, int64(meta.BytesRequested)) {
+					log.Debug("Seed [create] deferred", "hash", meta.InfoHash, "request", meta.BytesRequested)
+					continue
+				}
 				counter := 0
 				for {
 					if t := tm.AddInfoHash(meta.InfoHash, int64(meta.BytesRequested)); t != nil {
 						log.Debug("Seed [create] success", "hash", meta.InfoHash, "request", meta.BytesRequested)
+						if meta.IsFetch {
+							tm.undefer(meta.InfoHash)
+						}
 						if int64(meta.BytesRequested) > 0 {
 							tm.UpdateInfoHash(meta.InfoHash, int64(meta.BytesRequested))
 						}
@@ -923,14 +1286,25 @@ func (tm *TorrentManager) pendingTorrentLoop() {
 	for {
 		select {
 		case t := <-tm.pendingChan:
+			tm.lock.Lock()
 			tm.pendingTorrents[t.Torrent.InfoHash()] = t
+			tm.lock.Unlock()
 		case <-timer.C:
 			for _, t := range tm.pendingTorrents {
 				ih := t.Torrent.InfoHash()
-				if _, ok := BadFiles[t.infohash]; ok {
+				if t.Dropped() {
+					tm.lock.Lock()
+					delete(tm.pendingTorrents, ih)
+					tm.lock.Unlock()
+					continue
+				}
+				if tm.blocklist.Blocked(ih) {
 					continue
 				}
 				t.loop += 1
+				tm.lock.RLock()
+				urgent := tm.priority(ih) > priorityNone
+				tm.lock.RUnlock()
 				if t.Torrent.Info() != nil {
 					if t.start == 0 {
 						log.Info("A <- P (UDP)", "hash", ih, "pieces", t.Torrent.NumPieces())
@@ -940,7 +1314,9 @@ func (tm *TorrentManager) pendingTorrentLoop() {
 						log.Info("A <- P", "hash", ih, "pieces", t.Torrent.NumPieces(), "elapsed", time.Duration(mclock.Now())-time.Duration(t.start))
 					}
 					if err := t.WriteTorrent(); err == nil {
+						tm.lock.Lock()
 						delete(tm.pendingTorrents, ih)
+						tm.lock.Unlock()
 						t.loop = 0
 						/*if t.start == 0 {
 							log.Info("A <- P (UDP)", "hash", ih, "pieces", t.Torrent.NumPieces())
@@ -952,14 +1328,17 @@ func (tm *TorrentManager) pendingTorrentLoop() {
 						//t.start = mclock.Now()
 						tm.activeChan <- t
 					}
-				} else if t.loop > torrentWaitingTime/queryTimeInterval {
-					if !t.isBoosting {
+				} else if t.loop > torrentWaitingTime/queryTimeInterval || (urgent && t.loop > urgentWaitingTime/queryTimeInterval) {
+					if tm.boost(t) {
 						t.loop = 0
-						t.isBoosting = true
 						go func(t *Torrent) {
-							defer t.BoostOff()
+							defer tm.boostOff(t)
 							log.Info("Try to boost seed", "hash", t.infohash)
-							if data, err := tm.boostFetcher.GetTorrent(t.infohash); err == nil {
+							data, err := tm.peerFetcher.GetTorrent(ih)
+							if err != nil {
+								data, err = tm.boostFetcher.GetTorrent(t.infohash)
+							}
+							if err == nil {
 								if t.Torrent.Info() != nil {
 									log.Warn("Seed already exist", "hash", t.infohash)
 									return
@@ -981,7 +1360,7 @@ func (tm *TorrentManager) pendingTorrentLoop() {
 				} else {
 					//if (tm.bytes[ih] > 0 && t.start == 0) || (t.start == 0 && t.loop > 60) {
 					//if (tm.bytes[ih] > 0 && t.start == 0) || (t.start == 0 && tm.fullSeed) || (t.start == 0 && t.loop > 1800) {
-					if t.start == 0 && (tm.bytes[ih] > 0 || tm.fullSeed || t.loop > 600) { //|| len(tm.pendingTorrents) == 1) {
+					if t.start == 0 && (tm.bytes[ih] > 0 || t.forced || tm.fullSeed || urgent || t.loop > 600) { //|| len(tm.pendingTorrents) == 1) {
 						t.AddTrackers(tm.trackers)
 						t.start = mclock.Now()
 					}
@@ -1004,22 +1383,40 @@ func (tm *TorrentManager) activeTorrentLoop() {
 		counter++
 		select {
 		case t := <-tm.activeChan:
+			tm.lock.Lock()
 			tm.activeTorrents[t.Torrent.InfoHash()] = t
+			tm.lock.Unlock()
 		case <-timer.C:
+			tm.lock.Lock()
 			for _, t := range tm.torrents {
 				t.weight = 1 + int(t.cited*10/maxCited)
 			}
+			tm.lock.Unlock()
 			log_counter++
+			now := time.Now()
+			shaping := tm.activeShaping(now)
 			var active_paused, active_wait, active_boost, active_running int
 			//var activeTorrents []*Torrent
+			var runnable []*Torrent
 
 			for _, t := range tm.activeTorrents {
 				ih := t.Torrent.InfoHash()
+				if t.Dropped() {
+					tm.lock.Lock()
+					delete(tm.activeTorrents, ih)
+					tm.lock.Unlock()
+					continue
+				}
 				BytesRequested := int64(0)
-				tm.lock.RLock()
+				tm.lock.Lock()
+				held, quota := t.held, tm.bytes[ih]
+				t.priority = tm.priority(ih)
+				if t.forced && quota < t.Length() {
+					quota = t.Length()
+				}
 				if tm.fullSeed {
-					if tm.bytes[ih] >= t.Length() {
-						BytesRequested = tm.bytes[ih]
+					if quota >= t.Length() {
+						BytesRequested = quota
 						t.fast = true
 					} else {
 						if t.bytesRequested <= t.BytesCompleted() {
@@ -1028,34 +1425,28 @@ func (tm *TorrentManager) activeTorrentLoop() {
 						}
 					}
 				} else {
-					if tm.bytes[ih] >= t.Length() {
-						BytesRequested = tm.bytes[ih]
+					if quota >= t.Length() {
+						BytesRequested = quota
 						t.fast = true
 					} else {
 						if t.bytesRequested <= t.BytesCompleted() {
-							BytesRequested = int64(math.Min(float64(tm.bytes[ih]), float64(t.bytesRequested+block)))
+							BytesRequested = int64(math.Min(float64(quota), float64(t.bytesRequested+block)))
 							t.fast = false
 						}
 					}
 				}
-				tm.lock.RUnlock()
-
-				if t.bytesRequested < BytesRequested {
+				if !held && t.bytesRequested < BytesRequested {
 					t.bytesRequested = BytesRequested
 					t.bytesLimitation = tm.GetLimitation(BytesRequested)
-				} else {
-					/*if t.bytesRequested == 0 {
-						active_wait += 1
-						continue
-					}
-					if t.BytesMissing() > 0 {
-						active_running += 1
-						if log_counter%20 == 0 {
-							log.Info("[Downloading]", "hash", ih.String(), "complete", common.StorageSize(t.BytesCompleted()), "request", common.StorageSize(t.bytesRequested), "quota", common.StorageSize(tm.bytes[ih]), "total", common.StorageSize(t.Torrent.Length()), "prog", math.Min(float64(t.bytesCompleted), float64(t.bytesRequested))/float64(t.bytesCompleted+t.bytesMissing), "seg", len(t.Torrent.PieceStateRuns()), "conn", t.currentConns, "max", t.Torrent.NumPieces(), "status", t.status)
-						}
-						continue
-					}*/
 				}
+				tm.lock.Unlock()
+
+				if held {
+					t.Pause()
+					active_paused += 1
+					continue
+				}
+				t.shape(&shaping, now)
 
 				if t.bytesRequested == 0 {
 					active_wait += 1
@@ -1072,6 +1463,7 @@ func (tm *TorrentManager) activeTorrentLoop() {
 
 				if t.Finished() {
 					tm.lock.Lock()
+					delete(tm.priorities, ih)
 					if _, err := os.Stat(path.Join(tm.DataDir, t.InfoHash())); err == nil {
 						if len(tm.seedingChan) < cap(tm.seedingChan) {
 							log.Debug("Path exist", "hash", t.Torrent.InfoHash(), "path", path.Join(tm.DataDir, t.InfoHash()))
@@ -1110,20 +1502,28 @@ func (tm *TorrentManager) activeTorrentLoop() {
 					t.Pause()
 					active_paused += 1
 					if log_counter%20 == 0 {
-						log.Info("[Pausing]", "hash", ih.String(), "complete", common.StorageSize(t.bytesCompleted), "quota", common.StorageSize(t.bytesRequested), "total", common.StorageSize(t.bytesMissing+t.bytesCompleted), "prog", math.Min(float64(t.bytesCompleted), float64(t.bytesRequested))/float64(t.bytesCompleted+t.bytesMissing), "seg", len(t.Torrent.PieceStateRuns()), "conn", t.currentConns, "max", t.Torrent.NumPieces(), "status", t.status, "boost", t.isBoosting)
+						log.Info("[Pausing]", "hash", ih.String(), "complete", common.StorageSize(t.bytesCompleted), "quota", common.StorageSize(t.bytesRequested), "total", common.StorageSize(t.bytesMissing+t.bytesCompleted), "prog", math.Min(float64(t.bytesCompleted), float64(t.bytesRequested))/float64(t.bytesCompleted+t.bytesMissing), "seg", len(t.Torrent.PieceStateRuns()), "conn", t.currentConns, "max", t.Torrent.NumPieces(), "status", t.status, "boost", tm.boosting(t))
 					}
 					continue
 				} else if t.bytesRequested >= t.bytesCompleted+t.bytesMissing {
 					t.loop += 1
-					if t.loop > downloadWaitingTime/queryTimeInterval && t.bytesCompleted*2 < t.bytesRequested {
+					waiting := downloadWaitingTime
+					if t.priority > priorityNone {
+						waiting = urgentWaitingTime
+					}
+					if t.loop > waiting/queryTimeInterval && t.bytesCompleted*2 < t.bytesRequested {
 						t.loop = 0
-						if t.isBoosting {
+						if !tm.boost(t) {
 							continue
 						}
 						t.Pause()
-						t.isBoosting = true
 						go func(t *Torrent) {
-							defer t.BoostOff()
+							defer tm.boostOff(t)
+							limit := int((t.bytesRequested*int64(t.Torrent.NumPieces()) + t.Length() - 1) / t.Length())
+							if n, err := tm.peerFetcher.FetchPieces(t, limit); err == nil && n > 0 {
+								log.Info("Boosted from peers", "hash", ih, "pieces", n)
+								return
+							}
 							filepaths := []string{}
 							filedatas := [][]byte{}
 							for _, file := range t.Files() {
@@ -1143,7 +1543,7 @@ func (tm *TorrentManager) activeTorrentLoop() {
 						}(t)
 						active_boost += 1
 						if log_counter%20 == 0 {
-							log.Info("[Boosting]", "hash", ih.String(), "complete", common.StorageSize(t.bytesCompleted), "quota", common.StorageSize(t.bytesRequested), "total", common.StorageSize(t.bytesMissing+t.bytesCompleted), "prog", math.Min(float64(t.bytesCompleted), float64(t.bytesRequested))/float64(t.bytesCompleted+t.bytesMissing), "seg", len(t.Torrent.PieceStateRuns()), "max", t.Torrent.NumPieces(), "status", t.status, "boost", t.isBoosting)
+							log.Info("[Boosting]", "hash", ih.String(), "complete", common.StorageSize(t.bytesCompleted), "quota", common.StorageSize(t.bytesRequested), "total", common.StorageSize(t.bytesMissing+t.bytesCompleted), "prog", math.Min(float64(t.bytesCompleted), float64(t.bytesRequested))/float64(t.bytesCompleted+t.bytesMissing), "seg", len(t.Torrent.PieceStateRuns()), "max", t.Torrent.NumPieces(), "status", t.status, "boost", tm.boosting(t))
 						}
 						continue
 					}
@@ -1153,12 +1553,14 @@ func (tm *TorrentManager) activeTorrentLoop() {
 					log.Info("[Downloading]", "hash", ih.String(), "complete", common.StorageSize(t.bytesCompleted), "request", common.StorageSize(t.bytesRequested), "quota", common.StorageSize(tm.bytes[ih]), "total", common.StorageSize(t.Torrent.Length()), "prog", math.Min(float64(t.bytesCompleted), float64(t.bytesRequested))/float64(t.bytesCompleted+t.bytesMissing), "seg", len(t.Torrent.PieceStateRuns()), "conn", t.currentConns, "max", t.Torrent.NumPieces(), "status", t.status)
 				}
 
-				if t.bytesCompleted < t.bytesLimitation && !t.isBoosting {
+				if t.bytesCompleted < t.bytesLimitation && !tm.boosting(t) {
 					//activeTorrents = append(activeTorrents, t)
-					t.Run(tm.slot)
-					active_running += 1
+					runnable = append(runnable, t)
 				}
 			}
+			running, paused := tm.schedule(runnable)
+			active_running += running
+			active_paused += paused
 
 			/*if len(activeTorrents) <= tm.maxActiveTask {
 				for _, t := range activeTorrents {
@@ -1188,7 +1590,8 @@ func (tm *TorrentManager) activeTorrentLoop() {
 				//for _, ttt := range tm.client.Torrents() {
 				//	all += len(ttt.KnownSwarm())
 				//}
-				log.Info("Fs status", "pending", len(tm.pendingTorrents), "active", len(tm.activeTorrents), "wait", active_wait, "downloading", active_running, "paused", active_paused, "boost", active_boost, "seeding", len(tm.seedingTorrents), "size", common.StorageSize(total_size), "speed_a", common.StorageSize(total_size/log_counter*queryTimeInterval).String()+"/s", "speed_b", common.StorageSize(current_size/counter*queryTimeInterval).String()+"/s", "channel", len(tm.updateTorrent), "slot", tm.slot)
+				usage, quota, evicted := tm.DiskUsage()
+				log.Info("Fs status", "pending", len(tm.pendingTorrents), "active", len(tm.activeTorrents), "wait", active_wait, "downloading", active_running, "paused", active_paused, "boost", active_boost, "seeding", len(tm.seedingTorrents), "size", common.StorageSize(total_size), "speed_a", common.StorageSize(total_size/log_counter*queryTimeInterval).String()+"/s", "speed_b", common.StorageSize(current_size/counter*queryTimeInterval).String()+"/s", "channel", len(tm.updateTorrent), "slot", tm.slot, "usage", common.StorageSize(usage), "quota", common.StorageSize(quota), "evicted", evicted)
 				/*tmp := make(map[common.Hash]int)
 				sum := 0
 				for _, ttt := range tm.client.Torrents() {
@@ -1237,7 +1640,13 @@ func (tm *TorrentManager) seedingTask() error {
 	//} else {
 	var totalWeight int = 0
 	var nSeedTask int = tm.maxSeedTask
-	for _, t := range tm.seedingTorrents {
+	for ih, t := range tm.seedingTorrents {
+		if t.Dropped() {
+			tm.lock.Lock()
+			delete(tm.seedingTorrents, ih)
+			tm.lock.Unlock()
+			continue
+		}
 		if t.loop == 0 {
 			totalWeight += t.weight
 		} else if t.status == torrentSeeding {
@@ -7,9 +7,8 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/CortexFoundation/CortexTheseus/rpc"
 	"github.com/anacrolix/torrent/metainfo"
-	"io/ioutil"
-	"path"
 	"sync"
+	"time"
 	//"strings"
 	"errors"
 	"github.com/CortexFoundation/CortexTheseus/common/compress"
@@ -21,6 +20,12 @@ type CVMStorage interface {
 	GetFile(infohash string, path string) ([]byte, error)
 	Stop() error
 }
+
+// LazyStorage is a CVMStorage downloading the data only once requested.
+type LazyStorage interface {
+	CVMStorage
+	Fetch(infohash string) error
+}
 type GeneralMessage struct {
 	Version string `json:"version,omitempty"`
 	Commit  string `json:"commit,omitempty"`
@@ -31,6 +36,7 @@ type TorrentFS struct {
 	config  *Config
 	history *GeneralMessage
 	monitor *Monitor
+	boost   *BoostServer
 
 	fileLock  sync.Mutex
 	fileCache *lru.Cache
@@ -55,10 +61,41 @@ var torrentInstance *TorrentFS = nil
 //	return torrentInstance
 //}
 
+// cvmStorage replaces the torrent client as the data source of the CVM when
+// another storage is configured.
+var cvmStorage CVMStorage
+
 func GetStorage() CVMStorage {
+	if cvmStorage != nil {
+		return cvmStorage
+	}
 	return torrentInstance //GetTorrentInstance()
 }
 
+// CreateStorage creates the CVM storage of the given type: "simple" or "dir"
+// for a local directory, "s3" for an S3 compatible bucket, and the torrent
+// file system otherwise.
+func CreateStorage(storageType string, config Config) CVMStorage {
+	switch storageType {
+	case "simple", "dir":
+		return NewDirStorage(config.DataDir)
+	case "s3":
+		s, err := NewS3Storage(&config)
+		if err != nil {
+			log.Error("Failed to create s3 storage", "err", err)
+			return nil
+		}
+		return s
+	default:
+		fs, err := New(&config, "")
+		if err != nil {
+			log.Error("Failed to create fs storage", "err", err)
+			return nil
+		}
+		return fs
+	}
+}
+
 func GetConfig() *Config {
 	if torrentInstance != nil {
 		return torrentInstance.Config()
@@ -77,6 +114,29 @@ func New(config *Config, commit string) (*TorrentFS, error) {
 		return torrentInstance, nil
 	}
 
+	switch config.Storage {
+	case "", "torrent":
+	case "dir", "s3":
+		if cvmStorage = CreateStorage(config.Storage, *config); cvmStorage == nil {
+			return nil, fmt.Errorf("invalid %s storage", config.Storage)
+		}
+		log.Info("Fs cvm storage", "type", config.Storage)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", config.Storage)
+	}
+
+	fs, err := newTorrentFS(config, commit)
+	if err != nil {
+		return nil, err
+	}
+	torrentInstance = fs
+	//Torrentfs_handle = torrentInstance
+	return torrentInstance, nil
+}
+
+// newTorrentFS creates a torrentfs instance apart from the one New shares
+// with the CVM, e.g. one of the several nodes of a test swarm.
+func newTorrentFS(config *Config, commit string) (*TorrentFS, error) {
 	//versionMeta := ""
 	//TorrentAPIAvailable.Lock()
 	//if len(params.VersionMeta) > 0 {
@@ -90,30 +150,64 @@ func New(config *Config, commit string) (*TorrentFS, error) {
 
 	log.Info("Fs version info", "version", msg.Version)
 
+	switch config.SyncMode {
+	case "", syncModeFull, syncModeLazy:
+	default:
+		return nil, fmt.Errorf("unknown sync mode %q", config.SyncMode)
+	}
+
 	monitor, moErr := NewMonitor(config)
 	if moErr != nil {
 		log.Error("Failed create monitor")
 		return nil, moErr
 	}
 
-	torrentInstance = &TorrentFS{
+	var boost *BoostServer
+	if config.BoostAddr != "" {
+		var err error
+		if boost, err = NewBoostServer(config, monitor.dl); err != nil {
+			return nil, err
+		}
+	}
+
+	fs := &TorrentFS{
 		config:  config,
 		history: msg,
 		monitor: monitor,
+		boost:   boost,
 	}
-	torrentInstance.fileCache, _ = lru.New(8)
-	torrentInstance.fileCh = make(chan bool, 4)
-	//Torrentfs_handle = torrentInstance
-	//torrentInstance.compress = true
+	fs.fileCache, _ = lru.New(8)
+	fs.fileCh = make(chan bool, 4)
+	//fs.compress = true
 
-	return torrentInstance, nil
+	return fs, nil
+}
+
+// SetChainBackend makes the monitor read the chain from b instead of over
+// RPC. It must be called before Start.
+func (tfs *TorrentFS) SetChainBackend(b ChainBackend) {
+	tfs.monitor.backend = b
 }
 
 // Protocols implements the node.Service interface.
-func (tfs *TorrentFS) Protocols() []p2p.Protocol { return nil }
+func (tfs *TorrentFS) Protocols() []p2p.Protocol {
+	if tfs == nil || tfs.monitor == nil {
+		return nil
+	}
+	return tfs.monitor.dl.Protocols()
+}
 
 // APIs implements the node.Service interface.
-func (tfs *TorrentFS) APIs() []rpc.API { return nil }
+func (tfs *TorrentFS) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "torrent",
+			Version:   "1.0",
+			Service:   NewPrivateTorrentAPI(tfs),
+			Public:    false,
+		},
+	}
+}
 
 // Start starts the data collection thread and the listening server of the dashboard.
 // Implements the node.Service interface.
@@ -122,7 +216,13 @@ func (tfs *TorrentFS) Start(server *p2p.Server) error {
 	if tfs == nil || tfs.monitor == nil {
 		return nil
 	}
-	return tfs.monitor.Start()
+	if err := tfs.monitor.Start(); err != nil {
+		return err
+	}
+	if tfs.boost != nil {
+		return tfs.boost.Start()
+	}
+	return nil
 }
 
 // Stop stops the data collection thread and the connection listener of the dashboard.
@@ -131,6 +231,9 @@ func (tfs *TorrentFS) Stop() error {
 	if tfs == nil || tfs.monitor == nil {
 		return nil
 	}
+	if tfs.boost != nil {
+		tfs.boost.Stop()
+	}
 	// Wait until every goroutine terminates.
 	tfs.monitor.Stop()
 	return nil
@@ -148,24 +251,54 @@ func (fs *TorrentFS) Available(infohash string, rawSize int64) (bool, error) {
 	//}
 	ih := metainfo.NewHashFromHex(infohash)
 	tm := fs.monitor.dl //CurrentTorrentManager
+	if tm.Blocklist().Blocked(ih) {
+		log.Debug("Blocked seed requested", "hash", infohash)
+		return false, errBlocked
+	}
 	//log.Debug("storage", "ih", ih)
 	if torrent := tm.GetTorrent(ih); torrent == nil {
 		//log.Debug("storage", "ih", ih, "torrent", torrent)
 		log.Debug("Seed not found", "hash", infohash)
+		fs.request(ih)
 		return false, errors.New("download not completed")
 	} else {
-		if !torrent.IsAvailable() {
+		if !tm.Available(torrent) {
 			log.Debug("[Not available] Download not completed", "hash", infohash, "raw", rawSize, "complete", torrent.BytesCompleted())
+			fs.request(ih)
 			return false, fmt.Errorf("download not completed: %d %d", torrent.BytesCompleted(), rawSize)
 		}
+		tm.AccessTorrent(ih)
 		//log.Debug("storage", "Available", torrent.IsAvailable(), "torrent.BytesCompleted()", torrent.BytesCompleted(), "rawSize", rawSize)
 		//log.Info("download not completed", "complete", torrent.BytesCompleted(), "miss", torrent.BytesMissing(), "raw", rawSize)
 		return torrent.BytesCompleted() <= rawSize, nil
 	}
 }
 
+// request starts fetching the missing data of ih for the block processing
+// asking for it, at block priority. The data deferred in lazy sync mode or
+// evicted is fetched again; the block fails with a runtime error meanwhile,
+// and is imported again once the data is stored.
+func (fs *TorrentFS) request(ih metainfo.Hash) {
+	tm := fs.monitor.dl
+	if tm.AccessTorrent(ih) {
+		log.Debug("Seed requested by block processing", "hash", ih)
+	}
+	tm.PrioritizeTorrent(ih, priorityBlock)
+}
+
+// Fetch waits a while for the data of infohash in lazy sync mode, as it is
+// only downloaded once requested. Available and GetFile never wait, so that
+// block processing is not held by the download; only rpc calls fetch first.
+func (fs *TorrentFS) Fetch(infohash string) error {
+	if fs.config.SyncMode != syncModeLazy {
+		return nil
+	}
+	timeout := time.Duration(fs.config.LazyTimeout) * time.Second
+	return fs.monitor.dl.FetchTorrent(metainfo.NewHashFromHex(infohash), priorityRPC, timeout)
+}
+
 func (fs *TorrentFS) release() {
-	<-torrentInstance.fileCh
+	<-fs.fileCh
 }
 
 func (fs *TorrentFS) unzip(data []byte, c bool) ([]byte, error) {
@@ -187,16 +320,20 @@ func (fs *TorrentFS) zip(data []byte, c bool) ([]byte, error) {
 func (fs *TorrentFS) GetFile(infohash string, subpath string) ([]byte, error) {
 	ih := metainfo.NewHashFromHex(infohash)
 	tm := fs.monitor.dl //CurrentTorrentManager
+	if tm.Blocklist().Blocked(ih) {
+		log.Debug("Blocked seed requested", "hash", infohash)
+		return nil, errBlocked
+	}
 	if torrent := tm.GetTorrent(ih); torrent == nil {
 		log.Debug("Torrent not found", "hash", infohash)
 		return nil, errors.New("download not completed")
 	} else {
 
-		if !torrent.IsAvailable() {
+		if !tm.Available(torrent) {
 			log.Error("Read unavailable file", "hash", infohash, "subpath", subpath)
 			return nil, errors.New("download not completed")
 		}
-		torrentInstance.fileCh <- true
+		fs.fileCh <- true
 		defer fs.release()
 		var key = infohash + subpath
 
@@ -214,8 +351,7 @@ func (fs *TorrentFS) GetFile(infohash string, subpath string) ([]byte, error) {
 
 		fs.fileLock.Lock()
 		defer fs.fileLock.Unlock()
-		fn := path.Join(fs.config.DataDir, infohash, subpath)
-		data, err := ioutil.ReadFile(fn)
+		data, err := tm.ReadFile(ih, subpath)
 		for _, file := range torrent.Files() {
 			log.Debug("File path info", "path", file.Path(), "subpath", subpath)
 			if file.Path() == subpath[1:] {
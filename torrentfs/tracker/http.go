@@ -0,0 +1,190 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/CortexFoundation/CortexTheseus/metrics/exp"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func (t *Tracker) startHTTP() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/announce", t.handleAnnounce)
+	mux.HandleFunc("/scrape", t.handleScrape)
+	mux.HandleFunc("/stats.json", t.handleStats)
+	mux.HandleFunc("/health", t.handleHealth)
+	mux.Handle("/debug/metrics", exp.ExpHandler(metrics.DefaultRegistry))
+	for _, addr := range t.config.HTTPAddrs {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		t.http = append(t.http, srv)
+		t.listeners = append(t.listeners, listener)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			srv.Serve(listener)
+		}()
+		log.Info("HTTP tracker started", "addr", listener.Addr())
+	}
+	return nil
+}
+
+func (t *Tracker) fail(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(bencode.MustMarshal(map[string]interface{}{"failure reason": reason}))
+}
+
+// remoteIP returns the address of the client, the one the peer listens on.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (t *Tracker) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	httpAnnounceMeter.Mark(1)
+	q := r.URL.Query()
+	ihs := q.Get("info_hash")
+	if len(ihs) != metainfo.HashSize {
+		t.fail(w, "invalid info_hash")
+		return
+	}
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		t.fail(w, "invalid port")
+		return
+	}
+	left, err := strconv.ParseInt(q.Get("left"), 10, 64)
+	if err != nil {
+		left = -1
+	}
+	numWant := -1
+	if s := q.Get("numwant"); s != "" {
+		if numWant, err = strconv.Atoi(s); err != nil {
+			numWant = -1
+		}
+	}
+	ip := remoteIP(r)
+	if ip == nil {
+		t.fail(w, "invalid address")
+		return
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	var ih metainfo.Hash
+	copy(ih[:], ihs)
+
+	peers, seeders, leechers, err := t.announce(ih, &peer{ip, uint16(port), left, time.Now()}, q.Get("event"), numWant)
+	if err != nil {
+		t.fail(w, err.Error())
+		return
+	}
+	resp := map[string]interface{}{
+		"interval":   int64(t.config.Interval / time.Second),
+		"complete":   seeders,
+		"incomplete": leechers,
+	}
+	if q.Get("compact") != "0" {
+		var compact []byte
+		for _, p := range peers {
+			compact = append(compact, compactPeer(p)...)
+		}
+		if ip.To4() != nil {
+			resp["peers"] = string(compact)
+		} else {
+			resp["peers"] = ""
+			resp["peers6"] = string(compact)
+		}
+	} else {
+		list := make([]interface{}, 0, len(peers))
+		for _, p := range peers {
+			list = append(list, map[string]interface{}{"ip": p.ip.String(), "port": p.port})
+		}
+		resp["peers"] = list
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(bencode.MustMarshal(resp))
+}
+
+func (t *Tracker) handleScrape(w http.ResponseWriter, r *http.Request) {
+	files := make(map[string]interface{})
+	for _, ihs := range r.URL.Query()["info_hash"] {
+		if len(ihs) != metainfo.HashSize {
+			continue
+		}
+		var ih metainfo.Hash
+		copy(ih[:], ihs)
+		seeders, completed, leechers := t.scrape(ih)
+		files[ihs] = map[string]interface{}{
+			"complete":   seeders,
+			"downloaded": completed,
+			"incomplete": leechers,
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(bencode.MustMarshal(map[string]interface{}{"files": files}))
+}
+
+func (t *Tracker) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Stats())
+}
+
+func (t *Tracker) handleHealth(w http.ResponseWriter, r *http.Request) {
+	stats := t.Stats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "ok",
+		"uptime":   int64(time.Since(t.start) / time.Second),
+		"torrents": stats.Torrents,
+		"peers":    stats.PeersAll,
+	})
+}
+
+// compactPeer encodes p as its IP followed by its port in network order, 6
+// bytes for IPv4 and 18 for IPv6.
+func compactPeer(p *peer) []byte {
+	ip := p.ip.To4()
+	if ip == nil {
+		ip = p.ip.To16()
+	}
+	b := make([]byte, len(ip)+2)
+	copy(b, ip)
+	binary.BigEndian.PutUint16(b[len(ip):], p.port)
+	return b
+}
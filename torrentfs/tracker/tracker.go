@@ -0,0 +1,328 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tracker implements a BitTorrent tracker speaking HTTP and UDP
+// (BEP 15), keeping its swarms in memory.
+package tracker
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var (
+	httpAnnounceMeter = metrics.NewRegisteredMeter("tracker/announce/http", nil)
+	udpAnnounceMeter  = metrics.NewRegisteredMeter("tracker/announce/udp", nil)
+	scrapeMeter       = metrics.NewRegisteredMeter("tracker/scrape", nil)
+	rejectedMeter     = metrics.NewRegisteredMeter("tracker/rejected", nil)
+	torrentsGauge     = metrics.NewRegisteredGauge("tracker/torrents", nil)
+	peersGauge        = metrics.NewRegisteredGauge("tracker/peers", nil)
+)
+
+var errUnknownTorrent = errors.New("unknown torrent")
+
+// Config are the settings of a tracker.
+type Config struct {
+	HTTPAddrs []string // Listening addresses of the HTTP tracker
+	UDPAddrs  []string // Listening addresses of the UDP tracker
+
+	Interval time.Duration // Announce interval asked to the peers
+	Expiry   time.Duration // Peers silent for longer are dropped
+	NumWant  int           // Peers returned when the announce does not say
+
+	// Filter restricts the tracked torrents to those it accepts, e.g. the
+	// ones created on chain. All are tracked when nil.
+	Filter func(ih metainfo.Hash) bool
+}
+
+// DefaultConfig contains the default tracker settings.
+var DefaultConfig = Config{
+	Interval: 10 * time.Minute,
+	Expiry:   25 * time.Minute,
+	NumWant:  50,
+}
+
+const maxNumWant = 200
+
+type peer struct {
+	ip   net.IP
+	port uint16
+	left int64
+	seen time.Time
+}
+
+type swarm struct {
+	peers     map[string]*peer // keyed by ip:port
+	seeders   int
+	completed int
+}
+
+// Tracker keeps the swarms announced over HTTP and UDP.
+type Tracker struct {
+	config Config
+	start  time.Time
+
+	lock   sync.RWMutex
+	swarms map[metainfo.Hash]*swarm
+
+	http      []*http.Server
+	listeners []net.Listener // of the HTTP servers
+	udp       []net.PacketConn
+	secret    []byte
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a tracker with the given configuration.
+func New(config Config) *Tracker {
+	if config.Interval <= 0 {
+		config.Interval = DefaultConfig.Interval
+	}
+	if config.Expiry <= 0 {
+		config.Expiry = DefaultConfig.Expiry
+	}
+	if config.NumWant <= 0 {
+		config.NumWant = DefaultConfig.NumWant
+	}
+	return &Tracker{
+		config: config,
+		swarms: make(map[metainfo.Hash]*swarm),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Start opens the listeners and starts serving.
+func (t *Tracker) Start() error {
+	t.start = time.Now()
+	if err := t.startUDP(); err != nil {
+		t.Stop()
+		return err
+	}
+	if err := t.startHTTP(); err != nil {
+		t.Stop()
+		return err
+	}
+	t.wg.Add(1)
+	go t.expireLoop()
+	return nil
+}
+
+// Stop closes the listeners and waits for the serving goroutines.
+func (t *Tracker) Stop() {
+	select {
+	case <-t.quit:
+		return
+	default:
+	}
+	close(t.quit)
+	for _, srv := range t.http {
+		srv.Close()
+	}
+	for _, conn := range t.udp {
+		conn.Close()
+	}
+	t.wg.Wait()
+	log.Info("Tracker stopped")
+}
+
+// URLs returns the announce URLs of the started listeners, HTTP first. They
+// tell the actual ports of listening addresses with port 0.
+func (t *Tracker) URLs() []string {
+	var urls []string
+	for _, l := range t.listeners {
+		urls = append(urls, "http://"+l.Addr().String()+"/announce")
+	}
+	for _, conn := range t.udp {
+		urls = append(urls, "udp://"+conn.LocalAddr().String()+"/announce")
+	}
+	return urls
+}
+
+// announce records p in the swarm of ih, or removes it when stopped, and
+// returns up to numWant other peers of the same address family.
+func (t *Tracker) announce(ih metainfo.Hash, p *peer, event string, numWant int) (peers []*peer, seeders, leechers int, err error) {
+	if t.config.Filter != nil && !t.config.Filter(ih) {
+		rejectedMeter.Mark(1)
+		return nil, 0, 0, errUnknownTorrent
+	}
+	if numWant < 0 {
+		numWant = t.config.NumWant
+	}
+	if numWant > maxNumWant {
+		numWant = maxNumWant
+	}
+	key := net.JoinHostPort(p.ip.String(), strconv.Itoa(int(p.port)))
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.swarms[ih]
+	if !ok {
+		s = &swarm{peers: make(map[string]*peer)}
+		t.swarms[ih] = s
+	}
+	if old, ok := s.peers[key]; ok {
+		s.remove(key, old)
+	}
+	switch event {
+	case "stopped":
+	default:
+		if event == "completed" {
+			s.completed++
+		}
+		s.add(key, p)
+	}
+	ipv4 := p.ip.To4() != nil
+	for k, other := range s.peers {
+		if len(peers) >= numWant {
+			break
+		}
+		if k == key || (other.ip.To4() != nil) != ipv4 {
+			continue
+		}
+		// Seeders do not need other seeders
+		if p.left == 0 && other.left == 0 {
+			continue
+		}
+		peers = append(peers, other)
+	}
+	if len(s.peers) == 0 {
+		delete(t.swarms, ih)
+	}
+	return peers, s.seeders, len(s.peers) - s.seeders, nil
+}
+
+// scrape returns the seeders, completed downloads and leechers of ih.
+func (t *Tracker) scrape(ih metainfo.Hash) (seeders, completed, leechers int) {
+	scrapeMeter.Mark(1)
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if s, ok := t.swarms[ih]; ok {
+		return s.seeders, s.completed, len(s.peers) - s.seeders
+	}
+	return 0, 0, 0
+}
+
+func (s *swarm) add(key string, p *peer) {
+	s.peers[key] = p
+	if p.left == 0 {
+		s.seeders++
+	}
+}
+
+func (s *swarm) remove(key string, p *peer) {
+	delete(s.peers, key)
+	if p.left == 0 {
+		s.seeders--
+	}
+}
+
+// expireLoop drops the peers which stopped announcing.
+func (t *Tracker) expireLoop() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.config.Interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			var expired int
+			t.lock.Lock()
+			for ih, s := range t.swarms {
+				for key, p := range s.peers {
+					if now.Sub(p.seen) > t.config.Expiry {
+						s.remove(key, p)
+						expired++
+					}
+				}
+				if len(s.peers) == 0 {
+					delete(t.swarms, ih)
+				}
+			}
+			t.lock.Unlock()
+			stats := t.Stats()
+			torrentsGauge.Update(int64(stats.Torrents))
+			peersGauge.Update(int64(stats.PeersAll))
+			log.Debug("Tracker peers expired", "expired", expired, "torrents", stats.Torrents, "peers", stats.PeersAll)
+		case <-t.quit:
+			return
+		}
+	}
+}
+
+// Stats is the summary of the swarms, in the format of the stats.json of
+// the bittorrent-tracker npm package probed by the torrentfs monitor.
+type Stats struct {
+	Torrents              int `json:"torrents"`
+	ActiveTorrents        int `json:"activeTorrents"`
+	PeersAll              int `json:"peersAll"`
+	PeersSeederOnly       int `json:"peersSeederOnly"`
+	PeersLeecherOnly      int `json:"peersLeecherOnly"`
+	PeersSeederAndLeecher int `json:"peersSeederAndLeecher"`
+	PeersIPv4             int `json:"peersIPv4"`
+	PeersIPv6             int `json:"peersIPv6"`
+}
+
+// Stats returns the summary of the swarms.
+func (t *Tracker) Stats() Stats {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	var stats Stats
+	// Peers are counted by address across swarms, as the npm tracker does
+	var (
+		all      = make(map[string]net.IP)
+		seeding  = make(map[string]bool)
+		leeching = make(map[string]bool)
+	)
+	for _, s := range t.swarms {
+		stats.Torrents++
+		if len(s.peers) > 0 {
+			stats.ActiveTorrents++
+		}
+		for key, p := range s.peers {
+			all[key] = p.ip
+			if p.left == 0 {
+				seeding[key] = true
+			} else {
+				leeching[key] = true
+			}
+		}
+	}
+	for key, ip := range all {
+		switch {
+		case seeding[key] && leeching[key]:
+			stats.PeersSeederAndLeecher++
+		case seeding[key]:
+			stats.PeersSeederOnly++
+		default:
+			stats.PeersLeecherOnly++
+		}
+		if ip.To4() != nil {
+			stats.PeersIPv4++
+		} else {
+			stats.PeersIPv6++
+		}
+	}
+	stats.PeersAll = len(all)
+	return stats
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	bt "github.com/anacrolix/torrent/tracker"
+)
+
+func newTestTracker(t *testing.T, filter func(metainfo.Hash) bool) *Tracker {
+	tr := New(Config{
+		HTTPAddrs: []string{"127.0.0.1:0"},
+		UDPAddrs:  []string{"127.0.0.1:0"},
+		Filter:    filter,
+	})
+	if err := tr.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func announce(t *testing.T, url string, ih metainfo.Hash, port uint16, left int64, event bt.AnnounceEvent) (bt.AnnounceResponse, error) {
+	return bt.Announce{
+		TrackerUrl: url,
+		Request: bt.AnnounceRequest{
+			InfoHash: ih,
+			PeerId:   [20]byte{byte(port)},
+			Left:     left,
+			Event:    event,
+			NumWant:  -1,
+			Port:     port,
+		},
+	}.Do()
+}
+
+func TestTracker(t *testing.T) {
+	tr := newTestTracker(t, nil)
+	defer tr.Stop()
+
+	var (
+		httpURL = "http://" + tr.listeners[0].Addr().String() + "/announce"
+		udpURL  = "udp://" + tr.udp[0].LocalAddr().String()
+		ih      = metainfo.Hash{1}
+	)
+
+	res, err := announce(t, udpURL, ih, 1000, 0, bt.Started)
+	if err != nil {
+		t.Fatalf("udp announce: %v", err)
+	}
+	if res.Seeders != 1 || res.Leechers != 0 || len(res.Peers) != 0 {
+		t.Fatalf("seeder announce: %+v", res)
+	}
+	res, err = announce(t, httpURL, ih, 2000, 100, bt.Started)
+	if err != nil {
+		t.Fatalf("http announce: %v", err)
+	}
+	if res.Seeders != 1 || res.Leechers != 1 || len(res.Peers) != 1 || res.Peers[0].Port != 1000 {
+		t.Fatalf("leecher announce: %+v", res)
+	}
+	if res.Interval != int32(DefaultConfig.Interval.Seconds()) {
+		t.Fatalf("interval %d", res.Interval)
+	}
+	res, err = announce(t, udpURL, ih, 2000, 0, bt.Completed)
+	if err != nil {
+		t.Fatalf("udp announce: %v", err)
+	}
+	if res.Seeders != 2 || res.Leechers != 0 {
+		t.Fatalf("completed announce: %+v", res)
+	}
+	if seeders, completed, leechers := tr.scrape(ih); seeders != 2 || completed != 1 || leechers != 0 {
+		t.Fatalf("scrape: %d %d %d", seeders, completed, leechers)
+	}
+	if _, err = announce(t, httpURL, ih, 1000, 0, bt.Stopped); err != nil {
+		t.Fatalf("http announce: %v", err)
+	}
+
+	resp, err := http.Get("http://" + tr.listeners[0].Addr().String() + "/stats.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Torrents != 1 || stats.PeersAll != 1 || stats.PeersSeederOnly != 1 || stats.PeersIPv4 != 1 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
+
+func TestTrackerFilter(t *testing.T) {
+	known := metainfo.Hash{1}
+	tr := newTestTracker(t, func(ih metainfo.Hash) bool { return ih == known })
+	defer tr.Stop()
+
+	for _, url := range tr.URLs() {
+		if _, err := announce(t, url, known, 1000, 0, bt.Started); err != nil {
+			t.Fatalf("%s: known torrent refused: %v", url, err)
+		}
+		if _, err := announce(t, url, metainfo.Hash{2}, 1000, 0, bt.Started); err == nil {
+			t.Fatalf("%s: unknown torrent tracked", url)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/anacrolix/torrent/metainfo"
+	bt "github.com/anacrolix/torrent/tracker"
+)
+
+const (
+	// BEP 15 magic of the connect requests
+	udpProtocolID = 0x41727101980
+	// Connection ids are valid for at least this long, and at most twice
+	connectionIDLifetime = 2 * time.Minute
+	// Most info hashes scraped at once, the most fitting a 1500 bytes packet
+	maxScrape = 74
+)
+
+var events = map[bt.AnnounceEvent]string{
+	bt.None:      "",
+	bt.Completed: "completed",
+	bt.Started:   "started",
+	bt.Stopped:   "stopped",
+}
+
+func (t *Tracker) startUDP() error {
+	t.secret = make([]byte, 32)
+	if _, err := rand.Read(t.secret); err != nil {
+		return err
+	}
+	for _, addr := range t.config.UDPAddrs {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		t.udp = append(t.udp, conn)
+		t.wg.Add(1)
+		go t.serveUDP(conn)
+		log.Info("UDP tracker started", "addr", conn.LocalAddr())
+	}
+	return nil
+}
+
+// connectionID derives the connection id of a client from its address and
+// the current period, so that no state is kept between connect and announce.
+func (t *Tracker) connectionID(ip net.IP, period int64) int64 {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write(ip)
+	binary.Write(mac, binary.BigEndian, period)
+	return int64(binary.BigEndian.Uint64(mac.Sum(nil)))
+}
+
+func (t *Tracker) validConnectionID(ip net.IP, id int64) bool {
+	period := time.Now().UnixNano() / int64(connectionIDLifetime)
+	return id == t.connectionID(ip, period) || id == t.connectionID(ip, period-1)
+}
+
+func (t *Tracker) serveUDP(conn net.PacketConn) {
+	defer t.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-t.quit:
+			default:
+				log.Warn("UDP tracker stopped", "addr", conn.LocalAddr(), "err", err)
+			}
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if resp := t.handleUDP(buf[:n], udpAddr); resp != nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func udpResponse(action bt.Action, txID int32, parts ...interface{}) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, bt.ResponseHeader{Action: action, TransactionId: txID})
+	for _, part := range parts {
+		binary.Write(&buf, binary.BigEndian, part)
+	}
+	return buf.Bytes()
+}
+
+func udpError(txID int32, msg string) []byte {
+	return udpResponse(bt.ActionError, txID, []byte(msg))
+}
+
+// handleUDP answers a BEP 15 request, nil when it should be ignored.
+func (t *Tracker) handleUDP(b []byte, addr *net.UDPAddr) []byte {
+	r := bytes.NewReader(b)
+	var h bt.RequestHeader
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return nil
+	}
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	period := time.Now().UnixNano() / int64(connectionIDLifetime)
+
+	switch h.Action {
+	case bt.ActionConnect:
+		if h.ConnectionId != udpProtocolID {
+			return nil
+		}
+		return udpResponse(bt.ActionConnect, h.TransactionId, bt.ConnectionResponse{ConnectionId: t.connectionID(ip, period)})
+
+	case bt.ActionAnnounce:
+		udpAnnounceMeter.Mark(1)
+		if !t.validConnectionID(ip, h.ConnectionId) {
+			return udpError(h.TransactionId, "connection expired")
+		}
+		var req bt.AnnounceRequest
+		if err := binary.Read(r, binary.BigEndian, &req); err != nil {
+			return udpError(h.TransactionId, "invalid announce")
+		}
+		event, ok := events[req.Event]
+		if !ok {
+			return udpError(h.TransactionId, "invalid event")
+		}
+		// The IP of the request is ignored, peers are reached where they sent from
+		p := &peer{ip, req.Port, req.Left, time.Now()}
+		peers, seeders, leechers, err := t.announce(metainfo.Hash(req.InfoHash), p, event, int(req.NumWant))
+		if err != nil {
+			return udpError(h.TransactionId, err.Error())
+		}
+		var compact []byte
+		for _, p := range peers {
+			compact = append(compact, compactPeer(p)...)
+		}
+		return udpResponse(bt.ActionAnnounce, h.TransactionId, bt.AnnounceResponseHeader{
+			Interval: int32(t.config.Interval / time.Second),
+			Leechers: int32(leechers),
+			Seeders:  int32(seeders),
+		}, compact)
+
+	case bt.ActionScrape:
+		if !t.validConnectionID(ip, h.ConnectionId) {
+			return udpError(h.TransactionId, "connection expired")
+		}
+		var stats []int32
+		for i := 0; i < maxScrape && r.Len() >= metainfo.HashSize; i++ {
+			var ih metainfo.Hash
+			r.Read(ih[:])
+			seeders, completed, leechers := t.scrape(ih)
+			stats = append(stats, int32(seeders), int32(completed), int32(leechers))
+		}
+		return udpResponse(bt.ActionScrape, h.TransactionId, stats)
+
+	default:
+		return udpError(h.TransactionId, "unknown action")
+	}
+}
@@ -17,7 +17,8 @@ const (
 	opCommon      = 0
 	opCreateModel = 1
 	opCreateInput = 2
-	opNoInput     = 3
+	opDeprecate   = 3
+	opNoInput     = 4
 )
 
 //var (
@@ -41,7 +42,7 @@ func (t *Transaction) Op() (op int) {
 	op = opCommon
 	if len(t.Payload) >= 2 {
 		op = (int(t.Payload[0]) << 8) + int(t.Payload[1])
-		if op > 3 {
+		if op > opDeprecate {
 			op = opNoInput
 		}
 	} else if len(t.Payload) == 0 {
@@ -98,6 +99,47 @@ func (t *Transaction) Parse() *FileMeta {
 	}
 }
 
+// ParseDeprecation returns the deprecation carried by the transaction, if any.
+func (t *Transaction) ParseDeprecation() *types.Deprecation {
+	if t.Op() != opDeprecate || t.Recipient == nil {
+		return nil
+	}
+	deprecation, err := types.ParseDeprecation(t.Payload)
+	if err != nil {
+		return nil
+	}
+	return deprecation
+}
+
+// InferTargets returns the recipient of the transaction followed by the
+// addresses passed as its call arguments, among which the models and inputs
+// of the contracts calling INFER. Any ABI encoded word whose 12 leading bytes
+// are zero is taken for an address, small integers included, so the targets
+// are only candidates to be matched against the known files.
+//
+// The heuristic misses the files a contract reads from its storage or
+// computes, addresses packed off the 32 byte word boundaries, and the calls
+// the recipient makes to other contracts with arguments of its own.
+func (t *Transaction) InferTargets() []common.Address {
+	if t.Recipient == nil {
+		return nil
+	}
+	addrs := []common.Address{*t.Recipient}
+	if len(t.Payload) < 4+common.HashLength {
+		return addrs
+	}
+	for args := t.Payload[4:]; len(args) >= common.HashLength; args = args[common.HashLength:] {
+		word := args[:common.HashLength]
+		if !bytes.Equal(word[:common.HashLength-common.AddressLength], make([]byte, common.HashLength-common.AddressLength)) {
+			continue
+		}
+		if addr := common.BytesToAddress(word); addr != (common.Address{}) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 type transactionMarshaling struct {
 	Price    *hexutil.Big
 	Amount   *hexutil.Big
@@ -125,8 +167,9 @@ type TxReceipt struct {
 	// Transaction Hash
 	TxHash *common.Hash `json:"TransactionHash"  gencodec:"required"`
 	//Receipt   *TxReceipt      `json:"receipt"  rlp:"nil"`
-	GasUsed uint64 `json:"gasUsed" gencodec:"required"`
-	Status  uint64 `json:"status"`
+	GasUsed uint64       `json:"gasUsed" gencodec:"required"`
+	Status  uint64       `json:"status"`
+	Logs    []*types.Log `json:"logs"`
 }
 
 // FileMeta ...